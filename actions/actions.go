@@ -0,0 +1,161 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/url"
+	"push-request/models"
+	"strings"
+)
+
+type Action string
+
+const (
+	Approve Action = "approve"
+	Merge   Action = "merge"
+	Close   Action = "close"
+	Reply   Action = "reply"
+)
+
+// The APNs categories of the notifications. Each category is registered by the app with the actions listed beside it
+const (
+	PullRequestCategory         = "PULL_REQUEST"          // approve, merge, close, reply
+	ReviewedPullRequestCategory = "REVIEWED_PULL_REQUEST" // merge, close, reply
+	IssueCategory               = "ISSUE"                 // close, reply
+	ClosedThreadCategory        = "CLOSED_THREAD"         // reply
+	ThreadCategory              = "THREAD"                // reply
+)
+
+// Gets the APNs category of a notification for an event of the given type
+func Category(eventType models.EventType) string {
+	switch eventType {
+	case models.PrOpened, models.PrReviewRequested, models.PrCommented:
+		return PullRequestCategory
+
+	case models.PrReviewed:
+		return ReviewedPullRequestCategory
+
+	case models.IssueOpened, models.IssueAssigned, models.IssueCommented:
+		return IssueCategory
+
+	// Kept from the first version of the app, which registered it before THREAD existed
+	case models.IssueClosed, models.PrClosed, models.PrMerged:
+		return ClosedThreadCategory
+
+	default:
+		return ThreadCategory
+	}
+}
+
+// A Request to perform an action on the issue or pull request `number` of the repository `repo_name`
+type Request struct {
+	Action   Action `json:"action"`
+	RepoName string `json:"repo_name"`
+	Number   int    `json:"number"`
+	Body     string `json:"body,omitempty"`
+}
+
+func (request *Request) ownerAndRepo() (string, string) {
+	parts := strings.SplitN(request.RepoName, "/", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+
+	return parts[0], parts[1]
+}
+
+func (request *Request) Validate() error {
+	switch request.Action {
+	case Approve, Merge, Close:
+
+	case Reply:
+		if strings.TrimSpace(request.Body) == "" {
+			return errors.New("a reply requires a body")
+		}
+
+	default:
+		return fmt.Errorf("unknown action %q", request.Action)
+	}
+
+	if owner, repo := request.ownerAndRepo(); owner == "" || repo == "" {
+		return fmt.Errorf("invalid repo name %q", request.RepoName)
+	}
+
+	if request.Number <= 0 {
+		return fmt.Errorf("invalid number %d", request.Number)
+	}
+
+	return nil
+}
+
+type tokenTransport struct {
+	token string
+}
+
+func (transport *tokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "token "+transport.token)
+
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// Creates a GitHub REST API client authenticated with the given token. baseURL must end with a slash
+func NewClient(token string, baseURL string) (*github.Client, error) {
+	client := github.NewClient(&http.Client{Transport: &tokenTransport{token: token}})
+
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client.BaseURL = parsedURL
+	return client, nil
+}
+
+// Performs the action through the GitHub REST API, returning a description of the result
+func Perform(ctx context.Context, client *github.Client, request *Request) (string, error) {
+	owner, repo := request.ownerAndRepo()
+
+	var err error
+	var description string
+
+	switch request.Action {
+	case Approve:
+		review := &github.PullRequestReviewRequest{Event: github.String("APPROVE")}
+		if request.Body != "" {
+			review.Body = github.String(request.Body)
+		}
+
+		_, _, err = client.PullRequests.CreateReview(ctx, owner, repo, request.Number, review)
+		description = fmt.Sprintf("Approved #%d", request.Number)
+
+	case Merge:
+		var result *github.PullRequestMergeResult
+
+		result, _, err = client.PullRequests.Merge(ctx, owner, repo, request.Number, request.Body, nil)
+		if err == nil && !result.GetMerged() {
+			err = errors.New(result.GetMessage())
+		}
+		description = fmt.Sprintf("Merged #%d", request.Number)
+
+	case Close:
+		_, _, err = client.Issues.Edit(ctx, owner, repo, request.Number, &github.IssueRequest{State: github.String("closed")})
+		description = fmt.Sprintf("Closed #%d", request.Number)
+
+	case Reply:
+		_, _, err = client.Issues.CreateComment(ctx, owner, repo, request.Number, &github.IssueComment{Body: github.String(request.Body)})
+		description = fmt.Sprintf("Commented on #%d", request.Number)
+
+	default:
+		err = fmt.Errorf("unknown action %q", request.Action)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to %s #%d (%w)", request.Action, request.Number, err)
+	}
+
+	return description, nil
+}
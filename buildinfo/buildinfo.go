@@ -0,0 +1,11 @@
+package buildinfo
+
+// The commit and time the server was built from, set when building with
+//
+//	go build -ldflags "-X push-request/buildinfo.Commit=$(git rev-parse HEAD) -X push-request/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// On Heroku, the Go buildpack sets the commit when GO_LINKER_SYMBOL is push-request/buildinfo.Commit
+var (
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
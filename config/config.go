@@ -0,0 +1,447 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sideshow/apns2/token"
+	"net"
+	"net/url"
+	"push-request/logging"
+	"push-request/models"
+	"strings"
+	"time"
+)
+
+// The configuration of the server. Every field can be set in the config file, under its `yaml`/`toml` key, or by
+// the environment variable in its `env` tag. Fields tagged `secret` are redacted when the configuration is printed
+type Config struct {
+	Port int `yaml:"port" toml:"port" env:"PORT"`
+
+	// `DEVELOPMENT` when running against the APNs sandbox
+	Environment string `yaml:"environment" toml:"environment" env:"GO_ENV"`
+
+	Log     LogConfig     `yaml:"log" toml:"log"`
+	HTTP    HTTPConfig    `yaml:"http" toml:"http"`
+	Storage StorageConfig `yaml:"storage" toml:"storage"`
+	APNS    APNSConfig    `yaml:"apns" toml:"apns"`
+	Github  GithubConfig  `yaml:"github" toml:"github"`
+	Stream  StreamConfig  `yaml:"stream" toml:"stream"`
+	Tracing TracingConfig `yaml:"tracing" toml:"tracing"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+}
+
+type LogConfig struct {
+	// One of debug, info, warn or error
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+}
+
+// Durations are given like `30s` or `1m30s`
+type HTTPConfig struct {
+	// How long clients may take to send a request, and the server may take to respond, except to event streams
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+
+	// How long idle keep-alive connections are kept open
+	IdleTimeout time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+
+	// How long requests are still served after SIGTERM while the readiness check fails, so load balancers stop
+	// sending requests before connections are refused
+	DrainDelay time.Duration `yaml:"drain_delay" toml:"drain_delay" env:"HTTP_DRAIN_DELAY"`
+
+	// How long in-flight requests and background work are given to finish once the server is told to stop. Heroku
+	// kills processes 30 seconds after sending SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT"`
+
+	// The largest request bodies accepted, in bytes. GitHub sends webhook payloads of up to 25 MB
+	MaxBodyBytes    int64 `yaml:"max_body_bytes" toml:"max_body_bytes" env:"HTTP_MAX_BODY_BYTES"`
+	MaxWebhookBytes int64 `yaml:"max_webhook_bytes" toml:"max_webhook_bytes" env:"HTTP_MAX_WEBHOOK_BYTES"`
+
+	// The origins whose browsers may call the API, separated by commas, or `*` for any origin. Browsers can't call
+	// it cross-origin when none are given
+	CORSOrigins string `yaml:"cors_origins" toml:"cors_origins" env:"HTTP_CORS_ORIGINS"`
+
+	// Takes the IP of clients from the last entry of `X-Forwarded-For`, which is set by the load balancer in front
+	// of the server, such as Heroku's router. Clients could spoof their IP if it were set without a load balancer,
+	// but it must be set behind one, or every client is rate limited as the load balancer. The Procfile sets it
+	TrustProxy bool `yaml:"trust_proxy" toml:"trust_proxy" env:"HTTP_TRUST_PROXY"`
+}
+
+const (
+	BackendMongo    = "mongo"
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+type StorageConfig struct {
+	// One of mongo, postgres or sqlite
+	Backend string `yaml:"backend" toml:"backend" env:"STORAGE_BACKEND"`
+
+	MongoURI      string `yaml:"mongo_uri" toml:"mongo_uri" env:"DB_URI" secret:"password"`
+	MongoDatabase string `yaml:"mongo_database" toml:"mongo_database" env:"DB_NAME"`
+
+	// A PostgreSQL connection string or the path of a SQLite database
+	DatabaseURL string `yaml:"database_url" toml:"database_url" env:"DATABASE_URL" secret:"password"`
+}
+
+type APNSConfig struct {
+	// The .p8 key used to sign APNs tokens, as PEM or base64 encoded PEM
+	AuthKey string `yaml:"auth_key" toml:"auth_key" env:"APNS_AUTH_KEY" secret:"true"`
+	KeyId   string `yaml:"key_id" toml:"key_id" env:"APNS_KID"`
+	TeamId  string `yaml:"team_id" toml:"team_id" env:"APNS_ISS"`
+
+	// The bundle id of the app
+	Topic string `yaml:"topic" toml:"topic" env:"APNS_TOPIC"`
+
+	// The environment of devices registered before they reported theirs. Defaults to sandbox in development and
+	// production otherwise
+	DefaultEnvironment models.APNSEnvironment `yaml:"default_environment" toml:"default_environment" env:"APNS_DEFAULT_ENVIRONMENT"`
+}
+
+type GithubConfig struct {
+	APIURL string `yaml:"api_url" toml:"api_url" env:"GITHUB_API_URL"`
+
+	// The GitHub App is optional, and configured when its id is set
+	AppId         int64  `yaml:"app_id" toml:"app_id" env:"GITHUB_APP_ID"`
+	AppPrivateKey string `yaml:"app_private_key" toml:"app_private_key" env:"GITHUB_APP_PRIVATE_KEY" secret:"true"`
+
+	// The secret GitHub signs webhooks with, set in the settings of the GitHub App
+	WebhookSecret string `yaml:"webhook_secret" toml:"webhook_secret" env:"GITHUB_WEBHOOK_SECRET" secret:"true"`
+}
+
+const (
+	BrokerMemory = "memory"
+	BrokerMongo  = "mongo"
+)
+
+type StreamConfig struct {
+	// memory fans events out in-process, and mongo through a change stream, for running several replicas. The change
+	// stream only sees the events stored in MongoDB, so mongo requires the mongo backend
+	Broker string `yaml:"broker" toml:"broker" env:"STREAM_BROKER"`
+}
+
+const (
+	RateLimitMemory = "memory"
+	RateLimitMongo  = "mongo"
+)
+
+// Requests are limited with token buckets, which let a burst of requests through and then a number of requests per
+// minute. A limit of 0 requests per minute isn't enforced
+type RateLimitConfig struct {
+	// memory keeps the buckets of each replica in-process, and mongo shares them between replicas
+	Store string `yaml:"store" toml:"store" env:"RATE_LIMIT_STORE"`
+
+	// Requests from each IP, except webhooks, which GitHub sends from a few IPs for every installation. Behind a load
+	// balancer, the IP of clients is only known when the proxy is trusted
+	IPPerMinute int `yaml:"ip_per_minute" toml:"ip_per_minute" env:"RATE_LIMIT_IP_PER_MINUTE"`
+	IPBurst     int `yaml:"ip_burst" toml:"ip_burst" env:"RATE_LIMIT_IP_BURST"`
+
+	// Requests authenticated as each User
+	UserPerMinute int `yaml:"user_per_minute" toml:"user_per_minute" env:"RATE_LIMIT_USER_PER_MINUTE"`
+	UserBurst     int `yaml:"user_burst" toml:"user_burst" env:"RATE_LIMIT_USER_BURST"`
+
+	// Webhooks of each installation of the GitHub App
+	InstallationPerMinute int `yaml:"installation_per_minute" toml:"installation_per_minute" env:"RATE_LIMIT_INSTALLATION_PER_MINUTE"`
+	InstallationBurst     int `yaml:"installation_burst" toml:"installation_burst" env:"RATE_LIMIT_INSTALLATION_BURST"`
+
+	// The most devices a User may register, or 0 for any number
+	MaxDevicesPerUser int `yaml:"max_devices_per_user" toml:"max_devices_per_user" env:"RATE_LIMIT_MAX_DEVICES_PER_USER"`
+}
+
+// Traces are exported over OTLP to a collector, such as the OpenTelemetry Collector or a hosted tracing backend
+type TracingConfig struct {
+	// The `host:port` of the collector's OTLP gRPC endpoint. Traces aren't exported when it isn't set
+	Endpoint string `yaml:"endpoint" toml:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+
+	// Connects to the collector without TLS
+	Insecure bool `yaml:"insecure" toml:"insecure" env:"OTEL_EXPORTER_OTLP_INSECURE"`
+
+	// Headers sent with every export, such as API keys, as `key=value` pairs separated by commas
+	Headers string `yaml:"headers" toml:"headers" env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true"`
+
+	ServiceName string `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME"`
+
+	// The fraction of traces sampled, from 0 to 1. Requests continuing a trace follow the sampling of their caller
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// The configuration used for anything that isn't configured
+func Default() *Config {
+	return &Config{
+		Port: 8080,
+		Log:  LogConfig{Level: "info"},
+		HTTP: HTTPConfig{
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 25 * time.Second,
+			MaxBodyBytes:    1 << 20,
+			MaxWebhookBytes: 25 << 20,
+		},
+		Storage: StorageConfig{Backend: BackendMongo},
+		Github:  GithubConfig{APIURL: "https://api.github.com/"},
+		Stream:  StreamConfig{Broker: BrokerMemory},
+		Tracing: TracingConfig{ServiceName: "push-request", SampleRatio: 1},
+		RateLimit: RateLimitConfig{
+			Store:                 RateLimitMemory,
+			IPPerMinute:           120,
+			IPBurst:               60,
+			UserPerMinute:         60,
+			UserBurst:             30,
+			InstallationPerMinute: 600,
+			InstallationBurst:     200,
+			MaxDevicesPerUser:     20,
+		},
+	}
+}
+
+// Fills in the fields whose defaults depend on other fields
+func (cfg *Config) resolve() {
+	if cfg.APNS.DefaultEnvironment == "" {
+		cfg.APNS.DefaultEnvironment = models.APNSProduction
+
+		if cfg.Environment == "DEVELOPMENT" {
+			cfg.APNS.DefaultEnvironment = models.APNSSandbox
+		}
+	}
+}
+
+// A ValidationError lists every problem with a configuration, so they can all be fixed at once
+type ValidationError struct {
+	Problems []string
+}
+
+func (err *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(err.Problems, "\n  - ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, key string, env string, message string, args ...interface{}) {
+	if !ok {
+		v.problems = append(v.problems, fmt.Sprintf("%s (%s) %s", key, env, fmt.Sprintf(message, args...)))
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: v.problems}
+}
+
+// Checks the configuration, returning a ValidationError with every problem found
+func (cfg *Config) Validate() error {
+	var v validator
+
+	cfg.validateStorage(&v)
+	cfg.validateServer(&v)
+
+	return v.err()
+}
+
+// Checks only the configuration of storage, which is all the commands that migrate storage need
+func (cfg *Config) ValidateStorage() error {
+	var v validator
+
+	cfg.validateStorage(&v)
+
+	return v.err()
+}
+
+func (cfg *Config) validateStorage(v *validator) {
+	switch cfg.Storage.Backend {
+	case BackendMongo:
+	case BackendPostgres, BackendSQLite:
+		v.check(cfg.Storage.DatabaseURL != "", "storage.database_url", "DATABASE_URL", "is required by the %s backend",
+			cfg.Storage.Backend)
+	default:
+		v.check(false, "storage.backend", "STORAGE_BACKEND", "must be mongo, postgres or sqlite")
+	}
+
+	switch cfg.Stream.Broker {
+	case BrokerMemory:
+	case BrokerMongo:
+		v.check(cfg.Storage.Backend == BackendMongo, "stream.broker", "STREAM_BROKER",
+			"must be memory with the %s backend, since mongo streams the events stored in MongoDB", cfg.Storage.Backend)
+	default:
+		v.check(false, "stream.broker", "STREAM_BROKER", "must be memory or mongo")
+	}
+
+	switch cfg.RateLimit.Store {
+	case RateLimitMemory, RateLimitMongo:
+	default:
+		v.check(false, "rate_limit.store", "RATE_LIMIT_STORE", "must be memory or mongo")
+	}
+
+	if cfg.UsesMongo() {
+		v.check(cfg.Storage.MongoURI != "", "storage.mongo_uri", "DB_URI", "is required to use MongoDB")
+		v.check(cfg.Storage.MongoDatabase != "", "storage.mongo_database", "DB_NAME", "is required to use MongoDB")
+	}
+}
+
+func (cfg *Config) validateServer(v *validator) {
+	v.check(cfg.Port > 0 && cfg.Port <= 65535, "port", "PORT", "must be between 1 and 65535")
+
+	_, err := logging.ParseLevel(cfg.Log.Level)
+	v.check(err == nil, "log.level", "LOG_LEVEL", "must be debug, info, warn or error")
+
+	v.check(cfg.HTTP.ReadTimeout > 0, "http.read_timeout", "HTTP_READ_TIMEOUT", "must be positive")
+	v.check(cfg.HTTP.WriteTimeout > 0, "http.write_timeout", "HTTP_WRITE_TIMEOUT", "must be positive")
+	v.check(cfg.HTTP.IdleTimeout > 0, "http.idle_timeout", "HTTP_IDLE_TIMEOUT", "must be positive")
+	v.check(cfg.HTTP.DrainDelay >= 0, "http.drain_delay", "HTTP_DRAIN_DELAY", "must not be negative")
+	v.check(cfg.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout", "HTTP_SHUTDOWN_TIMEOUT", "must be positive")
+	v.check(cfg.HTTP.MaxBodyBytes > 0, "http.max_body_bytes", "HTTP_MAX_BODY_BYTES", "must be positive")
+	v.check(cfg.HTTP.MaxWebhookBytes > 0, "http.max_webhook_bytes", "HTTP_MAX_WEBHOOK_BYTES", "must be positive")
+
+	validOrigins := true
+	for _, origin := range cfg.HTTP.Origins() {
+		parsed, err := url.Parse(origin)
+		validOrigins = validOrigins && (origin == "*" || err == nil && parsed.Scheme != "" && parsed.Host != "" && parsed.Path == "")
+	}
+
+	v.check(validOrigins, "http.cors_origins", "HTTP_CORS_ORIGINS",
+		"must be * or origins like https://example.com separated by commas")
+
+	for _, limit := range []struct {
+		key       string
+		env       string
+		perMinute int
+		burst     int
+	}{
+		{"ip", "IP", cfg.RateLimit.IPPerMinute, cfg.RateLimit.IPBurst},
+		{"user", "USER", cfg.RateLimit.UserPerMinute, cfg.RateLimit.UserBurst},
+		{"installation", "INSTALLATION", cfg.RateLimit.InstallationPerMinute, cfg.RateLimit.InstallationBurst},
+	} {
+		v.check(limit.perMinute >= 0, "rate_limit."+limit.key+"_per_minute", "RATE_LIMIT_"+limit.env+"_PER_MINUTE",
+			"must not be negative")
+		v.check(limit.perMinute == 0 || limit.burst > 0, "rate_limit."+limit.key+"_burst", "RATE_LIMIT_"+limit.env+"_BURST",
+			"must be positive when the limit is enforced")
+	}
+
+	v.check(cfg.RateLimit.MaxDevicesPerUser >= 0, "rate_limit.max_devices_per_user", "RATE_LIMIT_MAX_DEVICES_PER_USER",
+		"must not be negative")
+
+	if cfg.APNS.AuthKey == "" {
+		v.check(false, "apns.auth_key", "APNS_AUTH_KEY", "is required")
+	} else if _, err := cfg.APNS.Token(); err != nil {
+		v.check(false, "apns.auth_key", "APNS_AUTH_KEY", "must be a .p8 key as PEM or base64 encoded PEM")
+	}
+
+	v.check(cfg.APNS.KeyId != "", "apns.key_id", "APNS_KID", "is required")
+	v.check(cfg.APNS.TeamId != "", "apns.team_id", "APNS_ISS", "is required")
+	v.check(cfg.APNS.Topic != "", "apns.topic", "APNS_TOPIC", "is required")
+
+	switch cfg.APNS.DefaultEnvironment {
+	case models.APNSProduction, models.APNSSandbox:
+	default:
+		v.check(false, "apns.default_environment", "APNS_DEFAULT_ENVIRONMENT", "must be production or sandbox")
+	}
+
+	if cfg.Tracing.Enabled() {
+		_, _, err := net.SplitHostPort(cfg.Tracing.Endpoint)
+		v.check(err == nil, "tracing.endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT", "must be a host:port")
+
+		_, err = cfg.Tracing.HeaderMap()
+		v.check(err == nil, "tracing.headers", "OTEL_EXPORTER_OTLP_HEADERS", "must be key=value pairs separated by commas")
+	}
+
+	v.check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing.sample_ratio",
+		"TRACING_SAMPLE_RATIO", "must be between 0 and 1")
+
+	v.check(cfg.Github.WebhookSecret != "", "github.webhook_secret", "GITHUB_WEBHOOK_SECRET", "is required")
+
+	apiURL, err := url.Parse(cfg.Github.APIURL)
+	v.check(err == nil && apiURL.IsAbs(), "github.api_url", "GITHUB_API_URL", "must be an absolute URL")
+
+	if cfg.Github.AppId != 0 || cfg.Github.AppPrivateKey != "" {
+		v.check(cfg.Github.AppId > 0, "github.app_id", "GITHUB_APP_ID", "is required with a GitHub App private key")
+
+		if cfg.Github.AppPrivateKey == "" {
+			v.check(false, "github.app_private_key", "GITHUB_APP_PRIVATE_KEY", "is required with a GitHub App id")
+		} else if _, err := jwt.ParseRSAPrivateKeyFromPEM(cfg.Github.PrivateKeyPEM()); err != nil {
+			v.check(false, "github.app_private_key", "GITHUB_APP_PRIVATE_KEY",
+				"must be an RSA private key as PEM or base64 encoded PEM")
+		}
+	}
+}
+
+// Reports whether the server connects to MongoDB, for storage, to fan out events or to share rate limits
+func (cfg *Config) UsesMongo() bool {
+	return cfg.Storage.Backend == BackendMongo || cfg.Stream.Broker == BrokerMongo || cfg.RateLimit.Store == RateLimitMongo
+}
+
+// Lists the origins allowed to call the API cross-origin
+func (cfg *HTTPConfig) Origins() []string {
+	var origins []string
+
+	for _, origin := range strings.Split(cfg.CORSOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+
+	return origins
+}
+
+// Reports whether traces are exported
+func (cfg *TracingConfig) Enabled() bool {
+	return cfg.Endpoint != ""
+}
+
+// Parses the headers sent with every export
+func (cfg *TracingConfig) HeaderMap() (map[string]string, error) {
+	headers := map[string]string{}
+
+	for _, pair := range strings.Split(cfg.Headers, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid header %q", pair)
+		}
+
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return headers, nil
+}
+
+// Reports whether a GitHub App is configured
+func (cfg *GithubConfig) HasApp() bool {
+	return cfg.AppId != 0
+}
+
+// Keys are given as PEM, or base64 encoded PEM so they fit on one line in environment variables
+func decodePEM(key string) []byte {
+	if strings.HasPrefix(strings.TrimSpace(key), "-----BEGIN") {
+		return []byte(key)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil
+	}
+
+	return decoded
+}
+
+func (cfg *GithubConfig) PrivateKeyPEM() []byte {
+	return decodePEM(cfg.AppPrivateKey)
+}
+
+// Creates the token APNs requests are signed with
+func (cfg *APNSConfig) Token() (*token.Token, error) {
+	authKey, err := token.AuthKeyFromBytes(decodePEM(cfg.AuthKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &token.Token{AuthKey: authKey, KeyID: cfg.KeyId, TeamID: cfg.TeamId}, nil
+}
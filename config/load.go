@@ -0,0 +1,235 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Looks up an environment variable, like os.LookupEnv
+type LookupEnv func(key string) (string, bool)
+
+// Loads the configuration with Resolve and validates it
+func Load(path string, lookupEnv LookupEnv) (*Config, error) {
+	cfg, err := Resolve(path, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Resolves the configuration, starting from Default, then applying the YAML or TOML file at path, if one is given,
+// and then the environment. A variable `NAME_FILE` gives the path of a file holding the value of `NAME`, for secrets
+// mounted as files
+func Resolve(path string, lookupEnv LookupEnv) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadEnv(lookupEnv, cfg); err != nil {
+		return nil, err
+	}
+
+	cfg.resolve()
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file (%w)", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		if err = decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("invalid config file %s (%w)", path, err)
+		}
+
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s (%w)", path, err)
+		}
+
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("invalid config file %s (unknown key %s)", path, undecoded[0])
+		}
+
+	default:
+		return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+
+	return nil
+}
+
+// A configurable field, found by walking the Config
+type field struct {
+	key    string
+	env    string
+	secret string
+	value  reflect.Value
+}
+
+func fields(value reflect.Value, prefix string) []field {
+	var found []field
+
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		key := prefix + structField.Tag.Get("yaml")
+
+		if structField.Type.Kind() == reflect.Struct {
+			found = append(found, fields(value.Field(i), key+".")...)
+			continue
+		}
+
+		found = append(found, field{
+			key:    key,
+			env:    structField.Tag.Get("env"),
+			secret: structField.Tag.Get("secret"),
+			value:  value.Field(i),
+		})
+	}
+
+	return found
+}
+
+func loadEnv(lookupEnv LookupEnv, cfg *Config) error {
+	var problems []string
+
+	for _, field := range fields(reflect.ValueOf(cfg).Elem(), "") {
+		value, ok := lookupEnv(field.env)
+
+		if path, fileOk := lookupEnv(field.env + "_FILE"); fileOk {
+			if ok {
+				problems = append(problems, fmt.Sprintf("%s and %s_FILE can't both be set", field.env, field.env))
+				continue
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s_FILE can't be read (%s)", field.env, err.Error()))
+				continue
+			}
+
+			value, ok = strings.TrimRight(string(data), "\r\n"), true
+		}
+
+		if !ok {
+			continue
+		}
+
+		if err := set(field.value, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s) %s", field.key, field.env, err.Error()))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func set(value reflect.Value, text string) error {
+	if value.Type() == durationType {
+		parsed, err := time.ParseDuration(text)
+		if err != nil {
+			return errors.New("must be a duration, such as 30s")
+		}
+
+		value.SetInt(int64(parsed))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return errors.New("must be a whole number")
+		}
+
+		value.SetInt(parsed)
+
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+
+		value.SetFloat(parsed)
+
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+
+		value.SetBool(parsed)
+
+	default:
+		return errors.New("can't be set from the environment")
+	}
+
+	return nil
+}
+
+// Describes the configuration one field per line, as `key = value (ENV)`, with secrets redacted. Passwords in
+// connection strings are redacted, leaving the rest of the connection string to check
+func (cfg *Config) Describe() string {
+	var builder strings.Builder
+
+	for _, field := range fields(reflect.ValueOf(cfg).Elem(), "") {
+		value := fmt.Sprint(field.value.Interface())
+
+		switch {
+		case value == "":
+			value = "(not set)"
+		case field.secret == "true":
+			value = "[redacted]"
+		case field.secret == "password":
+			value = redactPassword(value)
+		}
+
+		_, _ = fmt.Fprintf(&builder, "%s = %s (%s)\n", field.key, value, field.env)
+	}
+
+	return builder.String()
+}
+
+// Redacts the password of a connection string, or all of it if it isn't a URL
+func redactPassword(connection string) string {
+	parsed, err := url.Parse(connection)
+	if err != nil || parsed.Scheme == "" {
+		return "[redacted]"
+	}
+
+	return parsed.Redacted()
+}
@@ -0,0 +1,193 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"push-request/models"
+	"time"
+)
+
+const mediaNamespace = "http://search.yahoo.com/mrss/"
+
+type Feed struct {
+	// A permanent id of the feed, as a URI. Feeds are read with a secret token, so the id mustn't contain it
+	ID string
+
+	Title string
+
+	// The URL the feed is read from, with its secret token, which feed readers follow to refresh it
+	SelfURL string
+
+	Events []models.StoredEvent
+}
+
+// The time of the most recent event in the feed, or the zero time if the feed is empty
+func (feed *Feed) Updated() time.Time {
+	var updated time.Time
+
+	for _, e := range feed.Events {
+		if e.Event.Timestamp.After(updated) {
+			updated = e.Event.Timestamp
+		}
+	}
+
+	return updated
+}
+
+type mediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string          `xml:"id"`
+	Title     string          `xml:"title"`
+	Summary   string          `xml:"summary"`
+	Link      atomLink        `xml:"link"`
+	Updated   string          `xml:"updated"`
+	Category  *atomCategory   `xml:"category,omitempty"`
+	Thumbnail *mediaThumbnail `xml:"media:thumbnail,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Media   string      `xml:"xmlns:media,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string          `xml:"title"`
+	Description string          `xml:"description"`
+	Link        string          `xml:"link"`
+	GUID        rssGUID         `xml:"guid"`
+	PubDate     string          `xml:"pubDate"`
+	Category    string          `xml:"category,omitempty"`
+	Thumbnail   *mediaThumbnail `xml:"media:thumbnail,omitempty"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Media   string     `xml:"xmlns:media,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+func entryTitle(event *models.Event) string {
+	return fmt.Sprintf("[%s] %s", event.RepoName, event.Title)
+}
+
+func entryID(storedEvent *models.StoredEvent) string {
+	return fmt.Sprintf("urn:push-request:event:%s", storedEvent.ID.Hex())
+}
+
+func thumbnail(event *models.Event) *mediaThumbnail {
+	if event.AvatarUrl == "" {
+		return nil
+	}
+
+	return &mediaThumbnail{URL: event.AvatarUrl}
+}
+
+// Renders the feed as an Atom 1.0 document
+func (feed *Feed) Atom() ([]byte, error) {
+	doc := atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		Media:   mediaNamespace,
+		ID:      feed.ID,
+		Title:   feed.Title,
+		Updated: feed.Updated().UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: "Push Request"},
+		Links:   []atomLink{{Rel: "self", Href: feed.SelfURL}},
+	}
+
+	for i := range feed.Events {
+		storedEvent := &feed.Events[i]
+		event := &storedEvent.Event
+
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:        entryID(storedEvent),
+			Title:     entryTitle(event),
+			Summary:   event.Description,
+			Link:      atomLink{Rel: "alternate", Href: event.Url},
+			Updated:   event.Timestamp.UTC().Format(time.RFC3339),
+			Category:  &atomCategory{Term: string(event.EventType)},
+			Thumbnail: thumbnail(event),
+		})
+	}
+
+	return marshal(doc)
+}
+
+// Renders the feed as an RSS 2.0 document
+func (feed *Feed) RSS() ([]byte, error) {
+	doc := rssFeed{
+		Version: "2.0",
+		Media:   mediaNamespace,
+		Channel: rssChannel{
+			Title:       feed.Title,
+			Link:        feed.SelfURL,
+			Description: feed.Title,
+		},
+	}
+
+	if updated := feed.Updated(); !updated.IsZero() {
+		doc.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for i := range feed.Events {
+		storedEvent := &feed.Events[i]
+		event := &storedEvent.Event
+
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       entryTitle(event),
+			Description: event.Description,
+			Link:        event.Url,
+			GUID:        rssGUID{IsPermaLink: false, Value: entryID(storedEvent)},
+			PubDate:     event.Timestamp.UTC().Format(time.RFC1123Z),
+			Category:    string(event.EventType),
+			Thumbnail:   thumbnail(event),
+		})
+	}
+
+	return marshal(doc)
+}
+
+func marshal(doc interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), body...), nil
+}
@@ -0,0 +1,236 @@
+package githubapp
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Installation tokens are refreshed this long before they expire, so a token is never used as it expires
+const tokenExpiryMargin = time.Minute
+
+// GitHub rejects app JWTs valid for longer than 10 minutes
+const jwtLifetime = 9 * time.Minute
+
+// Returned by RepositoryInstallation when the app isn't installed on the repository
+var ErrNotInstalled = errors.New("the GitHub App isn't installed on the repository")
+
+// The token cached for an installation. Its mutex is held while a new token is exchanged, so concurrent callers wait
+// for it instead of exchanging their own, without waiting for the exchanges of other installations
+type installationToken struct {
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// A Client authenticates as a GitHub App, and as its installations to call the GitHub REST API on their behalf
+type Client struct {
+	appId      int64
+	privateKey *rsa.PrivateKey
+	baseURL    *url.URL
+
+	mutex  sync.Mutex
+	tokens map[int64]*installationToken
+}
+
+// Creates a Client for the app with the given id and PEM encoded private key. baseURL must end with a slash
+func NewClient(appId int64, privateKeyPEM []byte, baseURL string) (*Client, error) {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App private key (%w)", err)
+	}
+
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API URL (%w)", err)
+	}
+
+	return &Client{
+		appId:      appId,
+		privateKey: privateKey,
+		baseURL:    parsedURL,
+		tokens:     map[int64]*installationToken{},
+	}, nil
+}
+
+// Signs a JWT authenticating as the app itself
+func (client *Client) AppJWT() (string, error) {
+	now := time.Now()
+
+	claims := jwt.StandardClaims{
+		// Backdated to allow for clock drift between the server and GitHub
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(jwtLifetime).Unix(),
+		Issuer:    strconv.FormatInt(client.appId, 10),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(client.privateKey)
+}
+
+// Gets a token authenticating as the installation, exchanging a new app JWT for it when the cached one is about to expire
+func (client *Client) InstallationToken(ctx context.Context, installationId int64) (string, error) {
+	client.mutex.Lock()
+	cached, ok := client.tokens[installationId]
+	if !ok {
+		cached = &installationToken{}
+		client.tokens[installationId] = cached
+	}
+	client.mutex.Unlock()
+
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+
+	if time.Now().Add(tokenExpiryMargin).Before(cached.expiresAt) {
+		return cached.token, nil
+	}
+
+	appClient := client.appClient()
+
+	req, err := appClient.NewRequest("POST", fmt.Sprintf("app/installations/%d/access_tokens", installationId), nil)
+	if err != nil {
+		return "", err
+	}
+
+	var token github.InstallationToken
+	if _, err = appClient.Do(ctx, req, &token); err != nil {
+		return "", fmt.Errorf("failed to create token for installation %d (%w)", installationId, err)
+	}
+
+	cached.token = token.GetToken()
+	cached.expiresAt = token.GetExpiresAt()
+	return cached.token, nil
+}
+
+// Gets the id of the installation of the app on the repository, given as `owner/name`, or returns ErrNotInstalled
+func (client *Client) RepositoryInstallation(ctx context.Context, repoName string) (int64, error) {
+	parts := strings.Split(repoName, "/")
+	if len(parts) != 2 {
+		return 0, ErrNotInstalled
+	}
+
+	installation, res, err := client.appClient().Apps.FindRepositoryInstallation(ctx, parts[0], parts[1])
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		return 0, ErrNotInstalled
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to find installation of %s (%w)", repoName, err)
+	}
+
+	return installation.GetID(), nil
+}
+
+// Reports whether the user with the github id can see the repository, given as `owner/name`, as the installation tells.
+// Public repositories can be seen by anyone, and private ones by their collaborators, including the members of the
+// organization owning them
+func (client *Client) CanSee(ctx context.Context, installationId int64, repoName string, githubId int64) (bool, error) {
+	parts := strings.Split(repoName, "/")
+	if len(parts) != 2 {
+		return false, nil
+	}
+
+	githubClient := client.Installation(installationId)
+
+	repo, res, err := githubClient.Repositories.Get(ctx, parts[0], parts[1])
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to get repository %s (%w)", repoName, err)
+	}
+
+	if !repo.GetPrivate() {
+		return true, nil
+	}
+
+	user, res, err := githubClient.Users.GetByID(ctx, githubId)
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to get user %d (%w)", githubId, err)
+	}
+
+	level, res, err := githubClient.Repositories.GetPermissionLevel(ctx, parts[0], parts[1], user.GetLogin())
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to get permission of %s on %s (%w)", user.GetLogin(), repoName, err)
+	}
+
+	return level.GetPermission() != "none", nil
+}
+
+// Creates a GitHub REST API client authenticated as the app itself
+func (client *Client) appClient() *github.Client {
+	return client.newGithubClient(func(context.Context) (string, error) {
+		appJWT, err := client.AppJWT()
+		return "Bearer " + appJWT, err
+	})
+}
+
+// Creates a GitHub REST API client authenticated as the installation
+func (client *Client) Installation(installationId int64) *github.Client {
+	return client.newGithubClient(func(ctx context.Context) (string, error) {
+		token, err := client.InstallationToken(ctx, installationId)
+		return "token " + token, err
+	})
+}
+
+// Gets the logins of the members of an organization's team, as seen by the installation
+func (client *Client) TeamMembers(ctx context.Context, installationId int64, org string, teamSlug string) ([]string, error) {
+	githubClient := client.Installation(installationId)
+
+	req, err := githubClient.NewRequest("GET", fmt.Sprintf("orgs/%s/teams/%s/members", org, teamSlug), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*github.User
+	if _, err = githubClient.Do(ctx, req, &members); err != nil {
+		return nil, fmt.Errorf("failed to list members of team %s/%s (%w)", org, teamSlug, err)
+	}
+
+	logins := make([]string, 0, len(members))
+	for _, member := range members {
+		logins = append(logins, member.GetLogin())
+	}
+
+	return logins, nil
+}
+
+func (client *Client) newGithubClient(authorization func(context.Context) (string, error)) *github.Client {
+	githubClient := github.NewClient(&http.Client{Transport: &authTransport{authorization: authorization}})
+	githubClient.BaseURL = client.baseURL
+
+	return githubClient
+}
+
+type authTransport struct {
+	authorization func(context.Context) (string, error)
+}
+
+func (transport *authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	authorization, err := transport.authorization(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", authorization)
+
+	return http.DefaultTransport.RoundTrip(r)
+}
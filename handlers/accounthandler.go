@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"push-request/logging"
+	"push-request/storage"
+)
+
+// A `github_app_authorization` webhook, sent when a user revokes their authorization of the GitHub App. The version
+// of go-github in use doesn't know this event, so it is decoded here
+type authorizationEvent struct {
+	Action string `json:"action"`
+	Sender struct {
+		Id int64 `json:"id"`
+	} `json:"sender"`
+}
+
+// Deletes the user who revoked their authorization of the GitHub App, since they no longer use the app. Users who
+// aren't registered are ignored
+func (server *Server) handleAuthorizationEvent(ctx context.Context, event *authorizationEvent) error {
+	if event.Action != "revoked" {
+		return nil
+	}
+
+	err := storage.DeleteUser(ctx, server.Stores, event.Sender.Id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to delete github id %d (%w)", event.Sender.Id, err)
+	}
+
+	logging.FromContext(ctx).Info("deleted user after they revoked their authorization", "user_id", event.Sender.Id)
+	return nil
+}
+
+// Deletes the User, with their devices, events, subscriptions and installation links
+func (server *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	err := storage.DeleteUser(r.Context(), server.Stores, user.GithubId)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "User not found")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle DELETE user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns every piece of data held about the User, as a JSON archive
+func (server *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	export, err := storage.ExportUser(r.Context(), server.Stores, user.GithubId)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "User not found")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle GET export", err)
+		return
+	}
+
+	bytes, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		writeInternalError(w, r, "handle GET export", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="push-request-export.json"`)
+
+	if _, err = w.Write(bytes); err != nil {
+		requestLogger(w, r).Warn("write export", "error", err)
+	}
+}
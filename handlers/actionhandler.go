@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"push-request/actions"
+)
+
+// Performs an action chosen from a notification through the GitHub REST API, with the user's token given in the
+// `X-Github-Token` header. The Authorization header alone doesn't prove who the caller is, so the action is never
+// performed as the GitHub App. The result is returned, and also reported to the user's devices as a follow-up
+// notification
+func (server *Server) handleAction(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	var request actions.Request
+
+	err := decodeBody(r, &request)
+	if err == nil {
+		err = request.Validate()
+	}
+
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	token := r.Header.Get(githubTokenHeader)
+	if token == "" {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "An X-Github-Token header is required")
+		return
+	}
+
+	client, err := actions.NewClient(token, server.GithubBaseURL)
+	if err != nil {
+		writeInternalError(w, r, "handle POST action", err)
+		return
+	}
+
+	result, actionErr := actions.Perform(r.Context(), client, &request)
+	if actionErr != nil {
+		requestLogger(w, r).Warn("perform action", "user_id", user.GithubId, "error", actionErr)
+		result = actionErr.Error()
+	}
+
+	for _, device := range user.ListDevices() {
+		if err = server.sendActionResultNotification(r.Context(), &device, &request, result); err != nil {
+			requestLogger(w, r).Error("send action result notification", "user_id", user.GithubId, "error", err)
+		}
+	}
+
+	// The result of a failed action is GitHub's reason, which the user needs to see
+	if actionErr != nil {
+		writeError(w, r, http.StatusBadGateway, CodeUpstreamError, result)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]string{"result": result})
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"push-request/logging"
+	"push-request/models"
+	"push-request/router"
+	"push-request/storage"
+	"strings"
+)
+
+// The prefix of the routes of the admin API, which operators call with an API key
+const adminPrefix = "/admin"
+
+// The route parameters that identify what an admin request is about, with the prefix of its target in the audit log
+var auditTargets = []struct {
+	param  string
+	prefix string
+}{
+	{"github_id", "user:"},
+	{"installation_id", "installation:"},
+}
+
+// Gets the APIKey in the `Authorization` header, given as `Bearer <key>`, or responds with an error and returns nil
+func (server *Server) authenticateAPIKey(w http.ResponseWriter, r *http.Request) *models.APIKey {
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+
+	keyId, ok := models.ParseAPIKeyId(token)
+	if !ok || token == header {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "The Authorization header must be a bearer API key")
+		return nil
+	}
+
+	key, err := server.Stores.APIKeys.Get(r.Context(), keyId)
+	if errors.Is(err, storage.ErrNotFound) || err == nil && !key.Matches(token) {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid API key")
+		return nil
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "authenticate API key", err)
+		return nil
+	}
+
+	return key
+}
+
+// Authenticates the requests of an admin route with an API key, and only lets keys whose role includes the role
+// through. Every authenticated request is recorded in the audit log as the action, with the status it was responded
+// to with, whether or not it was allowed
+func (server *Server) requireAPIKey(role models.Role, action string) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := server.authenticateAPIKey(w, r)
+			if key == nil {
+				return
+			}
+
+			entry := &models.AuditEntry{
+				KeyId:     key.KeyId,
+				KeyName:   key.Name,
+				Role:      key.Role,
+				Action:    action,
+				Target:    auditTarget(r),
+				RequestId: requestId(w, r),
+			}
+
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				body, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					writeRequestError(w, r, errors.New("the request body couldn't be read"))
+					return
+				}
+
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+				entry.Body = string(bytes.TrimSpace(body))
+			}
+
+			recorder := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				// recoverPanics responds with an internal error once the panic reaches it
+				if recovered := recover(); recovered != nil {
+					server.audit(r, entry, http.StatusInternalServerError)
+					panic(recovered)
+				}
+
+				server.audit(r, entry, recorder.status)
+			}()
+
+			if !key.Role.Includes(role) {
+				writeError(recorder, r, http.StatusForbidden, CodeForbidden, "The role of the API key doesn't allow this action")
+				return
+			}
+
+			next.ServeHTTP(recorder, r)
+		})
+	}
+}
+
+// Gets the user or installation an admin request is about, like `user:1`, or "" if it isn't about one
+func auditTarget(r *http.Request) string {
+	for _, target := range auditTargets {
+		if value := router.Param(r, target.param); value != "" {
+			return target.prefix + value
+		}
+	}
+
+	return ""
+}
+
+// Records the admin request in the audit log and logs it. The action was already taken, so an entry that can't be
+// stored is only logged, with everything it would have recorded
+func (server *Server) audit(r *http.Request, entry *models.AuditEntry, status int) {
+	entry.Status = status
+
+	logger := logging.FromContext(r.Context()).With("key_id", entry.KeyId, "key_name", entry.KeyName,
+		"action", entry.Action, "target", entry.Target, "status", status)
+
+	// The request may have been canceled once responded to, but the entry must still be stored
+	if err := server.Stores.Audit.Create(context.Background(), entry); err != nil {
+		logger.Error("failed to record admin action", "error", err, "body", entry.Body)
+		return
+	}
+
+	logger.Info("admin action")
+}
+
+// An auditRecorder records the status of the response to an admin request
+type auditRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (recorder *auditRecorder) WriteHeader(status int) {
+	if !recorder.wroteHeader {
+		recorder.status = status
+		recorder.wroteHeader = true
+	}
+
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *auditRecorder) Write(bytes []byte) (int, error) {
+	recorder.wroteHeader = true
+	return recorder.ResponseWriter.Write(bytes)
+}
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"push-request/models"
+	"push-request/router"
+	"push-request/storage"
+	"strconv"
+)
+
+// How many records the admin API lists at a time, unless asked for fewer
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 100
+)
+
+// The message of test pushes sent without one
+const defaultTestPushMessage = "This is a test notification from Push Request"
+
+// A User as operators see it, with the details of their devices. Their feed token is left out, since it would let
+// operators read their feed
+type adminUser struct {
+	*models.User
+	Devices       []models.Device       `json:"devices"`
+	Installations []models.Installation `json:"installations,omitempty"`
+}
+
+func newAdminUser(user *models.User) adminUser {
+	withoutSecrets := *user
+	withoutSecrets.FeedToken = ""
+
+	return adminUser{User: &withoutSecrets, Devices: user.ListDevices()}
+}
+
+// Gets a route parameter that the OpenAPI document requires to be an integer
+func intParam(r *http.Request, name string) int64 {
+	value, _ := strconv.ParseInt(router.Param(r, name), 10, 64)
+	return value
+}
+
+// Gets the integer query parameter, or 0 when it isn't given. An invalid value is responded to with a ValidationError,
+// and false is returned
+func queryInt(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, true
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		writeRequestError(w, r, &ValidationError{Details: []FieldError{{Field: name, Message: "must be a whole number"}}})
+		return 0, false
+	}
+
+	return parsed, true
+}
+
+// Gets how many records to list from the `limit` query parameter, which is at most maxAdminPageSize
+func pageLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return defaultAdminPageSize
+	}
+
+	if limit > maxAdminPageSize {
+		return maxAdminPageSize
+	}
+
+	return limit
+}
+
+// Gets the User of the `github_id` route parameter, or responds with an error and returns nil
+func (server *Server) adminUser(w http.ResponseWriter, r *http.Request, context string) *models.User {
+	user, err := server.Stores.Users.Get(r.Context(), intParam(r, "github_id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "User not found")
+		return nil
+	}
+
+	if err != nil {
+		writeInternalError(w, r, context, err)
+		return nil
+	}
+
+	return user
+}
+
+// Lists Users by github id, a page at a time, optionally only the User with the device given as `device_token`, or
+// only those who are disabled or not
+func (server *Server) handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	userQuery := storage.UserQuery{DeviceToken: query.Get("device_token"), Limit: pageLimit(r)}
+
+	var ok bool
+	if userQuery.After, ok = queryInt(w, r, "after"); !ok {
+		return
+	}
+
+	if value := query.Get("disabled"); value != "" {
+		disabled, err := strconv.ParseBool(value)
+		if err != nil {
+			writeRequestError(w, r, &ValidationError{Details: []FieldError{{Field: "disabled", Message: "must be a boolean"}}})
+			return
+		}
+
+		userQuery.Disabled = &disabled
+	}
+
+	users, err := server.Stores.Users.List(r.Context(), userQuery)
+	if err != nil {
+		writeInternalError(w, r, "handle admin list users", err)
+		return
+	}
+
+	res := make([]adminUser, len(users))
+	for i := range users {
+		res[i] = newAdminUser(&users[i])
+	}
+
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// Gets a User with their devices and the installations linked to them
+func (server *Server) handleAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	user := server.adminUser(w, r, "handle admin get user")
+	if user == nil {
+		return
+	}
+
+	res := newAdminUser(user)
+
+	installations, err := server.Stores.Installations.ListByGithubId(r.Context(), user.GithubId)
+	if err != nil {
+		writeInternalError(w, r, "handle admin get user", err)
+		return
+	}
+
+	res.Installations = installations
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// Lists the Devices of a User
+func (server *Server) handleAdminGetDevices(w http.ResponseWriter, r *http.Request) {
+	if user := server.adminUser(w, r, "handle admin get devices"); user != nil {
+		writeJSON(w, r, http.StatusOK, user.ListDevices())
+	}
+}
+
+// Lists the most recent events delivered to a User, newest first
+func (server *Server) handleAdminGetEvents(w http.ResponseWriter, r *http.Request) {
+	user := server.adminUser(w, r, "handle admin get events")
+	if user == nil {
+		return
+	}
+
+	events, err := server.Stores.Events.List(r.Context(), user.GithubId, nil, pageLimit(r))
+	if err != nil {
+		writeInternalError(w, r, "handle admin get events", err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, events)
+}
+
+// A test push to the devices of a User, or only to the device given as `device_token`
+type testPushRequest struct {
+	DeviceToken string `json:"device_token"`
+	Message     string `json:"message"`
+}
+
+// The outcome of sending a test push to a device
+type testPushResult struct {
+	Token string `json:"token"`
+	Sent  bool   `json:"sent"`
+	Error string `json:"error,omitempty"`
+}
+
+// Sends a test push to the devices of a User, even if they are disabled, and reports whether APNs accepted each one.
+// The request body is optional
+func (server *Server) handleAdminTestPush(w http.ResponseWriter, r *http.Request) {
+	var request testPushRequest
+
+	if r.ContentLength != 0 {
+		if err := decodeBody(r, &request); err != nil {
+			writeRequestError(w, r, err)
+			return
+		}
+	}
+
+	if request.Message == "" {
+		request.Message = defaultTestPushMessage
+	}
+
+	user := server.adminUser(w, r, "handle admin test push")
+	if user == nil {
+		return
+	}
+
+	devices := user.ListDevices()
+
+	if request.DeviceToken != "" {
+		var selected []models.Device
+		for _, device := range devices {
+			if device.Token == request.DeviceToken {
+				selected = append(selected, device)
+			}
+		}
+
+		if len(selected) == 0 {
+			writeNotFound(w, r, "Device not found")
+			return
+		}
+
+		devices = selected
+	}
+
+	res := make([]testPushResult, len(devices))
+
+	for i := range devices {
+		res[i] = testPushResult{Token: devices[i].Token, Sent: true}
+
+		if err := server.sendTestNotification(r.Context(), &devices[i], request.Message); err != nil {
+			res[i] = testPushResult{Token: devices[i].Token, Error: err.Error()}
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, res)
+}
+
+// Disables or enables a User, responding with the updated User
+func (server *Server) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	user, err := storage.UpdateUser(r.Context(), server.Stores.Users, intParam(r, "github_id"), func(user *models.User) error {
+		user.Disabled = disabled
+		return nil
+	})
+
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "User not found")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle admin set disabled", err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, newAdminUser(user))
+}
+
+// Disables a User, who can't use the API anymore and isn't sent notifications, until they are enabled again. Their
+// data is kept
+func (server *Server) handleAdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	server.setDisabled(w, r, true)
+}
+
+// Enables a User who was disabled
+func (server *Server) handleAdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	server.setDisabled(w, r, false)
+}
+
+// Lists installations of the GitHub App by installation id, a page at a time, or only those linked to the User given
+// as `github_id`
+func (server *Server) handleAdminListInstallations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	githubId, ok := queryInt(w, r, "github_id")
+	if !ok {
+		return
+	}
+
+	after, ok := queryInt(w, r, "after")
+	if !ok {
+		return
+	}
+
+	var installations []models.Installation
+	var err error
+
+	if query.Get("github_id") != "" {
+		installations, err = server.Stores.Installations.ListByGithubId(r.Context(), githubId)
+	} else {
+		installations, err = server.Stores.Installations.List(r.Context(), after, pageLimit(r))
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle admin list installations", err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, installations)
+}
+
+func (server *Server) writeInstallation(w http.ResponseWriter, r *http.Request, context string) {
+	installation, err := server.Stores.Installations.Get(r.Context(), intParam(r, "installation_id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "Installation not found")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, context, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, installation)
+}
+
+// Gets an installation of the GitHub App
+func (server *Server) handleAdminGetInstallation(w http.ResponseWriter, r *http.Request) {
+	server.writeInstallation(w, r, "handle admin get installation")
+}
+
+// The User an installation is linked to instead
+type relinkRequest struct {
+	GithubId int64 `json:"github_id"`
+}
+
+// Links an installation of the GitHub App to another User, such as when it was linked to the wrong account, and
+// responds with the installation. The User doesn't need to be registered yet
+func (server *Server) handleAdminRelinkInstallation(w http.ResponseWriter, r *http.Request) {
+	var request relinkRequest
+
+	err := decodeBody(r, &request)
+	if err == nil {
+		var v validator
+		v.Check(request.GithubId > 0, "github_id", "must be a github id")
+		err = v.Err()
+	}
+
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	err = server.Stores.Installations.Link(r.Context(), intParam(r, "installation_id"), request.GithubId)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "Installation not found")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle admin relink installation", err)
+		return
+	}
+
+	server.writeInstallation(w, r, "handle admin relink installation")
+}
+
+// Lists the most recent entries of the audit log, newest first, a page at a time. Pages after the first start
+// `before` the last entry of the page before
+func (server *Server) handleAdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	before := primitive.NilObjectID
+
+	if value := r.URL.Query().Get("before"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			writeRequestError(w, r, &ValidationError{Details: []FieldError{{Field: "before", Message: "must be the id of an entry"}}})
+			return
+		}
+
+		before = id
+	}
+
+	entries, err := server.Stores.Audit.List(r.Context(), before, pageLimit(r))
+	if err != nil {
+		writeInternalError(w, r, "handle admin audit log", err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, entries)
+}
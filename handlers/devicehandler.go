@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"push-request/models"
+	"push-request/storage"
+)
+
+// The longest device name accepted
+const maxDeviceNameLength = 100
+
+// The longest app version and locale accepted
+const maxDeviceDetailLength = 50
+
+// Checks the device, reporting its fields under the prefix
+func validateDevice(v *validator, prefix string, device *models.Device) {
+	validateDeviceToken(v, prefix+".token", device.Token)
+
+	switch device.Platform {
+	case "", models.PlatformIOS, models.PlatformIPadOS, models.PlatformMacOS:
+	default:
+		v.Check(false, prefix+".platform", "unknown platform %q", device.Platform)
+	}
+
+	switch device.Environment {
+	case "", models.APNSProduction, models.APNSSandbox:
+	default:
+		v.Check(false, prefix+".environment", "unknown APNs environment %q", device.Environment)
+	}
+
+	v.Check(len(device.Name) <= maxDeviceNameLength, prefix+".name", "must be at most %d characters",
+		maxDeviceNameLength)
+	v.Check(len(device.AppVersion) <= maxDeviceDetailLength, prefix+".app_version", "must be at most %d characters",
+		maxDeviceDetailLength)
+	v.Check(len(device.Locale) <= maxDeviceDetailLength, prefix+".locale", "must be at most %d characters",
+		maxDeviceDetailLength)
+}
+
+// Event types that may be given as null, which is told apart from leaving them out
+type optionalEventTypes struct {
+	Set   bool
+	Types []models.EventType
+}
+
+func (types *optionalEventTypes) UnmarshalJSON(data []byte) error {
+	types.Set = true
+	return json.Unmarshal(data, &types.Types)
+}
+
+// Changes to a device. Fields left out are left unchanged. `allowed_types` overrides the allowed types of the User on
+// this device, and null removes the override
+type deviceRequest struct {
+	Token        string             `json:"token"`
+	Name         *string            `json:"name"`
+	AllowedTypes optionalEventTypes `json:"allowed_types"`
+}
+
+func (request *deviceRequest) validate() error {
+	var v validator
+
+	validateDeviceToken(&v, "token", request.Token)
+
+	if request.Name != nil {
+		v.Check(len(*request.Name) <= maxDeviceNameLength, "name", "must be at most %d characters",
+			maxDeviceNameLength)
+	}
+
+	v.eventTypes("allowed_types", request.AllowedTypes.Types)
+
+	return v.Err()
+}
+
+// Lists the Devices of the User
+func (server *Server) handleGetDevices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, currentUser(r).ListDevices())
+}
+
+// Renames one of the User's Devices or changes its allowed types. The device is given as `token`, with its changes,
+// in the request body
+func (server *Server) handlePatchDevice(w http.ResponseWriter, r *http.Request) {
+	var request deviceRequest
+
+	err := decodeBody(r, &request)
+	if err == nil {
+		err = request.validate()
+	}
+
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	_, err = storage.UpdateUser(r.Context(), server.Stores.Users, currentUser(r).GithubId, func(user *models.User) error {
+		if !user.HasDevice(request.Token) {
+			return storage.ErrNotFound
+		}
+
+		device := user.Devices[request.Token]
+
+		if request.Name != nil {
+			device.Name = *request.Name
+		}
+
+		if request.AllowedTypes.Set {
+			device.AllowedTypes = request.AllowedTypes.Types
+		}
+
+		if user.Devices == nil {
+			user.Devices = map[string]models.Device{}
+		}
+
+		user.Devices[request.Token] = device
+		return nil
+	})
+
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "Device not found")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle PATCH device", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Removes one of the User's Devices, given as `token` in the query
+func (server *Server) handleDeleteDevice(w http.ResponseWriter, r *http.Request) {
+	request := deviceRequest{Token: r.URL.Query().Get("token")}
+
+	if err := request.validate(); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	err := server.Stores.Users.RemoveDevice(r.Context(), currentUser(r).GithubId, request.Token)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "Device not found")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle DELETE device", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
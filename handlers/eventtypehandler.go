@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"push-request/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type eventCategoryResponse struct {
+	Id    models.EventCategory `json:"id"`
+	Label string               `json:"label"`
+}
+
+// The notification an event type is delivered as, laid out like the APNs alert
+type sampleNotification struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle"`
+	Body     string `json:"body"`
+}
+
+type eventTypeResponse struct {
+	Type        models.EventType     `json:"type"`
+	Category    models.EventCategory `json:"category"`
+	Label       string               `json:"label"`
+	Description string               `json:"description"`
+	Sample      sampleNotification   `json:"sample_notification"`
+	DefaultOn   bool                 `json:"default_on"`
+}
+
+type eventTypesResponse struct {
+	Language   string                  `json:"language"`
+	Categories []eventCategoryResponse `json:"categories"`
+	EventTypes []eventTypeResponse     `json:"event_types"`
+}
+
+// Chooses the language to respond in from the `lang` query parameter, or else the `Accept-Language` header,
+// falling back to models.DefaultLanguage
+func negotiateLanguage(r *http.Request) string {
+	if language := primaryLanguage(r.URL.Query().Get("lang")); supportsLanguage(language) {
+		return language
+	}
+
+	type candidate struct {
+		language string
+		quality  float64
+	}
+
+	var candidates []candidate
+
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		quality := 1.0
+
+		for _, param := range fields[1:] {
+			if q := strings.TrimPrefix(strings.TrimSpace(param), "q="); q != param {
+				if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+					quality = parsed
+				}
+			}
+		}
+
+		if fields[0] != "" && quality > 0 {
+			candidates = append(candidates, candidate{fields[0], quality})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	for _, candidate := range candidates {
+		if language := primaryLanguage(candidate.language); supportsLanguage(language) {
+			return language
+		}
+	}
+
+	return models.DefaultLanguage
+}
+
+// Gets the language of a tag such as `de-CH`, or `de_CH` as iOS formats locales
+func primaryLanguage(tag string) string {
+	return strings.ToLower(strings.SplitN(strings.ReplaceAll(tag, "_", "-"), "-", 2)[0])
+}
+
+func supportsLanguage(language string) bool {
+	for _, supported := range models.Languages {
+		if supported == language {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lists every event type with its category, label, description, sample notification and whether it is on by
+// default, so the app can build its settings without a release for each new type. Text is localized to the language
+// negotiated by negotiateLanguage
+func (server *Server) handleEventTypes(w http.ResponseWriter, r *http.Request) {
+	language := negotiateLanguage(r)
+	response := eventTypesResponse{Language: language}
+
+	for _, category := range models.EventCategories {
+		response.Categories = append(response.Categories, eventCategoryResponse{
+			Id:    category,
+			Label: category.Label().In(language),
+		})
+	}
+
+	for _, info := range models.EventTypeCatalog {
+		response.EventTypes = append(response.EventTypes, eventTypeResponse{
+			Type:        info.Type,
+			Category:    info.Category,
+			Label:       info.Label.In(language),
+			Description: info.Description.In(language),
+			Sample: sampleNotification{
+				Title:    info.Sample.RepoName,
+				Subtitle: info.Sample.Title,
+				Body:     info.Sample.Description,
+			},
+			DefaultOn: info.DefaultOn,
+		})
+	}
+
+	w.Header().Set("Content-Language", language)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	writeJSON(w, r, http.StatusOK, response)
+}
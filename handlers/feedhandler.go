@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"push-request/feeds"
+	"push-request/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const feedLength = 50
+
+func isNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// Identifies the feed by a hash of the User and the repositories it is narrowed to, so that it is the same however
+// its query is written and the token isn't revealed to feed readers and aggregators
+func feedID(githubId int64, repos []string) string {
+	sorted := append([]string{}, repos...)
+	sort.Strings(sorted)
+
+	key := strconv.FormatInt(githubId, 10)
+	for i, repo := range sorted {
+		if i == 0 || repo != sorted[i-1] {
+			key += "\n" + repo
+		}
+	}
+
+	checksum := sha256.Sum256([]byte(key))
+	return "urn:push-request:feed:" + hex.EncodeToString(checksum[:])
+}
+
+// The URL of the feed, which feed readers follow to refresh it, so it keeps the token. Requests are logged and
+// traced without their query, so the token doesn't end up there
+func feedSelfURL(r *http.Request) string {
+	self := url.URL{Scheme: "https", Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.Query().Encode()}
+	return self.String()
+}
+
+// Gets the Atom or RSS feed of the events delivered to the User owning the secret `token` query parameter.
+// The feed can be narrowed to specific repositories with one or more `repo` query parameters
+func (server *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	token := query.Get("token")
+	if token == "" {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Missing feed token")
+		return
+	}
+
+	user, err := server.Stores.Users.GetByFeedToken(r.Context(), token)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid feed token")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle GET feed", err)
+		return
+	}
+
+	if user.Disabled {
+		writeAccountDisabled(w, r)
+		return
+	}
+
+	events, err := server.Stores.Events.List(r.Context(), user.GithubId, query["repo"], feedLength)
+	if err != nil {
+		writeInternalError(w, r, "handle GET feed", err)
+		return
+	}
+
+	feed := &feeds.Feed{
+		ID:      feedID(user.GithubId, query["repo"]),
+		Title:   "Push Request",
+		SelfURL: feedSelfURL(r),
+		Events:  events,
+	}
+
+	var body []byte
+	var contentType string
+
+	if strings.HasSuffix(r.URL.Path, ".rss") {
+		body, err = feed.RSS()
+		contentType = "application/rss+xml; charset=utf-8"
+	} else {
+		body, err = feed.Atom()
+		contentType = "application/atom+xml; charset=utf-8"
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle GET feed", err)
+		return
+	}
+
+	checksum := sha1.Sum(body)
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(checksum[:]))
+	lastModified := feed.Updated()
+
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if isNotModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if _, err = w.Write(body); err != nil {
+		requestLogger(w, r).Warn("write feed", "error", err)
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"push-request/buildinfo"
+	"push-request/logging"
+	"push-request/models"
+	"push-request/parsers"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// How long the database is given to answer the readiness check
+const pingTimeout = 2 * time.Second
+
+const (
+	checkOK      = "ok"
+	checkFailing = "failing"
+)
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+type checkResponse struct {
+	Name    string                 `json:"name"`
+	Status  string                 `json:"status"`
+	Message string                 `json:"message,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type readinessResponse struct {
+	Status string          `json:"status"`
+	Checks []checkResponse `json:"checks"`
+}
+
+type versionResponse struct {
+	Commit       string   `json:"commit"`
+	BuildTime    string   `json:"build_time"`
+	GoVersion    string   `json:"go_version"`
+	EventParsers []string `json:"event_parsers"`
+}
+
+// Marks the server as shutting down, so the readiness check fails and load balancers stop sending it requests
+func (server *Server) Drain() {
+	atomic.StoreInt32(&server.draining, 1)
+}
+
+func (server *Server) isDraining() bool {
+	return atomic.LoadInt32(&server.draining) == 1
+}
+
+// Reports that the process is alive, for liveness checks. It doesn't depend on the database, so a database outage
+// doesn't get every replica restarted
+func (server *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, healthResponse{Status: checkOK})
+}
+
+func (server *Server) checkStorage(ctx context.Context) checkResponse {
+	check := checkResponse{Name: server.Stores.Backend, Status: checkOK}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := server.Stores.Ping(ctx)
+	check.Details = map[string]interface{}{"latency_ms": time.Since(start).Milliseconds()}
+
+	if err != nil {
+		// The error may name the database's hosts, so it is only logged
+		logging.FromContext(ctx).Warn("readiness check failed", "check", check.Name, "error", err)
+
+		check.Status = checkFailing
+		check.Message = "The database can't be reached"
+	}
+
+	return check
+}
+
+func (server *Server) checkAPNS() checkResponse {
+	check := checkResponse{Name: "apns", Status: checkOK}
+
+	if _, err := server.apnsClient(&models.Device{}); err != nil || server.APNSTopic == "" {
+		check.Status = checkFailing
+		check.Message = "The APNs client isn't configured"
+	}
+
+	return check
+}
+
+func (server *Server) checkQueue() checkResponse {
+	pending := server.Broker.Pending()
+
+	check := checkResponse{
+		Name:    "queue",
+		Status:  checkOK,
+		Details: map[string]interface{}{"pending_events": pending, "max_pending_events": server.MaxPendingEvents},
+	}
+
+	if pending > server.MaxPendingEvents {
+		check.Status = checkFailing
+		check.Message = "Too many events are waiting to be written to stream clients"
+	}
+
+	return check
+}
+
+func (server *Server) checkShutdown() checkResponse {
+	check := checkResponse{Name: "shutdown", Status: checkOK}
+
+	if server.isDraining() {
+		check.Status = checkFailing
+		check.Message = "The server is shutting down"
+	}
+
+	return check
+}
+
+// Reports whether the server can handle requests, for readiness checks and load balancers: the database can be
+// reached, the APNs client is configured, events aren't piling up for stream clients and the server isn't shutting
+// down. Responds 503 with the failing checks otherwise
+func (server *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	response := readinessResponse{
+		Status: "ready",
+		Checks: []checkResponse{server.checkStorage(r.Context()), server.checkAPNS(), server.checkQueue(), server.checkShutdown()},
+	}
+
+	status := http.StatusOK
+
+	for _, check := range response.Checks {
+		if check.Status != checkOK {
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, r, status, response)
+}
+
+// Reports the commit and time the server was built from, and the webhook events it parses into notifications
+func (server *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, versionResponse{
+		Commit:       buildinfo.Commit,
+		BuildTime:    buildinfo.BuildTime,
+		GoVersion:    runtime.Version(),
+		EventParsers: parsers.ParsedEvents,
+	})
+}
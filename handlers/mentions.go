@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"push-request/logging"
+	"push-request/metrics"
+	"push-request/models"
+	"push-request/parsers"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mentions are resolved while GitHub waits for the webhook to be answered, which it gives up on after 10 seconds, so
+// resolving them is bounded in time and in the number of users looked up
+const (
+	mentionTimeout       = 5 * time.Second
+	maxMentionedLogins   = 100
+	mentionLookupWorkers = 8
+)
+
+// Resolves the github ids of the users mentioned, expanding team mentions into their members. The author of the
+// mention is left out, and logins past maxMentionedLogins or not resolved within mentionTimeout are dropped
+func (server *Server) mentionedGithubIds(ctx context.Context, mention *parsers.Mention) []int64 {
+	ctx, cancel := context.WithTimeout(ctx, mentionTimeout)
+	defer cancel()
+
+	installationId := mention.Event.InstallationId
+	logins := append([]string{}, mention.Logins...)
+
+	for _, team := range mention.Teams {
+		parts := strings.SplitN(team, "/", 2)
+
+		members, err := server.GithubApp.TeamMembers(ctx, installationId, parts[0], parts[1])
+		if err != nil {
+			// The team may not be visible to the installation
+			logging.FromContext(ctx).Warn("resolve mention of team", "team", team, "error", err)
+			continue
+		}
+
+		logins = append(logins, members...)
+	}
+
+	seen := map[string]bool{strings.ToLower(mention.Author): true}
+
+	var unique []string
+	for _, login := range logins {
+		if !seen[strings.ToLower(login)] {
+			seen[strings.ToLower(login)] = true
+			unique = append(unique, login)
+		}
+	}
+
+	if len(unique) > maxMentionedLogins {
+		logging.FromContext(ctx).Warn("too many users mentioned", "mentioned", len(unique), "resolved", maxMentionedLogins)
+		unique = unique[:maxMentionedLogins]
+	}
+
+	client := server.GithubApp.Installation(installationId)
+	githubIds := make([]int64, len(unique))
+
+	// The workers take the index of the next login to resolve
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	for i := 0; i < mentionLookupWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range next {
+				githubUser, _, err := client.Users.Get(ctx, unique[i])
+				if err != nil {
+					// Not every mention refers to an existing user
+					logging.FromContext(ctx).Warn("resolve mention of user", "login", unique[i], "error", err)
+					continue
+				}
+
+				githubIds[i] = githubUser.GetID()
+			}
+		}()
+	}
+
+	for i := range unique {
+		next <- i
+	}
+
+	close(next)
+	wg.Wait()
+
+	var resolved []int64
+	for _, githubId := range githubIds {
+		if githubId != 0 {
+			resolved = append(resolved, githubId)
+		}
+	}
+
+	return resolved
+}
+
+// Delivers a "mentioned you" event to every registered user mentioned who allows them and hasn't muted the thread,
+// unless the user was already delivered an event for the same webhook. Mentions subscribe the user to the thread
+func (server *Server) deliverMention(ctx context.Context, mention *parsers.Mention, delivered map[int64]bool) error {
+	if server.GithubApp == nil {
+		logging.FromContext(ctx).Warn("a GitHub App is required to resolve mentions")
+		return nil
+	}
+
+	for _, githubId := range server.mentionedGithubIds(ctx, mention) {
+		if delivered[githubId] {
+			continue
+		}
+
+		user, err := server.Stores.Users.Get(ctx, githubId)
+		if err != nil {
+			continue
+		}
+
+		if !user.AllowsEventType(models.Mentioned) {
+			server.filtered(metrics.FilterTypeNotAllowed)
+			continue
+		}
+
+		if server.isMuted(ctx, githubId, mention.Event) {
+			server.filtered(metrics.FilterMuted)
+			continue
+		}
+
+		event := *mention.Event
+		if err = server.deliverEvent(ctx, user, &event, user.DevicesAllowing(models.Mentioned)); err != nil {
+			return fmt.Errorf("failed to deliver mention to github id %d (%w)", githubId, err)
+		}
+
+		if err = server.Stores.Subscriptions.Subscribe(ctx, githubId, event.RepoName, event.Number, models.ReasonMentioned); err != nil {
+			return fmt.Errorf("failed to subscribe github id %d to %s#%d (%w)", githubId, event.RepoName, event.Number, err)
+		}
+
+		delivered[githubId] = true
+	}
+
+	return nil
+}
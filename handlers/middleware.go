@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"push-request/actions"
+	"push-request/models"
+	"push-request/tracing"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The header of the GitHub token that proves who the caller is
+const githubTokenHeader = "X-Github-Token"
+
+// How long GitHub is given to tell who a token belongs to
+const githubTokenTimeout = 5 * time.Second
+
+// Probes are requested every few seconds by load balancers and Prometheus, so their requests aren't logged
+var unloggedRoutes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/version": true,
+	"/metrics": true,
+}
+
+// Times and traces the requests of a route by its pattern
+func (server *Server) instrument(pattern string, next http.Handler) http.Handler {
+	return server.Metrics.InstrumentRoute(pattern, tracing.Handler(pattern, next).ServeHTTP)
+}
+
+// Gives every request an id, set on the response, and logs that it was received with logRequest
+func (server *Server) logRequests(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unloggedRoutes[pattern] {
+			requestId(w, r)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, logRequest(w, r))
+	})
+}
+
+// Limits how much of the body of a request is read, so a client can't exhaust the server's memory. Reading past the
+// limit fails, which decodeBody reports as errBodyTooLarge. Webhooks carry whole pull requests, so they are allowed
+// MaxWebhookBytes instead of MaxBodyBytes
+func (server *Server) limitBody(pattern string, next http.Handler) http.Handler {
+	limit := server.MaxBodyBytes
+	if pattern == webhookRoute {
+		limit = server.MaxWebhookBytes
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Responds with an internal error when a handler panics, logging the panic with its stack instead of letting it close
+// the connection. http.ErrAbortHandler is a deliberate abort, so it is left to the server
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			requestLogger(w, r).Error("handler panicked", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+			writeError(w, r, http.StatusInternalServerError, CodeInternalError, "An internal error occurred")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The headers browsers may send cross-origin, and read from responses
+const (
+	corsAllowedHeaders = "Authorization, Content-Type, If-Match, If-None-Match, Last-Event-ID, X-Github-Token, X-Request-Id"
+	corsExposedHeaders = "ETag, X-Request-Id"
+)
+
+func (server *Server) allowsOrigin(origin string) bool {
+	for _, allowed := range server.CORSOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lets the browsers of CORSOrigins call the API. Preflight requests are answered with the methods the route handles,
+// which the router sets in the `Allow` header before responding that OPTIONS isn't allowed
+func (server *Server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		if !server.allowsOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		allow := w.Header().Get("Allow")
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" || allow == "" {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Del("Allow")
+		w.Header().Set("Access-Control-Allow-Methods", allow)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Compresses responses with gzip for clients that accept it. Whether a response is compressed is decided when its
+// status is written, from its headers, so that event streams, responses that are already encoded and responses
+// without a body are written as they are
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writer := &gzipWriter{ResponseWriter: w}
+		defer writer.close()
+
+		next.ServeHTTP(writer, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(encoding, ";")
+		if strings.TrimSpace(fields[0]) != "gzip" {
+			continue
+		}
+
+		return len(fields) == 1 || strings.ReplaceAll(fields[1], " ", "") != "q=0"
+	}
+
+	return false
+}
+
+// Reports whether responses with the content type are worth compressing
+func isCompressible(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+xml"), strings.HasSuffix(mediaType, "+json"):
+		return true
+	default:
+		return false
+	}
+}
+
+// A gzipWriter compresses the body of a response when its headers allow it. Event streams flush their responses or
+// hijack their connections, so it passes both through
+type gzipWriter struct {
+	http.ResponseWriter
+	gzip        *gzip.Writer
+	wroteHeader bool
+}
+
+func (writer *gzipWriter) WriteHeader(status int) {
+	if writer.wroteHeader {
+		writer.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	writer.wroteHeader = true
+	header := writer.Header()
+
+	if status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified &&
+		header.Get("Content-Encoding") == "" && isCompressible(header.Get("Content-Type")) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		writer.gzip = gzip.NewWriter(writer.ResponseWriter)
+	}
+
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *gzipWriter) Write(bytes []byte) (int, error) {
+	if !writer.wroteHeader {
+		if writer.Header().Get("Content-Type") == "" {
+			writer.Header().Set("Content-Type", http.DetectContentType(bytes))
+		}
+
+		writer.WriteHeader(http.StatusOK)
+	}
+
+	if writer.gzip != nil {
+		return writer.gzip.Write(bytes)
+	}
+
+	return writer.ResponseWriter.Write(bytes)
+}
+
+func (writer *gzipWriter) Flush() {
+	if writer.gzip != nil {
+		_ = writer.gzip.Flush()
+	}
+
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (writer *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := writer.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer can't be hijacked")
+	}
+
+	return hijacker.Hijack()
+}
+
+// Writes the end of the compressed body
+func (writer *gzipWriter) close() {
+	if writer.gzip != nil {
+		_ = writer.gzip.Close()
+	}
+}
+
+type userKey struct{}
+
+// Authenticates the requests of a route, responding with an error to those without a registered User, and limits the
+// requests of each User with UserLimiter. The handler gets the User with currentUser
+func (server *Server) requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := server.authenticate(w, r)
+		if user == nil {
+			return
+		}
+
+		if server.UserLimiter != nil && !server.allow(w, r, server.UserLimiter, strconv.FormatInt(user.GithubId, 10)) {
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// Only lets a request through if its X-Github-Token header is a GitHub token of the User authenticated by
+// requireUser. The Authorization header alone doesn't prove who the caller is, so routes that delete or reveal the
+// User's data also require a token, which GitHub tells the owner of
+func (server *Server) requireGithubToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(githubTokenHeader)
+		if token == "" {
+			writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "An X-Github-Token header is required")
+			return
+		}
+
+		client, err := actions.NewClient(token, server.GithubBaseURL)
+		if err != nil {
+			writeInternalError(w, r, "require GitHub token", err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), githubTokenTimeout)
+		defer cancel()
+
+		owner, res, err := client.Users.Get(ctx, "")
+		if res != nil && res.StatusCode == http.StatusUnauthorized {
+			writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid GitHub token")
+			return
+		}
+
+		if err != nil {
+			requestLogger(w, r).Warn("require GitHub token", "error", err)
+			writeError(w, r, http.StatusBadGateway, CodeUpstreamError, "GitHub couldn't tell who the token belongs to")
+			return
+		}
+
+		if owner.GetID() != currentUser(r).GithubId {
+			writeError(w, r, http.StatusForbidden, CodeForbidden, "The GitHub token belongs to another user")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Gets the User authenticated by requireUser
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userKey{}).(*models.User)
+	return user
+}
+
+// Keeps the responses of a route from being cached, such as health checks, which must reach the server
+func noStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
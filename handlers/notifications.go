@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"go.opentelemetry.io/otel/trace"
+	"push-request/actions"
+	"push-request/metrics"
+	"push-request/models"
+	"push-request/tracing"
+)
+
+// Gets the APNs environment the device is notified through
+func (server *Server) apnsEnvironment(device *models.Device) models.APNSEnvironment {
+	if device.Environment == "" {
+		return server.DefaultAPNSEnvironment
+	}
+
+	return device.Environment
+}
+
+// Gets the client of the APNs environment the device is notified through
+func (server *Server) apnsClient(device *models.Device) (*apns2.Client, error) {
+	environment := server.apnsEnvironment(device)
+
+	client := server.APNS
+	if environment == models.APNSSandbox {
+		client = server.APNSSandbox
+	}
+
+	if client == nil {
+		return nil, fmt.Errorf("no APNS client for the %s environment", environment)
+	}
+
+	return client, nil
+}
+
+// Counts a push notification sent to the device, and ends the span of sending it with the same result
+func (server *Server) countPush(span trace.Span, device *models.Device, result string, reason string, err error) {
+	server.Metrics.Pushes.WithLabelValues("apns", string(server.apnsEnvironment(device)), result, reason).Inc()
+
+	span.SetAttributes(tracing.ReasonKey.String(reason))
+	tracing.End(span, result, err)
+}
+
+// Sends the payload to the device. Every notification shows an alert, so it is sent as one, with a high priority
+func (server *Server) push(ctx context.Context, device *models.Device, payload *payload.Payload) error {
+	_, span := tracing.Start(ctx, "apns.push",
+		tracing.PushProviderKey.String("apns"),
+		tracing.PushEnvironmentKey.String(string(server.apnsEnvironment(device))))
+
+	client, err := server.apnsClient(device)
+	if err != nil {
+		server.countPush(span, device, metrics.PushError, "not_configured", err)
+		return err
+	}
+
+	notification := &apns2.Notification{
+		DeviceToken: device.Token,
+		Topic:       server.APNSTopic,
+		Priority:    apns2.PriorityHigh,
+		PushType:    apns2.PushTypeAlert,
+		Payload:     payload,
+	}
+
+	res, err := client.Push(notification)
+	if err != nil {
+		err = fmt.Errorf("failed to send APNS notification (%w)", err)
+		server.countPush(span, device, metrics.PushError, "transport", err)
+		return err
+	}
+
+	if !res.Sent() {
+		err = fmt.Errorf("failed to send APNS notification (%d %s)", res.StatusCode, res.Reason)
+		server.countPush(span, device, metrics.PushRejected, res.Reason, err)
+		return err
+	}
+
+	server.countPush(span, device, metrics.PushSent, "none", nil)
+	return nil
+}
+
+// Sends a notification for the event. Its category lets the user act on the event from the lock screen,
+// and `content-available` lets the app refresh in the background
+func (server *Server) sendAPNSNotification(ctx context.Context, device *models.Device, event *models.Event) error {
+	return server.push(ctx, device, payload.NewPayload().
+		AlertTitle(event.RepoName).
+		AlertSubtitle(event.Title).
+		AlertBody(event.Description).
+		Category(actions.Category(event.EventType)).
+		ThreadID(fmt.Sprintf("%s#%d", event.RepoName, event.Number)).
+		ContentAvailable().
+		Custom("event", event))
+}
+
+// Sends a notification reporting the result of an action performed from a notification
+func (server *Server) sendActionResultNotification(ctx context.Context, device *models.Device, request *actions.Request, result string) error {
+	return server.push(ctx, device, payload.NewPayload().
+		AlertTitle(request.RepoName).
+		AlertBody(result).
+		ThreadID(fmt.Sprintf("%s#%d", request.RepoName, request.Number)))
+}
+
+// Sends a notification with the message, so operators can check that the device receives notifications
+func (server *Server) sendTestNotification(ctx context.Context, device *models.Device, message string) error {
+	return server.push(ctx, device, payload.NewPayload().
+		AlertTitle("Push Request").
+		AlertBody(message))
+}
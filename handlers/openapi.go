@@ -0,0 +1,1006 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"push-request/actions"
+	"push-request/models"
+	"push-request/openapi"
+	"push-request/router"
+)
+
+// The OpenAPI document of the API, which clients are generated from and requests are validated against. Every route
+// must be described by an operation, which the tests check along with the responses of the handlers
+var apiDocument = newAPIDocument()
+
+var apiDocumentJSON = mustMarshal(apiDocument)
+
+func mustMarshal(value interface{}) []byte {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return bytes
+}
+
+// Serves the OpenAPI document of the API
+func (server *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := w.Write(apiDocumentJSON); err != nil {
+		requestLogger(w, r).Warn("write OpenAPI document", "error", err)
+	}
+}
+
+// Responds with an error to requests that don't match the operation of their route in the OpenAPI document, so that
+// handlers only validate what the document can't express. Routes without an operation aren't validated
+func validateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := apiDocument.Operation(r.Method, router.Pattern(r))
+		if operation == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		err := apiDocument.ValidateRequest(operation, r)
+
+		var validationError *ValidationError
+
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r)
+
+		case errors.As(err, &validationError):
+			writeRequestError(w, r, validationError)
+
+		case errors.Is(err, openapi.ErrMalformedJSON):
+			writeRequestError(w, r, errors.New("the request body is not valid JSON"))
+
+		case isBodyTooLarge(err):
+			writeRequestError(w, r, errBodyTooLarge)
+
+		default:
+			writeRequestError(w, r, errors.New("the request body couldn't be read"))
+		}
+	})
+}
+
+// Operations that act on behalf of a User are authenticated by their github id
+var userSecurity = []openapi.SecurityRequirement{{"githubId": {}}}
+
+// Operations of the admin API are authenticated by an API key
+var adminSecurity = []openapi.SecurityRequirement{{"apiKey": {}}}
+
+func jsonContent(schema *openapi.Schema) map[string]*openapi.MediaType {
+	return map[string]*openapi.MediaType{"application/json": {Schema: schema}}
+}
+
+func jsonBody(description string, schema *openapi.Schema) *openapi.RequestBody {
+	return &openapi.RequestBody{Description: description, Required: true, Content: jsonContent(schema)}
+}
+
+func jsonResponse(description string, schema *openapi.Schema) *openapi.Response {
+	return &openapi.Response{Description: description, Content: jsonContent(schema)}
+}
+
+func emptyResponse(description string) *openapi.Response {
+	return &openapi.Response{Description: description}
+}
+
+func errorResponse(description string) *openapi.Response {
+	return jsonResponse(description, openapi.Ref("Error"))
+}
+
+// Adds the responses every operation may give: requests that are invalid, too large or rate limited, and internal
+// errors
+func responses(documented map[string]*openapi.Response) map[string]*openapi.Response {
+	all := map[string]*openapi.Response{
+		"400": errorResponse("The request is invalid"),
+		"413": errorResponse("The request body is too large"),
+		"429": {
+			Description: "The client sent too many requests",
+			Headers: map[string]*openapi.Header{
+				"Retry-After": {Required: true, Schema: integerSchema("Seconds to wait before retrying")},
+			},
+			Content: jsonContent(openapi.Ref("Error")),
+		},
+		"default": errorResponse("An internal error"),
+	}
+
+	for status, response := range documented {
+		all[status] = response
+	}
+
+	return all
+}
+
+// Adds the responses of operations that require a User to responses
+func userResponses(documented map[string]*openapi.Response) map[string]*openapi.Response {
+	all := responses(documented)
+
+	if _, ok := all["401"]; !ok {
+		all["401"] = errorResponse("The Authorization header isn't a github id")
+	}
+
+	if _, ok := all["403"]; !ok {
+		all["403"] = errorResponse("The User's account is disabled")
+	}
+
+	if _, ok := all["404"]; !ok {
+		all["404"] = errorResponse("The User isn't registered")
+	}
+
+	return all
+}
+
+// The GitHub token required by operations that delete or reveal the data of the User
+var githubTokenParameter = headerParameter(githubTokenHeader, "A GitHub token of the User, proving who the caller is",
+	false, stringSchema(""))
+
+// Adds the responses of operations that require the User's GitHub token, as well as the User, to responses
+func githubTokenResponses(documented map[string]*openapi.Response) map[string]*openapi.Response {
+	all := map[string]*openapi.Response{
+		"401": errorResponse("The Authorization header isn't a github id, or X-Github-Token is missing or invalid"),
+		"403": errorResponse("The User's account is disabled, or X-Github-Token belongs to another user"),
+		"502": errorResponse("GitHub couldn't tell who X-Github-Token belongs to"),
+	}
+
+	for status, response := range documented {
+		all[status] = response
+	}
+
+	return userResponses(all)
+}
+
+// Adds the responses of operations of the admin API to responses
+func adminResponses(documented map[string]*openapi.Response) map[string]*openapi.Response {
+	all := responses(documented)
+	all["401"] = errorResponse("The Authorization header isn't a valid API key")
+	all["403"] = errorResponse("The role of the API key doesn't allow the operation")
+	return all
+}
+
+// Removes the content of the responses of a GET operation, for the HEAD operation of the same route
+func headOperation(get *openapi.Operation, operationId string) *openapi.Operation {
+	head := *get
+	head.OperationId = operationId
+	head.Responses = map[string]*openapi.Response{}
+
+	for status, response := range get.Responses {
+		withoutContent := *response
+		withoutContent.Content = nil
+		head.Responses[status] = &withoutContent
+	}
+
+	return &head
+}
+
+func queryParameter(name string, description string, required bool, schema *openapi.Schema) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: openapi.InQuery, Description: description, Required: required, Schema: schema}
+}
+
+func pathParameter(name string, description string, schema *openapi.Schema) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: openapi.InPath, Description: description, Required: true, Schema: schema}
+}
+
+func headerParameter(name string, description string, required bool, schema *openapi.Schema) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: openapi.InHeader, Description: description, Required: required,
+		Schema: schema}
+}
+
+func stringSchema(description string) *openapi.Schema {
+	return &openapi.Schema{Type: openapi.TypeString, Description: description}
+}
+
+func integerSchema(description string) *openapi.Schema {
+	return &openapi.Schema{Type: openapi.TypeInteger, Format: "int64", Description: description}
+}
+
+func dateTimeSchema(description string) *openapi.Schema {
+	return &openapi.Schema{Type: openapi.TypeString, Format: "date-time", Description: description}
+}
+
+func arraySchema(items *openapi.Schema) *openapi.Schema {
+	return &openapi.Schema{Type: openapi.TypeArray, Items: items}
+}
+
+func nullable(schema *openapi.Schema) *openapi.Schema {
+	schema.Nullable = true
+	return schema
+}
+
+func objectSchema(description string, required []string, properties map[string]*openapi.Schema) *openapi.Schema {
+	return &openapi.Schema{Type: openapi.TypeObject, Description: description, Required: required, Properties: properties}
+}
+
+// Adds the fields of a User to the properties, and those every stored model has
+func withUserFields(properties map[string]*openapi.Schema) map[string]*openapi.Schema {
+	properties["github_id"] = integerSchema("")
+	properties["device_tokens"] = nullable(arraySchema(openapi.Ref("DeviceToken")))
+	properties["allowed_types"] = nullable(arraySchema(openapi.Ref("EventType")))
+	properties["version"] = integerSchema("Increases whenever the User is updated")
+	properties["disabled"] = &openapi.Schema{Type: openapi.TypeBoolean, Description: "Whether an operator disabled the User"}
+	return withModelFields(properties)
+}
+
+// Adds the fields every stored model has to the properties
+func withModelFields(properties map[string]*openapi.Schema) map[string]*openapi.Schema {
+	properties["_id"] = stringSchema("The id of the record")
+	properties["created_at"] = dateTimeSchema("When the record was created")
+	properties["updated_at"] = dateTimeSchema("When the record was last updated")
+	return properties
+}
+
+func stringEnum(values ...string) []string {
+	return values
+}
+
+func eventTypeNames() []string {
+	names := make([]string, len(models.EventTypes))
+	for i, eventType := range models.EventTypes {
+		names[i] = string(eventType)
+	}
+
+	return names
+}
+
+func categoryNames() []string {
+	names := make([]string, len(models.EventCategories))
+	for i, category := range models.EventCategories {
+		names[i] = string(category)
+	}
+
+	return names
+}
+
+func newSchemas() map[string]*openapi.Schema {
+	positive := &openapi.Schema{Type: openapi.TypeInteger, Minimum: openapi.Float(1)}
+
+	threadProperties := func() map[string]*openapi.Schema {
+		return map[string]*openapi.Schema{
+			"repo_name": {Type: openapi.TypeString, MinLength: openapi.Int(1), Description: "The full name of the repository, like `owner/repo`"},
+			"number":    {Type: openapi.TypeInteger, Minimum: openapi.Float(1), Description: "The number of the issue or pull request"},
+			"muted":     {Type: openapi.TypeBoolean, Description: "Whether notifications of the thread are muted"},
+		}
+	}
+
+	return map[string]*openapi.Schema{
+		"EventType": {
+			Type:  openapi.TypeString,
+			Title: "event type",
+			Enum:  eventTypeNames(),
+		},
+		"EventCategory": {
+			Type: openapi.TypeString,
+			Enum: categoryNames(),
+		},
+		"Platform": {
+			Type:  openapi.TypeString,
+			Title: "platform",
+			Enum:  stringEnum(string(models.PlatformIOS), string(models.PlatformIPadOS), string(models.PlatformMacOS)),
+		},
+		"APNSEnvironment": {
+			Type:  openapi.TypeString,
+			Title: "APNs environment",
+			Enum:  stringEnum(string(models.APNSProduction), string(models.APNSSandbox)),
+		},
+		"DeviceToken": {
+			Type:        openapi.TypeString,
+			Description: "The hexadecimal APNs token of a device",
+			MinLength:   openapi.Int(1),
+			MaxLength:   openapi.Int(maxDeviceTokenLength),
+			Pattern:     "^[0-9a-fA-F]+$",
+		},
+		"Device": objectSchema("A device of a User, which notifications are sent to", []string{"token"},
+			map[string]*openapi.Schema{
+				"token":        openapi.Ref("DeviceToken"),
+				"platform":     openapi.Ref("Platform"),
+				"environment":  openapi.Ref("APNSEnvironment"),
+				"app_version":  {Type: openapi.TypeString, MaxLength: openapi.Int(maxDeviceDetailLength)},
+				"locale":       {Type: openapi.TypeString, MaxLength: openapi.Int(maxDeviceDetailLength)},
+				"name":         {Type: openapi.TypeString, MaxLength: openapi.Int(maxDeviceNameLength)},
+				"last_seen_at": dateTimeSchema("When the device was last registered. Ignored by registrations"),
+				"allowed_types": nullable(&openapi.Schema{
+					Type:        openapi.TypeArray,
+					Description: "The event types the device is notified of, or null for the User's allowed types. Ignored by registrations",
+					Items:       openapi.Ref("EventType"),
+				}),
+			}),
+		"Registration": objectSchema("The devices a User registers, with their allowed types", []string{"github_id"},
+			map[string]*openapi.Schema{
+				"github_id": {Type: openapi.TypeInteger, Format: "int64", Minimum: openapi.Float(1)},
+				"devices":   arraySchema(openapi.Ref("Device")),
+				"device_tokens": {
+					Type:        openapi.TypeArray,
+					Description: "The tokens of devices registered without their details, by versions of the app that predate them",
+					Items:       openapi.Ref("DeviceToken"),
+				},
+				"allowed_types": arraySchema(openapi.Ref("EventType")),
+			}),
+		"UserUpdate": objectSchema("Changes to a User. Fields left out are left unchanged", nil,
+			map[string]*openapi.Schema{
+				"allowed_types": nullable(arraySchema(openapi.Ref("EventType"))),
+			}),
+		"DeviceUpdate": objectSchema("Changes to a device, given by its token. Fields left out are left unchanged",
+			[]string{"token"}, map[string]*openapi.Schema{
+				"token": openapi.Ref("DeviceToken"),
+				"name":  nullable(&openapi.Schema{Type: openapi.TypeString, MaxLength: openapi.Int(maxDeviceNameLength)}),
+				"allowed_types": nullable(&openapi.Schema{
+					Type:        openapi.TypeArray,
+					Description: "Overrides the User's allowed types on the device, and null removes the override",
+					Items:       openapi.Ref("EventType"),
+				}),
+			}),
+		"Event": objectSchema("An event of a thread a User is notified of",
+			[]string{"event_type", "repo_name", "number", "title", "description", "avatar_url", "timestamp", "url",
+				"installation_id"},
+			map[string]*openapi.Schema{
+				"event_type":      openapi.Ref("EventType"),
+				"repo_name":       stringSchema("The full name of the repository"),
+				"number":          positive,
+				"title":           stringSchema("The title of the notification"),
+				"description":     stringSchema("The body of the notification"),
+				"avatar_url":      stringSchema("The avatar of the user who triggered the event"),
+				"timestamp":       dateTimeSchema("When the event happened"),
+				"url":             stringSchema("The page of the event on GitHub"),
+				"installation_id": integerSchema("The installation of the GitHub App the event was received from"),
+				"additions":       integerSchema("The lines a pull request adds"),
+				"deletions":       integerSchema("The lines a pull request deletes"),
+				"changed_files":   integerSchema("The files a pull request changes"),
+				"ci_status":       stringSchema("The status of the checks of a pull request"),
+			}),
+		"StoredEvent": objectSchema("An event delivered to a User", []string{"github_id", "event"},
+			withModelFields(map[string]*openapi.Schema{
+				"github_id": integerSchema(""),
+				"event":     openapi.Ref("Event"),
+			})),
+		"User": objectSchema("A registered User", []string{"github_id", "device_tokens", "allowed_types", "version"},
+			withUserFields(map[string]*openapi.Schema{
+				"feed_token":   stringSchema("The secret token of the User's feeds"),
+				"latest_event": openapi.Ref("Event"),
+			})),
+		"AdminUser": objectSchema("A User as operators see it, without their feed token",
+			[]string{"github_id", "device_tokens", "allowed_types", "version", "devices"},
+			withUserFields(map[string]*openapi.Schema{
+				"devices":       nullable(arraySchema(openapi.Ref("Device"))),
+				"installations": arraySchema(openapi.Ref("Installation")),
+			})),
+		"Thread": objectSchema("An issue or pull request", []string{"repo_name", "number"}, threadProperties()),
+		"ThreadMute": objectSchema("Whether an issue or pull request is muted", []string{"repo_name", "number", "muted"},
+			threadProperties()),
+		"Subscription": objectSchema("A thread a User is notified of",
+			[]string{"github_id", "repo_name", "number", "reason", "muted"},
+			withModelFields(map[string]*openapi.Schema{
+				"github_id": integerSchema(""),
+				"repo_name": stringSchema(""),
+				"number":    positive,
+				"reason": {
+					Type: openapi.TypeString,
+					Enum: stringEnum(string(models.ReasonManual), string(models.ReasonAuthor),
+						string(models.ReasonAssigned), string(models.ReasonReviewRequested),
+						string(models.ReasonCommented), string(models.ReasonReviewed), string(models.ReasonMentioned)),
+				},
+				"muted": {Type: openapi.TypeBoolean},
+			})),
+		"Installation": objectSchema("An installation of the GitHub App, linked to the User who installed it",
+			[]string{"installation_id", "github_id", "account_id"},
+			withModelFields(map[string]*openapi.Schema{
+				"installation_id": integerSchema(""),
+				"github_id":       integerSchema("The User the installation is linked to, or 0 once they were deleted"),
+				"account_id":      integerSchema("The account the GitHub App was installed on"),
+			})),
+		"Relink": objectSchema("The User an installation is linked to instead", []string{"github_id"},
+			map[string]*openapi.Schema{"github_id": positive}),
+		"TestPush": objectSchema("A test notification", nil, map[string]*openapi.Schema{
+			"device_token": openapi.Ref("DeviceToken"),
+			"message":      stringSchema("The body of the notification"),
+		}),
+		"TestPushResult": objectSchema("Whether APNs accepted the test notification of a device", []string{"token", "sent"},
+			map[string]*openapi.Schema{
+				"token": openapi.Ref("DeviceToken"),
+				"sent":  {Type: openapi.TypeBoolean},
+				"error": stringSchema("Why the notification wasn't sent"),
+			}),
+		"Role": {
+			Type:  openapi.TypeString,
+			Title: "role",
+			Enum:  stringEnum(string(models.RoleReadOnly), string(models.RoleOperator)),
+		},
+		"AuditEntry": objectSchema("A request an API key made to the admin API",
+			[]string{"key_id", "key_name", "role", "action", "status"},
+			withModelFields(map[string]*openapi.Schema{
+				"key_id":     stringSchema("The public part of the API key"),
+				"key_name":   stringSchema(""),
+				"role":       openapi.Ref("Role"),
+				"action":     stringSchema("What the request did, like `users.disable`"),
+				"target":     stringSchema("The User or installation the request was about, like `user:1`"),
+				"body":       stringSchema("The body of the request, for actions that change data"),
+				"status":     integerSchema("The status of the response"),
+				"request_id": stringSchema(""),
+			})),
+		"UserExport": objectSchema("Every piece of data held about a User",
+			[]string{"exported_at", "user", "devices", "installations", "subscriptions", "events"},
+			map[string]*openapi.Schema{
+				"exported_at":   dateTimeSchema(""),
+				"user":          openapi.Ref("User"),
+				"devices":       nullable(arraySchema(openapi.Ref("Device"))),
+				"installations": nullable(arraySchema(openapi.Ref("Installation"))),
+				"subscriptions": nullable(arraySchema(openapi.Ref("Subscription"))),
+				"events":        nullable(arraySchema(openapi.Ref("StoredEvent"))),
+			}),
+		"Action": objectSchema("An action chosen from a notification", []string{"action", "repo_name", "number"},
+			map[string]*openapi.Schema{
+				"action": {
+					Type:  openapi.TypeString,
+					Title: "action",
+					Enum: stringEnum(string(actions.Approve), string(actions.Merge), string(actions.Close),
+						string(actions.Reply)),
+				},
+				"repo_name": {Type: openapi.TypeString, Pattern: "^[^/]+/[^/]+$"},
+				"number":    positive,
+				"body":      stringSchema("The text of a reply, which replies require"),
+			}),
+		"ActionResult": objectSchema("The result of an action", []string{"result"},
+			map[string]*openapi.Schema{"result": stringSchema("A description of what was done")}),
+		"EventTypes": objectSchema("The event types, with their labels in the language of the response",
+			[]string{"language", "categories", "event_types"},
+			map[string]*openapi.Schema{
+				"language": {Type: openapi.TypeString, Enum: models.Languages},
+				"categories": arraySchema(objectSchema("", []string{"id", "label"}, map[string]*openapi.Schema{
+					"id":    openapi.Ref("EventCategory"),
+					"label": stringSchema(""),
+				})),
+				"event_types": arraySchema(objectSchema("",
+					[]string{"type", "category", "label", "description", "sample_notification", "default_on"},
+					map[string]*openapi.Schema{
+						"type":        openapi.Ref("EventType"),
+						"category":    openapi.Ref("EventCategory"),
+						"label":       stringSchema(""),
+						"description": stringSchema(""),
+						"sample_notification": objectSchema("", []string{"title", "subtitle", "body"},
+							map[string]*openapi.Schema{
+								"title":    stringSchema(""),
+								"subtitle": stringSchema(""),
+								"body":     stringSchema(""),
+							}),
+						"default_on": {Type: openapi.TypeBoolean},
+					})),
+			}),
+		"Health": objectSchema("", []string{"status"}, map[string]*openapi.Schema{
+			"status": {Type: openapi.TypeString, Enum: stringEnum(checkOK)},
+		}),
+		"Readiness": objectSchema("", []string{"status", "checks"}, map[string]*openapi.Schema{
+			"status": {Type: openapi.TypeString, Enum: stringEnum("ready", "unavailable")},
+			"checks": arraySchema(objectSchema("A dependency of the server", []string{"name", "status"},
+				map[string]*openapi.Schema{
+					"name":    stringSchema(""),
+					"status":  {Type: openapi.TypeString, Enum: stringEnum(checkOK, checkFailing)},
+					"message": stringSchema("Why the check is failing"),
+					"details": {Type: openapi.TypeObject},
+				})),
+		}),
+		"Version": objectSchema("", []string{"commit", "build_time", "go_version", "event_parsers"},
+			map[string]*openapi.Schema{
+				"commit":        stringSchema(""),
+				"build_time":    stringSchema(""),
+				"go_version":    stringSchema(""),
+				"event_parsers": arraySchema(stringSchema("A GitHub event that notifications are sent for")),
+			}),
+		"Error": objectSchema("", []string{"error"}, map[string]*openapi.Schema{
+			"error": objectSchema("", []string{"code", "message", "request_id"}, map[string]*openapi.Schema{
+				"code": {
+					Type: openapi.TypeString,
+					Enum: stringEnum(string(CodeInvalidRequest), string(CodeValidationFailed),
+						string(CodeUnauthorized), string(CodeForbidden), string(CodeNotFound), string(CodeMethodNotAllowed),
+						string(CodePreconditionFailed), string(CodePayloadTooLarge), string(CodeRateLimited),
+						string(CodeUpstreamError),
+						string(CodeUnavailable), string(CodeInternalError)),
+				},
+				"message":    stringSchema("A description of the error for developers"),
+				"request_id": stringSchema("The id of the request, to quote when reporting the error"),
+				"details": arraySchema(objectSchema("The problem with a field", []string{"field", "message"},
+					map[string]*openapi.Schema{
+						"field":   stringSchema(""),
+						"message": stringSchema(""),
+					})),
+			}),
+		}),
+	}
+}
+
+func newAPIDocument() *openapi.Document {
+	etag := &openapi.Header{Description: "The entity tag of the User", Required: true, Schema: stringSchema("")}
+	threadQuery := []*openapi.Parameter{
+		queryParameter("repo_name", "The full name of the repository", true, &openapi.Schema{Type: openapi.TypeString, MinLength: openapi.Int(1)}),
+		queryParameter("number", "The number of the issue or pull request", true, &openapi.Schema{Type: openapi.TypeInteger, Minimum: openapi.Float(1)}),
+	}
+
+	paths := map[string]*openapi.PathItem{
+		"/users": {
+			Post: &openapi.Operation{
+				OperationId: "registerUser",
+				Summary:     "Register devices for a User, creating the User if they don't exist",
+				Tags:        []string{"users"},
+				RequestBody: jsonBody("", openapi.Ref("Registration")),
+				Responses: responses(map[string]*openapi.Response{
+					"200": emptyResponse("The devices were already registered"),
+					"201": emptyResponse("A device was registered"),
+				}),
+			},
+			Get: &openapi.Operation{
+				OperationId: "getUser",
+				Summary:     "Get the User, with their latest event",
+				Tags:        []string{"users"},
+				Security:    userSecurity,
+				Responses: userResponses(map[string]*openapi.Response{
+					"200": {
+						Description: "The User",
+						Headers:     map[string]*openapi.Header{"ETag": etag},
+						Content:     jsonContent(openapi.Ref("User")),
+					},
+				}),
+			},
+			Patch: &openapi.Operation{
+				OperationId: "updateUser",
+				Summary:     "Update the User",
+				Tags:        []string{"users"},
+				Security:    userSecurity,
+				Parameters: []*openapi.Parameter{
+					headerParameter("If-Match", "An ETag of the User, to only update them if they weren't updated since",
+						false, stringSchema("")),
+				},
+				RequestBody: jsonBody("", openapi.Ref("UserUpdate")),
+				Responses: userResponses(map[string]*openapi.Response{
+					"200": {Description: "The User was updated", Headers: map[string]*openapi.Header{"ETag": etag}},
+					"412": errorResponse("The User was updated since the ETag in If-Match was returned"),
+				}),
+			},
+			Delete: &openapi.Operation{
+				OperationId: "deleteUser",
+				Summary:     "Delete the User, with their devices, events, subscriptions and installation links",
+				Description: "A GitHub token of the User is required in X-Github-Token",
+				Tags:        []string{"users"},
+				Security:    userSecurity,
+				Parameters:  []*openapi.Parameter{githubTokenParameter},
+				Responses:   githubTokenResponses(map[string]*openapi.Response{"204": emptyResponse("The User was deleted")}),
+			},
+		},
+		"/users/export": {
+			Get: &openapi.Operation{
+				OperationId: "exportUser",
+				Summary:     "Export every piece of data held about the User",
+				Description: "A GitHub token of the User is required in X-Github-Token",
+				Tags:        []string{"users"},
+				Security:    userSecurity,
+				Parameters:  []*openapi.Parameter{githubTokenParameter},
+				Responses: githubTokenResponses(map[string]*openapi.Response{
+					"200": jsonResponse("The archive of the User", openapi.Ref("UserExport")),
+				}),
+			},
+		},
+		"/users/devices": {
+			Get: &openapi.Operation{
+				OperationId: "listDevices",
+				Summary:     "List the devices of the User",
+				Tags:        []string{"devices"},
+				Security:    userSecurity,
+				Responses: userResponses(map[string]*openapi.Response{
+					"200": jsonResponse("The devices", arraySchema(openapi.Ref("Device"))),
+				}),
+			},
+			Patch: &openapi.Operation{
+				OperationId: "updateDevice",
+				Summary:     "Rename a device of the User or change its allowed types",
+				Tags:        []string{"devices"},
+				Security:    userSecurity,
+				RequestBody: jsonBody("", openapi.Ref("DeviceUpdate")),
+				Responses: userResponses(map[string]*openapi.Response{
+					"200": emptyResponse("The device was updated"),
+					"404": errorResponse("The User or the device wasn't found"),
+				}),
+			},
+			Delete: &openapi.Operation{
+				OperationId: "deleteDevice",
+				Summary:     "Remove a device of the User",
+				Tags:        []string{"devices"},
+				Security:    userSecurity,
+				Parameters: []*openapi.Parameter{
+					queryParameter("token", "The token of the device", true, openapi.Ref("DeviceToken")),
+				},
+				Responses: userResponses(map[string]*openapi.Response{
+					"204": emptyResponse("The device was removed"),
+					"404": errorResponse("The User or the device wasn't found"),
+				}),
+			},
+		},
+		"/users/subscriptions": {
+			Get: &openapi.Operation{
+				OperationId: "listSubscriptions",
+				Summary:     "List the threads the User is subscribed to",
+				Tags:        []string{"subscriptions"},
+				Security:    userSecurity,
+				Responses: userResponses(map[string]*openapi.Response{
+					"200": jsonResponse("The subscriptions", nullable(arraySchema(openapi.Ref("Subscription")))),
+				}),
+			},
+			Post: &openapi.Operation{
+				OperationId: "subscribe",
+				Summary:     "Subscribe the User to a thread, unmuting it if it was muted",
+				Tags:        []string{"subscriptions"},
+				Security:    userSecurity,
+				RequestBody: jsonBody("", openapi.Ref("Thread")),
+				Responses: userResponses(map[string]*openapi.Response{
+					"200": emptyResponse("The User was already subscribed"),
+					"201": emptyResponse("The User was subscribed"),
+					"403": errorResponse("The User's account is disabled, the GitHub App isn't installed on the repository, " +
+						"or the User can't see it"),
+				}),
+			},
+			Patch: &openapi.Operation{
+				OperationId: "muteSubscription",
+				Summary:     "Mute or unmute a thread, subscribing the User to it if they weren't",
+				Tags:        []string{"subscriptions"},
+				Security:    userSecurity,
+				RequestBody: jsonBody("", openapi.Ref("ThreadMute")),
+				Responses: userResponses(map[string]*openapi.Response{
+					"200": emptyResponse("The subscription was updated"),
+					"201": emptyResponse("The User was subscribed"),
+					"403": errorResponse("The User's account is disabled, the GitHub App isn't installed on the repository, " +
+						"or the User can't see it"),
+				}),
+			},
+			Delete: &openapi.Operation{
+				OperationId: "unsubscribe",
+				Summary:     "Unsubscribe the User from a thread",
+				Tags:        []string{"subscriptions"},
+				Security:    userSecurity,
+				Parameters:  threadQuery,
+				Responses: userResponses(map[string]*openapi.Response{
+					"204": emptyResponse("The User was unsubscribed"),
+					"404": errorResponse("The User or the subscription wasn't found"),
+				}),
+			},
+		},
+		"/webhook": {
+			Post: &openapi.Operation{
+				OperationId: "receiveWebhook",
+				Summary:     "Receive a webhook of the GitHub App",
+				Tags:        []string{"github"},
+				Parameters: []*openapi.Parameter{
+					headerParameter("X-GitHub-Event", "The name of the event", true, stringSchema("")),
+					headerParameter("X-GitHub-Delivery", "The id of the delivery", false, stringSchema("")),
+					headerParameter("X-Hub-Signature-256", "The HMAC-SHA256 of the payload with the webhook secret, "+
+						"as `sha256=<hex>`. Required when the server has a webhook secret", false, stringSchema("")),
+				},
+				RequestBody: jsonBody("", &openapi.Schema{Type: openapi.TypeObject, Description: "The payload of the event"}),
+				Responses: responses(map[string]*openapi.Response{
+					"200": emptyResponse("The webhook was handled"),
+					"201": emptyResponse("An installation was linked to its User"),
+					"401": errorResponse("The signature of the payload is missing or invalid"),
+					"404": errorResponse("No User is linked to the installation"),
+				}),
+			},
+		},
+		"/actions": {
+			Post: &openapi.Operation{
+				OperationId: "performAction",
+				Summary:     "Perform an action chosen from a notification",
+				Description: "The action is performed with the user's token in X-Github-Token, which is required",
+				Tags:        []string{"actions"},
+				Security:    userSecurity,
+				Parameters: []*openapi.Parameter{
+					headerParameter("X-Github-Token", "A GitHub token of the user", false, stringSchema("")),
+				},
+				RequestBody: jsonBody("", openapi.Ref("Action")),
+				Responses: userResponses(map[string]*openapi.Response{
+					"200": jsonResponse("The action was performed", openapi.Ref("ActionResult")),
+					"401": errorResponse("The Authorization header isn't a github id, or X-Github-Token is missing"),
+					"502": errorResponse("GitHub refused the action"),
+				}),
+			},
+		},
+		"/event-types": {
+			Get: &openapi.Operation{
+				OperationId: "listEventTypes",
+				Summary:     "List the event types Users can be notified of",
+				Tags:        []string{"catalog"},
+				Parameters: []*openapi.Parameter{
+					queryParameter("lang", "The language of the labels, over Accept-Language", false, stringSchema("")),
+					headerParameter("Accept-Language", "The languages the labels may be in", false, stringSchema("")),
+				},
+				Responses: responses(map[string]*openapi.Response{
+					"200": jsonResponse("The event types", openapi.Ref("EventTypes")),
+				}),
+			},
+		},
+		"/events/stream": {
+			Get: &openapi.Operation{
+				OperationId: "streamEvents",
+				Summary:     "Stream the events of the User as they are delivered",
+				Description: "Events are streamed as Server-Sent Events, or over a WebSocket if an upgrade is requested. " +
+					"A GitHub token of the User is required in X-Github-Token",
+				Tags:     []string{"events"},
+				Security: userSecurity,
+				Parameters: []*openapi.Parameter{
+					githubTokenParameter,
+					headerParameter("Last-Event-ID", "The id of the last event received, to resume from", false, stringSchema("")),
+					queryParameter("last_event_id", "The id of the last event received, for clients that can't set headers",
+						false, stringSchema("")),
+				},
+				Responses: githubTokenResponses(map[string]*openapi.Response{
+					"101": emptyResponse("The events are streamed over a WebSocket"),
+					"200": {
+						Description: "The events are streamed as Server-Sent Events",
+						Content:     map[string]*openapi.MediaType{"text/event-stream": {Schema: stringSchema("")}},
+					},
+					"503": {
+						Description: "The server is shutting down",
+						Headers: map[string]*openapi.Header{
+							"Retry-After": {Required: true, Schema: integerSchema("Seconds to wait before reconnecting")},
+						},
+						Content: jsonContent(openapi.Ref("Error")),
+					},
+				}),
+			},
+		},
+		"/healthz": {
+			Get: &openapi.Operation{
+				OperationId: "getHealth",
+				Summary:     "Check that the server is running",
+				Tags:        []string{"operations"},
+				Responses: map[string]*openapi.Response{
+					"200": jsonResponse("The server is running", openapi.Ref("Health")),
+				},
+			},
+		},
+		"/readyz": {
+			Get: &openapi.Operation{
+				OperationId: "getReadiness",
+				Summary:     "Check that the server can handle requests",
+				Tags:        []string{"operations"},
+				Responses: map[string]*openapi.Response{
+					"200": jsonResponse("Every dependency is available", openapi.Ref("Readiness")),
+					"503": jsonResponse("A dependency is failing", openapi.Ref("Readiness")),
+				},
+			},
+		},
+		"/version": {
+			Get: &openapi.Operation{
+				OperationId: "getVersion",
+				Summary:     "Get the build of the server",
+				Tags:        []string{"operations"},
+				Responses: map[string]*openapi.Response{
+					"200": jsonResponse("The build", openapi.Ref("Version")),
+				},
+			},
+		},
+		"/metrics": {
+			Get: &openapi.Operation{
+				OperationId: "getMetrics",
+				Summary:     "Get the metrics of the server, for Prometheus",
+				Tags:        []string{"operations"},
+				Responses: map[string]*openapi.Response{
+					"200": {
+						Description: "The metrics",
+						Content:     map[string]*openapi.MediaType{"text/plain": {Schema: stringSchema("")}},
+					},
+				},
+			},
+		},
+		"/openapi.json": {
+			Get: &openapi.Operation{
+				OperationId: "getOpenAPI",
+				Summary:     "Get this document",
+				Tags:        []string{"operations"},
+				Responses: map[string]*openapi.Response{
+					"200": jsonResponse("The OpenAPI document of the API", &openapi.Schema{Type: openapi.TypeObject}),
+				},
+			},
+		},
+	}
+
+	for _, feed := range []struct {
+		path        string
+		operationId string
+		mediaType   string
+	}{
+		{"/feed.atom", "getAtomFeed", "application/atom+xml"},
+		{"/feed.rss", "getRSSFeed", "application/rss+xml"},
+	} {
+		get := &openapi.Operation{
+			OperationId: feed.operationId,
+			Summary:     "Get the feed of the events delivered to the User owning the token",
+			Tags:        []string{"feeds"},
+			Parameters: []*openapi.Parameter{
+				queryParameter("token", "The feed token of the User", false, stringSchema("")),
+				queryParameter("repo", "Repositories to narrow the feed to", false, arraySchema(stringSchema(""))),
+				headerParameter("If-None-Match", "ETags of feeds the client has", false, stringSchema("")),
+				headerParameter("If-Modified-Since", "When the client last got the feed", false, stringSchema("")),
+			},
+			Responses: responses(map[string]*openapi.Response{
+				"200": {
+					Description: "The feed",
+					Headers: map[string]*openapi.Header{
+						"ETag": {Required: true, Schema: stringSchema("")},
+					},
+					Content: map[string]*openapi.MediaType{feed.mediaType: {Schema: stringSchema("")}},
+				},
+				"304": emptyResponse("The feed wasn't modified"),
+				"401": errorResponse("The token is missing or doesn't belong to a User"),
+				"403": errorResponse("The User's account is disabled"),
+			}),
+		}
+
+		paths[feed.path] = &openapi.PathItem{Get: get, Head: headOperation(get, feed.operationId+"Head")}
+	}
+
+	for _, path := range []string{"/healthz", "/readyz", "/version"} {
+		get := paths[path].Get
+		paths[path].Head = headOperation(get, get.OperationId+"Head")
+	}
+
+	// Routes of the API are served under its prefix, unlike the probes
+	for _, path := range versionedPatterns {
+		paths[apiPrefix+path] = paths[path]
+		delete(paths, path)
+	}
+
+	for path, item := range newAdminPaths() {
+		paths[adminPrefix+path] = item
+	}
+
+	return &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "Push Request",
+			Description: "Notifies the devices of GitHub users of the events of their issues and pull requests",
+			Version:     "1",
+		},
+		Paths:     paths,
+		PathParam: router.Param,
+		Components: openapi.Components{
+			Schemas: newSchemas(),
+			SecuritySchemes: map[string]*openapi.SecurityScheme{
+				"githubId": {
+					Type:        "apiKey",
+					Description: "The github id of the User",
+					Name:        "Authorization",
+					In:          openapi.InHeader,
+				},
+				"apiKey": {
+					Type:         "http",
+					Description:  "An API key of an operator, created with `push-request admin create-key`",
+					Scheme:       "bearer",
+					BearerFormat: "prk_<key id>_<secret>",
+				},
+			},
+		},
+	}
+}
+
+// Documents the routes of the admin API, without their prefix
+func newAdminPaths() map[string]*openapi.PathItem {
+	githubId := pathParameter("github_id", "The github id of the User", integerSchema(""))
+	installationId := pathParameter("installation_id", "The id of the installation", integerSchema(""))
+	limit := queryParameter("limit", fmt.Sprintf("How many to list, at most %d", maxAdminPageSize), false,
+		&openapi.Schema{Type: openapi.TypeInteger, Minimum: openapi.Float(1)})
+	after := func(description string) *openapi.Parameter {
+		return queryParameter("after", description, false, integerSchema(""))
+	}
+
+	userNotFound := errorResponse("The User isn't registered")
+	installationNotFound := errorResponse("The installation doesn't exist")
+
+	operation := func(operationId string, summary string, tag string, role models.Role) *openapi.Operation {
+		return &openapi.Operation{
+			OperationId: operationId,
+			Summary:     summary,
+			Description: fmt.Sprintf("Requires an API key with the %s role", role),
+			Tags:        []string{tag},
+			Security:    adminSecurity,
+		}
+	}
+
+	with := func(operation *openapi.Operation, parameters []*openapi.Parameter, requestBody *openapi.RequestBody,
+		documented map[string]*openapi.Response) *openapi.Operation {
+		operation.Parameters = parameters
+		operation.RequestBody = requestBody
+		operation.Responses = adminResponses(documented)
+		return operation
+	}
+
+	setDisabled := func(operationId string, summary string) *openapi.PathItem {
+		return &openapi.PathItem{Post: with(operation(operationId, summary, "admin-users", models.RoleOperator),
+			[]*openapi.Parameter{githubId}, nil, map[string]*openapi.Response{
+				"200": jsonResponse("The User", openapi.Ref("AdminUser")),
+				"404": userNotFound,
+			})}
+	}
+
+	return map[string]*openapi.PathItem{
+		"/users": {
+			Get: with(operation("adminListUsers", "List Users by github id", "admin-users", models.RoleReadOnly),
+				[]*openapi.Parameter{
+					after("Only lists Users with a greater github id, to get the page after the one ending with that User"),
+					queryParameter("device_token", "Only lists the User with the device", false, stringSchema("")),
+					queryParameter("disabled", "Only lists the Users who are disabled, or those who aren't", false,
+						&openapi.Schema{Type: openapi.TypeBoolean}),
+					limit,
+				}, nil, map[string]*openapi.Response{
+					"200": jsonResponse("The Users", arraySchema(openapi.Ref("AdminUser"))),
+				}),
+		},
+		"/users/{github_id}": {
+			Get: with(operation("adminGetUser", "Get a User, with their devices and installations", "admin-users",
+				models.RoleReadOnly), []*openapi.Parameter{githubId}, nil, map[string]*openapi.Response{
+				"200": jsonResponse("The User", openapi.Ref("AdminUser")),
+				"404": userNotFound,
+			}),
+		},
+		"/users/{github_id}/devices": {
+			Get: with(operation("adminGetDevices", "List the Devices of a User", "admin-users", models.RoleReadOnly),
+				[]*openapi.Parameter{githubId}, nil, map[string]*openapi.Response{
+					"200": jsonResponse("The Devices", arraySchema(openapi.Ref("Device"))),
+					"404": userNotFound,
+				}),
+		},
+		"/users/{github_id}/events": {
+			Get: with(operation("adminGetEvents", "List the most recent events delivered to a User", "admin-users",
+				models.RoleReadOnly), []*openapi.Parameter{githubId, limit}, nil, map[string]*openapi.Response{
+				"200": jsonResponse("The events, newest first", arraySchema(openapi.Ref("StoredEvent"))),
+				"404": userNotFound,
+			}),
+		},
+		"/users/{github_id}/test-push": {
+			Post: with(operation("adminTestPush", "Send a test notification to the devices of a User", "admin-users",
+				models.RoleOperator), []*openapi.Parameter{githubId},
+				&openapi.RequestBody{Content: jsonContent(openapi.Ref("TestPush"))},
+				map[string]*openapi.Response{
+					"200": jsonResponse("Whether each device was sent the notification",
+						arraySchema(openapi.Ref("TestPushResult"))),
+					"404": errorResponse("The User isn't registered, or doesn't have the device"),
+				}),
+		},
+		"/users/{github_id}/disable": setDisabled("adminDisableUser",
+			"Disable a User, who can't use the API and isn't notified until they are enabled"),
+		"/users/{github_id}/enable": setDisabled("adminEnableUser", "Enable a User who was disabled"),
+		"/installations": {
+			Get: with(operation("adminListInstallations", "List installations of the GitHub App by id",
+				"admin-installations", models.RoleReadOnly),
+				[]*openapi.Parameter{
+					after("Only lists installations with a greater id, to get the page after the one ending with that installation"),
+					queryParameter("github_id", "Only lists the installations linked to the User", false, integerSchema("")),
+					limit,
+				}, nil, map[string]*openapi.Response{
+					"200": jsonResponse("The installations", arraySchema(openapi.Ref("Installation"))),
+				}),
+		},
+		"/installations/{installation_id}": {
+			Get: with(operation("adminGetInstallation", "Get an installation of the GitHub App", "admin-installations",
+				models.RoleReadOnly), []*openapi.Parameter{installationId}, nil, map[string]*openapi.Response{
+				"200": jsonResponse("The installation", openapi.Ref("Installation")),
+				"404": installationNotFound,
+			}),
+			Patch: with(operation("adminRelinkInstallation", "Link an installation of the GitHub App to another User",
+				"admin-installations", models.RoleOperator), []*openapi.Parameter{installationId},
+				jsonBody("", openapi.Ref("Relink")), map[string]*openapi.Response{
+					"200": jsonResponse("The installation", openapi.Ref("Installation")),
+					"404": installationNotFound,
+				}),
+		},
+		"/audit-log": {
+			Get: with(operation("adminListAuditLog", "List the most recent requests made to the admin API",
+				"admin-audit", models.RoleReadOnly),
+				[]*openapi.Parameter{
+					queryParameter("before", "Only lists older entries, to get the page after the one ending with that entry",
+						false, &openapi.Schema{Type: openapi.TypeString, Pattern: "^[0-9a-f]{24}$"}),
+					limit,
+				}, nil, map[string]*openapi.Response{
+					"200": jsonResponse("The entries, newest first", arraySchema(openapi.Ref("AuditEntry"))),
+				}),
+		},
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"push-request/ratelimit"
+	"push-request/router"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The label of requests refused because a User has too many devices
+const deviceLimit = "devices"
+
+// Gets the IP of the client that sent the request. Behind a load balancer, the client is the last IP it added to
+// `X-Forwarded-For`, since the entries before it were sent by the client and can't be trusted
+func (server *Server) clientIP(r *http.Request) string {
+	if server.TrustProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Responds that the client sent too many requests, with the seconds until it may retry in `Retry-After`
+func writeRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, r, http.StatusTooManyRequests, CodeRateLimited, "Too many requests")
+}
+
+// Takes a token from the client's bucket of the limiter, or responds that the client sent too many requests and
+// returns false. Requests are allowed when the limiter's store fails, so that the API stays up without it
+func (server *Server) allow(w http.ResponseWriter, r *http.Request, limiter *ratelimit.Limiter, client string) bool {
+	result, err := limiter.Allow(r.Context(), client)
+	if err != nil {
+		requestLogger(w, r).Warn("rate limit", "limit", limiter.Name, "error", err)
+		return true
+	}
+
+	if !result.Allowed {
+		server.Metrics.RateLimited.WithLabelValues(limiter.Name).Inc()
+		writeRateLimited(w, r, result.RetryAfter)
+	}
+
+	return result.Allowed
+}
+
+// Limits the requests of each IP with IPLimiter. Probes aren't limited, and neither are webhooks, which GitHub sends
+// from the same few IPs for every installation. Their signature is checked instead, and those that are signed are
+// limited per installation
+func (server *Server) limitIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pattern := router.Pattern(r)
+
+		if server.IPLimiter == nil || unloggedRoutes[pattern] || pattern == webhookRoute ||
+			server.allow(w, r, server.IPLimiter, server.clientIP(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Limits the webhooks of each installation of the GitHub App with InstallationLimiter, responding and returning false
+// when the installation sent too many. Webhooks that aren't from an installation aren't limited. The payload must have
+// been verified, since anyone could otherwise exhaust the bucket of an installation by sending its id
+func (server *Server) allowInstallation(w http.ResponseWriter, r *http.Request, payload []byte) bool {
+	if server.InstallationLimiter == nil {
+		return true
+	}
+
+	var envelope struct {
+		Installation struct {
+			Id int64 `json:"id"`
+		} `json:"installation"`
+	}
+
+	if err := json.Unmarshal(payload, &envelope); err != nil || envelope.Installation.Id == 0 {
+		return true
+	}
+
+	return server.allow(w, r, server.InstallationLimiter, strconv.FormatInt(envelope.Installation.Id, 10))
+}
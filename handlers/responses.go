@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"push-request/logging"
+	"push-request/validation"
+	"regexp"
+)
+
+// A stable code identifying the kind of error a request failed with, which clients can rely on unlike messages
+type ErrorCode string
+
+const (
+	CodeInvalidRequest     ErrorCode = "invalid_request"
+	CodeValidationFailed   ErrorCode = "validation_failed"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeMethodNotAllowed   ErrorCode = "method_not_allowed"
+	CodePreconditionFailed ErrorCode = "precondition_failed"
+	CodePayloadTooLarge    ErrorCode = "payload_too_large"
+	CodeRateLimited        ErrorCode = "rate_limited"
+	CodeUpstreamError      ErrorCode = "upstream_error"
+	CodeUnavailable        ErrorCode = "unavailable"
+	CodeInternalError      ErrorCode = "internal_error"
+)
+
+// The problem with a field of a request
+type FieldError = validation.FieldError
+
+// The body of every error response
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code      ErrorCode    `json:"code"`
+	Message   string       `json:"message"`
+	RequestId string       `json:"request_id"`
+	Details   []FieldError `json:"details,omitempty"`
+}
+
+// Request ids given by clients are echoed back if they look like ids, so they can't be used to inject into logs
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Gets the id of the request from its `X-Request-Id` header, or generates one. The id is set on the response so
+// clients can quote it when reporting an error
+func requestId(w http.ResponseWriter, r *http.Request) string {
+	if id := w.Header().Get("X-Request-Id"); id != "" {
+		return id
+	}
+
+	id := r.Header.Get("X-Request-Id")
+	if !requestIdPattern.MatchString(id) {
+		bytes := make([]byte, 8)
+		_, _ = rand.Read(bytes)
+		id = hex.EncodeToString(bytes)
+	}
+
+	w.Header().Set("X-Request-Id", id)
+	return id
+}
+
+// Gets the logger of the request, which adds its id to every line
+func requestLogger(w http.ResponseWriter, r *http.Request) *logging.Logger {
+	return logging.FromContext(r.Context()).With("request_id", requestId(w, r))
+}
+
+// Logs that the request was received, and returns it with a context whose logger adds the request's id, so that
+// everything logged while handling it can be traced back to it. The id of its trace is added too when it is traced,
+// and webhooks add their delivery, so they can be followed through the pipeline
+func logRequest(w http.ResponseWriter, r *http.Request) *http.Request {
+	logger := requestLogger(w, r)
+
+	if delivery := r.Header.Get("X-GitHub-Delivery"); delivery != "" {
+		logger = logger.With("delivery_id", delivery, "github_event", r.Header.Get("X-GitHub-Event"))
+	}
+
+	if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+		logger = logger.With("trace_id", spanContext.TraceID().String())
+	}
+	logger.Info("request received", "method", r.Method, "path", r.URL.Path)
+
+	return r.WithContext(logging.NewContext(r.Context(), logger))
+}
+
+// Responds with the error envelope. The message must be safe to show to clients
+func writeError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string, details ...FieldError) {
+	body, _ := json.Marshal(ErrorResponse{ErrorBody{Code: code, Message: message, RequestId: requestId(w, r), Details: details}})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// Logs the error with the id of the request, and responds without any detail of it, since internal errors may
+// reveal how the server works
+func writeInternalError(w http.ResponseWriter, r *http.Request, context string, err error) {
+	requestLogger(w, r).Error(context, "error", err)
+
+	writeError(w, r, http.StatusInternalServerError, CodeInternalError, "An internal error occurred")
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Invalid Method")
+}
+
+func writeNotFound(w http.ResponseWriter, r *http.Request, message string) {
+	writeError(w, r, http.StatusNotFound, CodeNotFound, message)
+}
+
+// Responds with the value encoded as JSON
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		writeInternalError(w, r, "encode response", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if _, err = w.Write(body); err != nil {
+		requestLogger(w, r).Warn("write response", "error", err)
+	}
+}
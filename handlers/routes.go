@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"push-request/models"
+	"push-request/router"
+)
+
+// The prefix of the routes of the current version of the API. They are also served at the paths they had before
+// the API was versioned, which versions of the app already released call
+const apiPrefix = "/v1"
+
+const webhookRoute = apiPrefix + "/webhook"
+
+// The patterns of the routes served under apiPrefix
+var versionedPatterns = []string{
+	"/users", "/users/export", "/users/devices", "/users/subscriptions", "/webhook", "/actions", "/event-types",
+	"/feed.atom", "/feed.rss", "/events/stream",
+}
+
+// Routes the requests of the API to their handlers. Every request is timed, traced and logged, may be compressed and
+// called cross-origin, is rate limited and is validated against the OpenAPI document. Handlers of routes that
+// require a User are only called once it is authenticated, and those of the admin API once the API key is
+func (server *Server) Routes() http.Handler {
+	routes := router.New()
+
+	routes.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeNotFound(w, r, "No route matches the path")
+	})
+	routes.MethodNotAllowed = http.HandlerFunc(writeMethodNotAllowed)
+
+	routes.UseRoute(server.instrument, server.logRequests, server.limitBody)
+	routes.Use(recoverPanics, server.cors, compress, server.limitIPs, validateRequests)
+
+	api := func(method string, pattern string, handler http.HandlerFunc, middleware ...router.Middleware) {
+		routes.HandleFunc(method, apiPrefix+pattern, handler, middleware...)
+	}
+
+	api(http.MethodPost, "/users", server.handlePostUser)
+	api(http.MethodGet, "/users", server.handleGetUser, server.requireUser)
+	api(http.MethodPatch, "/users", server.handlePatchUser, server.requireUser)
+	api(http.MethodDelete, "/users", server.handleDeleteUser, server.requireUser, server.requireGithubToken)
+	api(http.MethodGet, "/users/export", server.handleExport, server.requireUser, server.requireGithubToken)
+
+	api(http.MethodGet, "/users/devices", server.handleGetDevices, server.requireUser)
+	api(http.MethodPatch, "/users/devices", server.handlePatchDevice, server.requireUser)
+	api(http.MethodDelete, "/users/devices", server.handleDeleteDevice, server.requireUser)
+
+	api(http.MethodGet, "/users/subscriptions", server.handleGetSubscriptions, server.requireUser)
+	api(http.MethodPost, "/users/subscriptions", server.handlePostSubscription, server.requireUser)
+	api(http.MethodPatch, "/users/subscriptions", server.handlePatchSubscription, server.requireUser)
+	api(http.MethodDelete, "/users/subscriptions", server.handleDeleteSubscription, server.requireUser)
+
+	api(http.MethodPost, "/webhook", server.handleWebhook)
+	api(http.MethodPost, "/actions", server.handleAction, server.requireUser)
+	api(http.MethodGet, "/event-types", server.handleEventTypes)
+
+	for _, feed := range []string{"/feed.atom", "/feed.rss"} {
+		api(http.MethodGet, feed, server.handleFeed)
+		api(http.MethodHead, feed, server.handleFeed)
+	}
+
+	// Streams are counted before they are authenticated, so that none start once the server is shutting down
+	api(http.MethodGet, "/events/stream", server.handleStream, server.trackStream, server.requireUser,
+		server.requireGithubToken)
+
+	for _, pattern := range versionedPatterns {
+		routes.Alias(pattern, apiPrefix+pattern)
+	}
+
+	admin := func(method string, pattern string, role models.Role, action string, handler http.HandlerFunc) {
+		routes.HandleFunc(method, adminPrefix+pattern, handler, server.requireAPIKey(role, action))
+	}
+
+	admin(http.MethodGet, "/users", models.RoleReadOnly, "users.list", server.handleAdminListUsers)
+	admin(http.MethodGet, "/users/{github_id}", models.RoleReadOnly, "users.get", server.handleAdminGetUser)
+	admin(http.MethodGet, "/users/{github_id}/devices", models.RoleReadOnly, "users.devices", server.handleAdminGetDevices)
+	admin(http.MethodGet, "/users/{github_id}/events", models.RoleReadOnly, "users.events", server.handleAdminGetEvents)
+	admin(http.MethodPost, "/users/{github_id}/test-push", models.RoleOperator, "users.test_push", server.handleAdminTestPush)
+	admin(http.MethodPost, "/users/{github_id}/disable", models.RoleOperator, "users.disable", server.handleAdminDisableUser)
+	admin(http.MethodPost, "/users/{github_id}/enable", models.RoleOperator, "users.enable", server.handleAdminEnableUser)
+
+	admin(http.MethodGet, "/installations", models.RoleReadOnly, "installations.list", server.handleAdminListInstallations)
+	admin(http.MethodGet, "/installations/{installation_id}", models.RoleReadOnly, "installations.get",
+		server.handleAdminGetInstallation)
+	admin(http.MethodPatch, "/installations/{installation_id}", models.RoleOperator, "installations.relink",
+		server.handleAdminRelinkInstallation)
+
+	admin(http.MethodGet, "/audit-log", models.RoleReadOnly, "audit_log.list", server.handleAdminAuditLog)
+
+	// Probes aren't versioned, since load balancers and Prometheus are configured with their paths
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		routes.HandleFunc(method, "/healthz", server.handleHealth, noStore)
+		routes.HandleFunc(method, "/readyz", server.handleReady, noStore)
+		routes.HandleFunc(method, "/version", server.handleVersion, noStore)
+	}
+
+	routes.Handle(http.MethodGet, "/metrics", server.Metrics.Handler())
+	routes.HandleFunc(http.MethodGet, "/openapi.json", server.handleOpenAPI)
+
+	return routes
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/sideshow/apns2"
+	"net/http"
+	"push-request/githubapp"
+	"push-request/metrics"
+	"push-request/models"
+	"push-request/ratelimit"
+	"push-request/storage"
+	"push-request/stream"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Server handles the requests of the API with the stores and clients it is given
+type Server struct {
+	Stores *storage.Stores
+	Broker stream.Broker
+
+	// The clients of the APNs production and sandbox environments. Each device is notified through the environment
+	// it reported, or DefaultAPNSEnvironment if it didn't report one
+	APNS                   *apns2.Client
+	APNSSandbox            *apns2.Client
+	DefaultAPNSEnvironment models.APNSEnvironment
+
+	// The topic notifications are sent to, which is the bundle id of the app
+	APNSTopic string
+
+	// The base URL of the GitHub REST API, ending with a slash
+	GithubBaseURL string
+
+	// The GitHub App client, or nil if no app is configured
+	GithubApp *githubapp.Client
+
+	// The secret webhooks are signed with. Without one, signatures aren't checked and accounts aren't deleted when
+	// users revoke their authorization, which only tests and local development should rely on
+	WebhookSecret []byte
+
+	// The metrics of the server, served at /metrics
+	Metrics *metrics.Metrics
+
+	// How long the details of a pull request are looked up for before its event is delivered without them
+	EnrichTimeout time.Duration
+
+	// How often connected stream clients are sent a heartbeat
+	HeartbeatInterval time.Duration
+
+	// How many events may wait to be written to stream clients before the server reports it isn't ready
+	MaxPendingEvents int
+
+	// The largest request bodies accepted, in bytes. Webhooks are allowed MaxWebhookBytes instead
+	MaxBodyBytes    int64
+	MaxWebhookBytes int64
+
+	// The origins whose browsers may call the API, or `*` for any origin
+	CORSOrigins []string
+
+	// Limit the requests of each IP, authenticated User and installation of the GitHub App. Requests aren't limited
+	// by a nil limiter
+	IPLimiter           *ratelimit.Limiter
+	UserLimiter         *ratelimit.Limiter
+	InstallationLimiter *ratelimit.Limiter
+
+	// Takes the IP of clients from the last entry of `X-Forwarded-For`, which the load balancer sets
+	TrustProxy bool
+
+	// The most devices a User may register, or 0 for any number
+	MaxDevicesPerUser int
+
+	// Set by Drain once the server is shutting down, so it reports it isn't ready
+	draining int32
+
+	// Closed by CloseStreams to end the streams of connected clients, which are counted by streams. The mutex
+	// keeps streams from starting once they are closed
+	closeStreams chan struct{}
+	streamsMutex sync.Mutex
+	streams      sync.WaitGroup
+}
+
+// Creates a Server using the given stores, with an in-process broker, its own metrics and the public GitHub API
+func NewServer(stores *storage.Stores) *Server {
+	return &Server{
+		Stores:                 stores,
+		Broker:                 stream.NewMemoryBroker(),
+		DefaultAPNSEnvironment: models.APNSProduction,
+		GithubBaseURL:          "https://api.github.com/",
+		Metrics:                metrics.New(),
+		EnrichTimeout:          3 * time.Second,
+		HeartbeatInterval:      15 * time.Second,
+		MaxPendingEvents:       1000,
+		MaxBodyBytes:           1 << 20,
+		MaxWebhookBytes:        25 << 20,
+		closeStreams:           make(chan struct{}),
+	}
+}
+
+// Gets the User with the github id specified in the `Authorization` header, or responds with an error and returns nil
+func (server *Server) authenticate(w http.ResponseWriter, r *http.Request) *models.User {
+	githubId, err := strconv.ParseInt(r.Header.Get("Authorization"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "The Authorization header must be a github id")
+		return nil
+	}
+
+	user, err := server.Stores.Users.Get(r.Context(), githubId)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "User not found")
+		return nil
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "authenticate", err)
+		return nil
+	}
+
+	if user.Disabled {
+		writeAccountDisabled(w, r)
+		return nil
+	}
+
+	return user
+}
+
+// Responds that the User's account was disabled by an operator
+func writeAccountDisabled(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusForbidden, CodeForbidden, "The account is disabled")
+}
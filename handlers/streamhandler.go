@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net"
+	"net/http"
+	"push-request/logging"
+	"push-request/models"
+	"time"
+)
+
+// The maximum number of missed events replayed to a client resuming from a `Last-Event-ID`
+const streamBacklogLength = 100
+
+var upgrader = websocket.Upgrader{}
+
+type streamMessage struct {
+	Id    string       `json:"id"`
+	Event models.Event `json:"event"`
+}
+
+// A streamWriter writes events and heartbeats to a connected client, over SSE or a WebSocket
+type streamWriter interface {
+	writeEvent(storedEvent *models.StoredEvent) error
+	writeHeartbeat() error
+}
+
+type connKey struct{}
+
+// Keeps the connection of each request in its context, for http.Server.ConnContext, so that streams can replace the
+// server's read and write timeouts, which would otherwise end them
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// Lifts the read deadline of a stream's connection, since clients don't send anything after their request, and
+// returns the connection so each write can be given a deadline. Returns nil if the connection isn't known
+func streamConn(r *http.Request) net.Conn {
+	conn, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return nil
+	}
+
+	_ = conn.SetReadDeadline(time.Time{})
+	return conn
+}
+
+type sseWriter struct {
+	w            http.ResponseWriter
+	flusher      http.Flusher
+	conn         net.Conn
+	writeTimeout time.Duration
+}
+
+// Gives the next write until the next heartbeat is due to complete, so a client that stopped reading is dropped
+func (writer *sseWriter) setWriteDeadline() {
+	if writer.conn != nil {
+		_ = writer.conn.SetWriteDeadline(time.Now().Add(writer.writeTimeout))
+	}
+}
+
+func (writer *sseWriter) writeEvent(storedEvent *models.StoredEvent) error {
+	data, err := json.Marshal(storedEvent.Event)
+	if err != nil {
+		return err
+	}
+
+	writer.setWriteDeadline()
+	_, err = fmt.Fprintf(writer.w, "id: %s\nevent: event\ndata: %s\n\n", storedEvent.ID.Hex(), data)
+	writer.flusher.Flush()
+	return err
+}
+
+func (writer *sseWriter) writeHeartbeat() error {
+	writer.setWriteDeadline()
+	_, err := fmt.Fprint(writer.w, ": heartbeat\n\n")
+	writer.flusher.Flush()
+	return err
+}
+
+type webSocketWriter struct {
+	conn              *websocket.Conn
+	heartbeatInterval time.Duration
+}
+
+func (writer *webSocketWriter) writeEvent(storedEvent *models.StoredEvent) error {
+	_ = writer.conn.SetWriteDeadline(time.Now().Add(writer.heartbeatInterval))
+	return writer.conn.WriteJSON(streamMessage{Id: storedEvent.ID.Hex(), Event: storedEvent.Event})
+}
+
+func (writer *webSocketWriter) writeHeartbeat() error {
+	return writer.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writer.heartbeatInterval))
+}
+
+// Writes the backlog, followed by every new event until ctx is done or the streams are closed. Events from the
+// subscription that were already part of the backlog are skipped. They are recognized by their id, since the ids of
+// events stored by other replicas needn't be in the order they are published
+func (server *Server) pumpEvents(ctx context.Context, writer streamWriter, backlog []models.StoredEvent, events <-chan models.StoredEvent) error {
+	written := make(map[primitive.ObjectID]bool, len(backlog))
+
+	for i := range backlog {
+		if err := writer.writeEvent(&backlog[i]); err != nil {
+			return err
+		}
+		written[backlog[i].ID] = true
+	}
+
+	ticker := time.NewTicker(server.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-server.closeStreams:
+			return nil
+
+		case storedEvent := <-events:
+			if written[storedEvent.ID] {
+				continue
+			}
+
+			if err := writer.writeEvent(&storedEvent); err != nil {
+				return err
+			}
+
+		case <-ticker.C:
+			if err := writer.writeHeartbeat(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (server *Server) streamSSE(w http.ResponseWriter, r *http.Request, backlog []models.StoredEvent, events <-chan models.StoredEvent) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeInternalError(w, r, "handle GET stream", errors.New("the response writer doesn't support flushing"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	writer := &sseWriter{w: w, flusher: flusher, conn: streamConn(r), writeTimeout: server.HeartbeatInterval}
+
+	if err := server.pumpEvents(r.Context(), writer, backlog, events); err != nil {
+		logging.FromContext(r.Context()).Info("event stream ended", "error", err)
+	}
+}
+
+func (server *Server) streamWebSocket(w http.ResponseWriter, r *http.Request, backlog []models.StoredEvent, events <-chan models.StoredEvent) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied to the client
+		logging.FromContext(r.Context()).Warn("upgrade event stream", "error", err)
+		return
+	}
+
+	defer conn.Close()
+
+	// The connection was hijacked, so the server's read timeout would end the stream
+	_ = conn.SetReadDeadline(time.Time{})
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// Clients never send messages, but reading is required to process control frames and notice disconnects
+	go func() {
+		defer cancel()
+
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err = server.pumpEvents(ctx, &webSocketWriter{conn: conn, heartbeatInterval: server.HeartbeatInterval}, backlog, events); err != nil {
+		logging.FromContext(r.Context()).Info("event stream ended", "error", err)
+		return
+	}
+
+	// Clients reconnect, to another replica if this one is shutting down, and resume from their last event
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+		time.Now().Add(time.Second))
+}
+
+// Ends the streams of connected clients, which would otherwise keep the server from shutting down, and drains the
+// server. It is meant to be registered with http.Server.RegisterOnShutdown
+func (server *Server) CloseStreams() {
+	server.Drain()
+
+	server.streamsMutex.Lock()
+	defer server.streamsMutex.Unlock()
+
+	select {
+	case <-server.closeStreams:
+	default:
+		close(server.closeStreams)
+	}
+}
+
+// Counts a new stream, unless the streams were closed
+func (server *Server) startStream() bool {
+	server.streamsMutex.Lock()
+	defer server.streamsMutex.Unlock()
+
+	select {
+	case <-server.closeStreams:
+		return false
+	default:
+		server.streams.Add(1)
+		return true
+	}
+}
+
+// Closes the streams and waits until every one has ended, including WebSockets, which http.Server.Shutdown doesn't
+// wait for since their connections are hijacked
+func (server *Server) WaitForStreams(ctx context.Context) error {
+	server.CloseStreams()
+
+	done := make(chan struct{})
+
+	go func() {
+		server.streams.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Counts the streams of a route until they end, so the server can wait for them, and refuses to start them once
+// the streams were closed
+func (server *Server) trackStream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !server.startStream() {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "The server is shutting down")
+			return
+		}
+
+		defer server.streams.Done()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Streams the events of the User as they are delivered, using Server-Sent Events, or a WebSocket if an upgrade is
+// requested. A client resuming from a `Last-Event-ID` header (or `last_event_id` query parameter) first receives the
+// events it missed
+func (server *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+
+	events, unsubscribe := server.Broker.Subscribe(user.GithubId)
+	defer unsubscribe()
+
+	var backlog []models.StoredEvent
+
+	if lastEventId != "" {
+		id, err := primitive.ObjectIDFromHex(lastEventId)
+		if err != nil {
+			writeRequestError(w, r, &ValidationError{Details: []FieldError{{
+				Field:   "Last-Event-ID",
+				Message: "must be the id of an event",
+			}}})
+			return
+		}
+
+		backlog, err = server.Stores.Events.ListAfter(r.Context(), user.GithubId, id, streamBacklogLength)
+		if err != nil {
+			writeInternalError(w, r, "handle GET stream", err)
+			return
+		}
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		server.streamWebSocket(w, r, backlog, events)
+	} else {
+		server.streamSSE(w, r, backlog, events)
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"push-request/githubapp"
+	"push-request/metrics"
+	"push-request/models"
+	"push-request/parsers"
+	"push-request/storage"
+	"strconv"
+)
+
+type threadRequest struct {
+	RepoName string `json:"repo_name"`
+	Number   int    `json:"number"`
+	Muted    *bool  `json:"muted,omitempty"`
+}
+
+func (request *threadRequest) validate(requireMuted bool) error {
+	var v validator
+
+	v.Check(request.RepoName != "", "repo_name", "is required")
+	v.Check(request.Number > 0, "number", "must be a positive number")
+	v.Check(!requireMuted || request.Muted != nil, "muted", "is required")
+
+	return v.Err()
+}
+
+// Subscribes the registered participants to their thread
+func (server *Server) subscribeParticipants(ctx context.Context, participation *parsers.Participation) error {
+	for _, participant := range participation.Participants {
+		if _, err := server.Stores.Users.Get(ctx, participant.GithubId); err != nil {
+			continue
+		}
+
+		if err := server.Stores.Subscriptions.Subscribe(ctx, participant.GithubId, participation.RepoName, participation.Number, participant.Reason); err != nil {
+			return fmt.Errorf("failed to subscribe github id %d to %s#%d (%w)", participant.GithubId, participation.RepoName, participation.Number, err)
+		}
+	}
+
+	return nil
+}
+
+func (server *Server) isMuted(ctx context.Context, githubId int64, event *models.Event) bool {
+	subscription, err := server.Stores.Subscriptions.Get(ctx, githubId, event.RepoName, event.Number)
+	return err == nil && subscription.Muted
+}
+
+// Delivers the event to every user subscribed to its thread who hasn't muted it and allows its type, except the user
+// who triggered it
+func (server *Server) deliverToSubscribers(ctx context.Context, event *models.Event, senderId int64, delivered map[int64]bool) error {
+	subscriptions, err := server.Stores.Subscriptions.ListByThread(ctx, event.RepoName, event.Number)
+	if err != nil {
+		return fmt.Errorf("error getting subscriptions to %s#%d (%w)", event.RepoName, event.Number, err)
+	}
+
+	for _, subscription := range subscriptions {
+		if delivered[subscription.GithubId] {
+			continue
+		}
+
+		if subscription.Muted {
+			server.filtered(metrics.FilterMuted)
+			continue
+		}
+
+		if subscription.GithubId == senderId {
+			server.filtered(metrics.FilterSender)
+			continue
+		}
+
+		user, err := server.Stores.Users.Get(ctx, subscription.GithubId)
+		if err != nil {
+			continue
+		}
+
+		if !user.AllowsEventType(event.EventType) {
+			server.filtered(metrics.FilterTypeNotAllowed)
+			continue
+		}
+
+		subscriberEvent := *event
+		if err = server.deliverEvent(ctx, user, &subscriberEvent, user.DevicesAllowing(event.EventType)); err != nil {
+			return fmt.Errorf("failed to deliver event to github id %d (%w)", subscription.GithubId, err)
+		}
+
+		delivered[subscription.GithubId] = true
+	}
+
+	return nil
+}
+
+// Reports whether the user may subscribe to the threads of the repository. The GitHub App must be installed on it, so
+// that its events are received, and the user must be able to see it. The user who installed the app can, and anyone
+// else, such as the members of an organization, is checked with GitHub. Without a GitHub App no repository is covered
+func (server *Server) canSubscribe(ctx context.Context, githubId int64, repoName string) (bool, error) {
+	if server.GithubApp == nil {
+		return false, nil
+	}
+
+	installationId, err := server.GithubApp.RepositoryInstallation(ctx, repoName)
+	if errors.Is(err, githubapp.ErrNotInstalled) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	installation, err := server.Stores.Installations.Get(ctx, installationId)
+	if err == nil && installation.GithubId == githubId {
+		return true, nil
+	}
+
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+
+	return server.GithubApp.CanSee(ctx, installationId, repoName, githubId)
+}
+
+// Lists the Subscriptions of the User
+func (server *Server) handleGetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := server.Stores.Subscriptions.ListByUser(r.Context(), currentUser(r).GithubId)
+	if err != nil {
+		writeInternalError(w, r, "handle GET subscriptions", err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, subscriptions)
+}
+
+// Subscribes the User to a thread, given as `repo_name` and `number` in the request body, unmuting it if it was muted
+func (server *Server) handlePostSubscription(w http.ResponseWriter, r *http.Request) {
+	var request threadRequest
+
+	err := decodeBody(r, &request)
+	if err == nil {
+		request.Muted = nil
+		err = request.validate(false)
+	}
+
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	server.putSubscription(w, r, &request, "handle POST subscription")
+}
+
+// Mutes or unmutes a thread, given as `repo_name` and `number` with `muted` in the request body, subscribing the User
+// to it if they weren't
+func (server *Server) handlePatchSubscription(w http.ResponseWriter, r *http.Request) {
+	var request threadRequest
+
+	err := decodeBody(r, &request)
+	if err == nil {
+		err = request.validate(true)
+	}
+
+	if err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	server.putSubscription(w, r, &request, "handle PATCH subscription")
+}
+
+// Subscribes the User to a thread, muted if `muted` is true and unmuted otherwise. New subscriptions are only
+// accepted to repositories the GitHub App is installed on that the User can see. Errors are logged with the context of the handler
+func (server *Server) putSubscription(w http.ResponseWriter, r *http.Request, request *threadRequest, context string) {
+	ctx := r.Context()
+	user := currentUser(r)
+	muted := request.Muted != nil && *request.Muted
+
+	subscription, err := server.Stores.Subscriptions.Get(ctx, user.GithubId, request.RepoName, request.Number)
+	if errors.Is(err, storage.ErrNotFound) {
+		allowed, err := server.canSubscribe(ctx, user.GithubId, request.RepoName)
+		if err != nil {
+			writeInternalError(w, r, context, err)
+			return
+		}
+
+		if !allowed {
+			writeError(w, r, http.StatusForbidden, CodeForbidden,
+				"The GitHub App isn't installed on the repository, or the User can't see it")
+			return
+		}
+
+		subscription = &models.Subscription{
+			GithubId: user.GithubId,
+			RepoName: request.RepoName,
+			Number:   request.Number,
+			Reason:   models.ReasonManual,
+			Muted:    muted,
+		}
+
+		if err = server.Stores.Subscriptions.Create(ctx, subscription); err != nil {
+			writeInternalError(w, r, context, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, context, err)
+		return
+	}
+
+	subscription.Muted = muted
+	if err = server.Stores.Subscriptions.Update(ctx, subscription); err != nil {
+		writeInternalError(w, r, context, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Unsubscribes the User from a thread, given as `repo_name` and `number` in the query
+func (server *Server) handleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	request := threadRequest{RepoName: query.Get("repo_name")}
+	request.Number, _ = strconv.Atoi(query.Get("number"))
+
+	if err := request.validate(false); err != nil {
+		writeRequestError(w, r, err)
+		return
+	}
+
+	subscription, err := server.Stores.Subscriptions.Get(ctx, currentUser(r).GithubId, request.RepoName, request.Number)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "Subscription not found")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle DELETE subscription", err)
+		return
+	}
+
+	if err = server.Stores.Subscriptions.Delete(ctx, subscription); err != nil {
+		writeInternalError(w, r, "handle DELETE subscription", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,146 +1,280 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"push-request/models"
+	"push-request/storage"
 	"strconv"
+	"strings"
 )
 
-func containsString(array []string, element string) bool {
-	for _, a := range array {
-		if a == element {
-			return true
-		}
+// The longest device token accepted. APNs device tokens are currently 64 hex characters, but Apple reserves the
+// right to make them longer
+const maxDeviceTokenLength = 200
+
+// Devices are registered with their details in `devices`. Versions of the app that predate device details only
+// send their tokens, in `device_tokens`
+type registrationRequest struct {
+	GithubId     int64              `json:"github_id"`
+	DeviceTokens []string           `json:"device_tokens"`
+	Devices      []models.Device    `json:"devices"`
+	AllowedTypes []models.EventType `json:"allowed_types,omitempty"`
+}
+
+// Lists the devices of the request, with only the details a registration sets
+func (request *registrationRequest) devices() []models.Device {
+	var devices []models.Device
+
+	for _, device := range request.Devices {
+		device.LastSeenAt = nil
+		device.AllowedTypes = nil
+		devices = append(devices, device)
 	}
-	return false
+
+	for _, token := range request.DeviceTokens {
+		devices = append(devices, models.Device{Token: token})
+	}
+
+	return devices
 }
 
-// Creates a new User with the specified github id, device token, and allowed types
-// If a User with the github id already exists, the user is updated with the new device token
-func handlePostUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
+func validateDeviceToken(v *validator, field string, token string) {
+	v.Check(token != "" && len(token) <= maxDeviceTokenLength, field, "must be between 1 and %d characters",
+		maxDeviceTokenLength)
 
-	err := json.NewDecoder(r.Body).Decode(&user)
-	if err != nil {
-		fmt.Println("handle POST user: Failed to decode request body")
-		http.Error(w, "Failed to decode request body", http.StatusBadRequest)
-		return
+	hex := true
+	for _, c := range token {
+		hex = hex && strings.ContainsRune("0123456789abcdefABCDEF", c)
 	}
 
-	existingUser, err := models.GetUser(user.GithubId)
-	if err == nil {
-		if !containsString(existingUser.DeviceTokens, user.DeviceTokens[0]) {
-			fmt.Println("User with github id", user.GithubId, "already exists. Appending device token...")
-			existingUser.DeviceTokens = append(existingUser.DeviceTokens, user.DeviceTokens[0])
-			_ = existingUser.Save()
-		}
+	v.Check(hex, field, "must be hexadecimal")
+}
 
-		w.WriteHeader(http.StatusOK)
-		return
+func (request *registrationRequest) validate() error {
+	var v validator
+
+	v.Check(request.GithubId > 0, "github_id", "is required")
+	v.Check(len(request.Devices)+len(request.DeviceTokens) > 0, "devices",
+		"devices or device_tokens must contain at least one device")
+
+	for i, device := range request.Devices {
+		validateDevice(&v, fmt.Sprintf("devices[%d]", i), &device)
 	}
 
-	err = models.CreateUser(user.GithubId, user.DeviceTokens[0], user.AllowedTypes)
-	if err != nil {
-		fmt.Println("handle POST user: Failed to create user", err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	for i, token := range request.DeviceTokens {
+		validateDeviceToken(&v, fmt.Sprintf("device_tokens[%d]", i), token)
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	v.eventTypes("allowed_types", request.AllowedTypes)
+
+	return v.Err()
 }
 
-// Gets a User using the github id specified in the `Authorization` header
-func handleGetUser(w http.ResponseWriter, r *http.Request) {
-	authString := r.Header.Get("Authorization")
-	githubId, err := strconv.Atoi(authString)
+// Registers the devices for the User with the specified github id, creating the User if they don't exist. The
+// allowed types of an existing User are replaced when given. A device registered to another User is moved to this
+// one, since a device is signed in to a single account. The app registers its device every time it launches, which
+// keeps the device's details and last seen time current. Devices that would give the User more than
+// MaxDevicesPerUser are refused, though those before them are registered. A new User is linked to the unlinked
+// installations on their account
+func (server *Server) handlePostUser(w http.ResponseWriter, r *http.Request) {
+	var request registrationRequest
 
-	if err != nil {
-		fmt.Println("handle GET user", err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	err := decodeBody(r, &request)
+	if err == nil {
+		err = request.validate()
 	}
 
-	user, err := models.GetUser(int64(githubId))
 	if err != nil {
-		fmt.Println("handle GET user", err.Error())
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeRequestError(w, r, err)
 		return
 	}
 
-	bytes, err := json.Marshal(user)
+	disabled, err := server.isDisabled(r.Context(), request.GithubId)
 	if err != nil {
-		fmt.Println("handle GET user", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, "handle POST user: Failed to get user", err)
 		return
 	}
 
-	if _, err = w.Write(bytes); err != nil {
-		fmt.Println("handle GET user", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if disabled {
+		writeAccountDisabled(w, r)
 		return
 	}
+
+	created := false
+	tooMany := false
+
+	for _, device := range request.devices() {
+		deviceCreated, err := server.Stores.Users.Register(r.Context(), request.GithubId, device, request.AllowedTypes,
+			server.MaxDevicesPerUser)
+		if errors.Is(err, storage.ErrTooManyDevices) {
+			tooMany = true
+			break
+		}
+
+		if err != nil {
+			writeInternalError(w, r, "handle POST user: Failed to register user", err)
+			return
+		}
+
+		server.Metrics.Registrations.WithLabelValues(strconv.FormatBool(deviceCreated)).Inc()
+		created = created || deviceCreated
+	}
+
+	if created {
+		// A User who deleted their account gets back the installations on it
+		if err = server.Stores.Installations.LinkAccount(r.Context(), request.GithubId); err != nil {
+			writeInternalError(w, r, "handle POST user: Failed to link installations", err)
+			return
+		}
+	}
+
+	if tooMany {
+		server.Metrics.RateLimited.WithLabelValues(deviceLimit).Inc()
+		writeRequestError(w, r, &ValidationError{Details: []FieldError{{
+			Field:   "devices",
+			Message: fmt.Sprintf("a User can have at most %d devices", server.MaxDevicesPerUser),
+		}}})
+	} else if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
 }
 
-// Updates a User with new data. Currently, the only fields supported are `allowed_types`
-func handlePatchUser(w http.ResponseWriter, r *http.Request) {
-	authString := r.Header.Get("Authorization")
-	githubId, err := strconv.Atoi(authString)
+// Reports whether the User was disabled by an operator. Users who aren't registered yet aren't disabled
+func (server *Server) isDisabled(ctx context.Context, githubId int64) (bool, error) {
+	user, err := server.Stores.Users.Get(ctx, githubId)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
 
 	if err != nil {
-		fmt.Println("handle PATCH user", err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return false, err
+	}
+
+	return user.Disabled, nil
+}
+
+// Gets a User, with their latest event, using the github id specified in the `Authorization` header. Users created
+// before feeds were introduced are given a feed token on their first request
+func (server *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	if user.FeedToken == "" {
+		var err error
+		user, err = storage.UpdateUser(r.Context(), server.Stores.Users, user.GithubId, func(user *models.User) error {
+			if user.FeedToken != "" {
+				return nil
+			}
+
+			var err error
+			user.FeedToken, err = models.NewFeedToken()
+			return err
+		})
+
+		if err != nil {
+			writeInternalError(w, r, "handle GET user", err)
+			return
+		}
 	}
 
-	user, err := models.GetUser(int64(githubId))
+	latestEvents, err := server.Stores.Events.List(r.Context(), user.GithubId, nil, 1)
 	if err != nil {
-		fmt.Println("handle PATCH user", err.Error())
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeInternalError(w, r, "handle GET user", err)
 		return
 	}
 
-	var data struct {
-		AllowedTypes []models.EventType `json:"allowed_types,omitempty"`
+	if len(latestEvents) > 0 {
+		user.LatestEvent = &latestEvents[0].Event
+	}
+
+	w.Header().Set("ETag", userETag(user))
+	writeJSON(w, r, http.StatusOK, user)
+}
+
+// The entity tag of the User, which changes whenever they are updated
+func userETag(user *models.User) string {
+	return fmt.Sprintf("\"%d\"", user.Version)
+}
+
+// Parses the version of the User a request is conditional on, from its `If-Match` header. Requests without the
+// header, or with `*`, aren't conditional
+func parseIfMatch(r *http.Request) (version int64, conditional bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
 	}
 
-	err = json.NewDecoder(r.Body).Decode(&data)
+	version, err = strconv.ParseInt(strings.Trim(header, "\""), 10, 64)
 	if err != nil {
-		fmt.Println("handle PATCH user", err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false, &ValidationError{Details: []FieldError{{
+			Field:   "If-Match",
+			Message: "must be an ETag returned by GET /users",
+		}}}
+	}
+
+	return version, true, nil
+}
+
+// Updates the User with new data. Currently, the only fields supported are `allowed_types`. If the request has an
+// `If-Match` header, the User is only updated if they weren't updated since the ETag was returned, and 412 is
+// returned otherwise
+func (server *Server) handlePatchUser(w http.ResponseWriter, r *http.Request) {
+	githubId := currentUser(r).GithubId
+
+	version, conditional, err := parseIfMatch(r)
+	if err != nil {
+		writeRequestError(w, r, err)
 		return
 	}
 
-	if data.AllowedTypes != nil {
-		user.AllowedTypes = data.AllowedTypes
+	var data struct {
+		AllowedTypes []models.EventType `json:"allowed_types,omitempty"`
+	}
+
+	err = decodeBody(r, &data)
+	if err == nil {
+		var v validator
+		v.eventTypes("allowed_types", data.AllowedTypes)
+		err = v.Err()
 	}
 
-	err = user.Save()
 	if err != nil {
-		fmt.Println("handle PATCH user", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRequestError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
+	user, err := storage.UpdateUser(r.Context(), server.Stores.Users, githubId, func(user *models.User) error {
+		if conditional && user.Version != version {
+			return storage.ErrConflict
+		}
 
-func HandleUser(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		fmt.Println("POST /users")
-		handlePostUser(w, r)
+		if data.AllowedTypes != nil {
+			user.AllowedTypes = data.AllowedTypes
+		}
 
-	case http.MethodGet:
-		fmt.Println("GET /users")
-		handleGetUser(w, r)
+		return nil
+	})
 
-	case http.MethodPatch:
-		fmt.Println("PATCH /users")
-		handlePatchUser(w, r)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeNotFound(w, r, "User not found")
+		return
+	}
 
-	default:
-		http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+	if errors.Is(err, storage.ErrConflict) {
+		writeError(w, r, http.StatusPreconditionFailed, CodePreconditionFailed,
+			"User was updated since the ETag in If-Match was returned")
+		return
 	}
+
+	if err != nil {
+		writeInternalError(w, r, "handle PATCH user", err)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(user))
+	w.WriteHeader(http.StatusOK)
 }
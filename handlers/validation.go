@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"push-request/models"
+	"push-request/validation"
+	"reflect"
+)
+
+// A ValidationError lists the problems with the fields of a request
+type ValidationError = validation.Error
+
+// Collects the problems with the fields of a request
+type validator struct {
+	validation.Validator
+}
+
+func (v *validator) eventTypes(field string, eventTypes []models.EventType) {
+	for i, eventType := range eventTypes {
+		v.Check(eventType.IsKnown(), fmt.Sprintf("%s[%d]", field, i), "unknown event type %q", eventType)
+	}
+}
+
+// Returned when the body of a request is longer than its route allows
+var errBodyTooLarge = errors.New("the request body is too large")
+
+// Reports whether the error is from reading a body longer than the limit set by limitBody. net/http doesn't export
+// the error before Go 1.19
+func isBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
+
+// Decodes the JSON body of the request into the value. Type mismatches are reported as a ValidationError on the
+// field, and malformed JSON as an error of its own
+func decodeBody(r *http.Request, value interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(value)
+	if isBodyTooLarge(err) {
+		return errBodyTooLarge
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		field := typeError.Field
+		if field == "" {
+			field = "body"
+		}
+
+		return &ValidationError{Details: []FieldError{{Field: field, Message: "must be " + jsonTypeName(typeError.Type)}}}
+	}
+
+	if err != nil {
+		return errors.New("the request body is not valid JSON")
+	}
+
+	return nil
+}
+
+// Describes the JSON values a Go type is decoded from
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Ptr:
+		return jsonTypeName(t.Elem())
+	default:
+		return "an object"
+	}
+}
+
+// Responds to a request that failed validation or couldn't be decoded
+func writeRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errBodyTooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "The request body is too large")
+		return
+	}
+
+	var validationError *ValidationError
+	if errors.As(err, &validationError) {
+		writeError(w, r, http.StatusBadRequest, CodeValidationFailed, "The request is invalid", validationError.Details...)
+		return
+	}
+
+	writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+}
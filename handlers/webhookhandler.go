@@ -1,103 +1,225 @@
 package handlers
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/google/go-github/github"
-	"github.com/sideshow/apns2"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"push-request/logging"
+	"push-request/metrics"
 	"push-request/models"
 	"push-request/parsers"
+	"push-request/tracing"
+	"strings"
 )
 
-var apnsClient *apns2.Client
+func (server *Server) handleInstallationEvent(ctx context.Context, event *github.InstallationEvent) (bool, error) {
+	if event.GetAction() != "created" {
+		return false, nil
+	}
+
+	githubId := event.GetInstallation().GetAccount().GetID()
+	installationId := event.GetInstallation().GetID()
+
+	installation := &models.Installation{Id: installationId, GithubId: githubId, AccountId: githubId}
 
-func SetAPNSClient(client *apns2.Client) {
-	apnsClient = client
+	if err := server.Stores.Installations.Create(ctx, installation); err != nil {
+		return false, fmt.Errorf("failed to create installation (%w)", err)
+	}
+
+	return true, nil
 }
 
-func containsEventType(array []models.EventType, element models.EventType) bool {
-	for _, a := range array {
-		if a == element {
-			return true
-		}
+func (server *Server) getUser(ctx context.Context, installationId int64) (*models.User, error) {
+	installation, err := server.Stores.Installations.Get(ctx, installationId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting installation with id %d (%w)", installationId, err)
 	}
-	return false
+
+	user, err := server.Stores.Users.Get(ctx, installation.GithubId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user with github id %d (%w)", installation.GithubId, err)
+
+	}
+
+	return user, nil
 }
 
-func sendAPNSNotification(token string) error {
-	notification := &apns2.Notification{
-		DeviceToken: token,
-		Topic:       os.Getenv("APNS_TOPIC"),
-		Priority:    apns2.PriorityLow,
-		PushType:    apns2.PushTypeBackground,
-		Payload:     []byte(`{"aps":{"content-available":1}}`),
+// Stores the event in the user's history, publishes it to the user's connected clients and notifies the given devices
+// of the user. Nothing is delivered to users who were disabled. A device that can't be notified is logged, and doesn't
+// keep the others from being notified
+func (server *Server) deliverEvent(ctx context.Context, user *models.User, event *models.Event, devices []models.Device) error {
+	if user.Disabled {
+		server.filtered(metrics.FilterDisabled)
+		return nil
 	}
 
-	_, err := apnsClient.Push(notification)
+	storedEvent, err := server.Stores.Events.Create(ctx, user.GithubId, event)
 	if err != nil {
-		return fmt.Errorf("failed to send APNS notification (%w)", err)
+		return err
 	}
 
+	server.Broker.Publish(*storedEvent)
+
+	failed := 0
+
+	for _, device := range devices {
+		if err = server.sendAPNSNotification(ctx, &device, event); err != nil {
+			failed++
+			logging.FromContext(ctx).Warn("notify device", "user_id", user.GithubId, "platform", device.Platform,
+				"error", err)
+		}
+	}
+
+	logging.FromContext(ctx).Info("event delivered", "user_id", user.GithubId, "event_type", event.EventType,
+		"devices", len(devices), "failed_devices", failed)
+
 	return nil
 }
 
-func handleInstallationEvent(event *github.InstallationEvent) (bool, error) {
-	if event.GetAction() != "created" {
-		return false, nil
+// Adds the details of a pull request to its event. GitHub gives up on webhooks that aren't answered within 10
+// seconds, so the details are only looked up for EnrichTimeout, and the event is left as it was when they couldn't
+// all be
+func (server *Server) enrichEvent(ctx context.Context, event *models.Event) {
+	ctx, cancel := context.WithTimeout(ctx, server.EnrichTimeout)
+	defer cancel()
+
+	enriched := *event
+	if err := parsers.EnrichEvent(ctx, server.GithubApp, &enriched); err != nil {
+		logging.FromContext(ctx).Warn("enrich event", "error", err)
+		return
 	}
 
-	githubId := event.GetInstallation().GetAccount().GetID()
-	installationId := event.GetInstallation().GetID()
+	*event = enriched
+}
 
-	if err := models.CreateInstallation(installationId, githubId); err != nil {
-		return false, fmt.Errorf("failed to create installation (%w)", err)
+// Counts a webhook received from GitHub. Webhooks of events go-github doesn't know share a label, like unknown actions
+func (server *Server) countWebhook(eventName string, known bool, payload []byte) {
+	if !known {
+		eventName = "other"
 	}
 
-	return true, nil
+	var envelope struct {
+		Action string `json:"action"`
+	}
+
+	_ = json.Unmarshal(payload, &envelope)
+	server.Metrics.WebhooksReceived.WithLabelValues(eventName, metrics.Action(envelope.Action)).Inc()
 }
 
-func getUser(installationId int64) (*models.User, error) {
-	installation, err := models.GetInstallation(installationId)
-	if err != nil {
-		return nil, fmt.Errorf("error getting installation with id %d (%w)", installationId, err)
+// Parses the webhook into an event in a span of its own, so traces show how long parsing took
+func parseEvent(ctx context.Context, payload interface{}) *models.Event {
+	_, span := tracing.Start(ctx, "parsers.ParseRawEventPayload")
+
+	event := parsers.ParseRawEventPayload(payload)
+	if event == nil {
+		tracing.End(span, metrics.ParseNil, nil)
+		return nil
 	}
 
-	user, err := models.GetUser(installation.GithubId)
-	if err != nil {
-		return nil, fmt.Errorf("error getting user with github id %d (%w)", installation.GithubId, err)
+	span.SetAttributes(tracing.EventTypeKey.String(string(event.EventType)),
+		tracing.InstallationKey.Int64(event.InstallationId))
+	tracing.End(span, metrics.ParseParsed, nil)
 
+	return event
+}
+
+// The header GitHub sends the HMAC-SHA256 signature of webhook payloads in, as `sha256=<hex>`. The version of go-github
+// in use only validates the older SHA-1 `X-Hub-Signature`, so it is checked here
+const signatureHeader = "X-Hub-Signature-256"
+
+// Reports whether the payload was signed with the webhook secret
+func (server *Server) hasValidSignature(r *http.Request, payload []byte) bool {
+	signature, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(signatureHeader), "sha256="))
+	if err != nil {
+		return false
 	}
 
-	return user, nil
+	mac := hmac.New(sha256.New, server.WebhookSecret)
+	mac.Write(payload)
+
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+func (server *Server) filtered(reason string) {
+	server.Metrics.EventsFiltered.WithLabelValues(reason).Inc()
 }
 
-func HandleWebhook(w http.ResponseWriter, r *http.Request) {
+func (server *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	eventName := github.WebHookType(r)
+
+	tracing.Annotate(r.Context(), tracing.GithubEventKey.String(eventName),
+		tracing.DeliveryIdKey.String(github.DeliveryID(r)))
+
 	payload, err := ioutil.ReadAll(r.Body)
+	if isBodyTooLarge(err) {
+		writeRequestError(w, r, errBodyTooLarge)
+		return
+	}
+
 	if err != nil {
-		fmt.Println("handle webhook error", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, "handle webhook error", err)
 		return
 	}
 
 	defer r.Body.Close()
 
-	fmt.Println("webhook received: ", github.WebHookType(r))
+	verified := len(server.WebhookSecret) > 0
+	if verified && !server.hasValidSignature(r, payload) {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid webhook signature")
+		return
+	}
+
+	if verified && !server.allowInstallation(w, r, payload) {
+		return
+	}
+
+	if eventName == "github_app_authorization" {
+		server.countWebhook(eventName, true, payload)
+
+		var event authorizationEvent
+		if err = json.Unmarshal(payload, &event); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "the request body is not valid JSON")
+			return
+		}
+
+		// Anyone could send an unsigned revocation, so accounts are only deleted for signed ones
+		if !verified {
+			requestLogger(w, r).Warn("ignored unsigned authorization webhook", "user_id", event.Sender.Id)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if err = server.handleAuthorizationEvent(r.Context(), &event); err != nil {
+			writeInternalError(w, r, "handle webhook error", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	event, err := github.ParseWebHook(eventName, payload)
+	server.countWebhook(eventName, err == nil, payload)
 
-	event, err := github.ParseWebHook(github.WebHookType(r), payload)
 	if err != nil {
-		fmt.Println("handle webhook error", err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		server.Metrics.WebhookParses.WithLabelValues("other", metrics.ParseInvalid).Inc()
+
+		requestLogger(w, r).Warn("parse webhook", "error", err)
+		writeError(w, r, http.StatusBadRequest, CodeInvalidRequest, "The webhook payload couldn't be parsed")
 		return
 	}
 
 	switch event := event.(type) {
 	case *github.InstallationEvent:
-		isCreated, err := handleInstallationEvent(event)
+		isCreated, err := server.handleInstallationEvent(r.Context(), event)
 		if err != nil {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeInternalError(w, r, "handle webhook error", err)
 			return
 		}
 
@@ -112,39 +234,86 @@ func HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	default:
 	}
 
-	parsedEvent := parsers.ParseRawEventPayload(event)
-	if parsedEvent == nil {
-		fmt.Println("parsed event is nil")
-		w.WriteHeader(http.StatusOK)
-		return
-	}
+	parsedEvent := parseEvent(r.Context(), event)
+	mention := parsers.ParseMention(event)
+	participation := parsers.ParseParticipation(event, payload)
 
-	user, err := getUser(parsedEvent.InstallationId)
-	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+	if parsedEvent != nil {
+		server.Metrics.WebhookParses.WithLabelValues(eventName, metrics.ParseParsed).Inc()
+
+		r = r.WithContext(logging.With(r.Context(), "installation_id", parsedEvent.InstallationId,
+			"event_type", parsedEvent.EventType))
+
+		tracing.Annotate(r.Context(), tracing.InstallationKey.Int64(parsedEvent.InstallationId),
+			tracing.EventTypeKey.String(string(parsedEvent.EventType)))
+	} else {
+		server.Metrics.WebhookParses.WithLabelValues(eventName, metrics.ParseNil).Inc()
 	}
 
-	if !containsEventType(user.AllowedTypes, parsedEvent.EventType) {
+	if parsedEvent == nil && mention == nil && participation == nil {
+		server.filtered(metrics.FilterUnsupported)
+		requestLogger(w, r).Info("parsed event is nil")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	user.LatestEvent = parsedEvent
-	if err = user.Save(); err != nil {
-		fmt.Println("handle webhook error", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if participation != nil {
+		if err = server.subscribeParticipants(r.Context(), participation); err != nil {
+			writeInternalError(w, r, "handle webhook error", err)
+			return
+		}
+	}
+
+	// The github ids of the users the event was delivered to, so that no one is notified twice
+	delivered := map[int64]bool{}
+	var ownerErr error
+
+	if parsedEvent != nil {
+		if server.GithubApp != nil {
+			server.enrichEvent(r.Context(), parsedEvent)
+		}
+
+		var user *models.User
+
+		user, ownerErr = server.getUser(r.Context(), parsedEvent.InstallationId)
+
+		switch {
+		case ownerErr != nil:
+			server.filtered(metrics.FilterNoOwner)
+			requestLogger(w, r).Warn("no user is linked to the installation", "error", ownerErr)
+
+		case !user.AllowsEventType(parsedEvent.EventType):
+			server.filtered(metrics.FilterTypeNotAllowed)
+
+		case server.isMuted(r.Context(), user.GithubId, parsedEvent):
+			server.filtered(metrics.FilterMuted)
+
+		default:
+			if err = server.deliverEvent(r.Context(), user, parsedEvent, user.DevicesAllowing(parsedEvent.EventType)); err != nil {
+				writeInternalError(w, r, "handle webhook error", err)
+				return
+			}
+
+			delivered[user.GithubId] = true
+		}
+
+		if err = server.deliverToSubscribers(r.Context(), parsedEvent, parsers.ParseSender(event), delivered); err != nil {
+			writeInternalError(w, r, "handle webhook error", err)
+			return
+		}
 	}
 
-	for _, token := range user.DeviceTokens {
-		if err = sendAPNSNotification(token); err != nil {
-			fmt.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	if mention != nil {
+		if err = server.deliverMention(r.Context(), mention, delivered); err != nil {
+			writeInternalError(w, r, "handle webhook error", err)
 			return
 		}
 	}
 
+	if ownerErr != nil {
+		writeNotFound(w, r, "No user is linked to the installation")
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
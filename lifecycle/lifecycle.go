@@ -0,0 +1,158 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"push-request/logging"
+	"sync"
+	"time"
+)
+
+// A Service runs an HTTP server with background workers until it is told to stop. It then drains, calling the
+// functions registered with OnDrain and keeping on serving for DrainDelay, and shuts down within a deadline: it stops
+// accepting connections, waits for in-flight requests, stops the workers and waits for them, and then closes the
+// resources registered with OnShutdown, such as database clients
+type Service struct {
+	Server *http.Server
+
+	// How long requests are still served after being told to stop, so load balancers notice the failing readiness
+	// check before connections are refused
+	DrainDelay time.Duration
+
+	// How long shutting down may take, after which connections are closed and workers are abandoned
+	ShutdownTimeout time.Duration
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+	drains  []func()
+	closers []closer
+}
+
+type closer struct {
+	name  string
+	close func(ctx context.Context) error
+}
+
+func New(server *http.Server, shutdownTimeout time.Duration) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Service{Server: server, ShutdownTimeout: shutdownTimeout, ctx: ctx, cancel: cancel}
+}
+
+// Runs a background worker, which must return once its context is cancelled. Workers are stopped once in-flight
+// requests have finished, since requests may depend on them
+func (service *Service) Go(worker func(ctx context.Context)) {
+	service.workers.Add(1)
+
+	go func() {
+		defer service.workers.Done()
+		worker(service.ctx)
+	}()
+}
+
+// Registers a function called as soon as the service is told to stop, such as one failing the readiness check
+func (service *Service) OnDrain(drain func()) {
+	service.drains = append(service.drains, drain)
+}
+
+// Registers a function closing a resource once requests and workers have finished. Resources are closed in the
+// reverse of the order they were registered in, like deferred calls
+func (service *Service) OnShutdown(name string, close func(ctx context.Context) error) {
+	service.closers = append(service.closers, closer{name: name, close: close})
+}
+
+// Serves connections from the listener until a signal is received from stop, and then shuts down. Returns an error
+// if the server failed, or if shutting down didn't finish within ShutdownTimeout
+func (service *Service) Serve(listener net.Listener, stop <-chan os.Signal) error {
+	serveErr := make(chan error, 1)
+
+	go func() {
+		serveErr <- service.Server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		// The server failed without being told to stop, but workers and resources are still shut down
+		_ = service.Shutdown()
+		return err
+
+	case sig := <-stop:
+		logging.Default().Info("shutting down", "signal", sig)
+	}
+
+	service.drain(stop)
+
+	err := service.Shutdown()
+
+	if serveErr := <-serveErr; !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+
+	return err
+}
+
+// Calls the drain functions and waits for DrainDelay, or until a second signal asks to stop right away
+func (service *Service) drain(stop <-chan os.Signal) {
+	for _, drain := range service.drains {
+		drain()
+	}
+
+	if service.DrainDelay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(service.DrainDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-stop:
+	}
+}
+
+// Shuts down within ShutdownTimeout. Connections that are still open at the deadline are closed
+func (service *Service) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), service.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+
+	if err := service.Server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to drain requests (%w)", err))
+		_ = service.Server.Close()
+	}
+
+	service.cancel()
+
+	workersDone := make(chan struct{})
+	go func() {
+		service.workers.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("failed to stop workers (%w)", ctx.Err()))
+	}
+
+	for i := len(service.closers) - 1; i >= 0; i-- {
+		closer := service.closers[i]
+
+		if err := closer.close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close %s (%w)", closer.name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	logging.Default().Info("shut down")
+	return nil
+}
@@ -0,0 +1,254 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+
+	return levelNames[level]
+}
+
+// Parses the name of a level: debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(level), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// The value that replaces redacted values
+const Redacted = "[redacted]"
+
+// APNs device tokens are hex strings of 32 bytes or more, which are redacted wherever they appear
+var deviceTokenPattern = regexp.MustCompile(`\b[0-9A-Fa-f]{64,}\b`)
+
+// Reports whether the values of a field are secrets, such as tokens, keys and passwords
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+
+	return key == "authorization" || key == "token" || key == "password" ||
+		strings.HasSuffix(key, "_token") || strings.HasSuffix(key, "_tokens") ||
+		strings.HasSuffix(key, "secret") || strings.HasSuffix(key, "_key")
+}
+
+// A Logger writes leveled log lines as JSON objects, with the time, level and message followed by its fields. Fields
+// holding secrets, and device tokens anywhere, are redacted
+type Logger struct {
+	out    io.Writer
+	mutex  *sync.Mutex
+	level  Level
+	fields []field
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Creates a Logger writing the lines at level or above to out
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{out: out, mutex: &sync.Mutex{}, level: level}
+}
+
+var defaultLogger = New(os.Stdout, LevelInfo)
+
+// Gets the logger used when a context has none
+func Default() *Logger {
+	return defaultLogger
+}
+
+// Replaces the default logger. It isn't safe to call while other goroutines log
+func SetDefault(logger *Logger) {
+	defaultLogger = logger
+}
+
+// Creates a Logger adding the fields, given as alternating keys and values, to every line. A field replaces a field of
+// the logger with the same key
+func (logger *Logger) With(keyvals ...interface{}) *Logger {
+	return &Logger{out: logger.out, mutex: logger.mutex, level: logger.level, fields: logger.merge(keyvals)}
+}
+
+// Copies the fields of the logger, since loggers created with With share them, and adds the fields to them
+func (logger *Logger) merge(keyvals []interface{}) []field {
+	fields := append([]field(nil), logger.fields...)
+
+	for _, added := range toFields(keyvals) {
+		replaced := false
+
+		for i := range fields {
+			if fields[i].key == added.key {
+				fields[i] = added
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			fields = append(fields, added)
+		}
+	}
+
+	return fields
+}
+
+func toFields(keyvals []interface{}) []field {
+	var fields []field
+
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+
+		if i+1 == len(keyvals) {
+			fields = append(fields, field{key: "!BADKEY", value: key})
+			break
+		}
+
+		fields = append(fields, field{key: key, value: keyvals[i+1]})
+	}
+
+	return fields
+}
+
+// Reports whether lines of the level are written
+func (logger *Logger) Enabled(level Level) bool {
+	return level >= logger.level
+}
+
+func (logger *Logger) Debug(message string, keyvals ...interface{}) {
+	logger.log(LevelDebug, message, keyvals)
+}
+
+func (logger *Logger) Info(message string, keyvals ...interface{}) {
+	logger.log(LevelInfo, message, keyvals)
+}
+
+func (logger *Logger) Warn(message string, keyvals ...interface{}) {
+	logger.log(LevelWarn, message, keyvals)
+}
+
+func (logger *Logger) Error(message string, keyvals ...interface{}) {
+	logger.log(LevelError, message, keyvals)
+}
+
+func (logger *Logger) log(level Level, message string, keyvals []interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+
+	var line bytes.Buffer
+
+	line.WriteString(`{"time":`)
+	writeValue(&line, time.Now().UTC().Format(time.RFC3339Nano))
+	line.WriteString(`,"level":`)
+	writeValue(&line, level.String())
+	line.WriteString(`,"msg":`)
+	writeValue(&line, redactString(message))
+
+	for _, field := range logger.merge(keyvals) {
+		line.WriteByte(',')
+		writeValue(&line, field.key)
+		line.WriteByte(':')
+
+		if isSecret(field.key) {
+			writeValue(&line, Redacted)
+		} else {
+			writeValue(&line, redact(field.value))
+		}
+	}
+
+	line.WriteString("}\n")
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	_, _ = logger.out.Write(line.Bytes())
+}
+
+func redactString(text string) string {
+	return deviceTokenPattern.ReplaceAllString(text, Redacted)
+}
+
+// Converts the value to one encoded as JSON, with the device tokens in its text redacted
+func redact(value interface{}) interface{} {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case error:
+		return redactString(value.Error())
+	case time.Duration:
+		return value.String()
+	case string:
+		return redactString(value)
+	case fmt.Stringer:
+		return redactString(value.String())
+	case bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return value
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return redactString(fmt.Sprint(value))
+	}
+
+	// A token that was encoded as a number rather than in a string leaves the JSON invalid once redacted
+	if redacted := redactString(string(encoded)); json.Valid([]byte(redacted)) {
+		return json.RawMessage(redacted)
+	}
+
+	return redactString(string(encoded))
+}
+
+func writeValue(line *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	line.Write(encoded)
+}
+
+type contextKey struct{}
+
+// Creates a context carrying the logger, which FromContext gets
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// Gets the logger of the context, or the default logger if it has none
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return logger
+	}
+
+	return Default()
+}
+
+// Creates a context whose logger adds the fields, so everything logged with the context carries them
+func With(ctx context.Context, keyvals ...interface{}) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(keyvals...))
+}
@@ -1,63 +1,421 @@
 package main
 
 import (
-	"encoding/base64"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/Kamva/mgm"
 	"github.com/sideshow/apns2"
-	"github.com/sideshow/apns2/token"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"push-request/buildinfo"
+	"push-request/config"
+	"push-request/githubapp"
 	"push-request/handlers"
+	"push-request/lifecycle"
+	"push-request/logging"
+	"push-request/metrics"
+	"push-request/models"
+	"push-request/ratelimit"
+	"push-request/storage"
+	"push-request/stream"
+	"syscall"
+	"time"
 )
 
-func init() {
-	err := mgm.SetDefaultConfig(nil, os.Getenv("DB_NAME"), options.Client().ApplyURI(os.Getenv("DB_URI")))
+var configFile = flag.String("config", os.Getenv("CONFIG_FILE"), "the path of a YAML or TOML config file")
+
+var storageBackend = flag.String("storage", "", "the storage backend: mongo, postgres or sqlite, overriding the config")
+
+// Resolves the configuration, with the storage backend given by the `-storage` flag
+func resolveConfig() (*config.Config, error) {
+	cfg, err := config.Resolve(*configFile, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	if *storageBackend != "" {
+		cfg.Storage.Backend = *storageBackend
+	}
+
+	return cfg, nil
+}
+
+// Loads the configuration, exiting with every problem found by validate if it is invalid
+func loadConfig(validate func(*config.Config) error) *config.Config {
+	cfg, err := resolveConfig()
+	if err == nil {
+		err = validate(cfg)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	return cfg
+}
+
+// Prints the resolved configuration with its secrets redacted, and exits with an error if it is invalid
+func checkConfig() {
+	cfg, err := resolveConfig()
+	if err == nil {
+		fmt.Print(cfg.Describe())
+		err = cfg.Validate()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Println("The configuration is valid")
+}
+
+// Connects to MongoDB, timing its commands with the monitor if one is given, and applies the pending migrations of its
+// collections
+func setupMongo(cfg config.StorageConfig, monitor *event.CommandMonitor) {
+	err := mgm.SetDefaultConfig(nil, cfg.MongoDatabase, options.Client().ApplyURI(cfg.MongoURI).SetMonitor(monitor))
 	if err != nil {
 		panic(err)
 	}
+
+	versions, err := storage.MigrateMongo(context.Background())
+	if err != nil {
+		panic(err)
+	}
+
+	if len(versions) > 0 {
+		logging.Default().Info("applied mongo migrations", "versions", versions)
+	}
+}
+
+// Disconnects the MongoDB client connected by setupMongo
+func closeMongo(ctx context.Context) error {
+	_, client, _, err := mgm.DefaultConfigs()
+	if err != nil {
+		return err
+	}
+
+	return client.Disconnect(ctx)
 }
 
-func setupAPNS() {
-	encodedKey := os.Getenv("APNS_AUTH_KEY")
-	decodedKey, err := base64.StdEncoding.DecodeString(encodedKey)
+// Connects to the configured storage backend and migrates it, returning the stores and a function closing their
+// connections. The SQL backends connect to `DATABASE_URL`, a PostgreSQL connection string or the path of a SQLite
+// database. MongoDB is also connected to when events are fanned out through it
+func setupStores(cfg *config.Config, monitor *event.CommandMonitor) (*storage.Stores, func(ctx context.Context) error) {
+	closeStores := func(ctx context.Context) error { return nil }
+
+	if cfg.UsesMongo() {
+		setupMongo(cfg.Storage, monitor)
+		closeStores = closeMongo
+	}
+
+	if cfg.Storage.Backend == config.BackendMongo {
+		return storage.NewMongoStores(), closeStores
+	}
+
+	driver := storage.Postgres
+	if cfg.Storage.Backend == config.BackendSQLite {
+		driver = storage.SQLite
+	}
+
+	stores, db, err := storage.OpenSQLStores(context.Background(), driver, cfg.Storage.DatabaseURL)
 	if err != nil {
 		panic(err)
 	}
 
-	authKey, err := token.AuthKeyFromBytes(decodedKey)
+	closeMongoStores := closeStores
+	closeStores = func(ctx context.Context) error {
+		if err := db.Close(); err != nil {
+			return err
+		}
+
+		return closeMongoStores(ctx)
+	}
+
+	return stores, closeStores
+}
+
+// Copies the data stored in MongoDB into the configured SQL backend
+func copyFromMongo(cfg *config.Config) {
+	if cfg.Storage.Backend == config.BackendMongo {
+		panic(errors.New("the copy-mongo command requires a SQL storage backend"))
+	}
+
+	if cfg.Storage.MongoURI == "" || cfg.Storage.MongoDatabase == "" {
+		panic(errors.New("the copy-mongo command requires DB_URI and DB_NAME"))
+	}
+
+	ctx := context.Background()
+
+	stores, closeStores := setupStores(cfg, nil)
+	defer closeStores(ctx)
+
+	// The stores are only connected to MongoDB when the configuration otherwise uses it
+	if !cfg.UsesMongo() {
+		setupMongo(cfg.Storage, nil)
+		defer closeMongo(ctx)
+	}
+
+	res, err := storage.CopyFromMongo(ctx, stores)
 	if err != nil {
 		panic(err)
 	}
 
-	apnsToken := &token.Token{
-		AuthKey: authKey,
-		KeyID:   os.Getenv("APNS_KID"),
-		TeamID:  os.Getenv("APNS_ISS"),
+	fmt.Printf("Copied %d users, %d installations, %d events, %d subscriptions and %d API keys\n",
+		res.Users, res.Installations, res.Events, res.Subscriptions, res.APIKeys)
+}
+
+const adminUsage = `usage:
+  push-request admin create-key <name> <role>
+  push-request admin list-keys
+  push-request admin revoke-key <key id>`
+
+// Creates, lists and revokes the API keys of the admin API. The key of a new key is only printed once, when it is
+// created, since only its hash is stored
+func manageAPIKeys(args []string) {
+	// The number of arguments of each command, including its name
+	arities := map[string]int{"create-key": 3, "list-keys": 1, "revoke-key": 2}
+
+	if len(args) == 0 || arities[args[0]] != len(args) {
+		fmt.Fprintln(os.Stderr, adminUsage)
+		os.Exit(2)
 	}
 
-	var client = apns2.NewTokenClient(apnsToken)
+	stores, closeStores := setupStores(loadConfig((*config.Config).ValidateStorage), nil)
+	defer closeStores(context.Background())
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create-key":
+		role := models.Role(args[2])
+		if !role.IsKnown() {
+			fmt.Fprintf(os.Stderr, "the role must be one of %v\n", models.Roles)
+			os.Exit(2)
+		}
+
+		apiKey, key, err := models.NewAPIKey(args[1], role)
+		if err == nil {
+			err = stores.APIKeys.Create(ctx, apiKey)
+		}
+
+		if err != nil {
+			panic(err)
+		}
 
-	if os.Getenv("GO_ENV") == "DEVELOPMENT" {
-		client = client.Development()
-	} else {
-		client = client.Production()
+		fmt.Printf("Created the %s key %s. Store it now, since it won't be shown again:\n%s\n", role, apiKey.KeyId, key)
+
+	case "list-keys":
+		keys, err := stores.APIKeys.List(ctx)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, key := range keys {
+			fmt.Printf("%s\t%s\t%s\t%s\n", key.KeyId, key.Role, key.CreatedAt.Format(time.RFC3339), key.Name)
+		}
+
+	case "revoke-key":
+		err := stores.APIKeys.Delete(ctx, args[1])
+		if errors.Is(err, storage.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "there is no key %s\n", args[1])
+			os.Exit(1)
+		}
+
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("Revoked the key %s\n", args[1])
+	}
+}
+
+func setupAPNS(server *handlers.Server, cfg config.APNSConfig) {
+	apnsToken, err := cfg.Token()
+	if err != nil {
+		panic(err)
 	}
 
-	handlers.SetAPNSClient(client)
+	server.APNS = apns2.NewTokenClient(apnsToken).Production()
+	server.APNSSandbox = apns2.NewTokenClient(apnsToken).Development()
+	server.APNSTopic = cfg.Topic
+
+	// Devices registered before they reported their environment were registered with the environment of the server
+	server.DefaultAPNSEnvironment = cfg.DefaultEnvironment
+}
+
+// Fans events out through a MongoDB change stream when running several replicas, or in-process otherwise. The
+// change stream is watched until the service shuts down
+func setupBroker(server *handlers.Server, service *lifecycle.Service, cfg config.StreamConfig) {
+	if cfg.Broker == config.BrokerMongo {
+		broker := stream.NewChangeStreamBroker()
+		server.Broker = broker
+		service.Go(broker.Run)
+	}
+}
+
+// Configures the GitHub API, and the GitHub App client if one is configured
+func setupGithub(server *handlers.Server, cfg config.GithubConfig) {
+	server.GithubBaseURL = cfg.APIURL
+	server.WebhookSecret = []byte(cfg.WebhookSecret)
+
+	if !cfg.HasApp() {
+		return
+	}
+
+	app, err := githubapp.NewClient(cfg.AppId, cfg.PrivateKeyPEM(), cfg.APIURL)
+	if err != nil {
+		panic(err)
+	}
+
+	server.GithubApp = app
+}
+
+// Limits the requests of each IP, User and installation, with buckets shared through MongoDB when running several
+// replicas, or kept in-process otherwise
+func setupRateLimits(server *handlers.Server, cfg config.RateLimitConfig) {
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.Store == config.RateLimitMongo {
+		store = ratelimit.NewMongoStore()
+	}
+
+	server.IPLimiter = ratelimit.New("ip", ratelimit.PerMinute(cfg.IPPerMinute, cfg.IPBurst), store)
+	server.UserLimiter = ratelimit.New("user", ratelimit.PerMinute(cfg.UserPerMinute, cfg.UserBurst), store)
+	server.InstallationLimiter = ratelimit.New("installation",
+		ratelimit.PerMinute(cfg.InstallationPerMinute, cfg.InstallationBurst), store)
+	server.MaxDevicesPerUser = cfg.MaxDevicesPerUser
+}
+
+// Exports traces to the configured collector, flushing the spans that are still buffered when the service shuts down.
+// Spans aren't recorded when no collector is configured
+func setupTracing(service *lifecycle.Service, cfg config.TracingConfig) {
+	if !cfg.Enabled() {
+		return
+	}
+
+	// The headers were validated with the configuration
+	headers, _ := cfg.HeaderMap()
+
+	options := []otlpgrpc.Option{otlpgrpc.WithEndpoint(cfg.Endpoint), otlpgrpc.WithHeaders(headers)}
+	if cfg.Insecure {
+		options = append(options, otlpgrpc.WithInsecure())
+	}
+
+	exporter, err := otlp.NewExporter(context.Background(), otlpgrpc.NewDriver(options...))
+	if err != nil {
+		panic(err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.ServiceNameKey.String(cfg.ServiceName),
+			semconv.ServiceVersionKey.String(buildinfo.Commit),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	service.OnShutdown("tracing", provider.Shutdown)
 }
 
 func main() {
-	setupAPNS()
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "config":
+		if flag.Arg(1) != "check" {
+			fmt.Fprintln(os.Stderr, "usage: push-request config check")
+			os.Exit(2)
+		}
+
+		checkConfig()
+		return
+
+	case "migrate":
+		setupStores(loadConfig((*config.Config).ValidateStorage), nil)
+		return
+
+	case "copy-mongo":
+		copyFromMongo(loadConfig((*config.Config).ValidateStorage))
+		return
+
+	case "admin":
+		manageAPIKeys(flag.Args()[1:])
+		return
+	}
+
+	cfg := loadConfig((*config.Config).Validate)
 
-	http.HandleFunc("/users", handlers.HandleUser)
-	http.HandleFunc("/webhook", handlers.HandleWebhook)
+	// The level was validated with the configuration
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logging.SetDefault(logging.New(os.Stdout, level))
 
-	fmt.Println("Listening...")
+	serverMetrics := metrics.New()
 
-	err := http.ListenAndServe(fmt.Sprintf(":%s", os.Getenv("PORT")), nil)
+	stores, closeStores := setupStores(cfg, serverMetrics.MongoMonitor())
+	server := handlers.NewServer(storage.Traced(stores))
+	server.Metrics = serverMetrics
+
+	server.MaxBodyBytes = cfg.HTTP.MaxBodyBytes
+	server.MaxWebhookBytes = cfg.HTTP.MaxWebhookBytes
+	server.CORSOrigins = cfg.HTTP.Origins()
+	server.TrustProxy = cfg.HTTP.TrustProxy
+
+	httpServer := &http.Server{
+		Handler:      server.Routes(),
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
+
+		// Event streams outlive the write timeout, so they manage the deadlines of their connections themselves
+		ConnContext: handlers.ConnContext,
+	}
+
+	// Shutdown doesn't wait for event streams, which are hijacked or never idle, so they are told to close
+	httpServer.RegisterOnShutdown(server.CloseStreams)
+
+	service := lifecycle.New(httpServer, cfg.HTTP.ShutdownTimeout)
+	service.DrainDelay = cfg.HTTP.DrainDelay
+
+	// Registered first so that it shuts down last, exporting the spans of shutting down
+	setupTracing(service, cfg.Tracing)
+
+	service.OnDrain(server.Drain)
+	service.OnShutdown("stores", closeStores)
+	service.OnShutdown("event streams", server.WaitForStreams)
+
+	setupAPNS(server, cfg.APNS)
+	setupBroker(server, service, cfg.Stream)
+
+	setupGithub(server, cfg.Github)
+	setupRateLimits(server, cfg.RateLimit)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
 	if err != nil {
 		panic(err)
 	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
+	logging.Default().Info("listening", "port", cfg.Port)
+
+	if err = service.Serve(listener, stop); err != nil {
+		logging.Default().Error("shut down", "error", err)
+		os.Exit(1)
+	}
 }
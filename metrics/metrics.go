@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+	"net/http"
+)
+
+const namespace = "push_request"
+
+// The outcomes of parsing a webhook into an event
+const (
+	ParseParsed  = "parsed"
+	ParseNil     = "nil_event"
+	ParseInvalid = "invalid"
+)
+
+// The reasons an event isn't delivered to a user
+const (
+	FilterUnsupported    = "unsupported_event"
+	FilterNoOwner        = "no_owner"
+	FilterTypeNotAllowed = "type_not_allowed"
+	FilterMuted          = "muted"
+	FilterSender         = "sender"
+	FilterDisabled       = "user_disabled"
+)
+
+// The results of sending a push notification
+const (
+	PushSent     = "sent"
+	PushRejected = "rejected"
+	PushError    = "error"
+)
+
+// Metrics holds the collectors of the server, registered with their own Registry so that servers created by tests
+// don't share them
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	// Webhooks received from GitHub, by `X-GitHub-Event` and action
+	WebhooksReceived *prometheus.CounterVec
+
+	// Webhooks parsed into an event, by event and outcome
+	WebhookParses *prometheus.CounterVec
+
+	// Events that weren't delivered to a user, by reason
+	EventsFiltered *prometheus.CounterVec
+
+	// Devices registered with POST /users, by whether their user was created
+	Registrations *prometheus.CounterVec
+
+	// Push notifications sent, by provider, environment, result and the reason given by the provider
+	Pushes *prometheus.CounterVec
+
+	// Requests refused by a rate limit, by the limit: ip, user, installation or devices
+	RateLimited *prometheus.CounterVec
+
+	// The duration of MongoDB commands, by command and result
+	MongoDuration *prometheus.HistogramVec
+
+	// The duration of HTTP requests, by route, method and status code. Event streams last as long as their client
+	// stays connected
+	HTTPDuration *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	metrics := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		WebhooksReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhooks_received_total",
+			Help:      "Webhooks received from GitHub, by event and action.",
+		}, []string{"event", "action"}),
+		WebhookParses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_parses_total",
+			Help:      "Webhooks parsed into an event, by event and outcome.",
+		}, []string{"event", "outcome"}),
+		EventsFiltered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_filtered_total",
+			Help:      "Events that weren't delivered to a user, by reason.",
+		}, []string{"reason"}),
+		Registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "device_registrations_total",
+			Help:      "Devices registered, by whether their user was created.",
+		}, []string{"user_created"}),
+		Pushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pushes_total",
+			Help:      "Push notifications sent, by provider, environment, result and reason.",
+		}, []string{"provider", "environment", "result", "reason"}),
+		RateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      "Requests refused by a rate limit, by limit.",
+		}, []string{"limit"}),
+		MongoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "mongo_command_duration_seconds",
+			Help:      "The duration of MongoDB commands, by command and result.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"command", "result"}),
+		HTTPDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "The duration of HTTP requests, by route, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+	}
+
+	metrics.Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		metrics.WebhooksReceived,
+		metrics.WebhookParses,
+		metrics.EventsFiltered,
+		metrics.Registrations,
+		metrics.Pushes,
+		metrics.RateLimited,
+		metrics.MongoDuration,
+		metrics.HTTPDuration,
+	)
+
+	return metrics
+}
+
+// Serves the metrics in the Prometheus text format
+func (metrics *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+}
+
+// Times the requests of a route. The route is the pattern the handler is registered with rather than the path of
+// each request, so that paths can't add label values
+func (metrics *Metrics) InstrumentRoute(route string, handler http.HandlerFunc) http.Handler {
+	return promhttp.InstrumentHandlerDuration(metrics.HTTPDuration.MustCurryWith(prometheus.Labels{"route": route}), handler)
+}
+
+// Creates a monitor timing the commands of a MongoDB client
+func (metrics *Metrics) MongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			metrics.MongoDuration.WithLabelValues(e.CommandName, "success").Observe(float64(e.DurationNanos) / 1e9)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			metrics.MongoDuration.WithLabelValues(e.CommandName, "failure").Observe(float64(e.DurationNanos) / 1e9)
+		},
+	}
+}
+
+// The actions of the webhooks GitHub sends the app
+var knownActions = map[string]bool{}
+
+func init() {
+	for _, action := range []string{
+		"added", "assigned", "auto_merge_disabled", "auto_merge_enabled", "closed", "converted_to_draft", "created",
+		"deleted", "demilestoned", "dismissed", "edited", "labeled", "locked", "milestoned", "new_permissions_accepted",
+		"opened", "pinned", "ready_for_review", "removed", "reopened", "review_request_removed", "review_requested",
+		"revoked", "started", "submitted", "suspend", "synchronize", "transferred", "unassigned", "unlabeled", "unlocked",
+		"unpinned", "unsuspend",
+	} {
+		knownActions[action] = true
+	}
+}
+
+// Gets the label of a webhook's action. Webhooks aren't signed, so unknown actions share a label, so that requests
+// can't create any number of label values
+func Action(action string) string {
+	switch {
+	case action == "":
+		return "none"
+	case knownActions[action]:
+		return action
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/Kamva/mgm"
+	"strings"
+)
+
+// A Role is what an APIKey may do with the admin API
+type Role string
+
+const (
+	// Reads users, installations, events and the audit log
+	RoleReadOnly Role = "read_only"
+
+	// Also sends test pushes, re-links installations and disables accounts
+	RoleOperator Role = "operator"
+)
+
+// The roles, from the least to the most privileged
+var Roles = []Role{RoleReadOnly, RoleOperator}
+
+func (role Role) IsKnown() bool {
+	return role.rank() >= 0
+}
+
+func (role Role) rank() int {
+	for i, known := range Roles {
+		if role == known {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Reports whether the role may do everything the other role may
+func (role Role) Includes(other Role) bool {
+	return role.IsKnown() && role.rank() >= other.rank()
+}
+
+// The prefix of every API key, so that leaked keys are easy to recognize
+const apiKeyPrefix = "prk"
+
+// An APIKey authenticates an operator of the admin API. Only a hash of the key is stored: the key itself is shown
+// once, when it is created
+type APIKey struct {
+	mgm.DefaultModel `bson:",inline"`
+
+	// The public part of the key, which identifies it in the audit log
+	KeyId string `json:"key_id" bson:"key_id"`
+	Name  string `json:"name" bson:"name"`
+	Role  Role   `json:"role" bson:"role"`
+
+	// The hexadecimal SHA-256 hash of the key
+	Hash string `json:"-" bson:"hash"`
+}
+
+func (key *APIKey) CollectionName() string {
+	return "api_keys"
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Creates an APIKey with a new random key, returned along with it. Keys have the form `prk_<key id>_<secret>`, and
+// their secret is long enough that a plain hash of them can't be reversed
+func NewAPIKey(name string, role Role) (*APIKey, string, error) {
+	keyId, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := apiKeyPrefix + "_" + keyId + "_" + secret
+	return &APIKey{KeyId: keyId, Name: name, Role: role, Hash: hashAPIKey(key)}, key, nil
+}
+
+// Gets the key id of an API key, or reports that the key isn't well-formed
+func ParseAPIKeyId(key string) (string, bool) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+// Reports whether the key is the one the APIKey was created with, in constant time
+func (key *APIKey) Matches(candidate string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashAPIKey(candidate)), []byte(key.Hash)) == 1
+}
@@ -0,0 +1,28 @@
+package models
+
+import "github.com/Kamva/mgm"
+
+// An AuditEntry records a request an APIKey made to the admin API, whether or not it succeeded
+type AuditEntry struct {
+	mgm.DefaultModel `bson:",inline"`
+	KeyId            string `json:"key_id" bson:"key_id"`
+	KeyName          string `json:"key_name" bson:"key_name"`
+	Role             Role   `json:"role" bson:"role"`
+
+	// What the request did, like `users.disable`
+	Action string `json:"action" bson:"action"`
+
+	// The user or installation the request was about, like `user:1` or `installation:2`, if any
+	Target string `json:"target,omitempty" bson:"target,omitempty"`
+
+	// The JSON body of the request, for actions that change data
+	Body string `json:"body,omitempty" bson:"body,omitempty"`
+
+	// The status the request was responded to with
+	Status    int    `json:"status" bson:"status"`
+	RequestId string `json:"request_id,omitempty" bson:"request_id,omitempty"`
+}
+
+func (entry *AuditEntry) CollectionName() string {
+	return "audit_log"
+}
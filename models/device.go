@@ -0,0 +1,98 @@
+package models
+
+import "time"
+
+type Platform string
+
+const (
+	PlatformIOS    Platform = "ios"
+	PlatformIPadOS Platform = "ipados"
+	PlatformMacOS  Platform = "macos"
+)
+
+// The APNs environment a device receives notifications from, which depends on how the app was signed
+type APNSEnvironment string
+
+const (
+	APNSProduction APNSEnvironment = "production"
+	APNSSandbox    APNSEnvironment = "sandbox"
+)
+
+// A Device of a user that receives notifications, identified by its APNs device token. Devices registered before
+// their details were reported only have a token
+type Device struct {
+	Token       string          `json:"token" bson:"-"`
+	Platform    Platform        `json:"platform,omitempty" bson:"platform,omitempty"`
+	Environment APNSEnvironment `json:"environment,omitempty" bson:"environment,omitempty"`
+	AppVersion  string          `json:"app_version,omitempty" bson:"app_version,omitempty"`
+	Locale      string          `json:"locale,omitempty" bson:"locale,omitempty"`
+	Name        string          `json:"name,omitempty" bson:"name,omitempty"`
+
+	// When the app last registered the device, which it does every time it launches
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" bson:"last_seen_at,omitempty"`
+
+	// The event types the device is notified of, overriding the user's allowed types. Nil unless the user chose
+	// different types for this device
+	AllowedTypes []EventType `json:"allowed_types" bson:"allowed_types"`
+}
+
+// Reports whether the device's own allowed types let events of the type through. Devices that don't override the
+// allowed types of their user let every event through
+func (device *Device) Allows(eventType EventType) bool {
+	return device.AllowedTypes == nil || containsEventType(device.AllowedTypes, eventType)
+}
+
+// Lists the devices of the user in the order they were registered
+func (user *User) ListDevices() []Device {
+	devices := make([]Device, 0, len(user.DeviceTokens))
+
+	for _, token := range user.DeviceTokens {
+		device := user.Devices[token]
+		device.Token = token
+		devices = append(devices, device)
+	}
+
+	return devices
+}
+
+// Reports whether the device is registered to the user
+func (user *User) HasDevice(token string) bool {
+	for _, deviceToken := range user.DeviceTokens {
+		if deviceToken == token {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lists the devices of the user that want events of the type, by their own allowed types if they override the
+// user's, or else the user's
+func (user *User) DevicesAllowing(eventType EventType) []Device {
+	var devices []Device
+
+	for _, device := range user.ListDevices() {
+		if device.AllowedTypes != nil && device.Allows(eventType) ||
+			device.AllowedTypes == nil && containsEventType(user.AllowedTypes, eventType) {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices
+}
+
+// Reports whether the user wants events of the type, on all of their devices or on one that overrides their
+// allowed types
+func (user *User) AllowsEventType(eventType EventType) bool {
+	return containsEventType(user.AllowedTypes, eventType) || len(user.DevicesAllowing(eventType)) > 0
+}
+
+func containsEventType(array []EventType, element EventType) bool {
+	for _, a := range array {
+		if a == element {
+			return true
+		}
+	}
+
+	return false
+}
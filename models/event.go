@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"github.com/Kamva/mgm"
+	"time"
+)
 
 type EventType string
 
@@ -8,13 +11,28 @@ const (
 	IssueOpened       EventType = "issueOpened"
 	IssueClosed       EventType = "issueClosed"
 	IssueAssigned     EventType = "issueAssigned"
+	IssueCommented    EventType = "issueCommented"
 	PrOpened          EventType = "prOpened"
 	PrClosed          EventType = "prClosed"
 	PrMerged          EventType = "prMerged"
 	PrReviewRequested EventType = "prReviewRequested"
 	PrReviewed        EventType = "prReviewed"
+	PrCommented       EventType = "prCommented"
+	Mentioned         EventType = "mentioned"
 )
 
+// Every event type a user can allow
+var EventTypes = []EventType{
+	IssueOpened, IssueClosed, IssueAssigned, IssueCommented,
+	PrOpened, PrClosed, PrMerged, PrReviewRequested, PrReviewed, PrCommented,
+	Mentioned,
+}
+
+// Reports whether the event type is one of EventTypes
+func (eventType EventType) IsKnown() bool {
+	return containsEventType(EventTypes, eventType)
+}
+
 type Event struct {
 	EventType      EventType `json:"event_type"`
 	RepoName       string    `json:"repo_name"`
@@ -25,6 +43,12 @@ type Event struct {
 	Timestamp      time.Time `json:"timestamp"`
 	Url            string    `json:"url"`
 	InstallationId int64     `json:"installation_id"`
+
+	// Context fetched from the GitHub API for pull request events, when a GitHub App is configured
+	Additions    int    `json:"additions,omitempty"`
+	Deletions    int    `json:"deletions,omitempty"`
+	ChangedFiles int    `json:"changed_files,omitempty"`
+	CIStatus     string `json:"ci_status,omitempty"`
 }
 
 func NewEvent(
@@ -43,3 +67,10 @@ func NewEvent(
 		InstallationId: installationId,
 	}
 }
+
+// A StoredEvent is an Event that was delivered to a user, kept so it can be rendered in the user's feed
+type StoredEvent struct {
+	mgm.DefaultModel `bson:",inline"`
+	GithubId         int64 `json:"github_id" bson:"github_id"`
+	Event            Event `json:"event" bson:"event"`
+}
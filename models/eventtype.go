@@ -0,0 +1,245 @@
+package models
+
+import "time"
+
+// The language of text that isn't translated into the language asked for
+const DefaultLanguage = "en"
+
+// Text translated into several languages, keyed by ISO 639-1 language code
+type Localized map[string]string
+
+// Gets the text in the language, or in DefaultLanguage if it wasn't translated into it
+func (localized Localized) In(language string) string {
+	if text, ok := localized[language]; ok {
+		return text
+	}
+
+	return localized[DefaultLanguage]
+}
+
+// The languages the event type catalog is translated into
+var Languages = []string{"en", "de", "es", "fr"}
+
+// A group of related event types, shown as a section of the app's settings
+type EventCategory string
+
+const (
+	CategoryIssues       EventCategory = "issues"
+	CategoryPullRequests EventCategory = "pull_requests"
+	CategoryMentions     EventCategory = "mentions"
+)
+
+// The categories of the event type catalog, in the order they are shown
+var EventCategories = []EventCategory{CategoryIssues, CategoryPullRequests, CategoryMentions}
+
+var categoryLabels = map[EventCategory]Localized{
+	CategoryIssues:       {"en": "Issues", "de": "Issues", "es": "Issues", "fr": "Issues"},
+	CategoryPullRequests: {"en": "Pull requests", "de": "Pull Requests", "es": "Pull requests", "fr": "Pull requests"},
+	CategoryMentions:     {"en": "Mentions", "de": "Erwähnungen", "es": "Menciones", "fr": "Mentions"},
+}
+
+func (category EventCategory) Label() Localized {
+	return categoryLabels[category]
+}
+
+// Describes an event type for the app's settings, so new types can be offered without a release of the app
+type EventTypeInfo struct {
+	Type        EventType
+	Category    EventCategory
+	Label       Localized
+	Description Localized
+
+	// An event of the type, as the parsers would produce it, to preview its notification
+	Sample Event
+
+	// Whether users who don't choose their allowed types are notified of the type
+	DefaultOn bool
+}
+
+var sampleTime = time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+func sampleEvent(eventType EventType, number int, title string, description string) Event {
+	return Event{
+		EventType:   eventType,
+		RepoName:    "Codertocat/Hello-World",
+		Number:      number,
+		Title:       title,
+		Description: description,
+		AvatarUrl:   "https://avatars.githubusercontent.com/u/21031067",
+		Timestamp:   sampleTime,
+		Url:         "https://github.com/Codertocat/Hello-World",
+	}
+}
+
+// The catalog of every event type in EventTypes, in the order they are shown
+var EventTypeCatalog = []EventTypeInfo{
+	{
+		Type:     IssueOpened,
+		Category: CategoryIssues,
+		Label:    Localized{"en": "Issue opened", "de": "Issue eröffnet", "es": "Issue abierto", "fr": "Issue ouverte"},
+		Description: Localized{
+			"en": "Someone opens an issue in one of your repositories",
+			"de": "Jemand eröffnet ein Issue in einem deiner Repositorys",
+			"es": "Alguien abre un issue en uno de tus repositorios",
+			"fr": "Quelqu'un ouvre une issue dans l'un de vos dépôts",
+		},
+		Sample:    sampleEvent(IssueOpened, 1, "Spelling error in the README file", "Opened #1"),
+		DefaultOn: true,
+	},
+	{
+		Type:     IssueClosed,
+		Category: CategoryIssues,
+		Label:    Localized{"en": "Issue closed", "de": "Issue geschlossen", "es": "Issue cerrado", "fr": "Issue fermée"},
+		Description: Localized{
+			"en": "An issue in one of your repositories is closed",
+			"de": "Ein Issue in einem deiner Repositorys wird geschlossen",
+			"es": "Se cierra un issue en uno de tus repositorios",
+			"fr": "Une issue de l'un de vos dépôts est fermée",
+		},
+		Sample: sampleEvent(IssueClosed, 1, "Spelling error in the README file", "Closed #1"),
+	},
+	{
+		Type:     IssueAssigned,
+		Category: CategoryIssues,
+		Label:    Localized{"en": "Issue assigned", "de": "Issue zugewiesen", "es": "Issue asignado", "fr": "Issue assignée"},
+		Description: Localized{
+			"en": "An issue in one of your repositories is assigned to someone",
+			"de": "Ein Issue in einem deiner Repositorys wird jemandem zugewiesen",
+			"es": "Un issue de uno de tus repositorios se asigna a alguien",
+			"fr": "Une issue de l'un de vos dépôts est assignée à quelqu'un",
+		},
+		Sample:    sampleEvent(IssueAssigned, 1, "Spelling error in the README file", "Assigned #1 to @Codertocat"),
+		DefaultOn: true,
+	},
+	{
+		Type:     IssueCommented,
+		Category: CategoryIssues,
+		Label: Localized{
+			"en": "Issue comment", "de": "Kommentar zu Issue", "es": "Comentario en issue", "fr": "Commentaire sur une issue",
+		},
+		Description: Localized{
+			"en": "Someone comments on an issue in one of your repositories",
+			"de": "Jemand kommentiert ein Issue in einem deiner Repositorys",
+			"es": "Alguien comenta un issue de uno de tus repositorios",
+			"fr": "Quelqu'un commente une issue de l'un de vos dépôts",
+		},
+		Sample:    sampleEvent(IssueCommented, 1, "Spelling error in the README file", "@Codertocat commented on #1"),
+		DefaultOn: true,
+	},
+	{
+		Type:     PrOpened,
+		Category: CategoryPullRequests,
+		Label: Localized{
+			"en": "Pull request opened", "de": "Pull Request eröffnet", "es": "Pull request abierto", "fr": "Pull request ouverte",
+		},
+		Description: Localized{
+			"en": "Someone opens a pull request in one of your repositories",
+			"de": "Jemand eröffnet einen Pull Request in einem deiner Repositorys",
+			"es": "Alguien abre un pull request en uno de tus repositorios",
+			"fr": "Quelqu'un ouvre une pull request dans l'un de vos dépôts",
+		},
+		Sample:    sampleEvent(PrOpened, 2, "Update the README with new information", "Opened #2"),
+		DefaultOn: true,
+	},
+	{
+		Type:     PrClosed,
+		Category: CategoryPullRequests,
+		Label: Localized{
+			"en": "Pull request closed", "de": "Pull Request geschlossen", "es": "Pull request cerrado", "fr": "Pull request fermée",
+		},
+		Description: Localized{
+			"en": "A pull request in one of your repositories is closed without being merged",
+			"de": "Ein Pull Request in einem deiner Repositorys wird geschlossen, ohne gemergt zu werden",
+			"es": "Se cierra un pull request de uno de tus repositorios sin fusionarlo",
+			"fr": "Une pull request de l'un de vos dépôts est fermée sans être fusionnée",
+		},
+		Sample: sampleEvent(PrClosed, 2, "Update the README with new information", "Closed #2"),
+	},
+	{
+		Type:     PrMerged,
+		Category: CategoryPullRequests,
+		Label: Localized{
+			"en": "Pull request merged", "de": "Pull Request gemergt", "es": "Pull request fusionado", "fr": "Pull request fusionnée",
+		},
+		Description: Localized{
+			"en": "A pull request in one of your repositories is merged",
+			"de": "Ein Pull Request in einem deiner Repositorys wird gemergt",
+			"es": "Se fusiona un pull request de uno de tus repositorios",
+			"fr": "Une pull request de l'un de vos dépôts est fusionnée",
+		},
+		Sample:    sampleEvent(PrMerged, 2, "Update the README with new information", "Merged #2 into master"),
+		DefaultOn: true,
+	},
+	{
+		Type:     PrReviewRequested,
+		Category: CategoryPullRequests,
+		Label: Localized{
+			"en": "Review requested", "de": "Review angefordert", "es": "Revisión solicitada", "fr": "Revue demandée",
+		},
+		Description: Localized{
+			"en": "Someone's review is requested on a pull request in one of your repositories",
+			"de": "Für einen Pull Request in einem deiner Repositorys wird ein Review angefordert",
+			"es": "Se solicita una revisión de un pull request de uno de tus repositorios",
+			"fr": "Une revue est demandée sur une pull request de l'un de vos dépôts",
+		},
+		Sample:    sampleEvent(PrReviewRequested, 2, "Update the README with new information", "Requested review by @Octocat"),
+		DefaultOn: true,
+	},
+	{
+		Type:     PrReviewed,
+		Category: CategoryPullRequests,
+		Label: Localized{
+			"en": "Pull request reviewed", "de": "Pull Request reviewt", "es": "Pull request revisado", "fr": "Pull request revue",
+		},
+		Description: Localized{
+			"en": "Someone approves, requests changes on or reviews a pull request in one of your repositories",
+			"de": "Jemand genehmigt, reviewt oder fordert Änderungen an einem Pull Request in einem deiner Repositorys an",
+			"es": "Alguien aprueba, revisa o pide cambios en un pull request de uno de tus repositorios",
+			"fr": "Quelqu'un approuve, revoit ou demande des modifications sur une pull request de l'un de vos dépôts",
+		},
+		Sample:    sampleEvent(PrReviewed, 2, "Update the README with new information", "Approved #2"),
+		DefaultOn: true,
+	},
+	{
+		Type:     PrCommented,
+		Category: CategoryPullRequests,
+		Label: Localized{
+			"en": "Pull request comment", "de": "Kommentar zu Pull Request", "es": "Comentario en pull request",
+			"fr": "Commentaire sur une pull request",
+		},
+		Description: Localized{
+			"en": "Someone comments on a pull request in one of your repositories",
+			"de": "Jemand kommentiert einen Pull Request in einem deiner Repositorys",
+			"es": "Alguien comenta un pull request de uno de tus repositorios",
+			"fr": "Quelqu'un commente une pull request de l'un de vos dépôts",
+		},
+		Sample:    sampleEvent(PrCommented, 2, "Update the README with new information", "@Codertocat commented on #2"),
+		DefaultOn: true,
+	},
+	{
+		Type:     Mentioned,
+		Category: CategoryMentions,
+		Label:    Localized{"en": "Mentions", "de": "Erwähnungen", "es": "Menciones", "fr": "Mentions"},
+		Description: Localized{
+			"en": "Someone mentions you in an issue, pull request, review or comment",
+			"de": "Jemand erwähnt dich in einem Issue, Pull Request, Review oder Kommentar",
+			"es": "Alguien te menciona en un issue, pull request, revisión o comentario",
+			"fr": "Quelqu'un vous mentionne dans une issue, une pull request, une revue ou un commentaire",
+		},
+		Sample:    sampleEvent(Mentioned, 2, "Update the README with new information", "@Octocat mentioned you in #2"),
+		DefaultOn: true,
+	},
+}
+
+// Lists the event types users are notified of unless they choose their allowed types
+func DefaultEventTypes() []EventType {
+	eventTypes := []EventType{}
+
+	for _, info := range EventTypeCatalog {
+		if info.DefaultOn {
+			eventTypes = append(eventTypes, info.Type)
+		}
+	}
+
+	return eventTypes
+}
@@ -0,0 +1,26 @@
+package models
+
+import "github.com/Kamva/mgm"
+
+type SubscriptionReason string
+
+const (
+	ReasonManual          SubscriptionReason = "manual"
+	ReasonAuthor          SubscriptionReason = "author"
+	ReasonAssigned        SubscriptionReason = "assigned"
+	ReasonReviewRequested SubscriptionReason = "reviewRequested"
+	ReasonCommented       SubscriptionReason = "commented"
+	ReasonReviewed        SubscriptionReason = "reviewed"
+	ReasonMentioned       SubscriptionReason = "mentioned"
+)
+
+// A Subscription of a user to the activity on the issue or pull request `number` of the repository `repo_name`.
+// A muted subscription silences the thread for the user instead, even for event types the user allows
+type Subscription struct {
+	mgm.DefaultModel `bson:",inline"`
+	GithubId         int64              `json:"github_id" bson:"github_id"`
+	RepoName         string             `json:"repo_name" bson:"repo_name"`
+	Number           int                `json:"number" bson:"number"`
+	Reason           SubscriptionReason `json:"reason" bson:"reason"`
+	Muted            bool               `json:"muted" bson:"muted"`
+}
@@ -1,37 +1,58 @@
 package models
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/Kamva/mgm"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 type User struct {
 	mgm.DefaultModel `bson:",inline"`
 	GithubId         int64       `json:"github_id" bson:"github_id"`
 	DeviceTokens     []string    `json:"device_tokens" bson:"device_tokens"`
-	LatestEvent      *Event      `json:"latest_event,omitempty" bson:"latest_event,omitempty"`
 	AllowedTypes     []EventType `json:"allowed_types" bson:"allowed_types"`
+	FeedToken        string      `json:"feed_token,omitempty" bson:"feed_token,omitempty"`
+
+	// Incremented by every update of the user, so updates made from a stale copy can be detected
+	Version int64 `json:"version" bson:"version"`
+
+	// Set by operators to stop the user from using the API and being sent notifications
+	Disabled bool `json:"disabled,omitempty" bson:"disabled,omitempty"`
+
+	// The details of the devices in DeviceTokens, keyed by device token
+	Devices map[string]Device `json:"-" bson:"devices,omitempty"`
+
+	// The most recent event delivered to the user. It isn't stored with the user, but read from the event history
+	// when the user is returned by the API
+	LatestEvent *Event `json:"latest_event,omitempty" bson:"-"`
 }
 
-func CreateUser(githubId int64, deviceToken string, allowedTypes []EventType) error {
-	user := &User{
-		GithubId:     githubId,
-		DeviceTokens: []string{deviceToken},
-		LatestEvent:  nil,
-		AllowedTypes: allowedTypes,
+// Generates a random secret token used to authenticate requests for the user's feed
+func NewFeedToken() (string, error) {
+	bytes := make([]byte, 20)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
 	}
 
-	return mgm.Coll(user).Create(user)
+	return hex.EncodeToString(bytes), nil
 }
 
-func (user *User) Save() error {
-	return mgm.Coll(user).Update(user)
-}
+// Creates a User with the given device token and a new feed token. Users who don't choose their allowed types are
+// given DefaultEventTypes
+func NewUser(githubId int64, deviceToken string, allowedTypes []EventType) (*User, error) {
+	feedToken, err := NewFeedToken()
+	if err != nil {
+		return nil, err
+	}
 
-func GetUser(githubId int64) (user *User, error error) {
-	res := &User{}
-	coll := mgm.Coll(user)
+	if allowedTypes == nil {
+		allowedTypes = DefaultEventTypes()
+	}
 
-	err := coll.First(bson.M{"github_id": githubId}, res)
-	return res, err
+	return &User{
+		GithubId:     githubId,
+		DeviceTokens: []string{deviceToken},
+		AllowedTypes: allowedTypes,
+		FeedToken:    feedToken,
+	}, nil
 }
@@ -0,0 +1,197 @@
+package openapi
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The version of the OpenAPI Specification documents are written in
+const Version = "3.0.3"
+
+// A Document is an OpenAPI document. Only the parts of the specification the API uses are modelled, so that the
+// document can be written in Go and checked by the compiler
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+
+	// Gets the value of a path parameter of a request, which only the router that matched it knows. Path parameters
+	// aren't validated without it
+	PathParam func(r *http.Request, name string) string `json:"-"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// The operations of a path, by method
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Head   *Operation `json:"head,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Patch  *Operation `json:"patch,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+}
+
+// The security schemes a request must satisfy one of, each with its scopes
+type SecurityRequirement map[string][]string
+
+type Operation struct {
+	OperationId string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Security    []SecurityRequirement `json:"security,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+}
+
+// The locations of parameters
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+)
+
+// A Parameter of an operation. Query parameters with an array schema may be repeated, and path parameters must be
+// required
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Name        string `json:"name,omitempty"`
+	In          string `json:"in,omitempty"`
+
+	// The HTTP authentication scheme of a scheme of type `http`, like `bearer`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// The types of schemas
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeArray   = "array"
+	TypeObject  = "object"
+)
+
+// A Schema describes a JSON value. A schema with a Ref is replaced by the schema it refers to
+type Schema struct {
+	Ref string `json:"$ref,omitempty"`
+
+	Type        string `json:"type,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Nullable    bool   `json:"nullable,omitempty"`
+
+	// Strings
+	Enum      []string `json:"enum,omitempty"`
+	MinLength *int     `json:"minLength,omitempty"`
+	MaxLength *int     `json:"maxLength,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+
+	// Numbers
+	Minimum *float64 `json:"minimum,omitempty"`
+
+	// Arrays
+	Items *Schema `json:"items,omitempty"`
+
+	// Objects. Properties that aren't listed are allowed, and described by AdditionalProperties if it is set
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Refers to a schema of the document's components
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// Creates an int for the length constraints of a Schema
+func Int(value int) *int {
+	return &value
+}
+
+// Creates a float64 for the numeric constraints of a Schema
+func Float(value float64) *float64 {
+	return &value
+}
+
+// Lists the operations of the path, by method
+func (item *PathItem) Operations() map[string]*Operation {
+	operations := map[string]*Operation{}
+
+	for method, operation := range map[string]*Operation{
+		http.MethodGet:    item.Get,
+		http.MethodHead:   item.Head,
+		http.MethodPost:   item.Post,
+		http.MethodPatch:  item.Patch,
+		http.MethodDelete: item.Delete,
+	} {
+		if operation != nil {
+			operations[method] = operation
+		}
+	}
+
+	return operations
+}
+
+// Gets the operation of the method on the path, which is a path template of the document, or nil if there isn't one
+func (doc *Document) Operation(method string, path string) *Operation {
+	item, ok := doc.Paths[path]
+	if !ok {
+		return nil
+	}
+
+	return item.Operations()[method]
+}
+
+// Gets the schema a schema refers to, or the schema itself if it doesn't refer to another
+func (doc *Document) resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+
+	return schema
+}
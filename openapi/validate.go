@@ -0,0 +1,348 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"push-request/validation"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Returned when a body that must be JSON isn't
+var ErrMalformedJSON = errors.New("the body is not valid JSON")
+
+// Patterns are compiled the first time they are used
+var patterns sync.Map
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if compiled, ok := patterns.Load(pattern); ok {
+		return compiled.(*regexp.Regexp), nil
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns.Store(pattern, compiled)
+	return compiled, nil
+}
+
+// Collects the values of a request or response that don't match the document
+type validator struct {
+	validation.Validator
+	doc *Document
+}
+
+func child(field string, name string) string {
+	if field == "" {
+		return name
+	}
+
+	return field + "." + name
+}
+
+// Describes the values of a type, for the message of a value of another type
+func describeType(schemaType string) string {
+	switch schemaType {
+	case TypeInteger, TypeNumber:
+		return "a number"
+	case TypeArray, TypeObject:
+		return "an " + schemaType
+	default:
+		return "a " + schemaType
+	}
+}
+
+// Checks the value, decoded from JSON with numbers as json.Number, against the schema
+func (v *validator) validate(schema *Schema, field string, value interface{}) {
+	schema = v.doc.resolve(schema)
+	if schema == nil {
+		return
+	}
+
+	if value == nil {
+		v.Check(schema.Nullable || schema.Type == "", field, "must not be null")
+		return
+	}
+
+	switch value := value.(type) {
+	case string:
+		if v.Check(schema.Type == "" || schema.Type == TypeString, field, "must be %s", describeType(schema.Type)) {
+			v.validateString(schema, field, value)
+		}
+
+	case json.Number:
+		if v.Check(schema.Type == "" || schema.Type == TypeNumber || schema.Type == TypeInteger, field, "must be %s",
+			describeType(schema.Type)) {
+			v.validateNumber(schema, field, value)
+		}
+
+	case bool:
+		v.Check(schema.Type == "" || schema.Type == TypeBoolean, field, "must be %s", describeType(schema.Type))
+
+	case []interface{}:
+		if v.Check(schema.Type == "" || schema.Type == TypeArray, field, "must be %s", describeType(schema.Type)) {
+			for i, item := range value {
+				v.validate(schema.Items, fmt.Sprintf("%s[%d]", field, i), item)
+			}
+		}
+
+	case map[string]interface{}:
+		if v.Check(schema.Type == "" || schema.Type == TypeObject, field, "must be %s", describeType(schema.Type)) {
+			v.validateObject(schema, field, value)
+		}
+	}
+}
+
+func (v *validator) validateString(schema *Schema, field string, value string) {
+	if len(schema.Enum) > 0 {
+		known := false
+		for _, allowed := range schema.Enum {
+			known = known || allowed == value
+		}
+
+		if schema.Title != "" {
+			v.Check(known, field, "unknown %s %q", schema.Title, value)
+		} else {
+			v.Check(known, field, "must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+	}
+
+	length := utf8.RuneCountInString(value)
+
+	switch {
+	case schema.MinLength != nil && schema.MaxLength != nil:
+		v.Check(length >= *schema.MinLength && length <= *schema.MaxLength, field,
+			"must be between %d and %d characters", *schema.MinLength, *schema.MaxLength)
+	case schema.MinLength != nil && *schema.MinLength == 1:
+		v.Check(length >= 1, field, "must not be empty")
+	case schema.MinLength != nil:
+		v.Check(length >= *schema.MinLength, field, "must be at least %d characters", *schema.MinLength)
+	case schema.MaxLength != nil:
+		v.Check(length <= *schema.MaxLength, field, "must be at most %d characters", *schema.MaxLength)
+	}
+
+	if schema.Pattern != "" {
+		pattern, err := compilePattern(schema.Pattern)
+		v.Check(err == nil && pattern.MatchString(value), field, "must match %s", schema.Pattern)
+	}
+
+	if schema.Format == "date-time" {
+		_, err := time.Parse(time.RFC3339Nano, value)
+		v.Check(err == nil, field, "must be a date-time")
+	}
+}
+
+func (v *validator) validateNumber(schema *Schema, field string, value json.Number) {
+	number, err := value.Float64()
+	if !v.Check(err == nil, field, "must be a number") {
+		return
+	}
+
+	if schema.Type == TypeInteger {
+		_, err = strconv.ParseInt(value.String(), 10, 64)
+		if !v.Check(err == nil, field, "must be a whole number") {
+			return
+		}
+	}
+
+	if schema.Minimum != nil {
+		v.Check(number >= *schema.Minimum, field, "must be at least %v", *schema.Minimum)
+	}
+}
+
+func (v *validator) validateObject(schema *Schema, field string, value map[string]interface{}) {
+	for _, name := range schema.Required {
+		_, ok := value[name]
+		v.Check(ok, child(field, name), "is required")
+	}
+
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if property, ok := schema.Properties[name]; ok {
+			v.validate(property, child(field, name), value[name])
+		} else if schema.AdditionalProperties != nil {
+			v.validate(schema.AdditionalProperties, child(field, name), value[name])
+		}
+	}
+}
+
+// Checks the value against the schema, reporting the problems found as a validation.Error, with fields named from
+// the field given
+func (doc *Document) Validate(schema *Schema, field string, value interface{}) error {
+	v := validator{doc: doc}
+	v.validate(schema, field, value)
+
+	return v.Err()
+}
+
+// Decodes the JSON, keeping numbers as json.Number so that integers can be told apart from other numbers
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, ErrMalformedJSON
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, ErrMalformedJSON
+	}
+
+	return value, nil
+}
+
+// Converts the text of a parameter into the JSON value its schema describes, leaving text that can't be converted
+// as a string so that it is reported as the wrong type
+func (doc *Document) parameterValue(schema *Schema, text string) interface{} {
+	switch doc.resolve(schema).Type {
+	case TypeInteger, TypeNumber:
+		if _, err := strconv.ParseFloat(text, 64); err == nil {
+			return json.Number(text)
+		}
+	case TypeBoolean:
+		if parsed, err := strconv.ParseBool(text); err == nil {
+			return parsed
+		}
+	}
+
+	return text
+}
+
+func (v *validator) validateParameters(operation *Operation, r *http.Request) {
+	query := r.URL.Query()
+
+	for _, parameter := range operation.Parameters {
+		var values []string
+
+		switch parameter.In {
+		case InPath:
+			if v.doc.PathParam == nil {
+				continue
+			}
+
+			if value := v.doc.PathParam(r, parameter.Name); value != "" {
+				values = []string{value}
+			}
+		case InQuery:
+			values = query[parameter.Name]
+		case InHeader:
+			values = r.Header.Values(parameter.Name)
+		}
+
+		if len(values) == 0 {
+			v.Check(!parameter.Required, parameter.Name, "is required")
+			continue
+		}
+
+		schema := v.doc.resolve(parameter.Schema)
+
+		if schema.Type == TypeArray {
+			items := make([]interface{}, len(values))
+			for i, value := range values {
+				items[i] = v.doc.parameterValue(schema.Items, value)
+			}
+
+			v.validate(schema, parameter.Name, items)
+			continue
+		}
+
+		v.validate(schema, parameter.Name, v.doc.parameterValue(schema, values[0]))
+	}
+}
+
+// Checks the parameters and JSON body of a request against the operation. The body is read and replaced, so that
+// handlers can still read it. Errors reading the body are returned as they are, and bodies that aren't JSON as
+// ErrMalformedJSON
+func (doc *Document) ValidateRequest(operation *Operation, r *http.Request) error {
+	v := validator{doc: doc}
+	v.validateParameters(operation, r)
+
+	if operation.RequestBody != nil {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		_ = r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+		mediaType := operation.RequestBody.Content["application/json"]
+
+		switch {
+		case len(bytes.TrimSpace(data)) == 0:
+			v.Check(!operation.RequestBody.Required, "body", "is required")
+
+		case mediaType != nil:
+			value, err := decodeJSON(data)
+			if err != nil {
+				return err
+			}
+
+			v.validate(mediaType.Schema, "", value)
+		}
+	}
+
+	return v.Err()
+}
+
+// Checks a response to the operation against the responses it documents: its status must be documented, and a JSON
+// body must match the schema of its status
+func (doc *Document) ValidateResponse(operation *Operation, status int, header http.Header, body []byte) error {
+	v := validator{doc: doc}
+
+	response, ok := operation.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = operation.Responses["default"]
+	}
+
+	if !v.Check(ok, "status", "%d isn't documented", status) {
+		return v.Err()
+	}
+
+	for name, documented := range response.Headers {
+		v.Check(!documented.Required || header.Get(name) != "", name, "is required")
+	}
+
+	if len(response.Content) == 0 {
+		v.Check(len(body) == 0, "body", "must be empty")
+		return v.Err()
+	}
+
+	contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+
+	mediaType, ok := response.Content[contentType]
+	if !v.Check(ok, "Content-Type", "%q isn't documented", contentType) {
+		return v.Err()
+	}
+
+	if contentType == "application/json" && mediaType.Schema != nil {
+		value, err := decodeJSON(body)
+		if err != nil {
+			return err
+		}
+
+		v.validate(mediaType.Schema, "", value)
+	}
+
+	return v.Err()
+}
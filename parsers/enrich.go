@@ -0,0 +1,55 @@
+package parsers
+
+import (
+	"context"
+	"fmt"
+	"push-request/githubapp"
+	"push-request/models"
+	"strings"
+)
+
+func isPullRequestEvent(eventType models.EventType) bool {
+	switch eventType {
+	case models.PrOpened, models.PrClosed, models.PrMerged, models.PrReviewRequested, models.PrReviewed:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// Adds context that is not part of the webhook payload to the event, fetched from the GitHub API as the installation
+// the event was delivered to. Pull request events are given their diff stats and the combined CI status of their head
+func EnrichEvent(ctx context.Context, app *githubapp.Client, event *models.Event) error {
+	if !isPullRequestEvent(event.EventType) {
+		return nil
+	}
+
+	parts := strings.SplitN(event.RepoName, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid repo name %q", event.RepoName)
+	}
+
+	owner, repo := parts[0], parts[1]
+	client := app.Installation(event.InstallationId)
+
+	pr, _, err := client.PullRequests.Get(ctx, owner, repo, event.Number)
+	if err != nil {
+		return fmt.Errorf("failed to get pull request %s#%d (%w)", event.RepoName, event.Number, err)
+	}
+
+	event.Additions = pr.GetAdditions()
+	event.Deletions = pr.GetDeletions()
+	event.ChangedFiles = pr.GetChangedFiles()
+
+	status, _, err := client.Repositories.GetCombinedStatus(ctx, owner, repo, pr.GetHead().GetSHA(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to get status of %s@%s (%w)", event.RepoName, pr.GetHead().GetSHA(), err)
+	}
+
+	if status.GetTotalCount() > 0 {
+		event.CIStatus = status.GetState()
+	}
+
+	return nil
+}
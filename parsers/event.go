@@ -6,6 +6,10 @@ import (
 	"push-request/models"
 )
 
+// The webhook events ParseRawEventPayload parses into notifications, by the name GitHub gives them in the
+// `X-GitHub-Event` header
+var ParsedEvents = []string{"issues", "issue_comment", "pull_request", "pull_request_review", "pull_request_review_comment"}
+
 func ParseRawEventPayload(payload interface{}) *models.Event {
 	var parsedEvent *models.Event
 
@@ -19,6 +23,12 @@ func ParseRawEventPayload(payload interface{}) *models.Event {
 	case *github.PullRequestEvent:
 		parsedEvent = parsePullRequest(e)
 
+	case *github.IssueCommentEvent:
+		parsedEvent = parseIssueComment(e)
+
+	case *github.PullRequestReviewCommentEvent:
+		parsedEvent = parsePRReviewComment(e)
+
 	default:
 		return nil
 	}
@@ -134,3 +144,49 @@ func parseIssuesEvent(e *github.IssuesEvent) *models.Event {
 		e.GetInstallation().GetID(),
 	)
 }
+
+func parseIssueComment(e *github.IssueCommentEvent) *models.Event {
+	issue := e.GetIssue()
+
+	if e.GetAction() != "created" {
+		return nil
+	}
+
+	// Comments on pull requests are delivered as comments on their issue
+	eventType := models.IssueCommented
+	if issue.IsPullRequest() {
+		eventType = models.PrCommented
+	}
+
+	return models.NewEvent(
+		eventType,
+		e.GetRepo().GetFullName(),
+		issue.GetNumber(),
+		issue.GetTitle(),
+		fmt.Sprintf("@%s commented on #%d", e.GetSender().GetLogin(), issue.GetNumber()),
+		e.GetSender().GetAvatarURL(),
+		e.GetComment().GetCreatedAt(),
+		e.GetComment().GetHTMLURL(),
+		e.GetInstallation().GetID(),
+	)
+}
+
+func parsePRReviewComment(e *github.PullRequestReviewCommentEvent) *models.Event {
+	pr := e.GetPullRequest()
+
+	if e.GetAction() != "created" {
+		return nil
+	}
+
+	return models.NewEvent(
+		models.PrCommented,
+		e.GetRepo().GetFullName(),
+		pr.GetNumber(),
+		pr.GetTitle(),
+		fmt.Sprintf("@%s commented on #%d", e.GetSender().GetLogin(), pr.GetNumber()),
+		e.GetSender().GetAvatarURL(),
+		e.GetComment().GetCreatedAt(),
+		e.GetComment().GetHTMLURL(),
+		e.GetInstallation().GetID(),
+	)
+}
@@ -0,0 +1,215 @@
+package parsers
+
+import (
+	"fmt"
+	"github.com/google/go-github/github"
+	"push-request/models"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Matches @login and @org/team mentions that are not part of an email address or a longer word
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@/.` + "`" + `])@([A-Za-z0-9](?:[A-Za-z0-9]|-[A-Za-z0-9]){0,38})(?:/([A-Za-z0-9][\w.-]*))?`)
+
+var inlineCodePattern = regexp.MustCompile("`+[^`]*`+")
+
+// The fields shared by the webhook payloads that can contain mentions
+type webhookEvent interface {
+	GetSender() *github.User
+	GetRepo() *github.Repository
+	GetInstallation() *github.Installation
+}
+
+// A Mention of users and teams in the body of an issue, pull request, review or comment
+type Mention struct {
+	Event *models.Event
+
+	// The login of the author, who is never notified of their own mentions
+	Author string
+	Logins []string
+
+	// Teams mentioned as "org/team"
+	Teams []string
+}
+
+// Extracts the logins and teams mentioned in a markdown body, ignoring code blocks, inline code and quoted text
+func ExtractMentions(body string) (logins []string, teams []string) {
+	seen := map[string]bool{}
+	inFence := ""
+
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if inFence != "" {
+			if strings.HasPrefix(trimmed, inFence) {
+				inFence = ""
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = trimmed[:3]
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, ">") || strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+
+		line = inlineCodePattern.ReplaceAllString(line, " ")
+
+		for _, match := range mentionPattern.FindAllStringSubmatch(line, -1) {
+			mention := match[1]
+			if match[2] != "" {
+				mention += "/" + match[2]
+			}
+
+			key := strings.ToLower(mention)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if match[2] != "" {
+				teams = append(teams, mention)
+			} else {
+				logins = append(logins, mention)
+			}
+		}
+	}
+
+	return logins, teams
+}
+
+// Gets the body an issue or pull request had before it was edited, or reports that the body wasn't changed
+func previousBody(changes *github.EditChange) (string, bool) {
+	if changes == nil || changes.Body == nil || changes.Body.From == nil {
+		return "", false
+	}
+
+	return *changes.Body.From, true
+}
+
+// Removes the mentions that were already in the previous mentions, ignoring case
+func withoutPrevious(mentions []string, previous []string) []string {
+	seen := map[string]bool{}
+	for _, mention := range previous {
+		seen[strings.ToLower(mention)] = true
+	}
+
+	var added []string
+	for _, mention := range mentions {
+		if !seen[strings.ToLower(mention)] {
+			added = append(added, mention)
+		}
+	}
+
+	return added
+}
+
+// Parses the mentions in the issue, pull request, review or comment of a webhook payload, or returns nil if it has none.
+// When an issue or pull request is edited, only the mentions added by the edit are parsed, so that fixing a typo
+// doesn't notify everyone mentioned again
+func ParseMention(payload interface{}) *Mention {
+	var body, title, url string
+	var number int
+	var timestamp time.Time
+
+	// The body before an edit, whose mentions were already notified
+	var previous *string
+
+	switch e := payload.(type) {
+	case *github.IssuesEvent:
+		if e.GetAction() != "opened" && e.GetAction() != "edited" {
+			return nil
+		}
+
+		if e.GetAction() == "edited" {
+			from, changed := previousBody(e.Changes)
+			if !changed {
+				return nil
+			}
+
+			previous = &from
+		}
+
+		issue := e.GetIssue()
+		body, number, title, url, timestamp = issue.GetBody(), issue.GetNumber(), issue.GetTitle(), issue.GetHTMLURL(), issue.GetUpdatedAt()
+
+	case *github.PullRequestEvent:
+		if e.GetAction() != "opened" && e.GetAction() != "edited" {
+			return nil
+		}
+
+		if e.GetAction() == "edited" {
+			from, changed := previousBody(e.Changes)
+			if !changed {
+				return nil
+			}
+
+			previous = &from
+		}
+
+		pr := e.GetPullRequest()
+		body, number, title, url, timestamp = pr.GetBody(), pr.GetNumber(), pr.GetTitle(), pr.GetHTMLURL(), pr.GetUpdatedAt()
+
+	case *github.IssueCommentEvent:
+		if e.GetAction() != "created" {
+			return nil
+		}
+
+		issue, comment := e.GetIssue(), e.GetComment()
+		body, number, title, url, timestamp = comment.GetBody(), issue.GetNumber(), issue.GetTitle(), comment.GetHTMLURL(), comment.GetCreatedAt()
+
+	case *github.PullRequestReviewEvent:
+		if e.GetAction() != "submitted" {
+			return nil
+		}
+
+		pr, review := e.GetPullRequest(), e.GetReview()
+		body, number, title, url, timestamp = review.GetBody(), pr.GetNumber(), pr.GetTitle(), review.GetHTMLURL(), review.GetSubmittedAt()
+
+	case *github.PullRequestReviewCommentEvent:
+		if e.GetAction() != "created" {
+			return nil
+		}
+
+		pr, comment := e.GetPullRequest(), e.GetComment()
+		body, number, title, url, timestamp = comment.GetBody(), pr.GetNumber(), pr.GetTitle(), comment.GetHTMLURL(), comment.GetCreatedAt()
+
+	default:
+		return nil
+	}
+
+	logins, teams := ExtractMentions(body)
+
+	if previous != nil {
+		previousLogins, previousTeams := ExtractMentions(*previous)
+		logins, teams = withoutPrevious(logins, previousLogins), withoutPrevious(teams, previousTeams)
+	}
+
+	if len(logins) == 0 && len(teams) == 0 {
+		return nil
+	}
+
+	e := payload.(webhookEvent)
+	author := e.GetSender().GetLogin()
+
+	return &Mention{
+		Event: models.NewEvent(
+			models.Mentioned,
+			e.GetRepo().GetFullName(),
+			number,
+			title,
+			fmt.Sprintf("@%s mentioned you in #%d", author, number),
+			e.GetSender().GetAvatarURL(),
+			timestamp,
+			url,
+			e.GetInstallation().GetID(),
+		),
+		Author: author,
+		Logins: logins,
+		Teams:  teams,
+	}
+}
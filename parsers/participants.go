@@ -0,0 +1,112 @@
+package parsers
+
+import (
+	"encoding/json"
+	"github.com/google/go-github/github"
+	"push-request/models"
+)
+
+// A Participant is a user taking part in an issue or pull request, who is subscribed to its activity
+type Participant struct {
+	GithubId int64
+	Reason   models.SubscriptionReason
+}
+
+// The Participants who joined the issue or pull request `Number` of the repository `RepoName`
+type Participation struct {
+	RepoName     string
+	Number       int
+	Participants []Participant
+}
+
+// Gets the github id of the user who triggered the webhook, or 0 if the payload has no sender
+func ParseSender(payload interface{}) int64 {
+	if e, ok := payload.(webhookEvent); ok {
+		return e.GetSender().GetID()
+	}
+
+	return 0
+}
+
+// The user assigned by a pull_request webhook, which go-github doesn't decode
+type pullRequestAssignment struct {
+	Assignee *github.User `json:"assignee"`
+}
+
+// Gets the users who became participants of the issue or pull request of a webhook payload: its author, assignees,
+// requested reviewers, commenters and reviewers. The raw payload is given for what go-github doesn't decode. Returns
+// nil if the payload adds no participants
+func ParseParticipation(payload interface{}, raw []byte) *Participation {
+	var number int
+	var participant Participant
+
+	switch e := payload.(type) {
+	case *github.IssuesEvent:
+		number = e.GetIssue().GetNumber()
+
+		switch e.GetAction() {
+		case "opened":
+			participant = Participant{GithubId: e.GetSender().GetID(), Reason: models.ReasonAuthor}
+
+		case "assigned":
+			// Older payloads only carry the assignee on the issue
+			assignee := e.GetAssignee()
+			if assignee == nil {
+				assignee = e.GetIssue().GetAssignee()
+			}
+
+			participant = Participant{GithubId: assignee.GetID(), Reason: models.ReasonAssigned}
+		}
+
+	case *github.PullRequestEvent:
+		number = e.GetPullRequest().GetNumber()
+
+		switch e.GetAction() {
+		case "opened":
+			participant = Participant{GithubId: e.GetSender().GetID(), Reason: models.ReasonAuthor}
+
+		case "assigned":
+			// The pull request only carries its first assignee, who may not be the one assigned
+			var assignment pullRequestAssignment
+			if json.Unmarshal(raw, &assignment) != nil || assignment.Assignee == nil {
+				assignment.Assignee = e.GetPullRequest().GetAssignee()
+			}
+
+			participant = Participant{GithubId: assignment.Assignee.GetID(), Reason: models.ReasonAssigned}
+
+		case "review_requested":
+			participant = Participant{GithubId: e.GetRequestedReviewer().GetID(), Reason: models.ReasonReviewRequested}
+		}
+
+	case *github.IssueCommentEvent:
+		number = e.GetIssue().GetNumber()
+
+		if e.GetAction() == "created" {
+			participant = Participant{GithubId: e.GetSender().GetID(), Reason: models.ReasonCommented}
+		}
+
+	case *github.PullRequestReviewEvent:
+		number = e.GetPullRequest().GetNumber()
+
+		if e.GetAction() == "submitted" {
+			participant = Participant{GithubId: e.GetSender().GetID(), Reason: models.ReasonReviewed}
+		}
+
+	case *github.PullRequestReviewCommentEvent:
+		number = e.GetPullRequest().GetNumber()
+
+		if e.GetAction() == "created" {
+			participant = Participant{GithubId: e.GetSender().GetID(), Reason: models.ReasonCommented}
+		}
+	}
+
+	if participant.GithubId == 0 {
+		return nil
+	}
+
+	return &Participation{
+		RepoName:     payload.(webhookEvent).GetRepo().GetFullName(),
+		Number:       number,
+		Participants: []Participant{participant},
+	}
+}
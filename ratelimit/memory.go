@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often a MemoryStore forgets the buckets that refilled, so that clients seen once don't use memory forever
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	limit     Limit
+}
+
+// A MemoryStore keeps buckets in-process, so each replica limits the requests it receives on its own
+type MemoryStore struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (store *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.sweep(now)
+
+	current, ok := store.buckets[key]
+	if !ok {
+		current = &bucket{tokens: float64(limit.Burst)}
+		store.buckets[key] = current
+	} else {
+		current.tokens = refill(current.tokens, now.Sub(current.updatedAt), limit)
+	}
+
+	var result Result
+	result, current.tokens = take(current.tokens, limit)
+	current.updatedAt = now
+	current.limit = limit
+
+	return result, nil
+}
+
+// Forgets the buckets that are full again, which are the same as buckets that were never used
+func (store *MemoryStore) sweep(now time.Time) {
+	if now.Sub(store.sweptAt) < sweepInterval {
+		return
+	}
+
+	store.sweptAt = now
+
+	for key, bucket := range store.buckets {
+		if now.Sub(bucket.updatedAt) >= bucket.limit.refillDuration() {
+			delete(store.buckets, key)
+		}
+	}
+}
+
+// Counts the buckets held, which have been used since they last refilled
+func (store *MemoryStore) Len() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return len(store.buckets)
+}
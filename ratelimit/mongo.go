@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/Kamva/mgm"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"push-request/storage"
+	"time"
+)
+
+// A MongoStore keeps buckets in MongoDB, so that replicas share them and a client is limited across all of them.
+// Each token is taken with a single atomic update, which requires MongoDB 4.2. Buckets expire once they refilled
+type MongoStore struct{}
+
+func NewMongoStore() *MongoStore {
+	return &MongoStore{}
+}
+
+type mongoBucket struct {
+	Tokens  float64 `bson:"tokens"`
+	Allowed bool    `bson:"allowed"`
+}
+
+func (store *MongoStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	now = now.UTC().Truncate(time.Millisecond)
+	burst := float64(limit.Burst)
+
+	// The bucket is refilled for the milliseconds since it was updated, or created full, then a token is taken if
+	// there is one. Both stages see the tokens of the stage before, so `allowed` reflects the refilled bucket
+	refilled := bson.M{"$min": bson.A{burst, bson.M{"$add": bson.A{
+		bson.M{"$ifNull": bson.A{"$tokens", burst}},
+		bson.M{"$multiply": bson.A{
+			bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated_at", now}}}}, 1000}},
+			limit.Rate,
+		}},
+	}}}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tokens":     bson.M{"$max": bson.A{0, refilled}},
+			"updated_at": now,
+			"expires_at": now.Add(limit.refillDuration()),
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"allowed": bson.M{"$gte": bson.A{"$tokens", 1}},
+			"tokens":  bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$tokens", 1}}, bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}},
+		}}},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var updated mongoBucket
+	err := mgm.CollectionByName(storage.RateLimitsCollection).FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).
+		Decode(&updated)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if updated.Allowed {
+		return Result{Allowed: true}, nil
+	}
+
+	result, _ := take(updated.Tokens, limit)
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// A Limit lets Burst requests through at once, and then Rate requests per second. A Limit without a rate or burst
+// isn't enforced
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Creates a Limit of requests per minute
+func PerMinute(requests int, burst int) Limit {
+	return Limit{Rate: float64(requests) / 60, Burst: burst}
+}
+
+// Reports whether the limit is enforced
+func (limit Limit) Enabled() bool {
+	return limit.Rate > 0 && limit.Burst > 0
+}
+
+// How long an emptied bucket takes to fill up again, after which it is the same as a bucket that was never used
+func (limit Limit) refillDuration() time.Duration {
+	return time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second))
+}
+
+// The outcome of taking a token from a bucket
+type Result struct {
+	Allowed bool
+
+	// How long until a token is available again, when the request isn't allowed
+	RetryAfter time.Duration
+}
+
+// A Store holds the token buckets of a Limiter. Buckets start full, and are refilled at the rate of their limit
+type Store interface {
+	// Takes a token from the bucket of the key, if it has one
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Adds the tokens refilled since the bucket was updated
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+}
+
+// Decides whether a request is allowed with the tokens in its bucket, returning the tokens left
+func take(tokens float64, limit Limit) (Result, float64) {
+	if tokens >= 1 {
+		return Result{Allowed: true}, tokens - 1
+	}
+
+	return Result{RetryAfter: time.Duration((1 - tokens) / limit.Rate * float64(time.Second))}, tokens
+}
+
+// A Limiter limits the requests of each client, such as an IP or a User, with a token bucket per client
+type Limiter struct {
+	// Prefixes the keys of the limiter's buckets, so limiters can share a Store
+	Name  string
+	Limit Limit
+	Store Store
+
+	// Gets the current time. Defaults to time.Now
+	Now func() time.Time
+}
+
+// Creates a Limiter keeping its buckets in the store
+func New(name string, limit Limit, store Store) *Limiter {
+	return &Limiter{Name: name, Limit: limit, Store: store, Now: time.Now}
+}
+
+// Takes a token from the bucket of the client. Requests are always allowed when the limit isn't enforced
+func (limiter *Limiter) Allow(ctx context.Context, client string) (Result, error) {
+	if limiter == nil || !limiter.Limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	now := time.Now
+	if limiter.Now != nil {
+		now = limiter.Now
+	}
+
+	return limiter.Store.Take(ctx, limiter.Name+":"+client, limiter.Limit, now())
+}
@@ -0,0 +1,243 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A Middleware wraps the handler of a route
+type Middleware func(next http.Handler) http.Handler
+
+// A RouteMiddleware wraps the handler of a route knowing the pattern it was registered with, such as to time requests
+// by route without letting paths add label values
+type RouteMiddleware func(pattern string, next http.Handler) http.Handler
+
+// The pattern requests that don't match any route are handled with, by the route middleware
+const Unmatched = "unmatched"
+
+// A Router dispatches requests to the handler registered for their method and path. Patterns are paths whose
+// segments may be parameters, like `/users/{id}`, which match any single segment and are read with Param.
+//
+// Every handler is wrapped, from the outside in, by the route middleware, the middleware given to Use, and the
+// middleware given when registering it. Requests that match no route, or whose method the route doesn't handle, are
+// handled by NotFound or MethodNotAllowed, wrapped in the same way, so that middleware such as CORS sees every request
+type Router struct {
+	NotFound http.Handler
+
+	// The methods the route handles are set in the `Allow` header before it is called
+	MethodNotAllowed http.Handler
+
+	routeMiddleware []RouteMiddleware
+	middleware      []Middleware
+	routes          map[string]*route
+	paths           []*path
+}
+
+// A route is the handlers registered with a pattern, by method
+type route struct {
+	pattern  string
+	handlers map[string]http.Handler
+}
+
+// A path is served by a route, under its own pattern or an alias of it
+type path struct {
+	segments []string
+	route    *route
+}
+
+// Creates a Router responding to unmatched requests with plain text errors
+func New() *Router {
+	return &Router{
+		NotFound: http.NotFoundHandler(),
+		MethodNotAllowed: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}),
+		routes: map[string]*route{},
+	}
+}
+
+// Adds middleware wrapping every route. Middleware must be added before the routes it wraps are registered
+func (router *Router) Use(middleware ...Middleware) {
+	if len(router.routes) > 0 {
+		panic("router: middleware must be added before routes")
+	}
+
+	router.middleware = append(router.middleware, middleware...)
+}
+
+// Adds route middleware, which wraps every route outside of the middleware added with Use. Like middleware, it must
+// be added before routes
+func (router *Router) UseRoute(middleware ...RouteMiddleware) {
+	if len(router.routes) > 0 {
+		panic("router: middleware must be added before routes")
+	}
+
+	router.routeMiddleware = append(router.routeMiddleware, middleware...)
+}
+
+// Registers the handler for requests with the method whose path matches the pattern, wrapped by the middleware
+func (router *Router) Handle(method string, pattern string, handler http.Handler, middleware ...Middleware) {
+	registered, ok := router.routes[pattern]
+	if !ok {
+		registered = &route{pattern: pattern, handlers: map[string]http.Handler{}}
+		router.routes[pattern] = registered
+		router.paths = append(router.paths, &path{segments: split(pattern), route: registered})
+	}
+
+	if _, ok := registered.handlers[method]; ok {
+		panic("router: " + method + " " + pattern + " is already registered")
+	}
+
+	registered.handlers[method] = router.wrap(pattern, handler, middleware)
+}
+
+func (router *Router) HandleFunc(method string, pattern string, handler http.HandlerFunc, middleware ...Middleware) {
+	router.Handle(method, pattern, handler, middleware...)
+}
+
+// Serves the routes registered with the pattern at the alias too, such as a path from before the API was versioned.
+// The alias must have the same parameters as the pattern, and route middleware sees requests to it as requests to the
+// pattern. Methods registered with the pattern later are served at the alias too
+func (router *Router) Alias(alias string, pattern string) {
+	registered, ok := router.routes[pattern]
+	if !ok {
+		panic("router: no routes are registered with " + pattern)
+	}
+
+	router.paths = append(router.paths, &path{segments: split(alias), route: registered})
+}
+
+func (router *Router) wrap(pattern string, handler http.Handler, middleware []Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	for i := len(router.middleware) - 1; i >= 0; i-- {
+		handler = router.middleware[i](handler)
+	}
+
+	for i := len(router.routeMiddleware) - 1; i >= 0; i-- {
+		handler = router.routeMiddleware[i](pattern, handler)
+	}
+
+	return handler
+}
+
+func split(pattern string) []string {
+	return strings.Split(strings.Trim(pattern, "/"), "/")
+}
+
+// Lists the methods registered with each pattern
+func (router *Router) Routes() map[string][]string {
+	routes := map[string][]string{}
+
+	for pattern, registered := range router.routes {
+		routes[pattern] = strings.Split(registered.methods(), ", ")
+	}
+
+	return routes
+}
+
+// The route that matched a request, kept in its context
+type match struct {
+	pattern string
+	params  map[string]string
+}
+
+type matchKey struct{}
+
+// Gets the pattern of the route that matched the request, even when it was requested at an alias, or "" if no route
+// matched it
+func Pattern(r *http.Request) string {
+	matched, _ := r.Context().Value(matchKey{}).(*match)
+	if matched == nil {
+		return ""
+	}
+
+	return matched.pattern
+}
+
+// Gets the value of the parameter of the route that matched the request, or "" if it has no such parameter
+func Param(r *http.Request, name string) string {
+	matched, _ := r.Context().Value(matchKey{}).(*match)
+	if matched == nil {
+		return ""
+	}
+
+	return matched.params[name]
+}
+
+// Matches the segments of a request's path against the path, returning its parameters
+func (path *path) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(path.segments) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+
+	for i, segment := range path.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+
+			params[segment[1:len(segment)-1]] = segments[i]
+			continue
+		}
+
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// Lists the methods the route handles, for the `Allow` header
+func (route *route) methods() string {
+	var methods []string
+
+	for method := range route.handlers {
+		methods = append(methods, method)
+	}
+
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// Finds the route serving the path, preferring the path with the fewest parameters, so that `/users/devices` is
+// matched before `/users/{id}`
+func (router *Router) match(requestPath string) (*route, map[string]string) {
+	segments := split(requestPath)
+
+	var matched *route
+	var matchedParams map[string]string
+
+	for _, path := range router.paths {
+		params, ok := path.match(segments)
+		if ok && (matched == nil || len(params) < len(matchedParams)) {
+			matched, matchedParams = path.route, params
+		}
+	}
+
+	return matched, matchedParams
+}
+
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	matched, params := router.match(r.URL.Path)
+	if matched == nil {
+		router.wrap(Unmatched, router.NotFound, nil).ServeHTTP(w, r)
+		return
+	}
+
+	handler, ok := matched.handlers[r.Method]
+	if !ok {
+		w.Header().Set("Allow", matched.methods())
+		router.wrap(Unmatched, router.MethodNotAllowed, nil).ServeHTTP(w, r)
+		return
+	}
+
+	handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), matchKey{}, &match{matched.pattern, params})))
+}
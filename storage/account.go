@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"push-request/models"
+	"time"
+)
+
+// How many events are read at a time when exporting a user's data
+const exportPageSize = 500
+
+// Every piece of data held about a user
+type UserExport struct {
+	ExportedAt    time.Time             `json:"exported_at"`
+	User          *models.User          `json:"user"`
+	Devices       []models.Device       `json:"devices"`
+	Installations []models.Installation `json:"installations"`
+	Subscriptions []models.Subscription `json:"subscriptions"`
+	Events        []models.StoredEvent  `json:"events"`
+}
+
+// Collects every piece of data held about the user, with their events oldest first
+func ExportUser(ctx context.Context, stores *Stores, githubId int64) (*UserExport, error) {
+	user, err := stores.Users.Get(ctx, githubId)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &UserExport{ExportedAt: time.Now().UTC(), User: user, Devices: user.ListDevices(), Events: []models.StoredEvent{}}
+
+	if export.Installations, err = stores.Installations.ListByGithubId(ctx, githubId); err != nil {
+		return nil, fmt.Errorf("failed to list installations (%w)", err)
+	}
+
+	if export.Subscriptions, err = stores.Subscriptions.ListByUser(ctx, githubId); err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions (%w)", err)
+	}
+
+	after := primitive.NilObjectID
+
+	for {
+		events, err := stores.Events.ListAfter(ctx, githubId, after, exportPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list events (%w)", err)
+		}
+
+		export.Events = append(export.Events, events...)
+
+		if len(events) < exportPageSize {
+			return export, nil
+		}
+
+		after = events[len(events)-1].ID
+	}
+}
+
+// Deletes the user with their devices, events, subscriptions and installation links. The installations themselves are
+// kept, so they are linked again if the user registers again. The user is deleted last, so a deletion that fails part
+// way can be retried
+func DeleteUser(ctx context.Context, stores *Stores, githubId int64) error {
+	if _, err := stores.Users.Get(ctx, githubId); err != nil {
+		return err
+	}
+
+	if err := stores.Subscriptions.DeleteByUser(ctx, githubId); err != nil {
+		return fmt.Errorf("failed to delete subscriptions (%w)", err)
+	}
+
+	if err := stores.Events.DeleteByUser(ctx, githubId); err != nil {
+		return fmt.Errorf("failed to delete events (%w)", err)
+	}
+
+	if err := stores.Installations.UnlinkByGithubId(ctx, githubId); err != nil {
+		return fmt.Errorf("failed to unlink installations (%w)", err)
+	}
+
+	return stores.Users.Delete(ctx, githubId)
+}
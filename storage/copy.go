@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/Kamva/mgm"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"push-request/models"
+)
+
+// Counts of the documents copied by CopyFromMongo
+type CopyResult struct {
+	Users         int
+	Installations int
+	Events        int
+	Subscriptions int
+	APIKeys       int
+}
+
+// Iterates over every document of the model's collection in the order of their ids
+func eachDocument(ctx context.Context, model mgm.Model, f func(cursor *mongo.Cursor) error) (int, error) {
+	cursor, err := mgm.Coll(model).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+
+	defer cursor.Close(ctx)
+
+	count := 0
+
+	for cursor.Next(ctx) {
+		if err = f(cursor); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, cursor.Err()
+}
+
+// Copies every user, installation, event, subscription and API key of the default mgm connection into the stores,
+// which are expected to be empty. Events are copied in the order they were delivered, so that they keep their order in
+// feeds and streams, but are given new ids. The audit log isn't copied, since entries would lose when they were made,
+// and stays in MongoDB
+func CopyFromMongo(ctx context.Context, stores *Stores) (*CopyResult, error) {
+	res := &CopyResult{}
+	var err error
+
+	res.Users, err = eachDocument(ctx, &models.User{}, func(cursor *mongo.Cursor) error {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		return stores.Users.Create(ctx, &user)
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed to copy users (%w)", err)
+	}
+
+	res.Installations, err = eachDocument(ctx, &models.Installation{}, func(cursor *mongo.Cursor) error {
+		var installation models.Installation
+		if err := cursor.Decode(&installation); err != nil {
+			return err
+		}
+
+		return stores.Installations.Create(ctx, &installation)
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed to copy installations (%w)", err)
+	}
+
+	res.Events, err = eachDocument(ctx, &models.StoredEvent{}, func(cursor *mongo.Cursor) error {
+		var storedEvent models.StoredEvent
+		if err := cursor.Decode(&storedEvent); err != nil {
+			return err
+		}
+
+		_, err := stores.Events.Create(ctx, storedEvent.GithubId, &storedEvent.Event)
+		return err
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed to copy events (%w)", err)
+	}
+
+	res.Subscriptions, err = eachDocument(ctx, &models.Subscription{}, func(cursor *mongo.Cursor) error {
+		var subscription models.Subscription
+		if err := cursor.Decode(&subscription); err != nil {
+			return err
+		}
+
+		return stores.Subscriptions.Create(ctx, &subscription)
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed to copy subscriptions (%w)", err)
+	}
+
+	res.APIKeys, err = eachDocument(ctx, &models.APIKey{}, func(cursor *mongo.Cursor) error {
+		var key models.APIKey
+		if err := cursor.Decode(&key); err != nil {
+			return err
+		}
+
+		return stores.APIKeys.Create(ctx, &key)
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed to copy API keys (%w)", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,700 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"push-request/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Creates empty stores kept in memory, for tests and running without a database
+func NewMemoryStores() *Stores {
+	return &Stores{
+		Users:         &memoryUserStore{users: map[int64]*models.User{}},
+		Installations: &memoryInstallationStore{installations: map[int64]*models.Installation{}},
+		Events:        &memoryEventStore{},
+		Subscriptions: &memorySubscriptionStore{},
+		APIKeys:       &memoryAPIKeyStore{keys: map[string]*models.APIKey{}},
+		Audit:         &memoryAuditStore{},
+		Backend:       "memory",
+		Ping:          func(ctx context.Context) error { return nil },
+	}
+}
+
+func copyUser(user *models.User) *models.User {
+	res := *user
+	res.DeviceTokens = append([]string(nil), user.DeviceTokens...)
+	res.AllowedTypes = append([]models.EventType(nil), user.AllowedTypes...)
+	res.LatestEvent = nil
+
+	res.Devices = map[string]models.Device{}
+	for token, device := range user.Devices {
+		if device.AllowedTypes != nil {
+			device.AllowedTypes = append([]models.EventType{}, device.AllowedTypes...)
+		}
+
+		res.Devices[token] = device
+	}
+
+	return &res
+}
+
+type memoryUserStore struct {
+	mutex sync.RWMutex
+	users map[int64]*models.User
+}
+
+func (store *memoryUserStore) Create(_ context.Context, user *models.User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user.ID = primitive.NewObjectID()
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+
+	store.users[user.GithubId] = copyUser(user)
+	return nil
+}
+
+func (store *memoryUserStore) Get(_ context.Context, githubId int64) (*models.User, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	user, ok := store.users[githubId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (store *memoryUserStore) GetByFeedToken(_ context.Context, feedToken string) (*models.User, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, user := range store.users {
+		if feedToken != "" && user.FeedToken == feedToken {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (store *memoryUserStore) Update(_ context.Context, user *models.User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	stored, ok := store.users[user.GithubId]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if stored.Version != user.Version {
+		return ErrConflict
+	}
+
+	user.Version++
+	user.UpdatedAt = time.Now().UTC()
+
+	store.users[user.GithubId] = copyUser(user)
+	return nil
+}
+
+func (store *memoryUserStore) Register(_ context.Context, githubId int64, device models.Device, allowedTypes []models.EventType, maxDevices int) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if user, ok := store.users[githubId]; ok && maxDevices > 0 && !containsString(user.DeviceTokens, device.Token) &&
+		len(user.DeviceTokens) >= maxDevices {
+		return false, ErrTooManyDevices
+	}
+
+	now := time.Now().UTC()
+
+	for _, user := range store.users {
+		if user.GithubId != githubId && removeDevice(user, device.Token) {
+			user.Version++
+		}
+	}
+
+	user, ok := store.users[githubId]
+	if !ok {
+		feedToken, err := models.NewFeedToken()
+		if err != nil {
+			return false, err
+		}
+
+		user = &models.User{GithubId: githubId, DeviceTokens: []string{}, AllowedTypes: models.DefaultEventTypes(), FeedToken: feedToken}
+		user.ID = primitive.NewObjectID()
+		user.CreatedAt = now
+		store.users[githubId] = user
+	}
+
+	if !containsString(user.DeviceTokens, device.Token) {
+		user.DeviceTokens = append(user.DeviceTokens, device.Token)
+	}
+
+	if user.Devices == nil {
+		user.Devices = map[string]models.Device{}
+	}
+
+	user.Devices[device.Token] = mergeDevice(user.Devices[device.Token], device, now)
+
+	if allowedTypes != nil {
+		user.AllowedTypes = append([]models.EventType{}, allowedTypes...)
+	}
+
+	user.Version++
+	user.UpdatedAt = now
+	return !ok, nil
+}
+
+// Updates the stored details of a device with the registered ones that aren't empty, and marks it as seen
+func mergeDevice(stored models.Device, registered models.Device, seenAt time.Time) models.Device {
+	for _, field := range []struct {
+		stored     *string
+		registered string
+	}{
+		{(*string)(&stored.Platform), string(registered.Platform)},
+		{(*string)(&stored.Environment), string(registered.Environment)},
+		{&stored.AppVersion, registered.AppVersion},
+		{&stored.Locale, registered.Locale},
+		{&stored.Name, registered.Name},
+	} {
+		if field.registered != "" {
+			*field.stored = field.registered
+		}
+	}
+
+	stored.LastSeenAt = &seenAt
+	return stored
+}
+
+// Removes the device from the user, reporting whether the user had it. The mutex must be held
+func removeDevice(user *models.User, deviceToken string) bool {
+	for i, token := range user.DeviceTokens {
+		if token == deviceToken {
+			user.DeviceTokens = append(user.DeviceTokens[:i:i], user.DeviceTokens[i+1:]...)
+			delete(user.Devices, deviceToken)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (store *memoryUserStore) RemoveDevice(_ context.Context, githubId int64, deviceToken string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user, ok := store.users[githubId]
+	if !ok || !removeDevice(user, deviceToken) {
+		return ErrNotFound
+	}
+
+	user.Version++
+	return nil
+}
+
+func (store *memoryUserStore) Delete(_ context.Context, githubId int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.users[githubId]; !ok {
+		return ErrNotFound
+	}
+
+	delete(store.users, githubId)
+	return nil
+}
+
+func (store *memoryUserStore) List(_ context.Context, query UserQuery) ([]models.User, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	res := []models.User{}
+
+	for _, user := range store.users {
+		if user.GithubId <= query.After {
+			continue
+		}
+
+		if query.DeviceToken != "" && !containsString(user.DeviceTokens, query.DeviceToken) {
+			continue
+		}
+
+		if query.Disabled != nil && user.Disabled != *query.Disabled {
+			continue
+		}
+
+		res = append(res, *copyUser(user))
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].GithubId < res[j].GithubId
+	})
+
+	if len(res) > query.Limit {
+		res = res[:query.Limit]
+	}
+
+	return res, nil
+}
+
+type memoryInstallationStore struct {
+	mutex         sync.RWMutex
+	installations map[int64]*models.Installation
+}
+
+func (store *memoryInstallationStore) Create(_ context.Context, installation *models.Installation) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	installation.ID = primitive.NewObjectID()
+	installation.CreatedAt = time.Now().UTC()
+	installation.UpdatedAt = installation.CreatedAt
+
+	res := *installation
+	store.installations[installation.Id] = &res
+	return nil
+}
+
+func (store *memoryInstallationStore) Get(_ context.Context, installationId int64) (*models.Installation, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	installation, ok := store.installations[installationId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	res := *installation
+	return &res, nil
+}
+
+func (store *memoryInstallationStore) GetByGithubId(_ context.Context, githubId int64) (*models.Installation, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, installation := range store.installations {
+		if installation.GithubId == githubId {
+			res := *installation
+			return &res, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (store *memoryInstallationStore) List(_ context.Context, after int64, limit int) ([]models.Installation, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	res := []models.Installation{}
+
+	for _, installation := range store.installations {
+		if installation.Id > after {
+			res = append(res, *installation)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Id < res[j].Id
+	})
+
+	if len(res) > limit {
+		res = res[:limit]
+	}
+
+	return res, nil
+}
+
+func (store *memoryInstallationStore) ListByGithubId(_ context.Context, githubId int64) ([]models.Installation, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	res := []models.Installation{}
+
+	for _, installation := range store.installations {
+		if installation.GithubId == githubId {
+			res = append(res, *installation)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Id < res[j].Id
+	})
+
+	return res, nil
+}
+
+func (store *memoryInstallationStore) Link(_ context.Context, installationId int64, githubId int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	installation, ok := store.installations[installationId]
+	if !ok {
+		return ErrNotFound
+	}
+
+	installation.GithubId = githubId
+	installation.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+func (store *memoryInstallationStore) UnlinkByGithubId(_ context.Context, githubId int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, installation := range store.installations {
+		if installation.GithubId == githubId {
+			installation.GithubId = 0
+			installation.UpdatedAt = time.Now().UTC()
+		}
+	}
+
+	return nil
+}
+
+func (store *memoryInstallationStore) LinkAccount(_ context.Context, githubId int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, installation := range store.installations {
+		if installation.AccountId == githubId && installation.GithubId == 0 {
+			installation.GithubId = githubId
+			installation.UpdatedAt = time.Now().UTC()
+		}
+	}
+
+	return nil
+}
+
+type memoryEventStore struct {
+	mutex  sync.RWMutex
+	events []models.StoredEvent
+}
+
+func (store *memoryEventStore) Create(_ context.Context, githubId int64, event *models.Event) (*models.StoredEvent, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	storedEvent := models.StoredEvent{GithubId: githubId, Event: *event}
+	storedEvent.ID = primitive.NewObjectID()
+	storedEvent.CreatedAt = time.Now().UTC()
+	storedEvent.UpdatedAt = storedEvent.CreatedAt
+
+	store.events = append(store.events, storedEvent)
+	return &storedEvent, nil
+}
+
+func containsString(array []string, element string) bool {
+	for _, a := range array {
+		if a == element {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *memoryEventStore) List(_ context.Context, githubId int64, repoNames []string, limit int) ([]models.StoredEvent, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	res := []models.StoredEvent{}
+
+	for _, storedEvent := range store.events {
+		if storedEvent.GithubId != githubId {
+			continue
+		}
+
+		if len(repoNames) > 0 && !containsString(repoNames, storedEvent.Event.RepoName) {
+			continue
+		}
+
+		res = append(res, storedEvent)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Event.Timestamp.After(res[j].Event.Timestamp)
+	})
+
+	if len(res) > limit {
+		res = res[:limit]
+	}
+
+	return res, nil
+}
+
+func (store *memoryEventStore) ListAfter(_ context.Context, githubId int64, id primitive.ObjectID, limit int) ([]models.StoredEvent, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	res := []models.StoredEvent{}
+
+	// Events are appended in the order of their increasing ids
+	for _, storedEvent := range store.events {
+		if storedEvent.GithubId != githubId || bytes.Compare(storedEvent.ID[:], id[:]) <= 0 {
+			continue
+		}
+
+		if res = append(res, storedEvent); len(res) == limit {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (store *memoryEventStore) DeleteByUser(_ context.Context, githubId int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var res []models.StoredEvent
+
+	for _, storedEvent := range store.events {
+		if storedEvent.GithubId != githubId {
+			res = append(res, storedEvent)
+		}
+	}
+
+	store.events = res
+	return nil
+}
+
+type memorySubscriptionStore struct {
+	mutex         sync.RWMutex
+	subscriptions []*models.Subscription
+}
+
+// Finds the index of the user's subscription to the thread, or -1 if they have none. The mutex must be held
+func (store *memorySubscriptionStore) find(githubId int64, repoName string, number int) int {
+	for i, subscription := range store.subscriptions {
+		if subscription.GithubId == githubId && subscription.RepoName == repoName && subscription.Number == number {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (store *memorySubscriptionStore) Subscribe(_ context.Context, githubId int64, repoName string, number int, reason models.SubscriptionReason) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if i := store.find(githubId, repoName, number); i >= 0 {
+		store.subscriptions[i].UpdatedAt = time.Now().UTC()
+		return nil
+	}
+
+	return store.create(&models.Subscription{GithubId: githubId, RepoName: repoName, Number: number, Reason: reason})
+}
+
+func (store *memorySubscriptionStore) create(subscription *models.Subscription) error {
+	subscription.ID = primitive.NewObjectID()
+	subscription.CreatedAt = time.Now().UTC()
+	subscription.UpdatedAt = subscription.CreatedAt
+
+	res := *subscription
+	store.subscriptions = append(store.subscriptions, &res)
+	return nil
+}
+
+func (store *memorySubscriptionStore) Create(_ context.Context, subscription *models.Subscription) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.create(subscription)
+}
+
+func (store *memorySubscriptionStore) Get(_ context.Context, githubId int64, repoName string, number int) (*models.Subscription, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	i := store.find(githubId, repoName, number)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+
+	res := *store.subscriptions[i]
+	return &res, nil
+}
+
+func (store *memorySubscriptionStore) Update(_ context.Context, subscription *models.Subscription) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	i := store.find(subscription.GithubId, subscription.RepoName, subscription.Number)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	subscription.UpdatedAt = time.Now().UTC()
+
+	res := *subscription
+	store.subscriptions[i] = &res
+	return nil
+}
+
+func (store *memorySubscriptionStore) Delete(_ context.Context, subscription *models.Subscription) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	i := store.find(subscription.GithubId, subscription.RepoName, subscription.Number)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	store.subscriptions = append(store.subscriptions[:i], store.subscriptions[i+1:]...)
+	return nil
+}
+
+func (store *memorySubscriptionStore) list(include func(*models.Subscription) bool) []models.Subscription {
+	res := []models.Subscription{}
+
+	for _, subscription := range store.subscriptions {
+		if include(subscription) {
+			res = append(res, *subscription)
+		}
+	}
+
+	return res
+}
+
+func (store *memorySubscriptionStore) ListByUser(_ context.Context, githubId int64) ([]models.Subscription, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	res := store.list(func(subscription *models.Subscription) bool {
+		return subscription.GithubId == githubId
+	})
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].UpdatedAt.After(res[j].UpdatedAt)
+	})
+
+	return res, nil
+}
+
+func (store *memorySubscriptionStore) ListByThread(_ context.Context, repoName string, number int) ([]models.Subscription, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.list(func(subscription *models.Subscription) bool {
+		return subscription.RepoName == repoName && subscription.Number == number
+	}), nil
+}
+
+func (store *memorySubscriptionStore) DeleteByUser(_ context.Context, githubId int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var res []*models.Subscription
+
+	for _, subscription := range store.subscriptions {
+		if subscription.GithubId != githubId {
+			res = append(res, subscription)
+		}
+	}
+
+	store.subscriptions = res
+	return nil
+}
+
+type memoryAPIKeyStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*models.APIKey
+}
+
+func (store *memoryAPIKeyStore) Create(_ context.Context, key *models.APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key.ID = primitive.NewObjectID()
+	key.CreatedAt = time.Now().UTC()
+	key.UpdatedAt = key.CreatedAt
+
+	res := *key
+	store.keys[key.KeyId] = &res
+	return nil
+}
+
+func (store *memoryAPIKeyStore) Get(_ context.Context, keyId string) (*models.APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	key, ok := store.keys[keyId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	res := *key
+	return &res, nil
+}
+
+func (store *memoryAPIKeyStore) List(_ context.Context) ([]models.APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	res := []models.APIKey{}
+	for _, key := range store.keys {
+		res = append(res, *key)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].ID[:], res[j].ID[:]) < 0
+	})
+
+	return res, nil
+}
+
+func (store *memoryAPIKeyStore) Delete(_ context.Context, keyId string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.keys[keyId]; !ok {
+		return ErrNotFound
+	}
+
+	delete(store.keys, keyId)
+	return nil
+}
+
+type memoryAuditStore struct {
+	mutex   sync.RWMutex
+	entries []models.AuditEntry
+}
+
+func (store *memoryAuditStore) Create(_ context.Context, entry *models.AuditEntry) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	entry.ID = primitive.NewObjectID()
+	entry.CreatedAt = time.Now().UTC()
+	entry.UpdatedAt = entry.CreatedAt
+
+	store.entries = append(store.entries, *entry)
+	return nil
+}
+
+func (store *memoryAuditStore) List(_ context.Context, before primitive.ObjectID, limit int) ([]models.AuditEntry, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	res := []models.AuditEntry{}
+
+	// Entries are appended in the order of their increasing ids
+	for i := len(store.entries) - 1; i >= 0 && len(res) < limit; i-- {
+		entry := store.entries[i]
+
+		if before == primitive.NilObjectID || bytes.Compare(entry.ID[:], before[:]) < 0 {
+			res = append(res, entry)
+		}
+	}
+
+	return res, nil
+}
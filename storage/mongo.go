@@ -0,0 +1,458 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Kamva/mgm"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"push-request/models"
+	"time"
+)
+
+// Creates stores backed by the collections of the default mgm connection
+func NewMongoStores() *Stores {
+	return &Stores{
+		Users:         &mongoUserStore{},
+		Installations: &mongoInstallationStore{},
+		Events:        &mongoEventStore{},
+		Subscriptions: &mongoSubscriptionStore{},
+		APIKeys:       &mongoAPIKeyStore{},
+		Audit:         &mongoAuditStore{},
+		Backend:       "mongo",
+		Ping:          pingMongo,
+	}
+}
+
+func pingMongo(ctx context.Context) error {
+	_, client, _, err := mgm.DefaultConfigs()
+	if err != nil {
+		return err
+	}
+
+	return client.Ping(ctx, readpref.Primary())
+}
+
+func mongoError(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func isDuplicateKeyError(err error) bool {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == 11000 {
+				return true
+			}
+		}
+	}
+
+	var commandError mongo.CommandError
+	return errors.As(err, &commandError) && commandError.Code == 11000
+}
+
+type mongoUserStore struct{}
+
+func (store *mongoUserStore) Create(ctx context.Context, user *models.User) error {
+	return mgm.Coll(user).CreateWithCtx(ctx, user)
+}
+
+func (store *mongoUserStore) first(ctx context.Context, filter bson.M) (*models.User, error) {
+	res := &models.User{}
+	err := mgm.Coll(res).FirstWithCtx(ctx, filter, res)
+
+	return res, mongoError(err)
+}
+
+func (store *mongoUserStore) Get(ctx context.Context, githubId int64) (*models.User, error) {
+	return store.first(ctx, bson.M{"github_id": githubId})
+}
+
+func (store *mongoUserStore) GetByFeedToken(ctx context.Context, feedToken string) (*models.User, error) {
+	return store.first(ctx, bson.M{"feed_token": feedToken})
+}
+
+func (store *mongoUserStore) Update(ctx context.Context, user *models.User) error {
+	version := user.Version
+	updatedAt := user.UpdatedAt
+
+	user.Version++
+	user.UpdatedAt = time.Now().UTC()
+
+	coll := mgm.Coll(user)
+
+	res, err := coll.ReplaceOne(ctx, bson.M{"_id": user.ID, "version": version}, user)
+	if err == nil && res.MatchedCount == 0 {
+		var count int64
+		if count, err = coll.CountDocuments(ctx, bson.M{"_id": user.ID}); err == nil {
+			err = ErrConflict
+			if count == 0 {
+				err = ErrNotFound
+			}
+		}
+	}
+
+	if err != nil {
+		user.Version = version
+		user.UpdatedAt = updatedAt
+	}
+
+	return err
+}
+
+func (store *mongoUserStore) Register(ctx context.Context, githubId int64, device models.Device, allowedTypes []models.EventType, maxDevices int) (bool, error) {
+	feedToken, err := models.NewFeedToken()
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+
+	deviceField := "devices." + device.Token
+	set := bson.M{"updated_at": now, deviceField + ".last_seen_at": now}
+
+	for field, value := range map[string]string{
+		"platform":    string(device.Platform),
+		"environment": string(device.Environment),
+		"app_version": device.AppVersion,
+		"locale":      device.Locale,
+		"name":        device.Name,
+	} {
+		if value != "" {
+			set[deviceField+"."+field] = value
+		}
+	}
+
+	setOnInsert := bson.M{"created_at": now, "feed_token": feedToken}
+
+	if allowedTypes != nil {
+		set["allowed_types"] = allowedTypes
+	} else {
+		setOnInsert["allowed_types"] = models.DefaultEventTypes()
+	}
+
+	update := bson.M{
+		"$addToSet":    bson.M{"device_tokens": device.Token},
+		"$inc":         bson.M{"version": 1},
+		"$set":         set,
+		"$setOnInsert": setOnInsert,
+	}
+
+	filter := bson.M{"github_id": githubId}
+	if maxDevices > 0 {
+		// The user only matches when they have the device already or room for another. Otherwise the upsert inserts a
+		// user with their github id again, which the unique index refuses
+		filter["$or"] = bson.A{
+			bson.M{"device_tokens": device.Token},
+			bson.M{fmt.Sprintf("device_tokens.%d", maxDevices-1): bson.M{"$exists": false}},
+		}
+	}
+
+	coll := mgm.Coll(&models.User{})
+	opts := options.Update().SetUpsert(true)
+
+	res, err := coll.UpdateOne(ctx, filter, update, opts)
+	if isDuplicateKeyError(err) {
+		// A concurrent registration created the user first, so the retry updates it
+		res, err = coll.UpdateOne(ctx, filter, update, opts)
+	}
+
+	if isDuplicateKeyError(err) {
+		return false, ErrTooManyDevices
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	_, err = coll.UpdateMany(ctx,
+		bson.M{"github_id": bson.M{"$ne": githubId}, "device_tokens": device.Token},
+		bson.M{
+			"$pull":  bson.M{"device_tokens": device.Token},
+			"$unset": bson.M{deviceField: ""},
+			"$inc":   bson.M{"version": 1},
+		})
+
+	return res.UpsertedCount > 0, err
+}
+
+func (store *mongoUserStore) RemoveDevice(ctx context.Context, githubId int64, deviceToken string) error {
+	res, err := mgm.Coll(&models.User{}).UpdateOne(ctx,
+		bson.M{"github_id": githubId, "device_tokens": deviceToken},
+		bson.M{
+			"$pull":  bson.M{"device_tokens": deviceToken},
+			"$unset": bson.M{"devices." + deviceToken: ""},
+			"$set":   bson.M{"updated_at": time.Now().UTC()},
+			"$inc":   bson.M{"version": 1},
+		})
+
+	return requireMatch(res, err)
+}
+
+func (store *mongoUserStore) Delete(ctx context.Context, githubId int64) error {
+	res, err := mgm.Coll(&models.User{}).DeleteOne(ctx, bson.M{"github_id": githubId})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (store *mongoUserStore) List(ctx context.Context, query UserQuery) ([]models.User, error) {
+	filter := bson.M{"github_id": bson.M{"$gt": query.After}}
+
+	if query.DeviceToken != "" {
+		filter["device_tokens"] = query.DeviceToken
+	}
+
+	// Users who were never disabled have no disabled field
+	if query.Disabled != nil && *query.Disabled {
+		filter["disabled"] = true
+	} else if query.Disabled != nil {
+		filter["disabled"] = bson.M{"$ne": true}
+	}
+
+	res := []models.User{}
+	opts := options.Find().SetSort(bson.M{"github_id": 1}).SetLimit(int64(query.Limit))
+
+	err := mgm.Coll(&models.User{}).SimpleFindWithCtx(ctx, &res, filter, opts)
+	return res, err
+}
+
+// Returns ErrNotFound if an update didn't match any document
+func requireMatch(res *mongo.UpdateResult, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type mongoInstallationStore struct{}
+
+func (store *mongoInstallationStore) Create(ctx context.Context, installation *models.Installation) error {
+	return mgm.Coll(installation).CreateWithCtx(ctx, installation)
+}
+
+func (store *mongoInstallationStore) first(ctx context.Context, filter bson.M) (*models.Installation, error) {
+	res := &models.Installation{}
+	err := mgm.Coll(res).FirstWithCtx(ctx, filter, res)
+
+	return res, mongoError(err)
+}
+
+func (store *mongoInstallationStore) Get(ctx context.Context, installationId int64) (*models.Installation, error) {
+	return store.first(ctx, bson.M{"installation_id": installationId})
+}
+
+func (store *mongoInstallationStore) GetByGithubId(ctx context.Context, githubId int64) (*models.Installation, error) {
+	return store.first(ctx, bson.M{"github_id": githubId})
+}
+
+func (store *mongoInstallationStore) List(ctx context.Context, after int64, limit int) ([]models.Installation, error) {
+	res := []models.Installation{}
+	opts := options.Find().SetSort(bson.M{"installation_id": 1}).SetLimit(int64(limit))
+
+	err := mgm.Coll(&models.Installation{}).SimpleFindWithCtx(ctx, &res, bson.M{"installation_id": bson.M{"$gt": after}}, opts)
+	return res, err
+}
+
+func (store *mongoInstallationStore) ListByGithubId(ctx context.Context, githubId int64) ([]models.Installation, error) {
+	res := []models.Installation{}
+	opts := options.Find().SetSort(bson.M{"installation_id": 1})
+
+	err := mgm.Coll(&models.Installation{}).SimpleFindWithCtx(ctx, &res, bson.M{"github_id": githubId}, opts)
+	return res, err
+}
+
+func (store *mongoInstallationStore) Link(ctx context.Context, installationId int64, githubId int64) error {
+	res, err := mgm.Coll(&models.Installation{}).UpdateOne(ctx,
+		bson.M{"installation_id": installationId},
+		bson.M{"$set": bson.M{"github_id": githubId, "updated_at": time.Now().UTC()}})
+
+	return requireMatch(res, err)
+}
+
+func (store *mongoInstallationStore) UnlinkByGithubId(ctx context.Context, githubId int64) error {
+	_, err := mgm.Coll(&models.Installation{}).UpdateMany(ctx,
+		bson.M{"github_id": githubId},
+		bson.M{"$set": bson.M{"github_id": 0, "updated_at": time.Now().UTC()}})
+
+	return err
+}
+
+func (store *mongoInstallationStore) LinkAccount(ctx context.Context, githubId int64) error {
+	_, err := mgm.Coll(&models.Installation{}).UpdateMany(ctx,
+		bson.M{"account_id": githubId, "github_id": 0},
+		bson.M{"$set": bson.M{"github_id": githubId, "updated_at": time.Now().UTC()}})
+
+	return err
+}
+
+type mongoEventStore struct{}
+
+func (store *mongoEventStore) Create(ctx context.Context, githubId int64, event *models.Event) (*models.StoredEvent, error) {
+	storedEvent := &models.StoredEvent{
+		GithubId: githubId,
+		Event:    *event,
+	}
+
+	return storedEvent, mgm.Coll(storedEvent).CreateWithCtx(ctx, storedEvent)
+}
+
+func (store *mongoEventStore) List(ctx context.Context, githubId int64, repoNames []string, limit int) ([]models.StoredEvent, error) {
+	res := []models.StoredEvent{}
+
+	filter := bson.M{"github_id": githubId}
+	if len(repoNames) > 0 {
+		filter["event.reponame"] = bson.M{"$in": repoNames}
+	}
+
+	opts := options.Find().SetSort(bson.M{"event.timestamp": -1}).SetLimit(int64(limit))
+
+	err := mgm.Coll(&models.StoredEvent{}).SimpleFindWithCtx(ctx, &res, filter, opts)
+	return res, err
+}
+
+func (store *mongoEventStore) ListAfter(ctx context.Context, githubId int64, id primitive.ObjectID, limit int) ([]models.StoredEvent, error) {
+	res := []models.StoredEvent{}
+
+	filter := bson.M{"github_id": githubId, "_id": bson.M{"$gt": id}}
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+
+	err := mgm.Coll(&models.StoredEvent{}).SimpleFindWithCtx(ctx, &res, filter, opts)
+	return res, err
+}
+
+func (store *mongoEventStore) DeleteByUser(ctx context.Context, githubId int64) error {
+	_, err := mgm.Coll(&models.StoredEvent{}).DeleteMany(ctx, bson.M{"github_id": githubId})
+	return err
+}
+
+type mongoSubscriptionStore struct{}
+
+func threadFilter(githubId int64, repoName string, number int) bson.M {
+	return bson.M{"github_id": githubId, "repo_name": repoName, "number": number}
+}
+
+func (store *mongoSubscriptionStore) Subscribe(ctx context.Context, githubId int64, repoName string, number int, reason models.SubscriptionReason) error {
+	now := time.Now().UTC()
+
+	update := bson.M{
+		"$setOnInsert": bson.M{"reason": reason, "muted": false, "created_at": now},
+		"$set":         bson.M{"updated_at": now},
+	}
+
+	coll := mgm.Coll(&models.Subscription{})
+
+	_, err := coll.UpdateOne(ctx, threadFilter(githubId, repoName, number), update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (store *mongoSubscriptionStore) Create(ctx context.Context, subscription *models.Subscription) error {
+	return mgm.Coll(subscription).CreateWithCtx(ctx, subscription)
+}
+
+func (store *mongoSubscriptionStore) Get(ctx context.Context, githubId int64, repoName string, number int) (*models.Subscription, error) {
+	res := &models.Subscription{}
+	err := mgm.Coll(res).FirstWithCtx(ctx, threadFilter(githubId, repoName, number), res)
+
+	return res, mongoError(err)
+}
+
+func (store *mongoSubscriptionStore) Update(ctx context.Context, subscription *models.Subscription) error {
+	return mgm.Coll(subscription).UpdateWithCtx(ctx, subscription)
+}
+
+func (store *mongoSubscriptionStore) Delete(ctx context.Context, subscription *models.Subscription) error {
+	return mgm.Coll(subscription).DeleteWithCtx(ctx, subscription)
+}
+
+func (store *mongoSubscriptionStore) ListByUser(ctx context.Context, githubId int64) ([]models.Subscription, error) {
+	res := []models.Subscription{}
+	opts := options.Find().SetSort(bson.M{"updated_at": -1})
+
+	err := mgm.Coll(&models.Subscription{}).SimpleFindWithCtx(ctx, &res, bson.M{"github_id": githubId}, opts)
+	return res, err
+}
+
+func (store *mongoSubscriptionStore) ListByThread(ctx context.Context, repoName string, number int) ([]models.Subscription, error) {
+	res := []models.Subscription{}
+
+	err := mgm.Coll(&models.Subscription{}).SimpleFindWithCtx(ctx, &res, bson.M{"repo_name": repoName, "number": number})
+	return res, err
+}
+
+func (store *mongoSubscriptionStore) DeleteByUser(ctx context.Context, githubId int64) error {
+	_, err := mgm.Coll(&models.Subscription{}).DeleteMany(ctx, bson.M{"github_id": githubId})
+	return err
+}
+
+type mongoAPIKeyStore struct{}
+
+func (store *mongoAPIKeyStore) Create(ctx context.Context, key *models.APIKey) error {
+	return mgm.Coll(key).CreateWithCtx(ctx, key)
+}
+
+func (store *mongoAPIKeyStore) Get(ctx context.Context, keyId string) (*models.APIKey, error) {
+	res := &models.APIKey{}
+	err := mgm.Coll(res).FirstWithCtx(ctx, bson.M{"key_id": keyId}, res)
+
+	return res, mongoError(err)
+}
+
+func (store *mongoAPIKeyStore) List(ctx context.Context) ([]models.APIKey, error) {
+	res := []models.APIKey{}
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+
+	err := mgm.Coll(&models.APIKey{}).SimpleFindWithCtx(ctx, &res, bson.M{}, opts)
+	return res, err
+}
+
+func (store *mongoAPIKeyStore) Delete(ctx context.Context, keyId string) error {
+	res, err := mgm.Coll(&models.APIKey{}).DeleteOne(ctx, bson.M{"key_id": keyId})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type mongoAuditStore struct{}
+
+func (store *mongoAuditStore) Create(ctx context.Context, entry *models.AuditEntry) error {
+	return mgm.Coll(entry).CreateWithCtx(ctx, entry)
+}
+
+func (store *mongoAuditStore) List(ctx context.Context, before primitive.ObjectID, limit int) ([]models.AuditEntry, error) {
+	filter := bson.M{}
+	if before != primitive.NilObjectID {
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	res := []models.AuditEntry{}
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit))
+
+	err := mgm.Coll(&models.AuditEntry{}).SimpleFindWithCtx(ctx, &res, filter, opts)
+	return res, err
+}
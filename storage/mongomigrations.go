@@ -0,0 +1,377 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/Kamva/mgm"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"push-request/models"
+	"time"
+)
+
+// How long stored events are kept before MongoDB expires them
+const eventRetention = 90 * 24 * time.Hour
+
+// How long the migration lock is held before it is considered abandoned by a replica that crashed
+const migrationLockTimeout = 5 * time.Minute
+
+// How often a replica waiting for the migration lock tries to take it
+const migrationLockRetryInterval = 500 * time.Millisecond
+
+// A migration of the Mongo collections. Migrations must be idempotent, since a replica may crash after applying
+// a migration but before recording it
+type mongoMigration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+}
+
+// The record of an applied migration, in the `migrations` collection
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// The migrations of the Mongo collections, in the order they are applied. Migrations must never change once
+// released: changes are made by appending a new migration
+var mongoMigrations = []mongoMigration{
+	{1, "merge duplicate users, installations and subscriptions", mergeDuplicates},
+	{2, "create unique indexes on github_id and installation_id", createIndexes},
+	{3, "expire stored events", createEventTTLIndex},
+	{4, "move latest events into the event history", moveLatestEvents},
+	{5, "index device tokens", createDeviceTokenIndex},
+	{6, "version users", versionUsers},
+	{7, "expire rate limit buckets", createRateLimitTTLIndex},
+	{8, "create a unique index on api key ids", createAPIKeyIndex},
+	{9, "record the account of installations", recordInstallationAccounts},
+}
+
+// Takes the migration lock, waiting for the replica holding it to release it. The returned function releases the lock
+func acquireMigrationLock(ctx context.Context) (func(), error) {
+	locks := mgm.CollectionByName("migration_locks")
+	owner := primitive.NewObjectID()
+
+	for {
+		now := time.Now().UTC()
+
+		// The lock document is inserted if it doesn't exist, and taken over if it expired. Otherwise the upsert
+		// fails on the duplicate _id, meaning another replica holds the lock
+		_, err := locks.UpdateOne(ctx,
+			bson.M{"_id": "migrations", "expires_at": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(migrationLockTimeout)}},
+			options.Update().SetUpsert(true))
+
+		if err == nil {
+			return func() {
+				_, _ = locks.DeleteOne(context.Background(), bson.M{"_id": "migrations", "owner": owner})
+			}, nil
+		}
+
+		if !isDuplicateKeyError(err) {
+			return nil, fmt.Errorf("failed to take the migration lock (%w)", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(migrationLockRetryInterval):
+		}
+	}
+}
+
+// Applies the migrations of the Mongo collections that weren't applied yet, and returns their versions. Replicas
+// starting at the same time take turns through a lock, so each migration is applied once
+func MigrateMongo(ctx context.Context) ([]int, error) {
+	release, err := acquireMigrationLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	migrations := mgm.CollectionByName("migrations")
+
+	var applied []appliedMigration
+	if err = migrations.SimpleFindWithCtx(ctx, &applied, bson.M{}); err != nil {
+		return nil, fmt.Errorf("failed to get the applied migrations (%w)", err)
+	}
+
+	isApplied := map[int]bool{}
+	for _, migration := range applied {
+		isApplied[migration.Version] = true
+	}
+
+	versions := []int{}
+
+	for _, migration := range mongoMigrations {
+		if isApplied[migration.Version] {
+			continue
+		}
+
+		if err = migration.Up(ctx); err != nil {
+			return versions, fmt.Errorf("failed to apply migration %d, %s (%w)", migration.Version, migration.Description, err)
+		}
+
+		record := appliedMigration{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now().UTC()}
+		if _, err = migrations.InsertOne(ctx, record); err != nil {
+			return versions, fmt.Errorf("failed to record migration %d (%w)", migration.Version, err)
+		}
+
+		versions = append(versions, migration.Version)
+	}
+
+	return versions, nil
+}
+
+// Merges the users created more than once by racing registrations into the first one created, with the device
+// tokens of every duplicate, and deletes duplicate installations and subscriptions. Unique indexes can't be
+// created otherwise
+func mergeDuplicates(ctx context.Context) error {
+	pipeline := func(key interface{}) mongo.Pipeline {
+		return mongo.Pipeline{
+			{{Key: "$sort", Value: bson.M{"_id": 1}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":           key,
+				"ids":           bson.M{"$push": "$_id"},
+				"device_tokens": bson.M{"$push": "$device_tokens"},
+				"count":         bson.M{"$sum": 1},
+			}}},
+			{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		}
+	}
+
+	type duplicates struct {
+		Ids          []primitive.ObjectID `bson:"ids"`
+		DeviceTokens [][]string           `bson:"device_tokens"`
+	}
+
+	findDuplicates := func(coll *mgm.Collection, key interface{}) ([]duplicates, error) {
+		var res []duplicates
+
+		cursor, err := coll.Aggregate(ctx, pipeline(key))
+		if err == nil {
+			err = cursor.All(ctx, &res)
+		}
+
+		return res, err
+	}
+
+	users := mgm.Coll(&models.User{})
+
+	duplicateUsers, err := findDuplicates(users, "$github_id")
+	if err != nil {
+		return err
+	}
+
+	for _, duplicate := range duplicateUsers {
+		var deviceTokens []string
+		for _, tokens := range duplicate.DeviceTokens {
+			deviceTokens = append(deviceTokens, tokens...)
+		}
+
+		update := bson.M{"$addToSet": bson.M{"device_tokens": bson.M{"$each": deviceTokens}}}
+		if _, err := users.UpdateOne(ctx, bson.M{"_id": duplicate.Ids[0]}, update); err != nil {
+			return err
+		}
+
+		if _, err := users.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicate.Ids[1:]}}); err != nil {
+			return err
+		}
+	}
+
+	deleteDuplicates := func(coll *mgm.Collection, key interface{}) error {
+		duplicates, err := findDuplicates(coll, key)
+		if err != nil {
+			return err
+		}
+
+		for _, duplicate := range duplicates {
+			if _, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicate.Ids[1:]}}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err = deleteDuplicates(mgm.Coll(&models.Installation{}), "$installation_id"); err != nil {
+		return err
+	}
+
+	thread := bson.M{"github_id": "$github_id", "repo_name": "$repo_name", "number": "$number"}
+	return deleteDuplicates(mgm.Coll(&models.Subscription{}), thread)
+}
+
+func createIndexes(ctx context.Context) error {
+	indexes := map[mgm.Model][]mongo.IndexModel{
+		&models.User{}: {
+			{Keys: bson.D{{Key: "github_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "feed_token", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		},
+		&models.Installation{}: {
+			{Keys: bson.D{{Key: "installation_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "github_id", Value: 1}}},
+		},
+		&models.StoredEvent{}: {
+			{Keys: bson.D{{Key: "github_id", Value: 1}, {Key: "event.timestamp", Value: -1}}},
+		},
+		&models.Subscription{}: {
+			{
+				Keys:    bson.D{{Key: "github_id", Value: 1}, {Key: "repo_name", Value: 1}, {Key: "number", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "repo_name", Value: 1}, {Key: "number", Value: 1}}},
+		},
+	}
+
+	for model, modelIndexes := range indexes {
+		if _, err := mgm.Coll(model).Indexes().CreateMany(ctx, modelIndexes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createEventTTLIndex(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(eventRetention.Seconds())),
+	}
+
+	_, err := mgm.Coll(&models.StoredEvent{}).Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// Device tokens are looked up when a device moves to another user
+func createDeviceTokenIndex(ctx context.Context) error {
+	_, err := mgm.Coll(&models.User{}).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "device_tokens", Value: 1}}})
+	return err
+}
+
+// The collection ratelimit.MongoStore keeps the token buckets of every replica in
+const RateLimitsCollection = "rate_limits"
+
+// Buckets are removed once they refilled, since a full bucket is the same as a bucket that was never used
+func createRateLimitTTLIndex(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	_, err := mgm.CollectionByName(RateLimitsCollection).Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// API keys are looked up by their key id on every request to the admin API
+func createAPIKeyIndex(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "key_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	_, err := mgm.Coll(&models.APIKey{}).Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// Installations keep the account they were installed on, so they can be linked to its user again once unlinked.
+// Until then, every installation was linked to the user of its account
+func recordInstallationAccounts(ctx context.Context) error {
+	installations := mgm.Coll(&models.Installation{})
+
+	cursor, err := installations.Find(ctx, bson.M{"account_id": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var installation struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			GithubId int64              `bson:"github_id"`
+		}
+
+		if err = cursor.Decode(&installation); err != nil {
+			return err
+		}
+
+		_, err = installations.UpdateOne(ctx, bson.M{"_id": installation.ID}, bson.M{"$set": bson.M{"account_id": installation.GithubId}})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = cursor.Err(); err != nil {
+		return err
+	}
+
+	_, err = installations.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "account_id", Value: 1}}})
+	return err
+}
+
+// Users are updated only if their version didn't change since they were read, which requires every user to have one
+func versionUsers(ctx context.Context) error {
+	_, err := mgm.Coll(&models.User{}).UpdateMany(ctx,
+		bson.M{"version": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"version": 0}})
+
+	return err
+}
+
+// Adds the latest event of every user to their event history, unless it is already part of it, and removes it from
+// the user. Latest events were kept with the user before the event history existed
+func moveLatestEvents(ctx context.Context) error {
+	users := mgm.Coll(&models.User{})
+	storedEvents := mgm.Coll(&models.StoredEvent{})
+
+	cursor, err := users.Find(ctx, bson.M{"latest_event": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			GithubId    int64              `bson:"github_id"`
+			LatestEvent *models.Event      `bson:"latest_event"`
+		}
+
+		if err = cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		if user.LatestEvent != nil {
+			filter := bson.M{
+				"github_id":       user.GithubId,
+				"event.eventtype": user.LatestEvent.EventType,
+				"event.url":       user.LatestEvent.Url,
+				"event.timestamp": user.LatestEvent.Timestamp,
+			}
+
+			count, err := storedEvents.CountDocuments(ctx, filter)
+			if err != nil {
+				return err
+			}
+
+			if count == 0 {
+				storedEvent := &models.StoredEvent{GithubId: user.GithubId, Event: *user.LatestEvent}
+				if err = storedEvents.CreateWithCtx(ctx, storedEvent); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err = users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$unset": bson.M{"latest_event": ""}}); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
@@ -0,0 +1,1002 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Kamva/mgm"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"push-request/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The database/sql drivers of the SQL databases the stores support
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite3"
+)
+
+// Opens the PostgreSQL or SQLite database at the data source name and creates stores backed by it, migrating its
+// schema to the latest version. The database must be closed by the caller
+func OpenSQLStores(ctx context.Context, driver string, dataSourceName string) (*Stores, *sql.DB, error) {
+	if driver != Postgres && driver != SQLite {
+		return nil, nil, fmt.Errorf("unsupported SQL driver %q", driver)
+	}
+
+	db, err := sql.Open(driver, dataSourceName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if driver == SQLite {
+		// SQLite allows a single writer, so connections would only wait on each other
+		db.SetMaxOpenConns(1)
+	}
+
+	stores, err := NewSQLStores(ctx, db, driver)
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, err
+	}
+
+	return stores, db, nil
+}
+
+// Creates stores backed by a database opened with the PostgreSQL or SQLite driver, migrating its schema to the
+// latest version
+func NewSQLStores(ctx context.Context, db *sql.DB, driver string) (*Stores, error) {
+	database := &sqlDB{db: db, postgres: driver == Postgres}
+
+	if err := database.migrate(ctx); err != nil {
+		return nil, err
+	}
+
+	return &Stores{
+		Users:         &sqlUserStore{database},
+		Installations: &sqlInstallationStore{database},
+		Events:        &sqlEventStore{database},
+		Subscriptions: &sqlSubscriptionStore{database},
+		APIKeys:       &sqlAPIKeyStore{database},
+		Audit:         &sqlAuditStore{database},
+		Backend:       backendName(driver),
+		Ping:          db.PingContext,
+	}, nil
+}
+
+func backendName(driver string) string {
+	if driver == SQLite {
+		return "sqlite"
+	}
+
+	return "postgres"
+}
+
+// A sqlDB runs queries written with `?` placeholders against PostgreSQL or SQLite
+type sqlDB struct {
+	db       *sql.DB
+	postgres bool
+}
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Rewrites the `?` placeholders of the query into the `$n` placeholders PostgreSQL expects
+func (database *sqlDB) rebind(query string) string {
+	if !database.postgres {
+		return query
+	}
+
+	var builder strings.Builder
+	n := 0
+
+	for _, c := range query {
+		if c == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		builder.WriteRune(c)
+	}
+
+	return builder.String()
+}
+
+func (database *sqlDB) exec(ctx context.Context, q querier, query string, args ...interface{}) (sql.Result, error) {
+	return q.ExecContext(ctx, database.rebind(query), args...)
+}
+
+func (database *sqlDB) query(ctx context.Context, q querier, query string, args ...interface{}) (*sql.Rows, error) {
+	return q.QueryContext(ctx, database.rebind(query), args...)
+}
+
+func (database *sqlDB) queryRow(ctx context.Context, q querier, query string, args ...interface{}) *sql.Row {
+	return q.QueryRowContext(ctx, database.rebind(query), args...)
+}
+
+// Runs f in a transaction, committed if f succeeds and rolled back otherwise
+func (database *sqlDB) withTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := database.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Applies the migrations that weren't applied yet, each in its own transaction
+func (database *sqlDB) migrate(ctx context.Context) error {
+	_, err := database.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create the schema_migrations table (%w)", err)
+	}
+
+	for i, migration := range sqlMigrations {
+		version := i + 1
+
+		err = database.withTx(ctx, func(tx *sql.Tx) error {
+			if database.postgres {
+				// Replicas starting at the same time wait for each other instead of applying a migration twice
+				if _, err := tx.ExecContext(ctx, "LOCK TABLE schema_migrations IN EXCLUSIVE MODE"); err != nil {
+					return err
+				}
+			}
+
+			var applied int
+
+			err := database.queryRow(ctx, tx, "SELECT COUNT(*) FROM schema_migrations WHERE version = ?", version).Scan(&applied)
+			if err != nil || applied > 0 {
+				return err
+			}
+
+			if _, err = tx.ExecContext(ctx, migration); err != nil {
+				return err
+			}
+
+			_, err = database.exec(ctx, tx, "INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)", version, time.Now().UTC())
+			return err
+		})
+
+		if err != nil {
+			return fmt.Errorf("failed to apply SQL migration %d (%w)", version, err)
+		}
+	}
+
+	return nil
+}
+
+func sqlError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// Returns ErrNotFound if an update or delete didn't affect any row
+func requireRow(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// A *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Assigns a new id and creation date to a model about to be inserted, unless it already has them, as the models copied
+// from MongoDB do
+func newModel(model *mgm.DefaultModel) {
+	if model.ID.IsZero() {
+		model.ID = primitive.NewObjectID()
+	}
+
+	if model.CreatedAt.IsZero() {
+		model.CreatedAt = time.Now().UTC()
+	}
+
+	if model.UpdatedAt.IsZero() {
+		model.UpdatedAt = model.CreatedAt
+	}
+}
+
+func scanModel(model *mgm.DefaultModel, id string, createdAt time.Time, updatedAt time.Time) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	model.ID = objectId
+	model.CreatedAt = createdAt.UTC()
+	model.UpdatedAt = updatedAt.UTC()
+	return nil
+}
+
+type sqlUserStore struct {
+	*sqlDB
+}
+
+const userColumns = "id, github_id, allowed_types, feed_token, version, disabled, created_at, updated_at"
+
+func (store *sqlUserStore) scan(row scanner) (*models.User, error) {
+	var user models.User
+	var id, allowedTypes string
+	var feedToken sql.NullString
+	var createdAt, updatedAt time.Time
+
+	err := row.Scan(&id, &user.GithubId, &allowedTypes, &feedToken, &user.Version, &user.Disabled, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+
+	if err = scanModel(&user.DefaultModel, id, createdAt, updatedAt); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(allowedTypes), &user.AllowedTypes); err != nil {
+		return nil, err
+	}
+
+	user.FeedToken = feedToken.String
+	return &user, nil
+}
+
+const deviceColumns = "token, platform, environment, app_version, locale, name, last_seen_at, allowed_types"
+
+func scanDevice(row scanner) (*models.Device, error) {
+	var device models.Device
+	var platform, environment, appVersion, locale, name, allowedTypes sql.NullString
+	var lastSeenAt sql.NullTime
+
+	err := row.Scan(&device.Token, &platform, &environment, &appVersion, &locale, &name, &lastSeenAt, &allowedTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	device.Platform = models.Platform(platform.String)
+	device.Environment = models.APNSEnvironment(environment.String)
+	device.AppVersion = appVersion.String
+	device.Locale = locale.String
+	device.Name = name.String
+
+	if lastSeenAt.Valid {
+		seenAt := lastSeenAt.Time.UTC()
+		device.LastSeenAt = &seenAt
+	}
+
+	if allowedTypes.Valid {
+		if err = json.Unmarshal([]byte(allowedTypes.String), &device.AllowedTypes); err != nil {
+			return nil, err
+		}
+	}
+
+	return &device, nil
+}
+
+// Gets the user matching the condition, with the device tokens in the order they were registered
+func (store *sqlUserStore) first(ctx context.Context, condition string, arg interface{}) (*models.User, error) {
+	row := store.queryRow(ctx, store.db, "SELECT "+userColumns+" FROM users WHERE "+condition, arg)
+
+	user, err := store.scan(row)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := store.query(ctx, store.db, "SELECT "+deviceColumns+" FROM devices WHERE github_id = ? ORDER BY position", user.GithubId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	user.DeviceTokens = []string{}
+	user.Devices = map[string]models.Device{}
+
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		user.DeviceTokens = append(user.DeviceTokens, device.Token)
+		user.Devices[device.Token] = *device
+	}
+
+	return user, rows.Err()
+}
+
+// Replaces the devices of the user with its device tokens
+func (store *sqlUserStore) saveDevices(ctx context.Context, tx *sql.Tx, user *models.User) error {
+	if _, err := store.exec(ctx, tx, "DELETE FROM devices WHERE github_id = ?", user.GithubId); err != nil {
+		return err
+	}
+
+	for position, token := range user.DeviceTokens {
+		device := user.Devices[token]
+
+		var allowedTypes sql.NullString
+		if device.AllowedTypes != nil {
+			encoded, err := json.Marshal(device.AllowedTypes)
+			if err != nil {
+				return err
+			}
+
+			allowedTypes = nullString(string(encoded))
+		}
+
+		var lastSeenAt sql.NullTime
+		if device.LastSeenAt != nil {
+			lastSeenAt = sql.NullTime{Time: *device.LastSeenAt, Valid: true}
+		}
+
+		_, err := store.exec(ctx, tx, "INSERT INTO devices (github_id, position, "+deviceColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			user.GithubId, position, token, nullString(string(device.Platform)), nullString(string(device.Environment)),
+			nullString(device.AppVersion), nullString(device.Locale), nullString(device.Name), lastSeenAt, allowedTypes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *sqlUserStore) Create(ctx context.Context, user *models.User) error {
+	allowedTypes, err := json.Marshal(user.AllowedTypes)
+	if err != nil {
+		return err
+	}
+
+	newModel(&user.DefaultModel)
+
+	return store.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := store.exec(ctx, tx, "INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			user.ID.Hex(), user.GithubId, string(allowedTypes), nullString(user.FeedToken), user.Version, user.Disabled,
+			user.CreatedAt, user.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		return store.saveDevices(ctx, tx, user)
+	})
+}
+
+func (store *sqlUserStore) Get(ctx context.Context, githubId int64) (*models.User, error) {
+	return store.first(ctx, "github_id = ?", githubId)
+}
+
+func (store *sqlUserStore) GetByFeedToken(ctx context.Context, feedToken string) (*models.User, error) {
+	return store.first(ctx, "feed_token = ?", feedToken)
+}
+
+func (store *sqlUserStore) Update(ctx context.Context, user *models.User) error {
+	allowedTypes, err := json.Marshal(user.AllowedTypes)
+	if err != nil {
+		return err
+	}
+
+	updatedAt := time.Now().UTC()
+
+	err = store.withTx(ctx, func(tx *sql.Tx) error {
+		err := requireRow(store.exec(ctx, tx,
+			"UPDATE users SET allowed_types = ?, feed_token = ?, disabled = ?, version = version + 1, updated_at = ? "+
+				"WHERE github_id = ? AND version = ?",
+			string(allowedTypes), nullString(user.FeedToken), user.Disabled, updatedAt, user.GithubId, user.Version))
+
+		if errors.Is(err, ErrNotFound) {
+			var exists bool
+			if err = store.queryRow(ctx, tx, "SELECT TRUE FROM users WHERE github_id = ?", user.GithubId).Scan(&exists); err == nil {
+				return ErrConflict
+			}
+
+			return sqlError(err)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return store.saveDevices(ctx, tx, user)
+	})
+
+	if err == nil {
+		user.Version++
+		user.UpdatedAt = updatedAt
+	}
+
+	return err
+}
+
+func (store *sqlUserStore) Register(ctx context.Context, githubId int64, device models.Device, allowedTypes []models.EventType, maxDevices int) (bool, error) {
+	feedToken, err := models.NewFeedToken()
+	if err != nil {
+		return false, err
+	}
+
+	// New users who didn't choose their allowed types are given the defaults
+	insertedTypes := allowedTypes
+	if insertedTypes == nil {
+		insertedTypes = models.DefaultEventTypes()
+	}
+
+	encodedTypes, err := json.Marshal(insertedTypes)
+	if err != nil {
+		return false, err
+	}
+
+	var created bool
+
+	err = store.withTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+
+		res, err := store.exec(ctx, tx, "INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (github_id) DO NOTHING",
+			primitive.NewObjectID().Hex(), githubId, string(encodedTypes), feedToken, 0, false, now, now)
+		if err != nil {
+			return err
+		}
+
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		created = inserted > 0
+
+		if created || allowedTypes == nil {
+			_, err = store.exec(ctx, tx, "UPDATE users SET version = version + 1, updated_at = ? WHERE github_id = ?", now, githubId)
+		} else {
+			_, err = store.exec(ctx, tx, "UPDATE users SET allowed_types = ?, version = version + 1, updated_at = ? WHERE github_id = ?",
+				string(encodedTypes), now, githubId)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// The user's row was locked by the update, so concurrent registrations are counted one after the other
+		if maxDevices > 0 {
+			var count, registered int
+
+			err = store.queryRow(ctx, tx,
+				"SELECT COUNT(*), COALESCE(SUM(CASE WHEN token = ? THEN 1 ELSE 0 END), 0) FROM devices WHERE github_id = ?",
+				device.Token, githubId).Scan(&count, &registered)
+			if err != nil {
+				return err
+			}
+
+			if registered == 0 && count >= maxDevices {
+				return ErrTooManyDevices
+			}
+		}
+
+		_, err = store.exec(ctx, tx,
+			"UPDATE users SET version = version + 1 WHERE github_id IN (SELECT github_id FROM devices WHERE token = ? AND github_id <> ?)",
+			device.Token, githubId)
+		if err != nil {
+			return err
+		}
+
+		if _, err = store.exec(ctx, tx, "DELETE FROM devices WHERE token = ? AND github_id <> ?", device.Token, githubId); err != nil {
+			return err
+		}
+
+		// The details of a device already registered are kept where the registration leaves them empty
+		_, err = store.exec(ctx, tx,
+			"INSERT INTO devices (github_id, token, position, platform, environment, app_version, locale, name, last_seen_at) "+
+				"SELECT ?, ?, COALESCE(MAX(position) + 1, 0), ?, ?, ?, ?, ?, ? FROM devices WHERE github_id = ? "+
+				"ON CONFLICT (github_id, token) DO UPDATE SET "+
+				"platform = COALESCE(excluded.platform, devices.platform), "+
+				"environment = COALESCE(excluded.environment, devices.environment), "+
+				"app_version = COALESCE(excluded.app_version, devices.app_version), "+
+				"locale = COALESCE(excluded.locale, devices.locale), "+
+				"name = COALESCE(excluded.name, devices.name), "+
+				"last_seen_at = excluded.last_seen_at",
+			githubId, device.Token, nullString(string(device.Platform)), nullString(string(device.Environment)),
+			nullString(device.AppVersion), nullString(device.Locale), nullString(device.Name), now, githubId)
+		return err
+	})
+
+	return created, err
+}
+
+func (store *sqlUserStore) RemoveDevice(ctx context.Context, githubId int64, deviceToken string) error {
+	return store.withTx(ctx, func(tx *sql.Tx) error {
+		err := requireRow(store.exec(ctx, tx, "DELETE FROM devices WHERE github_id = ? AND token = ?", githubId, deviceToken))
+		if err != nil {
+			return err
+		}
+
+		return store.incrementVersion(ctx, tx, githubId)
+	})
+}
+
+// Increments the version of the user after one of their devices was removed
+func (store *sqlUserStore) incrementVersion(ctx context.Context, tx *sql.Tx, githubId int64) error {
+	_, err := store.exec(ctx, tx, "UPDATE users SET version = version + 1, updated_at = ? WHERE github_id = ?", time.Now().UTC(), githubId)
+	return err
+}
+
+func (store *sqlUserStore) Delete(ctx context.Context, githubId int64) error {
+	return store.withTx(ctx, func(tx *sql.Tx) error {
+		// SQLite only enforces foreign keys when asked to, so devices are deleted explicitly
+		if _, err := store.exec(ctx, tx, "DELETE FROM devices WHERE github_id = ?", githubId); err != nil {
+			return err
+		}
+
+		return requireRow(store.exec(ctx, tx, "DELETE FROM users WHERE github_id = ?", githubId))
+	})
+}
+
+func (store *sqlUserStore) List(ctx context.Context, query UserQuery) ([]models.User, error) {
+	condition := "github_id > ?"
+	args := []interface{}{query.After}
+
+	if query.DeviceToken != "" {
+		condition += " AND github_id IN (SELECT github_id FROM devices WHERE token = ?)"
+		args = append(args, query.DeviceToken)
+	}
+
+	if query.Disabled != nil {
+		condition += " AND disabled = ?"
+		args = append(args, *query.Disabled)
+	}
+
+	rows, err := store.query(ctx, store.db, "SELECT github_id FROM users WHERE "+condition+" ORDER BY github_id LIMIT ?",
+		append(args, query.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var githubIds []int64
+
+	for rows.Next() {
+		var githubId int64
+		if err = rows.Scan(&githubId); err != nil {
+			return nil, err
+		}
+
+		githubIds = append(githubIds, githubId)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Each user is read with their devices. Pages are small, so reading them one at a time is simpler than joining
+	res := []models.User{}
+
+	for _, githubId := range githubIds {
+		user, err := store.Get(ctx, githubId)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, *user)
+	}
+
+	return res, nil
+}
+
+type sqlInstallationStore struct {
+	*sqlDB
+}
+
+func (store *sqlInstallationStore) Create(ctx context.Context, installation *models.Installation) error {
+	newModel(&installation.DefaultModel)
+
+	_, err := store.exec(ctx, store.db,
+		"INSERT INTO installations ("+installationColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		installation.ID.Hex(), installation.Id, installation.GithubId, installation.AccountId, installation.CreatedAt,
+		installation.UpdatedAt)
+	return err
+}
+
+const installationColumns = "id, installation_id, github_id, account_id, created_at, updated_at"
+
+func (store *sqlInstallationStore) scan(row scanner) (*models.Installation, error) {
+	var installation models.Installation
+	var id string
+	var createdAt, updatedAt time.Time
+
+	err := row.Scan(&id, &installation.Id, &installation.GithubId, &installation.AccountId, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+
+	return &installation, scanModel(&installation.DefaultModel, id, createdAt, updatedAt)
+}
+
+func (store *sqlInstallationStore) first(ctx context.Context, condition string, arg interface{}) (*models.Installation, error) {
+	return store.scan(store.queryRow(ctx, store.db, "SELECT "+installationColumns+" FROM installations WHERE "+condition, arg))
+}
+
+func (store *sqlInstallationStore) Get(ctx context.Context, installationId int64) (*models.Installation, error) {
+	return store.first(ctx, "installation_id = ?", installationId)
+}
+
+func (store *sqlInstallationStore) GetByGithubId(ctx context.Context, githubId int64) (*models.Installation, error) {
+	return store.first(ctx, "github_id = ?", githubId)
+}
+
+func (store *sqlInstallationStore) List(ctx context.Context, after int64, limit int) ([]models.Installation, error) {
+	return store.list(ctx, "SELECT "+installationColumns+" FROM installations WHERE installation_id > ? ORDER BY installation_id LIMIT ?",
+		after, limit)
+}
+
+func (store *sqlInstallationStore) ListByGithubId(ctx context.Context, githubId int64) ([]models.Installation, error) {
+	return store.list(ctx, "SELECT "+installationColumns+" FROM installations WHERE github_id = ? ORDER BY installation_id", githubId)
+}
+
+func (store *sqlInstallationStore) list(ctx context.Context, query string, args ...interface{}) ([]models.Installation, error) {
+	rows, err := store.query(ctx, store.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	res := []models.Installation{}
+
+	for rows.Next() {
+		installation, err := store.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, *installation)
+	}
+
+	return res, rows.Err()
+}
+
+func (store *sqlInstallationStore) Link(ctx context.Context, installationId int64, githubId int64) error {
+	return requireRow(store.exec(ctx, store.db, "UPDATE installations SET github_id = ?, updated_at = ? WHERE installation_id = ?",
+		githubId, time.Now().UTC(), installationId))
+}
+
+func (store *sqlInstallationStore) UnlinkByGithubId(ctx context.Context, githubId int64) error {
+	_, err := store.exec(ctx, store.db, "UPDATE installations SET github_id = 0, updated_at = ? WHERE github_id = ?",
+		time.Now().UTC(), githubId)
+	return err
+}
+
+func (store *sqlInstallationStore) LinkAccount(ctx context.Context, githubId int64) error {
+	_, err := store.exec(ctx, store.db,
+		"UPDATE installations SET github_id = ?, updated_at = ? WHERE account_id = ? AND github_id = 0",
+		githubId, time.Now().UTC(), githubId)
+	return err
+}
+
+type sqlEventStore struct {
+	*sqlDB
+}
+
+const eventColumns = "id, github_id, event, created_at, updated_at"
+
+func (store *sqlEventStore) Create(ctx context.Context, githubId int64, event *models.Event) (*models.StoredEvent, error) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	storedEvent := &models.StoredEvent{GithubId: githubId, Event: *event}
+	newModel(&storedEvent.DefaultModel)
+
+	_, err = store.exec(ctx, store.db,
+		"INSERT INTO events (id, github_id, repo_name, occurred_at, event, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		storedEvent.ID.Hex(), githubId, event.RepoName, event.Timestamp.UTC(), string(encoded), storedEvent.CreatedAt, storedEvent.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return storedEvent, nil
+}
+
+func (store *sqlEventStore) list(ctx context.Context, query string, args ...interface{}) ([]models.StoredEvent, error) {
+	rows, err := store.query(ctx, store.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	res := []models.StoredEvent{}
+
+	for rows.Next() {
+		var storedEvent models.StoredEvent
+		var id, event string
+		var createdAt, updatedAt time.Time
+
+		if err = rows.Scan(&id, &storedEvent.GithubId, &event, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+
+		if err = scanModel(&storedEvent.DefaultModel, id, createdAt, updatedAt); err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal([]byte(event), &storedEvent.Event); err != nil {
+			return nil, err
+		}
+
+		res = append(res, storedEvent)
+	}
+
+	return res, rows.Err()
+}
+
+func (store *sqlEventStore) List(ctx context.Context, githubId int64, repoNames []string, limit int) ([]models.StoredEvent, error) {
+	query := "SELECT " + eventColumns + " FROM events WHERE github_id = ?"
+	args := []interface{}{githubId}
+
+	if len(repoNames) > 0 {
+		query += " AND repo_name IN (?" + strings.Repeat(", ?", len(repoNames)-1) + ")"
+		for _, repoName := range repoNames {
+			args = append(args, repoName)
+		}
+	}
+
+	return store.list(ctx, query+" ORDER BY occurred_at DESC LIMIT ?", append(args, limit)...)
+}
+
+func (store *sqlEventStore) ListAfter(ctx context.Context, githubId int64, id primitive.ObjectID, limit int) ([]models.StoredEvent, error) {
+	// The hex encodings of object ids sort in the same order as the ids
+	return store.list(ctx, "SELECT "+eventColumns+" FROM events WHERE github_id = ? AND id > ? ORDER BY id LIMIT ?",
+		githubId, id.Hex(), limit)
+}
+
+func (store *sqlEventStore) DeleteByUser(ctx context.Context, githubId int64) error {
+	_, err := store.exec(ctx, store.db, "DELETE FROM events WHERE github_id = ?", githubId)
+	return err
+}
+
+type sqlSubscriptionStore struct {
+	*sqlDB
+}
+
+const subscriptionColumns = "id, github_id, repo_name, number, reason, muted, created_at, updated_at"
+
+func (store *sqlSubscriptionStore) scan(row scanner) (*models.Subscription, error) {
+	var subscription models.Subscription
+	var id string
+	var createdAt, updatedAt time.Time
+
+	err := row.Scan(&id, &subscription.GithubId, &subscription.RepoName, &subscription.Number, &subscription.Reason,
+		&subscription.Muted, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+
+	return &subscription, scanModel(&subscription.DefaultModel, id, createdAt, updatedAt)
+}
+
+func (store *sqlSubscriptionStore) Subscribe(ctx context.Context, githubId int64, repoName string, number int, reason models.SubscriptionReason) error {
+	subscription := &models.Subscription{GithubId: githubId, RepoName: repoName, Number: number, Reason: reason}
+	newModel(&subscription.DefaultModel)
+
+	_, err := store.exec(ctx, store.db,
+		"INSERT INTO subscriptions ("+subscriptionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT (github_id, repo_name, number) DO UPDATE SET updated_at = excluded.updated_at",
+		subscription.ID.Hex(), githubId, repoName, number, reason, false, subscription.CreatedAt, subscription.UpdatedAt)
+	return err
+}
+
+func (store *sqlSubscriptionStore) Create(ctx context.Context, subscription *models.Subscription) error {
+	newModel(&subscription.DefaultModel)
+
+	_, err := store.exec(ctx, store.db, "INSERT INTO subscriptions ("+subscriptionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		subscription.ID.Hex(), subscription.GithubId, subscription.RepoName, subscription.Number, subscription.Reason,
+		subscription.Muted, subscription.CreatedAt, subscription.UpdatedAt)
+	return err
+}
+
+func (store *sqlSubscriptionStore) Get(ctx context.Context, githubId int64, repoName string, number int) (*models.Subscription, error) {
+	return store.scan(store.queryRow(ctx, store.db,
+		"SELECT "+subscriptionColumns+" FROM subscriptions WHERE github_id = ? AND repo_name = ? AND number = ?",
+		githubId, repoName, number))
+}
+
+func (store *sqlSubscriptionStore) Update(ctx context.Context, subscription *models.Subscription) error {
+	subscription.UpdatedAt = time.Now().UTC()
+
+	return requireRow(store.exec(ctx, store.db,
+		"UPDATE subscriptions SET reason = ?, muted = ?, updated_at = ? WHERE github_id = ? AND repo_name = ? AND number = ?",
+		subscription.Reason, subscription.Muted, subscription.UpdatedAt, subscription.GithubId, subscription.RepoName,
+		subscription.Number))
+}
+
+func (store *sqlSubscriptionStore) Delete(ctx context.Context, subscription *models.Subscription) error {
+	return requireRow(store.exec(ctx, store.db,
+		"DELETE FROM subscriptions WHERE github_id = ? AND repo_name = ? AND number = ?",
+		subscription.GithubId, subscription.RepoName, subscription.Number))
+}
+
+func (store *sqlSubscriptionStore) list(ctx context.Context, query string, args ...interface{}) ([]models.Subscription, error) {
+	rows, err := store.query(ctx, store.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	res := []models.Subscription{}
+
+	for rows.Next() {
+		subscription, err := store.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, *subscription)
+	}
+
+	return res, rows.Err()
+}
+
+func (store *sqlSubscriptionStore) ListByUser(ctx context.Context, githubId int64) ([]models.Subscription, error) {
+	return store.list(ctx, "SELECT "+subscriptionColumns+" FROM subscriptions WHERE github_id = ? ORDER BY updated_at DESC", githubId)
+}
+
+func (store *sqlSubscriptionStore) ListByThread(ctx context.Context, repoName string, number int) ([]models.Subscription, error) {
+	return store.list(ctx, "SELECT "+subscriptionColumns+" FROM subscriptions WHERE repo_name = ? AND number = ?", repoName, number)
+}
+
+func (store *sqlSubscriptionStore) DeleteByUser(ctx context.Context, githubId int64) error {
+	_, err := store.exec(ctx, store.db, "DELETE FROM subscriptions WHERE github_id = ?", githubId)
+	return err
+}
+
+type sqlAPIKeyStore struct {
+	*sqlDB
+}
+
+const apiKeyColumns = "id, key_id, name, role, hash, created_at, updated_at"
+
+func (store *sqlAPIKeyStore) scan(row scanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var id string
+	var createdAt, updatedAt time.Time
+
+	err := row.Scan(&id, &key.KeyId, &key.Name, &key.Role, &key.Hash, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+
+	return &key, scanModel(&key.DefaultModel, id, createdAt, updatedAt)
+}
+
+func (store *sqlAPIKeyStore) Create(ctx context.Context, key *models.APIKey) error {
+	newModel(&key.DefaultModel)
+
+	_, err := store.exec(ctx, store.db, "INSERT INTO api_keys ("+apiKeyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		key.ID.Hex(), key.KeyId, key.Name, key.Role, key.Hash, key.CreatedAt, key.UpdatedAt)
+	return err
+}
+
+func (store *sqlAPIKeyStore) Get(ctx context.Context, keyId string) (*models.APIKey, error) {
+	return store.scan(store.queryRow(ctx, store.db, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_id = ?", keyId))
+}
+
+func (store *sqlAPIKeyStore) List(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := store.query(ctx, store.db, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	res := []models.APIKey{}
+
+	for rows.Next() {
+		key, err := store.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, *key)
+	}
+
+	return res, rows.Err()
+}
+
+func (store *sqlAPIKeyStore) Delete(ctx context.Context, keyId string) error {
+	return requireRow(store.exec(ctx, store.db, "DELETE FROM api_keys WHERE key_id = ?", keyId))
+}
+
+type sqlAuditStore struct {
+	*sqlDB
+}
+
+const auditColumns = "id, key_id, key_name, role, action, target, body, status, request_id, created_at, updated_at"
+
+func (store *sqlAuditStore) Create(ctx context.Context, entry *models.AuditEntry) error {
+	newModel(&entry.DefaultModel)
+
+	_, err := store.exec(ctx, store.db, "INSERT INTO audit_log ("+auditColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		entry.ID.Hex(), entry.KeyId, entry.KeyName, entry.Role, entry.Action, nullString(entry.Target),
+		nullString(entry.Body), entry.Status, nullString(entry.RequestId), entry.CreatedAt, entry.UpdatedAt)
+	return err
+}
+
+func (store *sqlAuditStore) List(ctx context.Context, before primitive.ObjectID, limit int) ([]models.AuditEntry, error) {
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	var args []interface{}
+
+	// The hex encodings of object ids sort in the same order as the ids
+	if before != primitive.NilObjectID {
+		query += " WHERE id < ?"
+		args = append(args, before.Hex())
+	}
+
+	rows, err := store.query(ctx, store.db, query+" ORDER BY id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	res := []models.AuditEntry{}
+
+	for rows.Next() {
+		var entry models.AuditEntry
+		var id string
+		var target, body, requestId sql.NullString
+		var createdAt, updatedAt time.Time
+
+		err = rows.Scan(&id, &entry.KeyId, &entry.KeyName, &entry.Role, &entry.Action, &target, &body, &entry.Status,
+			&requestId, &createdAt, &updatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = scanModel(&entry.DefaultModel, id, createdAt, updatedAt); err != nil {
+			return nil, err
+		}
+
+		entry.Target = target.String
+		entry.Body = body.String
+		entry.RequestId = requestId.String
+		res = append(res, entry)
+	}
+
+	return res, rows.Err()
+}
@@ -0,0 +1,124 @@
+package storage
+
+// The migrations of the SQL schema, in the order they are applied. The version of a migration is its index plus one.
+// Each migration must work on both PostgreSQL and SQLite, and must never change once released: changes to the schema
+// are made by appending a new migration
+var sqlMigrations = []string{
+	`
+	CREATE TABLE users (
+		id            TEXT PRIMARY KEY,
+		github_id     BIGINT NOT NULL UNIQUE,
+		allowed_types TEXT NOT NULL,
+		latest_event  TEXT,
+		feed_token    TEXT UNIQUE,
+		created_at    TIMESTAMP NOT NULL,
+		updated_at    TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE devices (
+		github_id BIGINT NOT NULL REFERENCES users (github_id) ON DELETE CASCADE,
+		token     TEXT NOT NULL,
+		position  INTEGER NOT NULL,
+		PRIMARY KEY (github_id, token)
+	);
+
+	CREATE TABLE installations (
+		id              TEXT PRIMARY KEY,
+		installation_id BIGINT NOT NULL UNIQUE,
+		github_id       BIGINT NOT NULL,
+		created_at      TIMESTAMP NOT NULL,
+		updated_at      TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX installations_github_id ON installations (github_id);
+
+	CREATE TABLE events (
+		id          TEXT PRIMARY KEY,
+		github_id   BIGINT NOT NULL,
+		repo_name   TEXT NOT NULL,
+		occurred_at TIMESTAMP NOT NULL,
+		event       TEXT NOT NULL,
+		created_at  TIMESTAMP NOT NULL,
+		updated_at  TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX events_github_id_occurred_at ON events (github_id, occurred_at);
+
+	CREATE TABLE subscriptions (
+		id         TEXT PRIMARY KEY,
+		github_id  BIGINT NOT NULL,
+		repo_name  TEXT NOT NULL,
+		number     INTEGER NOT NULL,
+		reason     TEXT NOT NULL,
+		muted      BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		UNIQUE (github_id, repo_name, number)
+	);
+
+	CREATE INDEX subscriptions_thread ON subscriptions (repo_name, number);
+	`,
+
+	// Latest events are read from the event history, which every delivered event is already part of
+	`
+	ALTER TABLE users DROP COLUMN latest_event;
+	`,
+
+	`
+	ALTER TABLE devices ADD COLUMN name TEXT;
+
+	CREATE INDEX devices_token ON devices (token);
+	`,
+
+	// Users are updated only if their version didn't change since they were read
+	`
+	ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+	`,
+
+	`
+	ALTER TABLE devices ADD COLUMN platform TEXT;
+	ALTER TABLE devices ADD COLUMN environment TEXT;
+	ALTER TABLE devices ADD COLUMN app_version TEXT;
+	ALTER TABLE devices ADD COLUMN locale TEXT;
+	ALTER TABLE devices ADD COLUMN last_seen_at TIMESTAMP;
+	ALTER TABLE devices ADD COLUMN allowed_types TEXT;
+	`,
+
+	// Operators of the admin API authenticate with API keys, and everything they do is recorded in the audit log
+	`
+	ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+	CREATE TABLE api_keys (
+		id         TEXT PRIMARY KEY,
+		key_id     TEXT NOT NULL UNIQUE,
+		name       TEXT NOT NULL,
+		role       TEXT NOT NULL,
+		hash       TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE audit_log (
+		id         TEXT PRIMARY KEY,
+		key_id     TEXT NOT NULL,
+		key_name   TEXT NOT NULL,
+		role       TEXT NOT NULL,
+		action     TEXT NOT NULL,
+		target     TEXT,
+		body       TEXT,
+		status     INTEGER NOT NULL,
+		request_id TEXT,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+	`,
+
+	// Installations keep the account they were installed on, so they can be linked to its user again once unlinked
+	`
+	ALTER TABLE installations ADD COLUMN account_id BIGINT NOT NULL DEFAULT 0;
+
+	UPDATE installations SET account_id = github_id;
+
+	CREATE INDEX installations_account_id ON installations (account_id);
+	`,
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"push-request/models"
+)
+
+// Returned by the stores when the requested document doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// Returned by the stores when a document was updated by someone else since it was read
+var ErrConflict = errors.New("conflict")
+
+// Returned by UserStore.Register when the user already has as many devices as they may
+var ErrTooManyDevices = errors.New("too many devices")
+
+// How many times UpdateUser reads the user again after a conflicting update
+const maxUpdateRetries = 10
+
+type UserStore interface {
+	Create(ctx context.Context, user *models.User) error
+	Get(ctx context.Context, githubId int64) (*models.User, error)
+	GetByFeedToken(ctx context.Context, feedToken string) (*models.User, error)
+
+	// Replaces the user and increments their version, unless the user was updated since this copy was read, in
+	// which case ErrConflict is returned and nothing is changed
+	Update(ctx context.Context, user *models.User) error
+
+	// Adds the device to the user in a single atomic operation, creating the user if they don't exist, and reports
+	// whether the user was created. The details of a device already registered are updated, except for those left
+	// empty, and it is marked as seen. The allowed types of the user are replaced unless nil, and new users are given
+	// models.DefaultEventTypes when they are nil. A device belongs to a single account, so it is removed from any
+	// other user. When maxDevices is positive, a new device is refused with ErrTooManyDevices, and nothing is changed,
+	// if the user already has that many
+	Register(ctx context.Context, githubId int64, device models.Device, allowedTypes []models.EventType, maxDevices int) (bool, error)
+
+	// Removes the device from the user, or returns ErrNotFound if the user has no such device
+	RemoveDevice(ctx context.Context, githubId int64, deviceToken string) error
+
+	// Deletes the user with their devices, or returns ErrNotFound if they don't exist
+	Delete(ctx context.Context, githubId int64) error
+
+	// Lists the users matching the query, by github id
+	List(ctx context.Context, query UserQuery) ([]models.User, error)
+}
+
+// Filters the users listed by UserStore.List, a page at a time
+type UserQuery struct {
+	// Only users with a greater github id are listed, so that a page starts after the last user of the page before
+	After int64
+
+	// Only lists the user with the device, if set
+	DeviceToken string
+
+	// Only lists the users who are disabled, or those who aren't, if set
+	Disabled *bool
+
+	Limit int
+}
+
+type InstallationStore interface {
+	Create(ctx context.Context, installation *models.Installation) error
+	Get(ctx context.Context, installationId int64) (*models.Installation, error)
+	GetByGithubId(ctx context.Context, githubId int64) (*models.Installation, error)
+
+	// Lists the installations with an installation id greater than after, by installation id
+	List(ctx context.Context, after int64, limit int) ([]models.Installation, error)
+
+	// Lists the installations of the GitHub App on the user's account, by installation id
+	ListByGithubId(ctx context.Context, githubId int64) ([]models.Installation, error)
+
+	// Links the installation to the user, or returns ErrNotFound if it doesn't exist
+	Link(ctx context.Context, installationId int64, githubId int64) error
+
+	// Unlinks the installations linked to the user, if any. They keep their account
+	UnlinkByGithubId(ctx context.Context, githubId int64) error
+
+	// Links the unlinked installations on the user's account to the user
+	LinkAccount(ctx context.Context, githubId int64) error
+}
+
+type EventStore interface {
+	// Stores an event delivered to the user
+	Create(ctx context.Context, githubId int64, event *models.Event) (*models.StoredEvent, error)
+
+	// Lists the most recent events delivered to the user, newest first. If repoNames is not empty,
+	// only events from those repositories are listed
+	List(ctx context.Context, githubId int64, repoNames []string, limit int) ([]models.StoredEvent, error)
+
+	// Lists the events delivered to the user after the event with the given id, oldest first
+	ListAfter(ctx context.Context, githubId int64, id primitive.ObjectID, limit int) ([]models.StoredEvent, error)
+
+	// Deletes every event delivered to the user
+	DeleteByUser(ctx context.Context, githubId int64) error
+}
+
+type SubscriptionStore interface {
+	// Subscribes the user to the thread, unless they are already subscribed to it or muted it
+	Subscribe(ctx context.Context, githubId int64, repoName string, number int, reason models.SubscriptionReason) error
+
+	Create(ctx context.Context, subscription *models.Subscription) error
+	Get(ctx context.Context, githubId int64, repoName string, number int) (*models.Subscription, error)
+	Update(ctx context.Context, subscription *models.Subscription) error
+	Delete(ctx context.Context, subscription *models.Subscription) error
+
+	// Lists the subscriptions of the user, most recently updated first
+	ListByUser(ctx context.Context, githubId int64) ([]models.Subscription, error)
+
+	// Lists the subscriptions of every user to the thread, muted ones included
+	ListByThread(ctx context.Context, repoName string, number int) ([]models.Subscription, error)
+
+	// Deletes every subscription of the user
+	DeleteByUser(ctx context.Context, githubId int64) error
+}
+
+type APIKeyStore interface {
+	Create(ctx context.Context, key *models.APIKey) error
+
+	// Gets the key with the key id, or returns ErrNotFound if there is none
+	Get(ctx context.Context, keyId string) (*models.APIKey, error)
+
+	// Lists every key, oldest first
+	List(ctx context.Context) ([]models.APIKey, error)
+
+	// Deletes the key, so it can't be used anymore, or returns ErrNotFound if it doesn't exist
+	Delete(ctx context.Context, keyId string) error
+}
+
+type AuditStore interface {
+	Create(ctx context.Context, entry *models.AuditEntry) error
+
+	// Lists the most recent entries, newest first. If before isn't primitive.NilObjectID, only the entries older than
+	// the entry with that id are listed, so that a page starts after the last entry of the page before
+	List(ctx context.Context, before primitive.ObjectID, limit int) ([]models.AuditEntry, error)
+}
+
+// Reads the user, applies the change and updates them, reading them again and reapplying the change if someone else
+// updated them in the meantime. The change must only depend on the user it is given
+func UpdateUser(ctx context.Context, users UserStore, githubId int64, change func(user *models.User) error) (*models.User, error) {
+	for attempt := 0; ; attempt++ {
+		user, err := users.Get(ctx, githubId)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = change(user); err != nil {
+			return nil, err
+		}
+
+		err = users.Update(ctx, user)
+		if err == nil {
+			return user, nil
+		}
+
+		if !errors.Is(err, ErrConflict) || attempt == maxUpdateRetries {
+			return nil, err
+		}
+	}
+}
+
+// Stores groups the stores of every model
+type Stores struct {
+	Users         UserStore
+	Installations InstallationStore
+	Events        EventStore
+	Subscriptions SubscriptionStore
+	APIKeys       APIKeyStore
+	Audit         AuditStore
+
+	// The name of the backend, such as mongo or postgres
+	Backend string
+
+	// Checks that the database can be reached
+	Ping func(ctx context.Context) error
+}
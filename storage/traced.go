@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"push-request/models"
+	"push-request/tracing"
+)
+
+// Wraps the stores so that every call is traced, in a span named by the store and method, such as `UserStore.Get`
+func Traced(stores *Stores) *Stores {
+	tracer := storeTracer{system: semconv.DBSystemKey.String(dbSystem(stores.Backend))}
+
+	traced := *stores
+	traced.Users = &tracedUserStore{stores.Users, tracer}
+	traced.Installations = &tracedInstallationStore{stores.Installations, tracer}
+	traced.Events = &tracedEventStore{stores.Events, tracer}
+	traced.Subscriptions = &tracedSubscriptionStore{stores.Subscriptions, tracer}
+	traced.APIKeys = &tracedAPIKeyStore{stores.APIKeys, tracer}
+	traced.Audit = &tracedAuditStore{stores.Audit, tracer}
+
+	return &traced
+}
+
+// Gets the name OpenTelemetry gives the database of the backend
+func dbSystem(backend string) string {
+	switch backend {
+	case "mongo":
+		return "mongodb"
+	case Postgres:
+		return "postgresql"
+	default:
+		return backend
+	}
+}
+
+type storeTracer struct {
+	system attribute.KeyValue
+}
+
+func (tracer storeTracer) start(ctx context.Context, store string, operation string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes, tracer.system, semconv.DBOperationKey.String(operation))
+	return tracing.Start(ctx, store+"."+operation, attributes...)
+}
+
+// Ends the span of a call. Documents that don't exist and conflicting updates are results callers handle rather
+// than failures
+func endStoreSpan(span trace.Span, err error) {
+	switch {
+	case err == nil:
+		tracing.End(span, "ok", nil)
+	case errors.Is(err, ErrNotFound):
+		tracing.End(span, "not_found", nil)
+	case errors.Is(err, ErrConflict):
+		tracing.End(span, "conflict", nil)
+	default:
+		tracing.End(span, "error", err)
+	}
+}
+
+func userAttribute(githubId int64) attribute.KeyValue {
+	return tracing.UserIdKey.Int64(githubId)
+}
+
+type tracedUserStore struct {
+	store  UserStore
+	tracer storeTracer
+}
+
+func (traced *tracedUserStore) Create(ctx context.Context, user *models.User) (err error) {
+	ctx, span := traced.tracer.start(ctx, "UserStore", "Create", userAttribute(user.GithubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Create(ctx, user)
+}
+
+func (traced *tracedUserStore) Get(ctx context.Context, githubId int64) (user *models.User, err error) {
+	ctx, span := traced.tracer.start(ctx, "UserStore", "Get", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Get(ctx, githubId)
+}
+
+func (traced *tracedUserStore) GetByFeedToken(ctx context.Context, feedToken string) (user *models.User, err error) {
+	ctx, span := traced.tracer.start(ctx, "UserStore", "GetByFeedToken")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.GetByFeedToken(ctx, feedToken)
+}
+
+func (traced *tracedUserStore) Update(ctx context.Context, user *models.User) (err error) {
+	ctx, span := traced.tracer.start(ctx, "UserStore", "Update", userAttribute(user.GithubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Update(ctx, user)
+}
+
+func (traced *tracedUserStore) Register(ctx context.Context, githubId int64, device models.Device, allowedTypes []models.EventType, maxDevices int) (created bool, err error) {
+	ctx, span := traced.tracer.start(ctx, "UserStore", "Register", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Register(ctx, githubId, device, allowedTypes, maxDevices)
+}
+
+func (traced *tracedUserStore) RemoveDevice(ctx context.Context, githubId int64, deviceToken string) (err error) {
+	ctx, span := traced.tracer.start(ctx, "UserStore", "RemoveDevice", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.RemoveDevice(ctx, githubId, deviceToken)
+}
+
+func (traced *tracedUserStore) Delete(ctx context.Context, githubId int64) (err error) {
+	ctx, span := traced.tracer.start(ctx, "UserStore", "Delete", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Delete(ctx, githubId)
+}
+
+func (traced *tracedUserStore) List(ctx context.Context, query UserQuery) (users []models.User, err error) {
+	ctx, span := traced.tracer.start(ctx, "UserStore", "List")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.List(ctx, query)
+}
+
+type tracedInstallationStore struct {
+	store  InstallationStore
+	tracer storeTracer
+}
+
+func (traced *tracedInstallationStore) Create(ctx context.Context, installation *models.Installation) (err error) {
+	ctx, span := traced.tracer.start(ctx, "InstallationStore", "Create",
+		tracing.InstallationKey.Int64(installation.Id), userAttribute(installation.GithubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Create(ctx, installation)
+}
+
+func (traced *tracedInstallationStore) Get(ctx context.Context, installationId int64) (installation *models.Installation, err error) {
+	ctx, span := traced.tracer.start(ctx, "InstallationStore", "Get", tracing.InstallationKey.Int64(installationId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Get(ctx, installationId)
+}
+
+func (traced *tracedInstallationStore) GetByGithubId(ctx context.Context, githubId int64) (installation *models.Installation, err error) {
+	ctx, span := traced.tracer.start(ctx, "InstallationStore", "GetByGithubId", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.GetByGithubId(ctx, githubId)
+}
+
+func (traced *tracedInstallationStore) List(ctx context.Context, after int64, limit int) (installations []models.Installation, err error) {
+	ctx, span := traced.tracer.start(ctx, "InstallationStore", "List")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.List(ctx, after, limit)
+}
+
+func (traced *tracedInstallationStore) ListByGithubId(ctx context.Context, githubId int64) (installations []models.Installation, err error) {
+	ctx, span := traced.tracer.start(ctx, "InstallationStore", "ListByGithubId", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.ListByGithubId(ctx, githubId)
+}
+
+func (traced *tracedInstallationStore) Link(ctx context.Context, installationId int64, githubId int64) (err error) {
+	ctx, span := traced.tracer.start(ctx, "InstallationStore", "Link", tracing.InstallationKey.Int64(installationId),
+		userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Link(ctx, installationId, githubId)
+}
+
+func (traced *tracedInstallationStore) UnlinkByGithubId(ctx context.Context, githubId int64) (err error) {
+	ctx, span := traced.tracer.start(ctx, "InstallationStore", "UnlinkByGithubId", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.UnlinkByGithubId(ctx, githubId)
+}
+
+func (traced *tracedInstallationStore) LinkAccount(ctx context.Context, githubId int64) (err error) {
+	ctx, span := traced.tracer.start(ctx, "InstallationStore", "LinkAccount", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.LinkAccount(ctx, githubId)
+}
+
+type tracedEventStore struct {
+	store  EventStore
+	tracer storeTracer
+}
+
+func (traced *tracedEventStore) Create(ctx context.Context, githubId int64, event *models.Event) (storedEvent *models.StoredEvent, err error) {
+	ctx, span := traced.tracer.start(ctx, "EventStore", "Create", userAttribute(githubId),
+		tracing.EventTypeKey.String(string(event.EventType)))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Create(ctx, githubId, event)
+}
+
+func (traced *tracedEventStore) List(ctx context.Context, githubId int64, repoNames []string, limit int) (events []models.StoredEvent, err error) {
+	ctx, span := traced.tracer.start(ctx, "EventStore", "List", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.List(ctx, githubId, repoNames, limit)
+}
+
+func (traced *tracedEventStore) ListAfter(ctx context.Context, githubId int64, id primitive.ObjectID, limit int) (events []models.StoredEvent, err error) {
+	ctx, span := traced.tracer.start(ctx, "EventStore", "ListAfter", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.ListAfter(ctx, githubId, id, limit)
+}
+
+func (traced *tracedEventStore) DeleteByUser(ctx context.Context, githubId int64) (err error) {
+	ctx, span := traced.tracer.start(ctx, "EventStore", "DeleteByUser", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.DeleteByUser(ctx, githubId)
+}
+
+type tracedSubscriptionStore struct {
+	store  SubscriptionStore
+	tracer storeTracer
+}
+
+func (traced *tracedSubscriptionStore) Subscribe(ctx context.Context, githubId int64, repoName string, number int, reason models.SubscriptionReason) (err error) {
+	ctx, span := traced.tracer.start(ctx, "SubscriptionStore", "Subscribe", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Subscribe(ctx, githubId, repoName, number, reason)
+}
+
+func (traced *tracedSubscriptionStore) Create(ctx context.Context, subscription *models.Subscription) (err error) {
+	ctx, span := traced.tracer.start(ctx, "SubscriptionStore", "Create", userAttribute(subscription.GithubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Create(ctx, subscription)
+}
+
+func (traced *tracedSubscriptionStore) Get(ctx context.Context, githubId int64, repoName string, number int) (subscription *models.Subscription, err error) {
+	ctx, span := traced.tracer.start(ctx, "SubscriptionStore", "Get", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Get(ctx, githubId, repoName, number)
+}
+
+func (traced *tracedSubscriptionStore) Update(ctx context.Context, subscription *models.Subscription) (err error) {
+	ctx, span := traced.tracer.start(ctx, "SubscriptionStore", "Update", userAttribute(subscription.GithubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Update(ctx, subscription)
+}
+
+func (traced *tracedSubscriptionStore) Delete(ctx context.Context, subscription *models.Subscription) (err error) {
+	ctx, span := traced.tracer.start(ctx, "SubscriptionStore", "Delete", userAttribute(subscription.GithubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Delete(ctx, subscription)
+}
+
+func (traced *tracedSubscriptionStore) ListByUser(ctx context.Context, githubId int64) (subscriptions []models.Subscription, err error) {
+	ctx, span := traced.tracer.start(ctx, "SubscriptionStore", "ListByUser", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.ListByUser(ctx, githubId)
+}
+
+func (traced *tracedSubscriptionStore) ListByThread(ctx context.Context, repoName string, number int) (subscriptions []models.Subscription, err error) {
+	ctx, span := traced.tracer.start(ctx, "SubscriptionStore", "ListByThread")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.ListByThread(ctx, repoName, number)
+}
+
+func (traced *tracedSubscriptionStore) DeleteByUser(ctx context.Context, githubId int64) (err error) {
+	ctx, span := traced.tracer.start(ctx, "SubscriptionStore", "DeleteByUser", userAttribute(githubId))
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.DeleteByUser(ctx, githubId)
+}
+
+type tracedAPIKeyStore struct {
+	store  APIKeyStore
+	tracer storeTracer
+}
+
+func (traced *tracedAPIKeyStore) Create(ctx context.Context, key *models.APIKey) (err error) {
+	ctx, span := traced.tracer.start(ctx, "APIKeyStore", "Create")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Create(ctx, key)
+}
+
+func (traced *tracedAPIKeyStore) Get(ctx context.Context, keyId string) (key *models.APIKey, err error) {
+	ctx, span := traced.tracer.start(ctx, "APIKeyStore", "Get")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Get(ctx, keyId)
+}
+
+func (traced *tracedAPIKeyStore) List(ctx context.Context) (keys []models.APIKey, err error) {
+	ctx, span := traced.tracer.start(ctx, "APIKeyStore", "List")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.List(ctx)
+}
+
+func (traced *tracedAPIKeyStore) Delete(ctx context.Context, keyId string) (err error) {
+	ctx, span := traced.tracer.start(ctx, "APIKeyStore", "Delete")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Delete(ctx, keyId)
+}
+
+type tracedAuditStore struct {
+	store  AuditStore
+	tracer storeTracer
+}
+
+func (traced *tracedAuditStore) Create(ctx context.Context, entry *models.AuditEntry) (err error) {
+	ctx, span := traced.tracer.start(ctx, "AuditStore", "Create")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.Create(ctx, entry)
+}
+
+func (traced *tracedAuditStore) List(ctx context.Context, before primitive.ObjectID, limit int) (entries []models.AuditEntry, err error) {
+	ctx, span := traced.tracer.start(ctx, "AuditStore", "List")
+	defer func() { endStoreSpan(span, err) }()
+
+	return traced.store.List(ctx, before, limit)
+}
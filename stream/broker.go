@@ -0,0 +1,88 @@
+package stream
+
+import (
+	"push-request/models"
+	"sync"
+)
+
+// The number of events buffered for a subscriber before new events are dropped for it
+const subscriberBuffer = 16
+
+// A Broker fans out each newly stored event to the clients of its user that are currently connected
+type Broker interface {
+	// Publishes an event that was just stored for a user
+	Publish(storedEvent models.StoredEvent)
+
+	// Subscribes to the events of a user. The returned function must be called to unsubscribe
+	Subscribe(githubId int64) (<-chan models.StoredEvent, func())
+
+	// Counts the events waiting to be written to connected clients. It grows when clients are written to more slowly
+	// than events arrive
+	Pending() int
+}
+
+// A MemoryBroker delivers events to the subscribers of a single process
+type MemoryBroker struct {
+	mutex       sync.Mutex
+	subscribers map[int64]map[chan models.StoredEvent]struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subscribers: map[int64]map[chan models.StoredEvent]struct{}{},
+	}
+}
+
+func (broker *MemoryBroker) Publish(storedEvent models.StoredEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	for subscriber := range broker.subscribers[storedEvent.GithubId] {
+		select {
+		case subscriber <- storedEvent:
+		default:
+			// A slow client must not block delivery; it can catch up by reconnecting with `Last-Event-ID`
+		}
+	}
+}
+
+func (broker *MemoryBroker) Subscribe(githubId int64) (<-chan models.StoredEvent, func()) {
+	subscriber := make(chan models.StoredEvent, subscriberBuffer)
+
+	broker.mutex.Lock()
+	if broker.subscribers[githubId] == nil {
+		broker.subscribers[githubId] = map[chan models.StoredEvent]struct{}{}
+	}
+	broker.subscribers[githubId][subscriber] = struct{}{}
+	broker.mutex.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			broker.mutex.Lock()
+			defer broker.mutex.Unlock()
+
+			delete(broker.subscribers[githubId], subscriber)
+			if len(broker.subscribers[githubId]) == 0 {
+				delete(broker.subscribers, githubId)
+			}
+		})
+	}
+
+	return subscriber, unsubscribe
+}
+
+func (broker *MemoryBroker) Pending() int {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	pending := 0
+
+	for _, subscribers := range broker.subscribers {
+		for subscriber := range subscribers {
+			pending += len(subscriber)
+		}
+	}
+
+	return pending
+}
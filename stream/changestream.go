@@ -0,0 +1,83 @@
+package stream
+
+import (
+	"context"
+	"github.com/Kamva/mgm"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"push-request/logging"
+	"push-request/models"
+	"time"
+)
+
+// A ChangeStreamBroker watches the stored events collection, so that an event stored by any replica is
+// delivered to the clients connected to every replica. Change streams require MongoDB to run as a replica set
+type ChangeStreamBroker struct {
+	local *MemoryBroker
+}
+
+// Creates a ChangeStreamBroker, which delivers events once Run is watching the stored events collection
+func NewChangeStreamBroker() *ChangeStreamBroker {
+	return &ChangeStreamBroker{local: NewMemoryBroker()}
+}
+
+// Publish is a no-op, since the event is picked up from the change stream once it is inserted
+func (broker *ChangeStreamBroker) Publish(models.StoredEvent) {}
+
+func (broker *ChangeStreamBroker) Subscribe(githubId int64) (<-chan models.StoredEvent, func()) {
+	return broker.local.Subscribe(githubId)
+}
+
+func (broker *ChangeStreamBroker) Pending() int {
+	return broker.local.Pending()
+}
+
+// Watches the stored events collection until ctx is cancelled, resuming after errors
+func (broker *ChangeStreamBroker) Run(ctx context.Context) {
+	var resumeToken bson.Raw
+
+	for ctx.Err() == nil {
+		var err error
+		if resumeToken, err = broker.watch(ctx, resumeToken); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Warn("change stream error", "error", err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func (broker *ChangeStreamBroker) watch(ctx context.Context, resumeToken bson.Raw) (bson.Raw, error) {
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+
+	opts := options.ChangeStream()
+	if resumeToken != nil {
+		opts.SetResumeAfter(resumeToken)
+	}
+
+	changeStream, err := mgm.Coll(&models.StoredEvent{}).Watch(ctx, pipeline, opts)
+	if err != nil {
+		return resumeToken, err
+	}
+
+	defer changeStream.Close(context.Background())
+
+	for changeStream.Next(ctx) {
+		var change struct {
+			FullDocument models.StoredEvent `bson:"fullDocument"`
+		}
+
+		if err = changeStream.Decode(&change); err != nil {
+			logging.FromContext(ctx).Warn("change stream error", "error", err)
+			continue
+		}
+
+		resumeToken = changeStream.ResumeToken()
+		broker.local.Publish(change.FullDocument)
+	}
+
+	return resumeToken, changeStream.Err()
+}
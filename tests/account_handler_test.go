@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"push-request/models"
+	"push-request/storage"
+	"testing"
+)
+
+func testDeleteUser(t *testing.T) {
+	server := newTestServer()
+
+	github := newFakeGithubUser(server, 1234)
+	defer github.close()
+
+	createUser(t, server, 1234, "a", []models.EventType{models.IssueOpened})
+	createInstallation(t, server, 2, 1234)
+	_, _ = server.Stores.Events.Create(context.Background(), 1234, &models.Event{Title: "event"})
+
+	req, _ := http.NewRequest("DELETE", "/users", nil)
+	req.Header.Add("Authorization", "1234")
+	req.Header.Add("X-Github-Token", "token")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	_, err := server.Stores.Users.Get(context.Background(), 1234)
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	installation, err := server.Stores.Installations.Get(context.Background(), 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), installation.GithubId)
+	}
+
+	events, _ := server.Stores.Events.List(context.Background(), 1234, nil, 10)
+	assert.Empty(t, events)
+
+	rr = httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Registering again links the installations on the User's account to them again
+	rr = postUser(server, map[string]interface{}{"github_id": 1234, "device_tokens": []string{"a"}})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	installation, err = server.Stores.Installations.Get(context.Background(), 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1234), installation.GithubId)
+	}
+}
+
+func testExportUser(t *testing.T) {
+	server := newTestServer()
+
+	github := newFakeGithubUser(server, 1234)
+	defer github.close()
+
+	createUser(t, server, 1234, "a", []models.EventType{models.IssueOpened})
+	createInstallation(t, server, 2, 1234)
+	_, _ = server.Stores.Events.Create(context.Background(), 1234, &models.Event{Title: "event"})
+	_ = server.Stores.Subscriptions.Subscribe(context.Background(), 1234, "Codertocat/Hello-World", 1, models.ReasonAuthor)
+
+	req, _ := http.NewRequest("GET", "/users/export", nil)
+	req.Header.Add("Authorization", "1234")
+	req.Header.Add("X-Github-Token", "token")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+
+	var export storage.UserExport
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&export))
+
+	assert.Equal(t, int64(1234), export.User.GithubId)
+	assert.NotEmpty(t, export.User.FeedToken)
+	assert.Equal(t, "a", export.Devices[0].Token)
+	assert.Equal(t, int64(2), export.Installations[0].Id)
+	assert.Equal(t, "Codertocat/Hello-World", export.Subscriptions[0].RepoName)
+	assert.Equal(t, "event", export.Events[0].Event.Title)
+
+	req.Header.Set("Authorization", "5678")
+
+	rr = httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func testAuthorizationRevoked(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueOpened})
+	createInstallation(t, server, 2, 1)
+
+	data, _ := ioutil.ReadFile("./fixtures/github_app_authorization.json")
+
+	// Without a webhook secret revocations can't be trusted, so they are acknowledged but ignored
+	rr := postWebhook(server, "github_app_authorization", data)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	_, err := server.Stores.Users.Get(context.Background(), 1)
+	assert.NoError(t, err)
+
+	server.WebhookSecret = []byte("secret")
+
+	rr = postWebhook(server, "github_app_authorization", data)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	_, err = server.Stores.Users.Get(context.Background(), 1)
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	installation, err := server.Stores.Installations.Get(context.Background(), 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), installation.GithubId)
+	}
+
+	// Revocations by users who aren't registered are acknowledged
+	rr = postWebhook(server, "github_app_authorization", data)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+// The github id in the Authorization header doesn't prove who the caller is, so deleting or exporting a User also
+// requires a GitHub token of theirs
+func testAccountRequiresGithubToken(t *testing.T) {
+	server := newTestServer()
+
+	github := newFakeGithubUser(server, 5678)
+	defer github.close()
+
+	createUser(t, server, 1234, "a", []models.EventType{models.IssueOpened})
+
+	for _, route := range []struct{ method, path string }{{"DELETE", "/users"}, {"GET", "/users/export"}} {
+		header := http.Header{"Authorization": {"1234"}}
+
+		rr := serveRoutes(server, route.method, route.path, nil, header)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, route.path)
+
+		header.Set("X-Github-Token", "invalid")
+		rr = serveRoutes(server, route.method, route.path, nil, header)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, route.path)
+
+		header.Set("X-Github-Token", "token")
+		rr = serveRoutes(server, route.method, route.path, nil, header)
+		assert.Equal(t, http.StatusForbidden, rr.Code, route.path)
+	}
+
+	_, err := server.Stores.Users.Get(context.Background(), 1234)
+	assert.NoError(t, err)
+}
+
+func TestAccountHandler(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-github-token-required": testAccountRequiresGithubToken,
+		"test-DELETE-user":           testDeleteUser,
+		"test-GET-export":            testExportUser,
+		"test-authorization-revoked": testAuthorizationRevoked,
+	}
+
+	for testName, test := range testMap {
+		t.Run(testName, test)
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"push-request/actions"
+	"push-request/handlers"
+	"push-request/models"
+	"testing"
+)
+
+func postAction(server *handlers.Server, request actions.Request, githubToken string) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(request)
+
+	req, _ := http.NewRequest("POST", "/actions", bytes.NewReader(encoded))
+	req.Header.Add("Authorization", "1")
+	if githubToken != "" {
+		req.Header.Add("X-Github-Token", githubToken)
+	}
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func testPostAction200(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1, "a", []models.EventType{models.PrOpened})
+
+	github := newFakeGithub()
+	defer github.close()
+	github.respond("POST", "/repos/Codertocat/Hello-World/pulls/2/reviews", http.StatusOK, map[string]interface{}{"id": 1})
+	server.GithubBaseURL = github.baseURL()
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	rr := postAction(server, actions.Request{Action: actions.Approve, RepoName: "Codertocat/Hello-World", Number: 2}, "secret")
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"result":"Approved #2"}`, rr.Body.String())
+	assert.Equal(t, "token secret", github.received()[0].Authorization)
+
+	notifications := apns.received()
+	assert.Len(t, notifications, 1)
+	assert.Equal(t, "a", notifications[0].DeviceToken)
+
+	alert := notifications[0].Payload["aps"].(map[string]interface{})["alert"].(map[string]interface{})
+	assert.Equal(t, "Approved #2", alert["body"])
+}
+
+func testPostAction502(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1, "a", []models.EventType{models.PrOpened})
+
+	github := newFakeGithub()
+	defer github.close()
+	server.GithubBaseURL = github.baseURL()
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	rr := postAction(server, actions.Request{Action: actions.Close, RepoName: "Codertocat/Hello-World", Number: 2}, "secret")
+
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+
+	notifications := apns.received()
+	assert.Len(t, notifications, 1)
+
+	alert := notifications[0].Payload["aps"].(map[string]interface{})["alert"].(map[string]interface{})
+	assert.Contains(t, alert["body"], "failed to close #2")
+}
+
+func testPostAction400(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1, "a", []models.EventType{models.PrOpened})
+
+	rr := postAction(server, actions.Request{Action: actions.Reply, RepoName: "Codertocat/Hello-World", Number: 2}, "secret")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Actions are never performed as the GitHub App, even for Users who installed it
+	createInstallation(t, server, 2, 1)
+
+	rr = postAction(server, actions.Request{Action: actions.Approve, RepoName: "Codertocat/Hello-World", Number: 2}, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestActionHandler(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-POST-action":             testPostAction200,
+		"test-POST-action-failed":      testPostAction502,
+		"test-POST-action-bad-request": testPostAction400,
+	}
+
+	for testName, test := range testMap {
+		t.Run(testName, test)
+	}
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"push-request/actions"
+	"push-request/models"
+	"testing"
+)
+
+func TestActionCategories(t *testing.T) {
+	assert.Equal(t, actions.PullRequestCategory, actions.Category(models.PrOpened))
+	assert.Equal(t, actions.PullRequestCategory, actions.Category(models.PrReviewRequested))
+	assert.Equal(t, actions.ReviewedPullRequestCategory, actions.Category(models.PrReviewed))
+	assert.Equal(t, actions.IssueCategory, actions.Category(models.IssueAssigned))
+	assert.Equal(t, actions.ClosedThreadCategory, actions.Category(models.PrMerged))
+	assert.Equal(t, actions.ClosedThreadCategory, actions.Category(models.IssueClosed))
+	assert.Equal(t, actions.ThreadCategory, actions.Category(models.Mentioned))
+}
+
+func TestActionValidation(t *testing.T) {
+	valid := actions.Request{Action: actions.Approve, RepoName: "Codertocat/Hello-World", Number: 2}
+	assert.NoError(t, valid.Validate())
+
+	invalid := map[string]actions.Request{
+		"unknown action": {Action: "delete", RepoName: "Codertocat/Hello-World", Number: 2},
+		"empty reply":    {Action: actions.Reply, RepoName: "Codertocat/Hello-World", Number: 2, Body: " "},
+		"invalid repo":   {Action: actions.Close, RepoName: "Hello-World", Number: 2},
+		"invalid number": {Action: actions.Close, RepoName: "Codertocat/Hello-World"},
+	}
+
+	for name, request := range invalid {
+		assert.Error(t, request.Validate(), name)
+	}
+}
+
+func TestPerformActions(t *testing.T) {
+	github := newFakeGithub()
+	defer github.close()
+
+	github.respond("POST", "/repos/Codertocat/Hello-World/pulls/2/reviews", http.StatusOK, map[string]interface{}{"id": 1})
+	github.respond("PUT", "/repos/Codertocat/Hello-World/pulls/2/merge", http.StatusOK, map[string]interface{}{"merged": true})
+	github.respond("PATCH", "/repos/Codertocat/Hello-World/issues/1", http.StatusOK, map[string]interface{}{"number": 1})
+	github.respond("POST", "/repos/Codertocat/Hello-World/issues/1/comments", http.StatusCreated, map[string]interface{}{"id": 1})
+
+	client, err := actions.NewClient("secret", github.baseURL())
+	assert.NoError(t, err)
+
+	requests := []actions.Request{
+		{Action: actions.Approve, RepoName: "Codertocat/Hello-World", Number: 2},
+		{Action: actions.Merge, RepoName: "Codertocat/Hello-World", Number: 2},
+		{Action: actions.Close, RepoName: "Codertocat/Hello-World", Number: 1},
+		{Action: actions.Reply, RepoName: "Codertocat/Hello-World", Number: 1, Body: "On it"},
+	}
+	results := []string{"Approved #2", "Merged #2", "Closed #1", "Commented on #1"}
+
+	for i := range requests {
+		result, err := actions.Perform(context.Background(), client, &requests[i])
+		assert.NoError(t, err)
+		assert.Equal(t, results[i], result)
+	}
+
+	received := github.received()
+	assert.Len(t, received, 4)
+	assert.Equal(t, "token secret", received[0].Authorization)
+	assert.Equal(t, "APPROVE", received[0].Body["event"])
+	assert.Equal(t, "closed", received[2].Body["state"])
+	assert.Equal(t, "On it", received[3].Body["body"])
+}
+
+func TestPerformActionFailure(t *testing.T) {
+	github := newFakeGithub()
+	defer github.close()
+
+	github.respond("PUT", "/repos/Codertocat/Hello-World/pulls/2/merge", http.StatusMethodNotAllowed, map[string]interface{}{
+		"message": "Pull Request is not mergeable",
+	})
+
+	client, _ := actions.NewClient("secret", github.baseURL())
+
+	_, err := actions.Perform(context.Background(), client, &actions.Request{
+		Action: actions.Merge, RepoName: "Codertocat/Hello-World", Number: 2,
+	})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Pull Request is not mergeable")
+}
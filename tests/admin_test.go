@@ -0,0 +1,437 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"push-request/handlers"
+	"push-request/metrics"
+	"push-request/models"
+	"push-request/storage"
+	"testing"
+	"time"
+)
+
+// Creates an API key with the role and returns it
+func createAPIKey(t *testing.T, server *handlers.Server, role models.Role) string {
+	apiKey, key, err := models.NewAPIKey(string(role)+" key", role)
+	if err == nil {
+		err = server.Stores.APIKeys.Create(context.Background(), apiKey)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func disableUser(t *testing.T, server *handlers.Server, githubId int64) {
+	_, err := storage.UpdateUser(context.Background(), server.Stores.Users, githubId, func(user *models.User) error {
+		user.Disabled = true
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func bearer(key string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + key}}
+}
+
+func listAuditLog(t *testing.T, server *handlers.Server) []models.AuditEntry {
+	entries, err := server.Stores.Audit.List(context.Background(), [12]byte{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entries
+}
+
+func testAdminAuthentication(t *testing.T) {
+	server := newTestServer()
+	key := createAPIKey(t, server, models.RoleReadOnly)
+
+	testCases := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{name: "missing", header: nil, status: http.StatusUnauthorized},
+		{name: "not bearer", header: http.Header{"Authorization": {key}}, status: http.StatusUnauthorized},
+		{name: "github id", header: http.Header{"Authorization": {"1"}}, status: http.StatusUnauthorized},
+		{name: "malformed", header: bearer("secret"), status: http.StatusUnauthorized},
+		{name: "wrong secret", header: bearer(key[:len(key)-1] + "x"), status: http.StatusUnauthorized},
+		{name: "unknown", header: bearer("prk_0000000000000000_secret"), status: http.StatusUnauthorized},
+		{name: "valid", header: bearer(key), status: http.StatusOK},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rr := serveRoutes(server, "GET", "/admin/users", nil, testCase.header)
+			assert.Equal(t, testCase.status, rr.Code, rr.Body.String())
+		})
+	}
+
+	// Requests that aren't authenticated aren't audited, since there is no one to attribute them to
+	assert.Len(t, listAuditLog(t, server), 1)
+
+	// Revoked keys can't be used anymore
+	keyId, _ := models.ParseAPIKeyId(key)
+	assert.NoError(t, server.Stores.APIKeys.Delete(context.Background(), keyId))
+
+	rr := serveRoutes(server, "GET", "/admin/users", nil, bearer(key))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func testAdminRoles(t *testing.T) {
+	server := newTestServer()
+	createUser(t, server, 1, "a", nil)
+
+	readOnly := createAPIKey(t, server, models.RoleReadOnly)
+	operator := createAPIKey(t, server, models.RoleOperator)
+
+	rr := serveRoutes(server, "POST", "/admin/users/1/disable", nil, bearer(readOnly))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	user, _ := server.Stores.Users.Get(context.Background(), 1)
+	assert.False(t, user.Disabled)
+
+	// Operators may do everything read-only keys may
+	rr = serveRoutes(server, "GET", "/admin/users/1", nil, bearer(operator))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveRoutes(server, "POST", "/admin/users/1/disable", nil, bearer(operator))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	user, _ = server.Stores.Users.Get(context.Background(), 1)
+	assert.True(t, user.Disabled)
+
+	// Keys with a role that isn't known anymore may do nothing
+	apiKey, key, _ := models.NewAPIKey("legacy", "admin")
+	_ = server.Stores.APIKeys.Create(context.Background(), apiKey)
+
+	rr = serveRoutes(server, "GET", "/admin/users", nil, bearer(key))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// Denied requests are audited too
+	entries := listAuditLog(t, server)
+	if assert.Len(t, entries, 4) {
+		assert.Equal(t, "users.list", entries[0].Action)
+		assert.Equal(t, http.StatusForbidden, entries[0].Status)
+		assert.Equal(t, "users.disable", entries[3].Action)
+		assert.Equal(t, models.RoleReadOnly, entries[3].Role)
+		assert.Equal(t, http.StatusForbidden, entries[3].Status)
+	}
+}
+
+func testAdminListUsers(t *testing.T) {
+	server := newTestServer()
+	key := createAPIKey(t, server, models.RoleReadOnly)
+
+	for githubId := int64(1); githubId <= 5; githubId++ {
+		createUser(t, server, githubId, string(rune('a'+githubId-1)), nil)
+	}
+
+	disableUser(t, server, 4)
+
+	list := func(query string) []int64 {
+		rr := serveRoutes(server, "GET", "/admin/users"+query, nil, bearer(key))
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var users []map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &users))
+
+		githubIds := []int64{}
+		for _, user := range users {
+			assert.NotContains(t, user, "feed_token")
+			githubIds = append(githubIds, int64(user["github_id"].(float64)))
+		}
+
+		return githubIds
+	}
+
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, list(""))
+	assert.Equal(t, []int64{1, 2}, list("?limit=2"))
+	assert.Equal(t, []int64{3, 4}, list("?after=2&limit=2"))
+	assert.Equal(t, []int64{3}, list("?device_token=c"))
+	assert.Equal(t, []int64{}, list("?device_token=z"))
+	assert.Equal(t, []int64{4}, list("?disabled=true"))
+	assert.Equal(t, []int64{1, 2, 3, 5}, list("?disabled=false"))
+
+	rr := serveRoutes(server, "GET", "/admin/users?limit=0", nil, bearer(key))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	for _, field := range []string{"after", "disabled"} {
+		rr = serveRoutes(server, "GET", "/admin/users?"+field+"=abc", nil, bearer(key))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, field)
+
+		if details := decodeErrorResponse(t, rr).Details; assert.Len(t, details, 1, field) {
+			assert.Equal(t, field, details[0].Field)
+		}
+	}
+}
+
+func testAdminGetUser(t *testing.T) {
+	server := newTestServer()
+	key := createAPIKey(t, server, models.RoleReadOnly)
+
+	createUser(t, server, 1, "a", nil)
+	createInstallation(t, server, 2, 1)
+	_, _ = server.Stores.Events.Create(context.Background(), 1, &models.Event{Title: "first", Timestamp: time.Unix(1, 0)})
+	_, _ = server.Stores.Events.Create(context.Background(), 1, &models.Event{Title: "second", Timestamp: time.Unix(2, 0)})
+
+	rr := serveRoutes(server, "GET", "/admin/users/1", nil, bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var user map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &user))
+	assert.NotContains(t, user, "feed_token")
+	assert.Equal(t, "a", user["devices"].([]interface{})[0].(map[string]interface{})["token"])
+	assert.Equal(t, 2.0, user["installations"].([]interface{})[0].(map[string]interface{})["installation_id"])
+
+	rr = serveRoutes(server, "GET", "/admin/users/1/devices", nil, bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var devices []models.Device
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &devices))
+	if assert.Len(t, devices, 1) {
+		assert.Equal(t, "a", devices[0].Token)
+	}
+
+	rr = serveRoutes(server, "GET", "/admin/users/1/events?limit=1", nil, bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var events []models.StoredEvent
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &events))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "second", events[0].Event.Title)
+	}
+
+	for _, path := range []string{"/admin/users/9", "/admin/users/9/devices", "/admin/users/9/events"} {
+		rr = serveRoutes(server, "GET", path, nil, bearer(key))
+		assert.Equal(t, http.StatusNotFound, rr.Code, path)
+	}
+}
+
+func testAdminTestPush(t *testing.T) {
+	server := newTestServer()
+	key := createAPIKey(t, server, models.RoleOperator)
+
+	createUser(t, server, 1, "a", nil)
+	_, _ = server.Stores.Users.Register(context.Background(), 1, models.Device{Token: "b"}, nil, 0)
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	rr := serveRoutes(server, "POST", "/admin/users/1/test-push", nil, bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var results []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	assert.Len(t, results, 2)
+
+	received := apns.received()
+	if assert.Len(t, received, 2) {
+		alert := received[0].Payload["aps"].(map[string]interface{})["alert"].(map[string]interface{})
+		assert.Equal(t, "This is a test notification from Push Request", alert["body"])
+	}
+
+	rr = serveRoutes(server, "POST", "/admin/users/1/test-push",
+		[]byte(`{"device_token": "b", "message": "Hello"}`), bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	received = apns.received()
+	if assert.Len(t, received, 3) {
+		assert.Equal(t, "b", received[2].DeviceToken)
+		assert.Equal(t, "Hello", received[2].Payload["aps"].(map[string]interface{})["alert"].(map[string]interface{})["body"])
+	}
+
+	// Rejected pushes are reported rather than failing the request
+	apns.reject("BadDeviceToken")
+
+	rr = serveRoutes(server, "POST", "/admin/users/1/test-push", []byte(`{"device_token": "a"}`), bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	results = nil
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, false, results[0]["sent"])
+		assert.Contains(t, results[0]["error"], "BadDeviceToken")
+	}
+
+	rr = serveRoutes(server, "POST", "/admin/users/1/test-push", []byte(`{"device_token": "c"}`), bearer(key))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func testAdminDisableUser(t *testing.T) {
+	server := newTestServer()
+	key := createAPIKey(t, server, models.RoleOperator)
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+	createInstallation(t, server, 2, 1)
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	rr := serveRoutes(server, "POST", "/admin/users/1/disable", nil, bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"disabled":true`)
+
+	// Disabled users can't use the API
+	rr = serveRoutes(server, "GET", "/users", nil, http.Header{"Authorization": {"1"}})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = serveRoutes(server, "POST", "/users", []byte(`{"github_id": 1, "device_tokens": ["b"]}`), nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	user, _ := server.Stores.Users.Get(context.Background(), 1)
+	assert.Equal(t, []string{"a"}, user.DeviceTokens)
+
+	// Nor are they sent notifications
+	rr = serveRoutes(server, "POST", "/webhook", []byte(fixture(t, "issue.json")),
+		http.Header{"X-Github-Event": {"issues"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, apns.received())
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.EventsFiltered.WithLabelValues(metrics.FilterDisabled)))
+
+	rr = serveRoutes(server, "POST", "/admin/users/1/enable", nil, bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), `"disabled"`)
+
+	rr = serveRoutes(server, "GET", "/users", nil, http.Header{"Authorization": {"1"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveRoutes(server, "POST", "/admin/users/9/disable", nil, bearer(key))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func testAdminInstallations(t *testing.T) {
+	server := newTestServer()
+	key := createAPIKey(t, server, models.RoleOperator)
+
+	createInstallation(t, server, 2, 1)
+	createInstallation(t, server, 3, 1)
+	createInstallation(t, server, 4, 5)
+
+	list := func(query string) []int64 {
+		rr := serveRoutes(server, "GET", "/admin/installations"+query, nil, bearer(key))
+		assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var installations []models.Installation
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &installations))
+
+		ids := []int64{}
+		for _, installation := range installations {
+			ids = append(ids, installation.Id)
+		}
+
+		return ids
+	}
+
+	assert.Equal(t, []int64{2, 3, 4}, list(""))
+	assert.Equal(t, []int64{3}, list("?after=2&limit=1"))
+	assert.Equal(t, []int64{4}, list("?github_id=5"))
+
+	for _, field := range []string{"after", "github_id"} {
+		rr := serveRoutes(server, "GET", "/admin/installations?"+field+"=abc", nil, bearer(key))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, field)
+
+		if details := decodeErrorResponse(t, rr).Details; assert.Len(t, details, 1, field) {
+			assert.Equal(t, field, details[0].Field)
+		}
+	}
+
+	rr := serveRoutes(server, "GET", "/admin/installations/3", nil, bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveRoutes(server, "PATCH", "/admin/installations/3", []byte(`{"github_id": 5}`), bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var installation models.Installation
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &installation))
+	assert.Equal(t, int64(5), installation.GithubId)
+
+	assert.Equal(t, []int64{3, 4}, list("?github_id=5"))
+
+	rr = serveRoutes(server, "PATCH", "/admin/installations/3", []byte(`{"github_id": 0}`), bearer(key))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	for _, method := range []string{"GET", "PATCH"} {
+		rr = serveRoutes(server, method, "/admin/installations/9", []byte(`{"github_id": 5}`), bearer(key))
+		assert.Equal(t, http.StatusNotFound, rr.Code, method)
+	}
+}
+
+func testAdminAuditLog(t *testing.T) {
+	server := newTestServer()
+	key := createAPIKey(t, server, models.RoleOperator)
+	keyId, _ := models.ParseAPIKeyId(key)
+
+	createInstallation(t, server, 2, 1)
+
+	rr := serveRoutes(server, "PATCH", "/admin/installations/2", []byte(`{"github_id": 5}`), bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveRoutes(server, "GET", "/admin/users/9", nil, bearer(key))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = serveRoutes(server, "GET", "/admin/audit-log", nil, bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var entries []models.AuditEntry
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entries))
+
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "users.get", entries[0].Action)
+		assert.Equal(t, "user:9", entries[0].Target)
+		assert.Equal(t, http.StatusNotFound, entries[0].Status)
+		assert.Empty(t, entries[0].Body)
+
+		assert.Equal(t, keyId, entries[1].KeyId)
+		assert.Equal(t, "operator key", entries[1].KeyName)
+		assert.Equal(t, models.RoleOperator, entries[1].Role)
+		assert.Equal(t, "installations.relink", entries[1].Action)
+		assert.Equal(t, "installation:2", entries[1].Target)
+		assert.Equal(t, `{"github_id": 5}`, entries[1].Body)
+		assert.Equal(t, http.StatusOK, entries[1].Status)
+		assert.NotEmpty(t, entries[1].RequestId)
+	}
+
+	// The listing itself was audited since, and the next page starts before the last entry of the first
+	rr = serveRoutes(server, "GET", "/admin/audit-log?limit=1&before="+entries[0].ID.Hex(), nil, bearer(key))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	entries = nil
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entries))
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "installations.relink", entries[0].Action)
+	}
+
+	// Keys are never shown, not even hashed
+	assert.NotContains(t, rr.Body.String(), key)
+	assert.NotContains(t, rr.Body.String(), "hash")
+}
+
+func TestAdmin(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-admin-authentication": testAdminAuthentication,
+		"test-admin-roles":          testAdminRoles,
+		"test-admin-list-users":     testAdminListUsers,
+		"test-admin-get-user":       testAdminGetUser,
+		"test-admin-test-push":      testAdminTestPush,
+		"test-admin-disable-user":   testAdminDisableUser,
+		"test-admin-installations":  testAdminInstallations,
+		"test-admin-audit-log":      testAdminAuditLog,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/models"
+	"testing"
+)
+
+func deviceRequest(server *handlers.Server, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var encoded []byte
+	if body != nil {
+		encoded, _ = json.Marshal(body)
+	}
+
+	req, _ := http.NewRequest(method, path, bytes.NewReader(encoded))
+	req.Header.Add("Authorization", "1")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func testDeviceLifecycle(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueOpened})
+	_, _ = server.Stores.Users.Register(context.Background(), 1, models.Device{Token: "b", Platform: models.PlatformIPadOS}, nil, 0)
+
+	rr := deviceRequest(server, "PATCH", "/users/devices", map[string]string{"token": "a", "name": "iPhone"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = deviceRequest(server, "PATCH", "/users/devices", map[string]interface{}{"token": "b", "allowed_types": []models.EventType{models.PrMerged}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = deviceRequest(server, "GET", "/users/devices", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var devices []models.Device
+	_ = json.NewDecoder(rr.Body).Decode(&devices)
+	assert.Len(t, devices, 2)
+	assert.Equal(t, models.Device{Token: "a", Name: "iPhone"}, devices[0])
+	assert.Equal(t, models.PlatformIPadOS, devices[1].Platform)
+	assert.NotNil(t, devices[1].LastSeenAt)
+	assert.Equal(t, []models.EventType{models.PrMerged}, devices[1].AllowedTypes)
+
+	// The name is kept when only the allowed types change, and null removes the override
+	rr = deviceRequest(server, "PATCH", "/users/devices", map[string]interface{}{"token": "a", "allowed_types": nil})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = deviceRequest(server, "PATCH", "/users/devices", map[string]interface{}{"token": "b", "allowed_types": nil})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	user, _ := server.Stores.Users.Get(context.Background(), 1)
+	assert.Equal(t, "iPhone", user.Devices["a"].Name)
+	assert.Nil(t, user.Devices["b"].AllowedTypes)
+
+	rr = deviceRequest(server, "DELETE", "/users/devices?token=a", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	user, _ = server.Stores.Users.Get(context.Background(), 1)
+	assert.Equal(t, []string{"b"}, user.DeviceTokens)
+
+	rr = deviceRequest(server, "DELETE", "/users/devices?token=a", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = deviceRequest(server, "PATCH", "/users/devices", map[string]string{"token": "a", "name": "iPhone"})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func testDeviceBadRequest(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueOpened})
+
+	rr := deviceRequest(server, "PATCH", "/users/devices", map[string]string{"name": "iPhone"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = deviceRequest(server, "PATCH", "/users/devices", map[string]string{"token": "not-hex", "name": "iPhone"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = deviceRequest(server, "DELETE", "/users/devices", nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestDeviceHandler(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-device-lifecycle":   testDeviceLifecycle,
+		"test-device-bad-request": testDeviceBadRequest,
+	}
+
+	for testName, test := range testMap {
+		t.Run(testName, test)
+	}
+}
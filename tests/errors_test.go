@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/models"
+	"push-request/storage"
+	"testing"
+)
+
+func decodeErrorResponse(t *testing.T, rr *httptest.ResponseRecorder) handlers.ErrorBody {
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var response handlers.ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, rr.Header().Get("X-Request-Id"), response.Error.RequestId)
+	assert.NotEmpty(t, response.Error.RequestId)
+
+	return response.Error
+}
+
+func testValidationDetails(t *testing.T) {
+	server := newTestServer()
+
+	rr := postUser(server, map[string]interface{}{
+		"github_id":     1234,
+		"devices":       []map[string]string{{"token": "a", "platform": "android"}},
+		"allowed_types": []string{"prOpened", "prExploded"},
+	})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	body := decodeErrorResponse(t, rr)
+	assert.Equal(t, handlers.CodeValidationFailed, body.Code)
+	assert.Equal(t, []handlers.FieldError{
+		{Field: "allowed_types[1]", Message: `unknown event type "prExploded"`},
+		{Field: "devices[0].platform", Message: `unknown platform "android"`},
+	}, body.Details)
+}
+
+func testTypeMismatch(t *testing.T) {
+	server := newTestServer()
+
+	rr := postUser(server, map[string]interface{}{"github_id": "1234", "device_tokens": []string{"a"}})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	body := decodeErrorResponse(t, rr)
+	assert.Equal(t, handlers.CodeValidationFailed, body.Code)
+	assert.Equal(t, []handlers.FieldError{{Field: "github_id", Message: "must be a number"}}, body.Details)
+}
+
+func testMalformedBody(t *testing.T) {
+	server := newTestServer()
+
+	req, _ := http.NewRequest("POST", "/users", bytes.NewBufferString("{"))
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, handlers.CodeInvalidRequest, decodeErrorResponse(t, rr).Code)
+}
+
+func testPatchUnknownType(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1234, "a", []models.EventType{models.PrMerged})
+
+	rr := patchUser(server, "", UserPatchData{AllowedTypes: []models.EventType{"prExploded"}})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "allowed_types[0]", decodeErrorResponse(t, rr).Details[0].Field)
+}
+
+func testRequestIdEchoed(t *testing.T) {
+	server := newTestServer()
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("Authorization", "1234")
+	req.Header.Set("X-Request-Id", "abc-123")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "abc-123", decodeErrorResponse(t, rr).RequestId)
+
+	req.Header.Set("X-Request-Id", "not\nan id")
+
+	rr = httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.NotEqual(t, "not\nan id", decodeErrorResponse(t, rr).RequestId)
+}
+
+func testUnauthorized(t *testing.T) {
+	server := newTestServer()
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, handlers.CodeUnauthorized, decodeErrorResponse(t, rr).Code)
+}
+
+func testInternalErrorNotLeaked(t *testing.T) {
+	stores := storage.NewMemoryStores()
+	stores.Users = &failingUserStore{stores.Users}
+	server := handlers.NewServer(stores)
+
+	for _, path := range []string{"/users", "/users/devices", "/users/subscriptions"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "1234")
+
+		rr := httptest.NewRecorder()
+		server.Routes().ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "mongo")
+		assert.Equal(t, handlers.CodeInternalError, decodeErrorResponse(t, rr).Code)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-validation-details":        testValidationDetails,
+		"test-type-mismatch":             testTypeMismatch,
+		"test-malformed-body":            testMalformedBody,
+		"test-PATCH-unknown-type":        testPatchUnknownType,
+		"test-request-id-echoed":         testRequestIdEchoed,
+		"test-unauthorized":              testUnauthorized,
+		"test-internal-error-not-leaked": testInternalErrorNotLeaked,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
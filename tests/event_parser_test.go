@@ -1,10 +1,12 @@
 package tests
 
 import (
+	"encoding/json"
 	"github.com/google/go-github/github"
 	"io/ioutil"
 	"push-request/models"
 	"push-request/parsers"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -77,3 +79,83 @@ func TestPRReviewParsing(t *testing.T) {
 		t.Errorf("\n got %v\nwant %v", got, want)
 	}
 }
+
+func TestIssueCommentParsing(t *testing.T) {
+	data, _ := ioutil.ReadFile("./fixtures/issue_comment.json")
+	event, _ := github.ParseWebHook("issue_comment", data)
+	date, _ := time.Parse(time.RFC3339, "2019-05-15T15:20:21Z")
+
+	got := *parsers.ParseRawEventPayload(event)
+	want := *models.NewEvent(
+		models.IssueCommented,
+		"Codertocat/Hello-World",
+		1,
+		"Spelling error in the README file",
+		"@Codertocat commented on #1",
+		"https://avatars1.githubusercontent.com/u/21031067?v=4",
+		date,
+		"https://github.com/Codertocat/Hello-World/issues/1#issuecomment-492700400",
+		2,
+	)
+
+	if got != want {
+		t.Errorf("\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestParticipationParsing(t *testing.T) {
+	data, _ := ioutil.ReadFile("./fixtures/issue_comment.json")
+	event, _ := github.ParseWebHook("issue_comment", data)
+
+	got := *parsers.ParseParticipation(event, data)
+	want := parsers.Participation{
+		RepoName:     "Codertocat/Hello-World",
+		Number:       1,
+		Participants: []parsers.Participant{{GithubId: 21031067, Reason: models.ReasonCommented}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got %v\nwant %v", got, want)
+	}
+
+	if sender := parsers.ParseSender(event); sender != 21031067 {
+		t.Errorf("\n got %v\nwant %v", sender, 21031067)
+	}
+
+	data, _ = ioutil.ReadFile("./fixtures/issue.json")
+	event, _ = github.ParseWebHook("issues", data)
+
+	got = *parsers.ParseParticipation(event, data)
+	want = parsers.Participation{
+		RepoName:     "Codertocat/Hello-World",
+		Number:       1,
+		Participants: []parsers.Participant{{GithubId: 21031067, Reason: models.ReasonAssigned}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got %v\nwant %v", got, want)
+	}
+
+	// A pull request assigned to a second user still carries its first assignee
+	var payload map[string]interface{}
+	data, _ = ioutil.ReadFile("./fixtures/pull_request.json")
+	_ = json.Unmarshal(data, &payload)
+
+	payload["action"] = "assigned"
+	payload["assignee"] = map[string]interface{}{"login": "octocat", "id": 583231}
+	payload["pull_request"].(map[string]interface{})["assignee"] = map[string]interface{}{"login": "Codertocat", "id": 21031067}
+
+	data, _ = json.Marshal(payload)
+	event, _ = github.ParseWebHook("pull_request", data)
+
+	got = *parsers.ParseParticipation(event, data)
+	want = parsers.Participation{
+		RepoName:     "Codertocat/Hello-World",
+		Number:       2,
+		Participants: []parsers.Participant{{GithubId: 583231, Reason: models.ReasonAssigned}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"push-request/models"
+	"testing"
+)
+
+type eventTypesResponse struct {
+	Language   string `json:"language"`
+	Categories []struct {
+		Id    string `json:"id"`
+		Label string `json:"label"`
+	} `json:"categories"`
+	EventTypes []struct {
+		Type               models.EventType `json:"type"`
+		Category           string           `json:"category"`
+		Label              string           `json:"label"`
+		Description        string           `json:"description"`
+		SampleNotification struct {
+			Title    string `json:"title"`
+			Subtitle string `json:"subtitle"`
+			Body     string `json:"body"`
+		} `json:"sample_notification"`
+		DefaultOn bool `json:"default_on"`
+	} `json:"event_types"`
+}
+
+func getEventTypes(t *testing.T, path string, acceptLanguage string) eventTypesResponse {
+	req, _ := http.NewRequest("GET", path, nil)
+	if acceptLanguage != "" {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+
+	rr := httptest.NewRecorder()
+	newTestServer().Routes().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response eventTypesResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, response.Language, rr.Header().Get("Content-Language"))
+	return response
+}
+
+func testCatalogComplete(t *testing.T) {
+	response := getEventTypes(t, "/event-types", "")
+	assert.Equal(t, "en", response.Language)
+
+	var types []models.EventType
+	categories := map[string]bool{}
+
+	for _, category := range response.Categories {
+		categories[category.Id] = true
+	}
+
+	for _, eventType := range response.EventTypes {
+		types = append(types, eventType.Type)
+
+		assert.True(t, categories[eventType.Category], eventType.Type)
+		assert.NotEmpty(t, eventType.Label, eventType.Type)
+		assert.NotEmpty(t, eventType.Description, eventType.Type)
+		assert.NotEmpty(t, eventType.SampleNotification.Title, eventType.Type)
+		assert.NotEmpty(t, eventType.SampleNotification.Body, eventType.Type)
+	}
+
+	assert.ElementsMatch(t, models.EventTypes, types)
+}
+
+func testCatalogTranslated(t *testing.T) {
+	for _, info := range models.EventTypeCatalog {
+		for _, language := range models.Languages {
+			assert.Contains(t, info.Label, language, info.Type)
+			assert.Contains(t, info.Description, language, info.Type)
+		}
+	}
+
+	for _, category := range models.EventCategories {
+		for _, language := range models.Languages {
+			assert.Contains(t, category.Label(), language, category)
+		}
+	}
+}
+
+func testCatalogLanguage(t *testing.T) {
+	languages := map[string]string{
+		"de-CH, en;q=0.8":          "de",
+		"ja, fr;q=0.9, de;q=0.5":   "fr",
+		"en;q=0.2, es-MX;q=0.7":    "es",
+		"ja, *;q=0.1":              "en",
+		"de;q=0, fr;q=invalid, es": "fr",
+	}
+
+	for acceptLanguage, language := range languages {
+		assert.Equal(t, language, getEventTypes(t, "/event-types", acceptLanguage).Language, acceptLanguage)
+	}
+
+	response := getEventTypes(t, "/event-types?lang=de_DE", "fr")
+	assert.Equal(t, "de", response.Language)
+	assert.Equal(t, "Erwähnungen", response.Categories[2].Label)
+}
+
+func testDefaultAllowedTypes(t *testing.T) {
+	server := newTestServer()
+
+	rr := postUser(server, map[string]interface{}{"github_id": 1, "device_tokens": []string{"a"}})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	user, _ := server.Stores.Users.Get(context.Background(), 1)
+	assert.Equal(t, models.DefaultEventTypes(), user.AllowedTypes)
+	assert.NotContains(t, user.AllowedTypes, models.PrClosed)
+
+	// Users who choose no types aren't given the defaults
+	rr = postUser(server, map[string]interface{}{"github_id": 2, "device_tokens": []string{"b"}, "allowed_types": []string{}})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	user, _ = server.Stores.Users.Get(context.Background(), 2)
+	assert.Empty(t, user.AllowedTypes)
+}
+
+func TestEventTypeHandler(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-GET-event-types-complete":   testCatalogComplete,
+		"test-GET-event-types-translated": testCatalogTranslated,
+		"test-GET-event-types-language":   testCatalogLanguage,
+		"test-POST-user-default-types":    testDefaultAllowedTypes,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sideshow/apns2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/models"
+	"push-request/storage"
+	"strings"
+	"sync"
+)
+
+// A fakeAPNS is a local stand-in for the APNs provider API that records every notification it receives
+type fakeAPNS struct {
+	server *httptest.Server
+
+	mutex         sync.Mutex
+	notifications []fakeNotification
+
+	// The reason notifications are rejected with, or empty to accept them
+	rejectReason string
+}
+
+type fakeNotification struct {
+	DeviceToken string
+	PushType    string
+	Priority    string
+	Payload     map[string]interface{}
+}
+
+func newFakeAPNS() *fakeAPNS {
+	fake := &fakeAPNS{}
+
+	fake.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var notification fakeNotification
+		notification.DeviceToken = strings.TrimPrefix(r.URL.Path, "/3/device/")
+		notification.PushType = r.Header.Get("apns-push-type")
+		notification.Priority = r.Header.Get("apns-priority")
+		_ = json.Unmarshal(body, &notification.Payload)
+
+		fake.mutex.Lock()
+		fake.notifications = append(fake.notifications, notification)
+		rejectReason := fake.rejectReason
+		fake.mutex.Unlock()
+
+		w.Header().Set("apns-id", "fake")
+
+		if rejectReason != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"reason": rejectReason})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return fake
+}
+
+func (fake *fakeAPNS) client() *apns2.Client {
+	return &apns2.Client{Host: fake.server.URL, HTTPClient: fake.server.Client()}
+}
+
+// Rejects the notifications received from now on with the reason
+func (fake *fakeAPNS) reject(reason string) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.rejectReason = reason
+}
+
+func (fake *fakeAPNS) received() []fakeNotification {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	return append([]fakeNotification{}, fake.notifications...)
+}
+
+func (fake *fakeAPNS) close() {
+	fake.server.Close()
+}
+
+// A fakeGithub is a local stand-in for the GitHub REST API that records every request it receives
+// and answers with the response registered for its method and path
+type fakeGithub struct {
+	server *httptest.Server
+
+	mutex     sync.Mutex
+	responses map[string]fakeResponse
+	requests  []fakeRequest
+}
+
+type fakeResponse struct {
+	Status int
+	Body   interface{}
+
+	// Answers with a status and body depending on the Authorization header, if set
+	Respond func(authorization string) (int, interface{})
+}
+
+type fakeRequest struct {
+	Method        string
+	Path          string
+	Authorization string
+	Body          map[string]interface{}
+}
+
+func newFakeGithub() *fakeGithub {
+	fake := &fakeGithub{responses: map[string]fakeResponse{}}
+
+	fake.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		request := fakeRequest{Method: r.Method, Path: r.URL.Path, Authorization: r.Header.Get("Authorization")}
+		_ = json.Unmarshal(body, &request.Body)
+
+		fake.mutex.Lock()
+		fake.requests = append(fake.requests, request)
+		response, ok := fake.responses[r.Method+" "+r.URL.Path]
+		fake.mutex.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+
+		if response.Respond != nil {
+			response.Status, response.Body = response.Respond(request.Authorization)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(response.Status)
+		_ = json.NewEncoder(w).Encode(response.Body)
+	}))
+
+	return fake
+}
+
+func (fake *fakeGithub) respond(method string, path string, status int, body interface{}) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.responses[method+" "+path] = fakeResponse{Status: status, Body: body}
+}
+
+func (fake *fakeGithub) respondTo(method string, path string, respond func(authorization string) (int, interface{})) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.responses[method+" "+path] = fakeResponse{Respond: respond}
+}
+
+func (fake *fakeGithub) received() []fakeRequest {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	return append([]fakeRequest{}, fake.requests...)
+}
+
+func (fake *fakeGithub) baseURL() string {
+	return fake.server.URL + "/"
+}
+
+func (fake *fakeGithub) close() {
+	fake.server.Close()
+}
+
+// Creates a fake GitHub API telling that the token `token` belongs to the user with the github id, and any other
+// token is invalid
+func newFakeGithubUser(server *handlers.Server, githubId int64) *fakeGithub {
+	github := newFakeGithub()
+	github.respondTo("GET", "/user", func(authorization string) (int, interface{}) {
+		if authorization != "token token" {
+			return http.StatusUnauthorized, map[string]string{"message": "Bad credentials"}
+		}
+
+		return http.StatusOK, map[string]interface{}{"id": githubId, "login": "Codertocat"}
+	})
+
+	server.GithubBaseURL = github.baseURL()
+	return github
+}
+
+// A failingUserStore fails every read with an error that mustn't reach clients
+type failingUserStore struct {
+	storage.UserStore
+}
+
+var errFailingStore = errors.New("mongo: connection to 10.0.0.1:27017 refused")
+
+func (store *failingUserStore) Get(ctx context.Context, githubId int64) (*models.User, error) {
+	return nil, errFailingStore
+}
+
+// A panickingUserStore panics on every read, like a handler with a bug
+type panickingUserStore struct {
+	storage.UserStore
+}
+
+func (store *panickingUserStore) Get(ctx context.Context, githubId int64) (*models.User, error) {
+	panic("user store panicked")
+}
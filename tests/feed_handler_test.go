@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"context"
+	"encoding/xml"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/models"
+	"testing"
+	"time"
+)
+
+func createFeedUser(t *testing.T, server *handlers.Server) *models.User {
+	user := createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned, models.PrOpened})
+
+	for _, storedEvent := range sampleFeed().Events {
+		_, _ = server.Stores.Events.Create(context.Background(), user.GithubId, &storedEvent.Event)
+	}
+
+	otherRepoEvent := sampleFeed().Events[0].Event
+	otherRepoEvent.RepoName = "Codertocat/Other"
+	_, _ = server.Stores.Events.Create(context.Background(), user.GithubId, &otherRepoEvent)
+
+	return user
+}
+
+func getFeed(server *handlers.Server, path string, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func testGetFeed200(t *testing.T) {
+	server := newTestServer()
+
+	user := createFeedUser(t, server)
+
+	rr := getFeed(server, "/feed.atom?token="+user.FeedToken+"&repo=Codertocat/Hello-World", nil)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/atom+xml; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.NotEmpty(t, rr.Header().Get("ETag"))
+	assert.Equal(t, "Wed, 15 May 2019 15:20:33 GMT", rr.Header().Get("Last-Modified"))
+	assert.NotContains(t, rr.Body.String(), "Codertocat/Other")
+
+	// Feed readers follow the self link to refresh the feed, so it keeps the token, but the id is shared and mustn't
+	id := atomFeedID(t, rr)
+	assert.Contains(t, rr.Body.String(), `/feed.atom?repo=Codertocat%2FHello-World&amp;token=`+user.FeedToken+`"`)
+	assert.NotContains(t, id, user.FeedToken)
+
+	// The id doesn't depend on how the query is written
+	rr = getFeed(server, "/feed.atom?repo=Codertocat/Hello-World&repo=Codertocat/Hello-World&token="+user.FeedToken, nil)
+	assert.Equal(t, id, atomFeedID(t, rr))
+
+	rr = getFeed(server, "/feed.atom?token="+user.FeedToken, nil)
+	assert.NotEqual(t, id, atomFeedID(t, rr))
+
+	rr = getFeed(server, "/feed.rss?token="+user.FeedToken, nil)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/rss+xml; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "Codertocat/Other")
+	assert.Contains(t, rr.Body.String(), "/feed.rss?token="+user.FeedToken+"</link>")
+}
+
+func atomFeedID(t *testing.T, rr *httptest.ResponseRecorder) string {
+	var doc struct {
+		ID string `xml:"id"`
+	}
+
+	assert.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &doc))
+	return doc.ID
+}
+
+func testGetFeed304(t *testing.T) {
+	server := newTestServer()
+
+	user := createFeedUser(t, server)
+	path := "/feed.atom?token=" + user.FeedToken
+
+	etag := getFeed(server, path, nil).Header().Get("ETag")
+
+	rr := getFeed(server, path, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.String())
+
+	rr = getFeed(server, path, http.Header{"If-Modified-Since": {time.Now().UTC().Format(http.TimeFormat)}})
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+
+	rr = getFeed(server, path, http.Header{"If-Modified-Since": {"Wed, 15 May 2019 15:20:00 GMT"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func testGetFeed401(t *testing.T) {
+	server := newTestServer()
+
+	_ = createFeedUser(t, server)
+
+	assert.Equal(t, http.StatusUnauthorized, getFeed(server, "/feed.atom", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, getFeed(server, "/feed.atom?token=invalid", nil).Code)
+}
+
+func TestFeedHandler(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-GET-feed":              testGetFeed200,
+		"test-GET-feed-not-modified": testGetFeed304,
+		"test-GET-feed-unauthorized": testGetFeed401,
+	}
+
+	for testName, test := range testMap {
+		t.Run(testName, test)
+	}
+}
@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"encoding/xml"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"push-request/feeds"
+	"push-request/models"
+	"testing"
+	"time"
+)
+
+func sampleFeed() *feeds.Feed {
+	older, _ := time.Parse(time.RFC3339, "2019-05-15T15:20:18Z")
+	newer, _ := time.Parse(time.RFC3339, "2019-05-15T15:20:33Z")
+
+	issue := models.StoredEvent{GithubId: 1, Event: *models.NewEvent(
+		models.IssueAssigned,
+		"Codertocat/Hello-World",
+		1,
+		"Spelling error in the README file",
+		"Assigned #1 to @Codertocat",
+		"https://avatars1.githubusercontent.com/u/21031067?v=4",
+		older,
+		"https://github.com/Codertocat/Hello-World/issues/1",
+		2,
+	)}
+	issue.ID = primitive.NewObjectID()
+
+	pr := models.StoredEvent{GithubId: 1, Event: *models.NewEvent(
+		models.PrOpened,
+		"Codertocat/Hello-World",
+		2,
+		"Update the README with new information.",
+		"Opened #2",
+		"https://avatars1.githubusercontent.com/u/21031067?v=4",
+		newer,
+		"https://github.com/Codertocat/Hello-World/pull/2",
+		2,
+	)}
+	pr.ID = primitive.NewObjectID()
+
+	return &feeds.Feed{
+		ID:      "urn:push-request:feed:abc",
+		Title:   "Push Request",
+		SelfURL: "https://example.com/feed.atom?token=abc",
+		Events:  []models.StoredEvent{pr, issue},
+	}
+}
+
+func TestAtomFeed(t *testing.T) {
+	feed := sampleFeed()
+
+	body, err := feed.Atom()
+	assert.NoError(t, err)
+
+	var doc struct {
+		ID      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Entries []struct {
+			ID      string `xml:"id"`
+			Title   string `xml:"title"`
+			Summary string `xml:"summary"`
+			Link    struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+			Updated   string `xml:"updated"`
+			Thumbnail struct {
+				URL string `xml:"url,attr"`
+			} `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+		} `xml:"entry"`
+	}
+
+	assert.NoError(t, xml.Unmarshal(body, &doc))
+	assert.Equal(t, "urn:push-request:feed:abc", doc.ID)
+	assert.Equal(t, "2019-05-15T15:20:33Z", doc.Updated)
+	assert.Len(t, doc.Entries, 2)
+
+	entry := doc.Entries[0]
+	assert.Equal(t, "urn:push-request:event:"+feed.Events[0].ID.Hex(), entry.ID)
+	assert.Equal(t, "[Codertocat/Hello-World] Update the README with new information.", entry.Title)
+	assert.Equal(t, "Opened #2", entry.Summary)
+	assert.Equal(t, "https://github.com/Codertocat/Hello-World/pull/2", entry.Link.Href)
+	assert.Equal(t, "2019-05-15T15:20:33Z", entry.Updated)
+	assert.Equal(t, "https://avatars1.githubusercontent.com/u/21031067?v=4", entry.Thumbnail.URL)
+}
+
+func TestRSSFeed(t *testing.T) {
+	feed := sampleFeed()
+
+	body, err := feed.RSS()
+	assert.NoError(t, err)
+
+	var doc struct {
+		Version string `xml:"version,attr"`
+		Channel struct {
+			LastBuildDate string `xml:"lastBuildDate"`
+			Items         []struct {
+				Title       string `xml:"title"`
+				Description string `xml:"description"`
+				Link        string `xml:"link"`
+				PubDate     string `xml:"pubDate"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+
+	assert.NoError(t, xml.Unmarshal(body, &doc))
+	assert.Equal(t, "2.0", doc.Version)
+	assert.Equal(t, "Wed, 15 May 2019 15:20:33 +0000", doc.Channel.LastBuildDate)
+	assert.Len(t, doc.Channel.Items, 2)
+
+	item := doc.Channel.Items[1]
+	assert.Equal(t, "[Codertocat/Hello-World] Spelling error in the README file", item.Title)
+	assert.Equal(t, "Assigned #1 to @Codertocat", item.Description)
+	assert.Equal(t, "https://github.com/Codertocat/Hello-World/issues/1", item.Link)
+	assert.Equal(t, "Wed, 15 May 2019 15:20:18 +0000", item.PubDate)
+}
@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"push-request/githubapp"
+	"push-request/models"
+	"push-request/parsers"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Generating keys is slow, so every test app shares one
+var testAppKey struct {
+	once sync.Once
+	key  *rsa.PrivateKey
+	err  error
+}
+
+func newTestApp(t *testing.T, baseURL string) (*githubapp.Client, *rsa.PrivateKey) {
+	testAppKey.once.Do(func() {
+		testAppKey.key, testAppKey.err = rsa.GenerateKey(rand.Reader, 2048)
+	})
+
+	privateKey, err := testAppKey.key, testAppKey.err
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	app, err := githubapp.NewClient(42, privateKeyPEM, baseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app, privateKey
+}
+
+func TestAppJWT(t *testing.T) {
+	app, privateKey := newTestApp(t, "https://api.github.com/")
+
+	signed, err := app.AppJWT()
+	assert.NoError(t, err)
+
+	claims := jwt.StandardClaims{}
+	_, err = jwt.ParseWithClaims(signed, &claims, func(token *jwt.Token) (interface{}, error) {
+		assert.Equal(t, jwt.SigningMethodRS256, token.Method)
+		return &privateKey.PublicKey, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "42", claims.Issuer)
+	assert.True(t, claims.ExpiresAt-claims.IssuedAt <= int64(10*time.Minute/time.Second))
+}
+
+func TestInstallationTokenCaching(t *testing.T) {
+	github := newFakeGithub()
+	defer github.close()
+
+	app, _ := newTestApp(t, github.baseURL())
+
+	github.respond("POST", "/app/installations/2/access_tokens", http.StatusCreated, map[string]interface{}{
+		"token":      "installation-token",
+		"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+
+	for i := 0; i < 2; i++ {
+		token, err := app.InstallationToken(context.Background(), 2)
+		assert.NoError(t, err)
+		assert.Equal(t, "installation-token", token)
+	}
+
+	received := github.received()
+	assert.Len(t, received, 1)
+	assert.True(t, strings.HasPrefix(received[0].Authorization, "Bearer "))
+
+	// A token about to expire is exchanged for a new one
+	github.respond("POST", "/app/installations/3/access_tokens", http.StatusCreated, map[string]interface{}{
+		"token":      "expiring-token",
+		"expires_at": time.Now().Add(30 * time.Second).Format(time.RFC3339),
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := app.InstallationToken(context.Background(), 3)
+		assert.NoError(t, err)
+	}
+
+	assert.Len(t, github.received(), 3)
+}
+
+// Exchanging the token of an installation mustn't block the other installations, and concurrent callers must wait for
+// the token being exchanged instead of exchanging their own
+func TestInstallationTokenConcurrency(t *testing.T) {
+	exchanging := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	var mutex sync.Mutex
+	exchanges := map[string]int{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		exchanges[r.URL.Path]++
+		mutex.Unlock()
+
+		if r.URL.Path == "/app/installations/2/access_tokens" {
+			exchanging <- struct{}{}
+			<-release
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      "installation-token",
+			"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+		})
+	}))
+	defer server.Close()
+
+	app, _ := newTestApp(t, server.URL+"/")
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := app.InstallationToken(context.Background(), 2)
+			assert.NoError(t, err)
+		}()
+	}
+
+	<-exchanging
+
+	done := make(chan error)
+	go func() {
+		_, err := app.InstallationToken(context.Background(), 3)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Error("exchanging the token of installation 2 blocked installation 3")
+	}
+
+	close(release)
+	wg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	assert.Equal(t, 1, exchanges["/app/installations/2/access_tokens"])
+	assert.Equal(t, 1, exchanges["/app/installations/3/access_tokens"])
+}
+
+func TestTeamMembers(t *testing.T) {
+	github := newFakeGithub()
+	defer github.close()
+
+	app, _ := newTestApp(t, github.baseURL())
+
+	github.respond("POST", "/app/installations/2/access_tokens", http.StatusCreated, map[string]interface{}{
+		"token":      "installation-token",
+		"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	github.respond("GET", "/orgs/octo-org/teams/reviewers/members", http.StatusOK, []map[string]interface{}{
+		{"login": "octocat", "id": 1},
+		{"login": "Codertocat", "id": 21031067},
+	})
+
+	logins, err := app.TeamMembers(context.Background(), 2, "octo-org", "reviewers")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"octocat", "Codertocat"}, logins)
+	assert.Equal(t, "token installation-token", github.received()[1].Authorization)
+}
+
+func TestEnrichEvent(t *testing.T) {
+	github := newFakeGithub()
+	defer github.close()
+
+	app, _ := newTestApp(t, github.baseURL())
+
+	github.respond("POST", "/app/installations/2/access_tokens", http.StatusCreated, map[string]interface{}{
+		"token":      "installation-token",
+		"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	github.respond("GET", "/repos/Codertocat/Hello-World/pulls/2", http.StatusOK, map[string]interface{}{
+		"number":        2,
+		"additions":     10,
+		"deletions":     3,
+		"changed_files": 2,
+		"head":          map[string]interface{}{"sha": "ec26c3e57ca3a959ca5aad62de7213c562f8c821"},
+	})
+	github.respond("GET", "/repos/Codertocat/Hello-World/commits/ec26c3e57ca3a959ca5aad62de7213c562f8c821/status", http.StatusOK, map[string]interface{}{
+		"state":       "success",
+		"total_count": 1,
+	})
+
+	event := models.Event{EventType: models.PrReviewed, RepoName: "Codertocat/Hello-World", Number: 2, InstallationId: 2}
+
+	assert.NoError(t, parsers.EnrichEvent(context.Background(), app, &event))
+	assert.Equal(t, 10, event.Additions)
+	assert.Equal(t, 3, event.Deletions)
+	assert.Equal(t, 2, event.ChangedFiles)
+	assert.Equal(t, "success", event.CIStatus)
+
+	issue := models.Event{EventType: models.IssueOpened, RepoName: "Codertocat/Hello-World", Number: 1, InstallationId: 2}
+
+	assert.NoError(t, parsers.EnrichEvent(context.Background(), app, &issue))
+	assert.Equal(t, models.Event{EventType: models.IssueOpened, RepoName: "Codertocat/Hello-World", Number: 1, InstallationId: 2}, issue)
+}
@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/go-github/github"
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/token"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/models"
+	"push-request/parsers"
+	"push-request/stream"
+	"testing"
+)
+
+type readinessResponse struct {
+	Status string `json:"status"`
+	Checks []struct {
+		Name    string                 `json:"name"`
+		Status  string                 `json:"status"`
+		Message string                 `json:"message"`
+		Details map[string]interface{} `json:"details"`
+	} `json:"checks"`
+}
+
+// Creates a server that is ready, with an APNs client that is configured but never used
+func newReadyServer() *handlers.Server {
+	server := newTestServer()
+	server.APNS = apns2.NewTokenClient(&token.Token{}).Production()
+	server.APNSTopic = "com.example.PushRequest"
+
+	return server
+}
+
+func getReadiness(t *testing.T, server *handlers.Server) (int, readinessResponse) {
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+
+	var response readinessResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	return rr.Code, response
+}
+
+// Gets the statuses of the readiness checks by name
+func checkStatuses(response readinessResponse) map[string]string {
+	statuses := map[string]string{}
+
+	for _, check := range response.Checks {
+		statuses[check.Name] = check.Status
+	}
+
+	return statuses
+}
+
+func testHealth(t *testing.T) {
+	server := newTestServer()
+
+	// Liveness doesn't depend on the database
+	server.Stores.Ping = func(ctx context.Context) error { return errors.New("unreachable") }
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rr.Body.String())
+
+	req, _ = http.NewRequest("POST", "/healthz", nil)
+	rr = httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func testReady(t *testing.T) {
+	status, response := getReadiness(t, newReadyServer())
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ready", response.Status)
+	assert.Equal(t, map[string]string{"memory": "ok", "apns": "ok", "queue": "ok", "shutdown": "ok"},
+		checkStatuses(response))
+}
+
+func testReadyDatabaseUnreachable(t *testing.T) {
+	server := newReadyServer()
+	server.Stores.Ping = func(ctx context.Context) error {
+		return errors.New("server selection error: mongo.internal:27017")
+	}
+
+	status, response := getReadiness(t, server)
+
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "unavailable", response.Status)
+	assert.Equal(t, "failing", checkStatuses(response)["memory"])
+
+	// The error isn't exposed, since it may name the database's hosts
+	assert.Equal(t, "The database can't be reached", response.Checks[0].Message)
+}
+
+func testReadyAPNSNotConfigured(t *testing.T) {
+	status, response := getReadiness(t, newTestServer())
+
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "failing", checkStatuses(response)["apns"])
+
+	// The sandbox client is used when devices default to the sandbox
+	server := newTestServer()
+	server.APNSSandbox = apns2.NewTokenClient(&token.Token{}).Development()
+	server.APNSTopic = "com.example.PushRequest"
+	server.DefaultAPNSEnvironment = models.APNSSandbox
+
+	status, _ = getReadiness(t, server)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func testReadyQueueDepth(t *testing.T) {
+	broker := stream.NewMemoryBroker()
+
+	server := newReadyServer()
+	server.Broker = broker
+	server.MaxPendingEvents = 1
+
+	_, unsubscribe := broker.Subscribe(1)
+	defer unsubscribe()
+
+	broker.Publish(models.StoredEvent{GithubId: 1})
+
+	status, response := getReadiness(t, server)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1), response.Checks[2].Details["pending_events"])
+
+	broker.Publish(models.StoredEvent{GithubId: 1})
+
+	status, response = getReadiness(t, server)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "failing", checkStatuses(response)["queue"])
+}
+
+func testReadyDraining(t *testing.T) {
+	server := newReadyServer()
+	server.Drain()
+
+	status, response := getReadiness(t, server)
+
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "failing", checkStatuses(response)["shutdown"])
+
+	// Closing the streams on shutdown drains the server too
+	server = newReadyServer()
+	server.CloseStreams()
+
+	status, _ = getReadiness(t, server)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+}
+
+func testVersion(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/version", nil)
+	rr := httptest.NewRecorder()
+	newTestServer().Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response struct {
+		Commit       string   `json:"commit"`
+		BuildTime    string   `json:"build_time"`
+		GoVersion    string   `json:"go_version"`
+		EventParsers []string `json:"event_parsers"`
+	}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "unknown", response.Commit)
+	assert.Equal(t, "unknown", response.BuildTime)
+	assert.NotEmpty(t, response.GoVersion)
+	assert.Equal(t, parsers.ParsedEvents, response.EventParsers)
+}
+
+// Every event listed as parsed must be parsed, so /version doesn't drift from the parsers
+func testParsedEvents(t *testing.T) {
+	fixtures := map[string]string{
+		"issues":              "issue.json",
+		"issue_comment":       "issue_comment.json",
+		"pull_request":        "pull_request.json",
+		"pull_request_review": "pr_review.json",
+	}
+
+	for _, name := range parsers.ParsedEvents {
+		fixture, ok := fixtures[name]
+		if !ok {
+			continue
+		}
+
+		data, _ := ioutil.ReadFile("./fixtures/" + fixture)
+
+		payload, err := github.ParseWebHook(name, data)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+
+		assert.NotNil(t, parsers.ParseRawEventPayload(payload), name)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-GET-healthz":                     testHealth,
+		"test-GET-readyz":                      testReady,
+		"test-GET-readyz-database-unreachable": testReadyDatabaseUnreachable,
+		"test-GET-readyz-apns-not-configured":  testReadyAPNSNotConfigured,
+		"test-GET-readyz-queue-depth":          testReadyQueueDepth,
+		"test-GET-readyz-draining":             testReadyDraining,
+		"test-GET-version":                     testVersion,
+		"test-version-parsed-events":           testParsedEvents,
+	}
+
+	for testName, test := range testMap {
+		t.Run(testName, test)
+	}
+}
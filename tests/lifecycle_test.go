@@ -0,0 +1,307 @@
+package tests
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"push-request/handlers"
+	"push-request/lifecycle"
+	"push-request/models"
+	"push-request/stream"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type runningService struct {
+	service *lifecycle.Service
+	url     string
+	stop    chan os.Signal
+	done    chan error
+}
+
+func startService(t *testing.T, httpServer *http.Server, shutdownTimeout time.Duration) *runningService {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	running := &runningService{
+		service: lifecycle.New(httpServer, shutdownTimeout),
+		url:     "http://" + listener.Addr().String(),
+		stop:    make(chan os.Signal, 1),
+		done:    make(chan error, 1),
+	}
+
+	go func() {
+		running.done <- running.service.Serve(listener, running.stop)
+	}()
+
+	return running
+}
+
+// Waits for Serve to return after shutting down
+func (running *runningService) wait(t *testing.T) error {
+	select {
+	case err := <-running.done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("the service did not shut down")
+		return nil
+	}
+}
+
+func testShutdownDrainsRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	running := startService(t, &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "done")
+	})}, 5*time.Second)
+
+	type result struct {
+		body string
+		err  error
+	}
+
+	responses := make(chan result, 1)
+
+	go func() {
+		res, err := http.Get(running.url)
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		responses <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	running.stop <- syscall.SIGTERM
+
+	// The in-flight request keeps the service running until it completes
+	select {
+	case err := <-running.done:
+		t.Fatal("the service shut down during a request", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	response := <-responses
+	assert.NoError(t, response.err)
+	assert.Equal(t, "done", response.body)
+	assert.NoError(t, running.wait(t))
+
+	// New connections are refused once the service has shut down
+	_, err := http.Get(running.url)
+	assert.Error(t, err)
+}
+
+func testShutdownStopsWorkersAndClosesResources(t *testing.T) {
+	running := startService(t, &http.Server{Handler: http.NotFoundHandler()}, 5*time.Second)
+
+	var mutex sync.Mutex
+	var order []string
+
+	record := func(step string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		order = append(order, step)
+	}
+
+	running.service.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		record("worker")
+	})
+
+	running.service.OnShutdown("database", func(ctx context.Context) error {
+		record("database")
+		return nil
+	})
+
+	running.service.OnShutdown("streams", func(ctx context.Context) error {
+		record("streams")
+		return nil
+	})
+
+	running.stop <- syscall.SIGINT
+	assert.NoError(t, running.wait(t))
+
+	// Workers finish before resources are closed, in the reverse order they were registered
+	assert.Equal(t, []string{"worker", "streams", "database"}, order)
+}
+
+func testShutdownDeadline(t *testing.T) {
+	started := make(chan struct{})
+
+	running := startService(t, &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+
+		// Hangs until its connection is closed
+		<-r.Context().Done()
+	})}, 100*time.Millisecond)
+
+	closed := make(chan bool, 1)
+
+	running.service.OnShutdown("database", func(ctx context.Context) error {
+		closed <- true
+		return nil
+	})
+
+	requestErr := make(chan error, 1)
+
+	go func() {
+		res, err := http.Get(running.url)
+		if err == nil {
+			_ = res.Body.Close()
+		}
+
+		requestErr <- err
+	}()
+
+	<-started
+	begin := time.Now()
+	running.stop <- syscall.SIGTERM
+
+	err := running.wait(t)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to drain requests")
+	assert.Less(t, int64(time.Since(begin)), int64(time.Second))
+
+	// Resources are closed even when the deadline passed, and the hanging request is cut off
+	assert.True(t, <-closed)
+	assert.Error(t, <-requestErr)
+}
+
+func testShutdownDrains(t *testing.T) {
+	server := newReadyServer()
+
+	running := startService(t, &http.Server{Handler: server.Routes()}, 5*time.Second)
+	running.service.DrainDelay = 300 * time.Millisecond
+	running.service.OnDrain(server.Drain)
+
+	res, err := http.Get(running.url + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	running.stop <- syscall.SIGTERM
+	time.Sleep(50 * time.Millisecond)
+
+	// Requests are still served during the drain delay, while the readiness check fails
+	res, err = http.Get(running.url + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	assert.NoError(t, running.wait(t))
+}
+
+func testShutdownClosesStreams(t *testing.T) {
+	server := newTestServer()
+	server.Broker = stream.NewMemoryBroker()
+	server.HeartbeatInterval = 50 * time.Millisecond
+
+	github := newFakeGithubUser(server, 1)
+	defer github.close()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+
+	httpServer := &http.Server{
+		Handler: server.Routes(),
+
+		// Much shorter than the streams are kept open, which they must outlive
+		ReadTimeout:  100 * time.Millisecond,
+		WriteTimeout: 100 * time.Millisecond,
+		ConnContext:  handlers.ConnContext,
+	}
+
+	httpServer.RegisterOnShutdown(server.CloseStreams)
+
+	running := startService(t, httpServer, 5*time.Second)
+	running.service.OnShutdown("event streams", server.WaitForStreams)
+
+	reader, closeStream := openSSEStream(t, &httptest.Server{URL: running.url}, "")
+	defer closeStream()
+
+	url := "ws" + strings.TrimPrefix(running.url, "http") + "/events/stream"
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"1"}, "X-Github-Token": {"token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	// Pings sent before the server closed the connection can't be answered once it has
+	conn.SetPingHandler(func(string) error { return nil })
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, []string{": heartbeat"}, readSSEMessage(t, reader))
+
+	running.stop <- syscall.SIGTERM
+	assert.NoError(t, running.wait(t))
+
+	// The SSE stream ends, and the WebSocket is closed as going away so clients reconnect elsewhere
+	for {
+		if _, err = reader.ReadString('\n'); err != nil {
+			break
+		}
+	}
+
+	assert.Equal(t, io.EOF, err)
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+
+	// Streams can't be started once they were closed
+	req, _ := http.NewRequest("GET", "/events/stream", nil)
+	req.Header.Add("Authorization", "1")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), `"code":"unavailable"`)
+}
+
+func TestLifecycle(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-shutdown-drains-requests":                    testShutdownDrainsRequests,
+		"test-shutdown-stops-workers-and-closes-resources": testShutdownStopsWorkersAndClosesResources,
+		"test-shutdown-deadline":                           testShutdownDeadline,
+		"test-shutdown-drains":                             testShutdownDrains,
+		"test-shutdown-closes-streams":                     testShutdownClosesStreams,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,225 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/logging"
+	"push-request/models"
+	"push-request/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+const loggedDeviceToken = "4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b"
+
+// Decodes the JSON lines written by a Logger
+func decodeLogLines(t *testing.T, buffer *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("invalid log line %q (%v)", line, err)
+		}
+
+		lines = append(lines, decoded)
+	}
+
+	return lines
+}
+
+// Finds the line with the message
+func findLogLine(lines []map[string]interface{}, message string) map[string]interface{} {
+	for _, line := range lines {
+		if line["msg"] == message {
+			return line
+		}
+	}
+
+	return nil
+}
+
+// Serves the request with a logger writing to the returned buffer
+func serveLogged(handler http.Handler, req *http.Request) (*httptest.ResponseRecorder, *bytes.Buffer) {
+	var buffer bytes.Buffer
+	req = req.WithContext(logging.NewContext(req.Context(), logging.New(&buffer, logging.LevelDebug)))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	return rr, &buffer
+}
+
+func testLoggingFormat(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := logging.New(&buffer, logging.LevelInfo).With("user_id", 1, "event_type", "IssueOpened")
+
+	logger.Debug("not written")
+	logger.Info("first", "attempt", 2, "delay", 1500*time.Millisecond)
+	logger.With("user_id", 2).Warn("second", "error", errors.New("failed"))
+	logger.Error("third", "event_type", "PrMerged", "dangling")
+
+	lines := decodeLogLines(t, &buffer)
+	if !assert.Len(t, lines, 3) {
+		return
+	}
+
+	assert.Equal(t, "info", lines[0]["level"])
+	assert.Equal(t, "first", lines[0]["msg"])
+	assert.Equal(t, 1.0, lines[0]["user_id"])
+	assert.Equal(t, 2.0, lines[0]["attempt"])
+	assert.Equal(t, "1.5s", lines[0]["delay"])
+	assert.NotEmpty(t, lines[0]["time"])
+
+	// Fields replace fields of the logger with the same key
+	assert.Equal(t, "warn", lines[1]["level"])
+	assert.Equal(t, 2.0, lines[1]["user_id"])
+	assert.Equal(t, "failed", lines[1]["error"])
+
+	assert.Equal(t, "error", lines[2]["level"])
+	assert.Equal(t, "PrMerged", lines[2]["event_type"])
+	assert.Equal(t, "dangling", lines[2]["!BADKEY"])
+	assert.Equal(t, 3, strings.Count(buffer.String(), `"event_type"`), "keys are written once per line")
+
+	level, err := logging.ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, logging.LevelWarn, level)
+
+	_, err = logging.ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func testLoggingRedaction(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := logging.New(&buffer, logging.LevelDebug)
+
+	logger.Info("register "+loggedDeviceToken,
+		"device_token", "a",
+		"Authorization", "Bearer abc",
+		"webhook_secret", "hunter2",
+		"private_key", "-----BEGIN",
+		"device", models.Device{Token: loggedDeviceToken},
+		"error", errors.New("apns rejected "+loggedDeviceToken),
+		"tokens", []string{loggedDeviceToken},
+	)
+
+	output := buffer.String()
+	assert.NotContains(t, output, loggedDeviceToken)
+	assert.NotContains(t, output, "Bearer abc")
+	assert.NotContains(t, output, "hunter2")
+	assert.NotContains(t, output, "BEGIN")
+
+	lines := decodeLogLines(t, &buffer)
+	if !assert.Len(t, lines, 1) {
+		return
+	}
+
+	assert.Equal(t, "register [redacted]", lines[0]["msg"])
+	assert.Equal(t, logging.Redacted, lines[0]["device_token"])
+	assert.Equal(t, logging.Redacted, lines[0]["Authorization"])
+	assert.Equal(t, logging.Redacted, lines[0]["webhook_secret"])
+	assert.Equal(t, logging.Redacted, lines[0]["private_key"])
+	assert.Equal(t, "apns rejected [redacted]", lines[0]["error"])
+	assert.Equal(t, []interface{}{logging.Redacted}, lines[0]["tokens"])
+}
+
+func testLoggingWebhookFields(t *testing.T) {
+	server := newTestServer()
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, loggedDeviceToken, []models.EventType{models.IssueAssigned})
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	data, _ := ioutil.ReadFile("./fixtures/issue.json")
+
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewReader(data))
+	req.Header.Set("X-Github-Event", "issues")
+	req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	req.Header.Set("X-Request-Id", "req-1")
+
+	rr, buffer := serveLogged(server.Routes(), req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, buffer.String(), loggedDeviceToken)
+
+	lines := decodeLogLines(t, buffer)
+
+	received := findLogLine(lines, "request received")
+	if assert.NotNil(t, received) {
+		assert.Equal(t, "req-1", received["request_id"])
+		assert.Equal(t, "72d3162e-cc78-11e3-81ab-4c9367dc0958", received["delivery_id"])
+		assert.Equal(t, "issues", received["github_event"])
+		assert.Equal(t, "POST", received["method"])
+	}
+
+	delivered := findLogLine(lines, "event delivered")
+	if assert.NotNil(t, delivered) {
+		assert.Equal(t, "req-1", delivered["request_id"])
+		assert.Equal(t, "72d3162e-cc78-11e3-81ab-4c9367dc0958", delivered["delivery_id"])
+		assert.Equal(t, 2.0, delivered["installation_id"])
+		assert.Equal(t, string(models.IssueAssigned), delivered["event_type"])
+		assert.Equal(t, 1.0, delivered["user_id"])
+	}
+}
+
+func testLoggingInternalError(t *testing.T) {
+	stores := storage.NewMemoryStores()
+	stores.Users = &failingUserStore{stores.Users}
+	server := handlers.NewServer(stores)
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("Authorization", "1234")
+
+	rr, buffer := serveLogged(server.Routes(), req)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	line := findLogLine(decodeLogLines(t, buffer), "authenticate")
+	if assert.NotNil(t, line) {
+		assert.Equal(t, "error", line["level"])
+		assert.Equal(t, rr.Header().Get("X-Request-Id"), line["request_id"])
+		assert.Equal(t, errFailingStore.Error(), line["error"])
+	}
+}
+
+func testLoggingContext(t *testing.T) {
+	var buffer bytes.Buffer
+	ctx := logging.NewContext(context.Background(), logging.New(&buffer, logging.LevelInfo))
+	ctx = logging.With(ctx, "installation_id", 2)
+
+	logging.FromContext(ctx).Info("linked")
+
+	lines := decodeLogLines(t, &buffer)
+	if assert.Len(t, lines, 1) {
+		assert.Equal(t, 2.0, lines[0]["installation_id"])
+	}
+
+	assert.Equal(t, logging.Default(), logging.FromContext(context.Background()))
+}
+
+func TestLogging(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-logging-format":         testLoggingFormat,
+		"test-logging-redaction":      testLoggingRedaction,
+		"test-logging-webhook-fields": testLoggingWebhookFields,
+		"test-logging-internal-error": testLoggingInternalError,
+		"test-logging-context":        testLoggingContext,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
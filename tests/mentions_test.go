@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"github.com/google/go-github/github"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"push-request/models"
+	"push-request/parsers"
+	"testing"
+	"time"
+)
+
+func TestExtractMentions(t *testing.T) {
+	body := "@alice can you look? /cc @octo-org/reviewers and @Bob.\n" +
+		"> @quoted should be ignored\n" +
+		"```go\n" +
+		"// @fenced too\n" +
+		"```\n" +
+		"    @indented code\n" +
+		"Not `@inline` code, nor an email like carol@example.com or a path like a/@b, but (@dave) and @alice again"
+
+	logins, teams := parsers.ExtractMentions(body)
+
+	assert.Equal(t, []string{"alice", "Bob", "dave"}, logins)
+	assert.Equal(t, []string{"octo-org/reviewers"}, teams)
+}
+
+func TestExtractMentionsWithoutMentions(t *testing.T) {
+	logins, teams := parsers.ExtractMentions("No one to notify here, @-@ not a login either")
+
+	assert.Empty(t, logins)
+	assert.Empty(t, teams)
+}
+
+func TestIssueCommentMentionParsing(t *testing.T) {
+	data, _ := ioutil.ReadFile("./fixtures/issue_comment.json")
+	event, _ := github.ParseWebHook("issue_comment", data)
+	date, _ := time.Parse(time.RFC3339, "2019-05-15T15:20:21Z")
+
+	mention := parsers.ParseMention(event)
+	assert.NotNil(t, mention)
+
+	want := *models.NewEvent(
+		models.Mentioned,
+		"Codertocat/Hello-World",
+		1,
+		"Spelling error in the README file",
+		"@Codertocat mentioned you in #1",
+		"https://avatars1.githubusercontent.com/u/21031067?v=4",
+		date,
+		"https://github.com/Codertocat/Hello-World/issues/1#issuecomment-492700400",
+		2,
+	)
+
+	assert.Equal(t, want, *mention.Event)
+	assert.Equal(t, "Codertocat", mention.Author)
+	assert.Equal(t, []string{"octocat", "Codertocat"}, mention.Logins)
+	assert.Equal(t, []string{"octo-org/reviewers"}, mention.Teams)
+}
+
+func TestEditedMentionParsing(t *testing.T) {
+	edited := func(body string, from *string) *github.IssuesEvent {
+		event := &github.IssuesEvent{
+			Action: github.String("edited"),
+			Issue:  &github.Issue{Number: github.Int(1), Body: github.String(body)},
+			Sender: &github.User{Login: github.String("Codertocat")},
+		}
+
+		if from != nil {
+			event.Changes = &github.EditChange{}
+			event.Changes.Body = &struct {
+				From *string `json:"from,omitempty"`
+			}{From: from}
+		}
+
+		return event
+	}
+
+	// Fixing a typo doesn't notify anyone again
+	assert.Nil(t, parsers.ParseMention(edited("Thanks @alice and @octo-org/reviewers", github.String("Thansk @Alice and @octo-org/reviewers"))))
+
+	// Nor does editing only the title
+	assert.Nil(t, parsers.ParseMention(edited("Thanks @alice", nil)))
+
+	mention := parsers.ParseMention(edited("Thanks @alice and @bob, cc @octo-org/reviewers", github.String("Thanks @alice")))
+	if assert.NotNil(t, mention) {
+		assert.Equal(t, []string{"bob"}, mention.Logins)
+		assert.Equal(t, []string{"octo-org/reviewers"}, mention.Teams)
+	}
+}
+
+func TestIssueWithoutMentionParsing(t *testing.T) {
+	data, _ := ioutil.ReadFile("./fixtures/issue.json")
+	event, _ := github.ParseWebHook("issues", data)
+
+	assert.Nil(t, parsers.ParseMention(event))
+}
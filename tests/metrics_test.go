@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/event"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/metrics"
+	"push-request/models"
+	"testing"
+)
+
+// Signs the payload with the secret, as GitHub does in `X-Hub-Signature-256`
+func signPayload(secret []byte, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sends a webhook, signed if the server has a webhook secret
+func postWebhook(server *handlers.Server, eventName string, payload []byte) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+	req.Header.Add("X-Github-Event", eventName)
+
+	if len(server.WebhookSecret) > 0 {
+		req.Header.Add("X-Hub-Signature-256", signPayload(server.WebhookSecret, payload))
+	}
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func testMetricsWebhookDelivered(t *testing.T) {
+	server := newTestServer()
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	data, _ := ioutil.ReadFile("./fixtures/issue.json")
+	assert.Equal(t, http.StatusOK, postWebhook(server, "issues", data).Code)
+
+	m := server.Metrics
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.WebhooksReceived.WithLabelValues("issues", "assigned")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.WebhookParses.WithLabelValues("issues", metrics.ParseParsed)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.Pushes.WithLabelValues("apns", "production", metrics.PushSent, "none")))
+	assert.Equal(t, 0, testutil.CollectAndCount(m.EventsFiltered))
+
+	// Rejected notifications are counted by the reason APNs gives
+	apns.reject("BadDeviceToken")
+	postWebhook(server, "issues", data)
+
+	assert.Equal(t, 1.0,
+		testutil.ToFloat64(m.Pushes.WithLabelValues("apns", "production", metrics.PushRejected, "BadDeviceToken")))
+}
+
+func testMetricsWebhookFiltered(t *testing.T) {
+	server := newTestServer()
+	data, _ := ioutil.ReadFile("./fixtures/issue.json")
+	m := server.Metrics
+
+	// No user is linked to the installation
+	postWebhook(server, "issues", data)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.EventsFiltered.WithLabelValues(metrics.FilterNoOwner)))
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.PrMerged})
+
+	postWebhook(server, "issues", data)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.EventsFiltered.WithLabelValues(metrics.FilterTypeNotAllowed)))
+
+	_, err := server.Stores.Users.Register(context.Background(), 1, models.Device{Token: "a"},
+		[]models.EventType{models.IssueAssigned}, 0)
+	assert.NoError(t, err)
+
+	assert.NoError(t, server.Stores.Subscriptions.Create(context.Background(), &models.Subscription{
+		GithubId: 1, RepoName: "Codertocat/Hello-World", Number: 1, Reason: models.ReasonManual, Muted: true,
+	}))
+
+	postWebhook(server, "issues", data)
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.EventsFiltered.WithLabelValues(metrics.FilterMuted)),
+		"the owner and their subscription are both muted")
+
+	// Events that aren't parsed into a notification
+	assert.Equal(t, http.StatusOK, postWebhook(server, "watch", []byte(`{"action":"started"}`)).Code)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.WebhooksReceived.WithLabelValues("watch", "started")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.WebhookParses.WithLabelValues("watch", metrics.ParseNil)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.EventsFiltered.WithLabelValues(metrics.FilterUnsupported)))
+}
+
+func testMetricsWebhookLabelsBounded(t *testing.T) {
+	server := newTestServer()
+	m := server.Metrics
+
+	postWebhook(server, "made_up_event", []byte(`{"action":"opened"}`))
+	postWebhook(server, "watch", []byte(`{"action":"made_up_action"}`))
+	postWebhook(server, "watch", []byte(`{}`))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.WebhooksReceived.WithLabelValues("other", "opened")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.WebhookParses.WithLabelValues("other", metrics.ParseInvalid)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.WebhooksReceived.WithLabelValues("watch", "other")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.WebhooksReceived.WithLabelValues("watch", "none")))
+}
+
+func testMetricsRegistrations(t *testing.T) {
+	server := newTestServer()
+
+	postUser(server, map[string]interface{}{"github_id": 1, "device_tokens": []string{"a", "b"}})
+	postUser(server, map[string]interface{}{"github_id": 1, "device_tokens": []string{"a"}})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.Registrations.WithLabelValues("true")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(server.Metrics.Registrations.WithLabelValues("false")))
+}
+
+func testMetricsHTTPAndMongo(t *testing.T) {
+	server := newTestServer()
+	m := server.Metrics
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Add("Authorization", "1")
+	server.Routes().ServeHTTP(httptest.NewRecorder(), req)
+
+	monitor := m.MongoMonitor()
+	monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", DurationNanos: 2e6},
+	})
+	monitor.Failed(context.Background(), &event.CommandFailedEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "insert", DurationNanos: 1e6},
+	})
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	body := rr.Body.String()
+	assert.Contains(t, body, `push_request_http_request_duration_seconds_count{code="404",method="get",route="/v1/users"} 1`)
+	assert.Contains(t, body, `push_request_mongo_command_duration_seconds_count{command="find",result="success"} 1`)
+	assert.Contains(t, body, `push_request_mongo_command_duration_seconds_count{command="insert",result="failure"} 1`)
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestMetrics(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-metrics-webhook-delivered":      testMetricsWebhookDelivered,
+		"test-metrics-webhook-filtered":       testMetricsWebhookFiltered,
+		"test-metrics-webhook-labels-bounded": testMetricsWebhookLabelsBounded,
+		"test-metrics-registrations":          testMetricsRegistrations,
+		"test-metrics-http-and-mongo":         testMetricsHTTPAndMongo,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"context"
+	"github.com/Kamva/mgm"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"push-request/models"
+	"push-request/storage"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func resetMongo(t *testing.T) {
+	ctx := context.Background()
+
+	for _, model := range []mgm.Model{&models.User{}, &models.Installation{}, &models.StoredEvent{}, &models.Subscription{},
+		&models.APIKey{}} {
+		if err := mgm.Coll(model).Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"migrations", "migration_locks"} {
+		if err := mgm.CollectionByName(name).Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testMigrationsApplyOnce(t *testing.T) {
+	versions, err := storage.MigrateMongo(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, versions)
+
+	versions, err = storage.MigrateMongo(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+}
+
+func testConcurrentMigrations(t *testing.T) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var applied []int
+
+	// Replicas starting at the same time
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			versions, err := storage.MigrateMongo(context.Background())
+			assert.NoError(t, err)
+
+			mutex.Lock()
+			applied = append(applied, versions...)
+			mutex.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	// Every migration was applied by exactly one replica
+	sort.Ints(applied)
+	for i, version := range applied {
+		assert.Equal(t, i+1, version)
+	}
+
+	count, err := mgm.CollectionByName("migrations").CountDocuments(context.Background(), bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(applied)), count)
+}
+
+func testDuplicatesMerged(t *testing.T) {
+	ctx := context.Background()
+	users := mgm.Coll(&models.User{})
+
+	// Users created twice by racing registrations
+	_, _ = users.InsertOne(ctx, bson.M{"github_id": 1, "device_tokens": []string{"a"}, "allowed_types": []string{}})
+	_, _ = users.InsertOne(ctx, bson.M{"github_id": 1, "device_tokens": []string{"b", "a"}, "allowed_types": []string{}})
+	_, _ = mgm.Coll(&models.Installation{}).InsertOne(ctx, bson.M{"installation_id": 2, "github_id": 1})
+	_, _ = mgm.Coll(&models.Installation{}).InsertOne(ctx, bson.M{"installation_id": 2, "github_id": 1})
+
+	_, err := storage.MigrateMongo(ctx)
+	assert.NoError(t, err)
+
+	var merged []models.User
+	assert.NoError(t, users.SimpleFind(&merged, bson.M{"github_id": 1}))
+	assert.Len(t, merged, 1)
+	assert.ElementsMatch(t, []string{"a", "b"}, merged[0].DeviceTokens)
+
+	count, _ := mgm.Coll(&models.Installation{}).CountDocuments(ctx, bson.M{"installation_id": 2})
+	assert.Equal(t, int64(1), count)
+
+	// The unique index now rejects duplicates
+	_, err = users.InsertOne(ctx, bson.M{"github_id": 1, "device_tokens": []string{"c"}})
+	assert.Error(t, err)
+}
+
+func testLatestEventsMoved(t *testing.T) {
+	ctx := context.Background()
+	users := mgm.Coll(&models.User{})
+
+	date := time.Date(2019, 5, 15, 15, 20, 18, 0, time.UTC)
+	latestEvent := models.Event{EventType: models.IssueOpened, Title: "latest", Url: "https://github.com/a/b/issues/1", Timestamp: date}
+	deliveredEvent := models.Event{EventType: models.IssueOpened, Title: "delivered", Url: "https://github.com/a/b/issues/2", Timestamp: date}
+
+	// One user's latest event predates the event history, the other's is already part of it
+	_, _ = users.InsertOne(ctx, bson.M{"github_id": 1, "device_tokens": []string{"a"}, "latest_event": latestEvent})
+	_, _ = users.InsertOne(ctx, bson.M{"github_id": 2, "device_tokens": []string{"b"}, "latest_event": deliveredEvent})
+	_ = mgm.Coll(&models.StoredEvent{}).Create(&models.StoredEvent{GithubId: 2, Event: deliveredEvent})
+
+	_, err := storage.MigrateMongo(ctx)
+	assert.NoError(t, err)
+
+	stores := storage.NewMongoStores()
+
+	for githubId, title := range map[int64]string{1: "latest", 2: "delivered"} {
+		events, err := stores.Events.List(ctx, githubId, nil, 10)
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		assert.Equal(t, title, events[0].Event.Title)
+	}
+
+	count, _ := users.CountDocuments(ctx, bson.M{"latest_event": bson.M{"$exists": true}})
+	assert.Zero(t, count)
+}
+
+// Runs against the MongoDB at `DB_URI`, and is skipped without one
+func TestMongoMigrations(t *testing.T) {
+	if os.Getenv("DB_URI") == "" {
+		t.Skip("DB_URI is not set")
+	}
+
+	err := mgm.SetDefaultConfig(nil, "push_request_migrations", options.Client().ApplyURI(os.Getenv("DB_URI")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testMap := map[string]func(*testing.T){
+		"test-migrations-apply-once": testMigrationsApplyOnce,
+		"test-concurrent-migrations": testConcurrentMigrations,
+		"test-duplicates-merged":     testDuplicatesMerged,
+		"test-latest-events-moved":   testLatestEventsMoved,
+	}
+
+	for testName, test := range testMap {
+		resetMongo(t)
+		t.Run(testName, test)
+	}
+}
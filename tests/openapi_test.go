@@ -0,0 +1,374 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/models"
+	"push-request/openapi"
+	"push-request/router"
+	"push-request/storage"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Gets the OpenAPI document the server serves
+func getAPIDocument(t *testing.T, server *handlers.Server) *openapi.Document {
+	rr := serveRoutes(server, "GET", "/openapi.json", nil, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var doc openapi.Document
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	return &doc
+}
+
+// Creates a server with a User 1 on device `a`, linked to installation 2 and subscribed to Codertocat/Hello-World#2,
+// whose feed token is `secret` and GitHub token `token`, and fake APNs and GitHub APIs with the GitHub App installed on
+// Codertocat/Hello-World
+func newOpenAPITestServer(t *testing.T) (*handlers.Server, func()) {
+	server := newTestServer()
+	ctx := context.Background()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned, models.PrOpened})
+	createInstallation(t, server, 2, 1)
+
+	_, err := storage.UpdateUser(ctx, server.Stores.Users, 1, func(user *models.User) error {
+		user.FeedToken = "secret"
+		return nil
+	})
+
+	if err == nil {
+		err = server.Stores.Subscriptions.Subscribe(ctx, 1, "Codertocat/Hello-World", 2, models.ReasonAuthor)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apns := newFakeAPNS()
+	server.APNS = apns.client()
+	server.APNSTopic = "com.example.PushRequest"
+
+	github := newFakeGithub()
+	github.respond("POST", "/repos/Codertocat/Hello-World/pulls/2/reviews", http.StatusOK, map[string]interface{}{"id": 1})
+	github.respond("GET", "/repos/Codertocat/Hello-World/installation", http.StatusOK, map[string]interface{}{"id": 2})
+	github.respondTo("GET", "/user", func(authorization string) (int, interface{}) {
+		if authorization == "token other" {
+			return http.StatusOK, map[string]interface{}{"id": 2}
+		}
+
+		return http.StatusOK, map[string]interface{}{"id": 1}
+	})
+	server.GithubBaseURL = github.baseURL()
+	server.GithubApp, _ = newTestApp(t, github.baseURL())
+
+	return server, func() {
+		apns.close()
+		github.close()
+	}
+}
+
+func fixture(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile("./fixtures/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func testOpenAPIDocumentServed(t *testing.T) {
+	doc := getAPIDocument(t, newTestServer())
+
+	assert.Equal(t, openapi.Version, doc.OpenAPI)
+	assert.Equal(t, "apiKey", doc.Components.SecuritySchemes["githubId"].Type)
+	assert.Equal(t, "Authorization", doc.Components.SecuritySchemes["githubId"].Name)
+}
+
+// Every route must be documented, and every operation documented must be routed
+func testOpenAPIRoutesDocumented(t *testing.T) {
+	server := newTestServer()
+	doc := getAPIDocument(t, server)
+	routes := server.Routes().(*router.Router).Routes()
+
+	for pattern, methods := range routes {
+		for _, method := range methods {
+			assert.NotNil(t, doc.Operation(method, pattern), "%s %s isn't documented", method, pattern)
+		}
+	}
+
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			assert.Contains(t, routes[path], method, "%s %s is documented but not routed", method, path)
+		}
+	}
+}
+
+// Sends requests covering every operation, checking that the handlers respond as the document describes
+func testOpenAPIResponses(t *testing.T) {
+	doc := getAPIDocument(t, newTestServer())
+	user := http.Header{"Authorization": {"1"}}
+	userWithToken := http.Header{"Authorization": {"1"}, "X-Github-Token": {"token"}}
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		body   string
+		setup  func(server *handlers.Server)
+		status int
+
+		// Sends the request with an API key of the role
+		role models.Role
+
+		// The path of the operation, if the request is sent to a path with parameters
+		operation string
+	}{
+		{name: "register", method: "POST", path: "/v1/users", status: http.StatusCreated,
+			body: `{"github_id": 3, "devices": [{"token": "b", "platform": "ios", "name": "iPhone"}]}`},
+		{name: "register again", method: "POST", path: "/v1/users", status: http.StatusOK,
+			body: `{"github_id": 1, "device_tokens": ["a"]}`},
+		{name: "register invalid", method: "POST", path: "/v1/users", status: http.StatusBadRequest,
+			body: `{"github_id": 3, "devices": [{"token": "xyz"}]}`},
+		{name: "register malformed", method: "POST", path: "/v1/users", status: http.StatusBadRequest, body: `{`},
+		{name: "get user", method: "GET", path: "/v1/users", header: user, status: http.StatusOK},
+		{name: "get user unauthorized", method: "GET", path: "/v1/users", status: http.StatusUnauthorized},
+		{name: "get unknown user", method: "GET", path: "/v1/users", header: http.Header{"Authorization": {"9"}},
+			status: http.StatusNotFound},
+		{name: "patch user", method: "PATCH", path: "/v1/users", header: user, status: http.StatusOK,
+			body: `{"allowed_types": ["prMerged"]}`},
+		{name: "patch user precondition", method: "PATCH", path: "/v1/users", status: http.StatusPreconditionFailed,
+			header: http.Header{"Authorization": {"1"}, "If-Match": {`"99"`}}, body: `{"allowed_types": null}`},
+		{name: "delete user", method: "DELETE", path: "/v1/users", header: userWithToken, status: http.StatusNoContent},
+		{name: "delete user without token", method: "DELETE", path: "/v1/users", header: user,
+			status: http.StatusUnauthorized},
+		{name: "export", method: "GET", path: "/v1/users/export", header: userWithToken, status: http.StatusOK},
+		{name: "export with token of another user", method: "GET", path: "/v1/users/export",
+			header: http.Header{"Authorization": {"1"}, "X-Github-Token": {"other"}}, status: http.StatusForbidden},
+		{name: "list devices", method: "GET", path: "/v1/users/devices", header: user, status: http.StatusOK},
+		{name: "patch device", method: "PATCH", path: "/v1/users/devices", header: user, status: http.StatusOK,
+			body: `{"token": "a", "name": "iPad", "allowed_types": ["prOpened"]}`},
+		{name: "patch unknown device", method: "PATCH", path: "/v1/users/devices", header: user,
+			status: http.StatusNotFound, body: `{"token": "b"}`},
+		{name: "delete device", method: "DELETE", path: "/v1/users/devices?token=a", header: user,
+			status: http.StatusNoContent},
+		{name: "delete device invalid", method: "DELETE", path: "/v1/users/devices", header: user,
+			status: http.StatusBadRequest},
+		{name: "list subscriptions", method: "GET", path: "/v1/users/subscriptions", header: user,
+			status: http.StatusOK},
+		{name: "subscribe", method: "POST", path: "/v1/users/subscriptions", header: user,
+			status: http.StatusCreated, body: `{"repo_name": "Codertocat/Hello-World", "number": 3}`},
+		{name: "mute", method: "PATCH", path: "/v1/users/subscriptions", header: user, status: http.StatusOK,
+			body: `{"repo_name": "Codertocat/Hello-World", "number": 2, "muted": true}`},
+		{name: "unsubscribe", method: "DELETE", path: "/v1/users/subscriptions?repo_name=Codertocat/Hello-World&number=2",
+			header: user, status: http.StatusNoContent},
+		{name: "unsubscribe unknown", method: "DELETE", path: "/v1/users/subscriptions?repo_name=a/b&number=1",
+			header: user, status: http.StatusNotFound},
+		{name: "webhook installation", method: "POST", path: "/v1/webhook", status: http.StatusCreated,
+			header: http.Header{"X-Github-Event": {"installation"}}, body: fixture(t, "installation.json")},
+		{name: "webhook event", method: "POST", path: "/v1/webhook", status: http.StatusOK,
+			header: http.Header{"X-Github-Event": {"issues"}}, body: fixture(t, "issue.json")},
+		{name: "webhook unsigned", method: "POST", path: "/v1/webhook", status: http.StatusUnauthorized,
+			header: http.Header{"X-Github-Event": {"issues"}}, body: fixture(t, "issue.json"),
+			setup: func(server *handlers.Server) { server.WebhookSecret = []byte("secret") }},
+		{name: "action", method: "POST", path: "/v1/actions", status: http.StatusOK,
+			header: http.Header{"Authorization": {"1"}, "X-Github-Token": {"token"}},
+			body:   `{"action": "approve", "repo_name": "Codertocat/Hello-World", "number": 2}`},
+		{name: "action refused", method: "POST", path: "/v1/actions", status: http.StatusBadGateway,
+			header: http.Header{"Authorization": {"1"}, "X-Github-Token": {"token"}},
+			body:   `{"action": "close", "repo_name": "Codertocat/Hello-World", "number": 2}`},
+		{name: "action without token", method: "POST", path: "/v1/actions", header: user,
+			status: http.StatusUnauthorized, body: `{"action": "merge", "repo_name": "Codertocat/Hello-World", "number": 2}`},
+		{name: "event types", method: "GET", path: "/v1/event-types?lang=de", status: http.StatusOK},
+		{name: "atom feed", method: "GET", path: "/v1/feed.atom?token=secret", status: http.StatusOK},
+		{name: "atom feed head", method: "HEAD", path: "/v1/feed.atom?token=secret", status: http.StatusOK},
+		{name: "rss feed", method: "GET", path: "/v1/feed.rss?token=secret&repo=a/b", status: http.StatusOK},
+		{name: "rss feed head", method: "HEAD", path: "/v1/feed.rss?token=secret", status: http.StatusOK},
+		{name: "feed unauthorized", method: "GET", path: "/v1/feed.atom?token=other", status: http.StatusUnauthorized},
+		{name: "feed not modified", method: "GET", path: "/v1/feed.rss?token=secret", status: http.StatusNotModified,
+			header: http.Header{"If-None-Match": {"*"}}},
+		{name: "stream", method: "GET", path: "/v1/events/stream", header: userWithToken, status: http.StatusOK},
+		{name: "stream without token", method: "GET", path: "/v1/events/stream", header: user,
+			status: http.StatusUnauthorized},
+		{name: "stream invalid", method: "GET", path: "/v1/events/stream?last_event_id=latest", header: userWithToken,
+			status: http.StatusBadRequest},
+		{name: "stream closed", method: "GET", path: "/v1/events/stream", header: userWithToken,
+			status: http.StatusServiceUnavailable, setup: func(server *handlers.Server) { server.CloseStreams() }},
+		{name: "health", method: "GET", path: "/healthz", status: http.StatusOK},
+		{name: "health head", method: "HEAD", path: "/healthz", status: http.StatusOK},
+		{name: "ready", method: "GET", path: "/readyz", status: http.StatusOK},
+		{name: "ready head", method: "HEAD", path: "/readyz", status: http.StatusOK},
+		{name: "not ready", method: "GET", path: "/readyz", status: http.StatusServiceUnavailable,
+			setup: func(server *handlers.Server) { server.Drain() }},
+		{name: "version", method: "GET", path: "/version", status: http.StatusOK},
+		{name: "version head", method: "HEAD", path: "/version", status: http.StatusOK},
+		{name: "metrics", method: "GET", path: "/metrics", status: http.StatusOK},
+		{name: "openapi", method: "GET", path: "/openapi.json", status: http.StatusOK},
+		{name: "admin list users", method: "GET", path: "/admin/users?disabled=false&limit=10",
+			role: models.RoleReadOnly, status: http.StatusOK},
+		{name: "admin list users unauthorized", method: "GET", path: "/admin/users", status: http.StatusUnauthorized},
+		{name: "admin get user", method: "GET", path: "/admin/users/1", operation: "/admin/users/{github_id}",
+			role: models.RoleReadOnly, status: http.StatusOK},
+		{name: "admin get unknown user", method: "GET", path: "/admin/users/9", operation: "/admin/users/{github_id}",
+			role: models.RoleReadOnly, status: http.StatusNotFound},
+		{name: "admin get devices", method: "GET", path: "/admin/users/1/devices",
+			operation: "/admin/users/{github_id}/devices", role: models.RoleReadOnly, status: http.StatusOK},
+		{name: "admin get events", method: "GET", path: "/admin/users/1/events",
+			operation: "/admin/users/{github_id}/events", role: models.RoleReadOnly, status: http.StatusOK},
+		{name: "admin test push", method: "POST", path: "/admin/users/1/test-push",
+			operation: "/admin/users/{github_id}/test-push", role: models.RoleOperator, status: http.StatusOK,
+			body: `{"message": "Hello"}`},
+		{name: "admin test push forbidden", method: "POST", path: "/admin/users/1/test-push",
+			operation: "/admin/users/{github_id}/test-push", role: models.RoleReadOnly, status: http.StatusForbidden},
+		{name: "admin disable user", method: "POST", path: "/admin/users/1/disable",
+			operation: "/admin/users/{github_id}/disable", role: models.RoleOperator, status: http.StatusOK},
+		{name: "admin enable user", method: "POST", path: "/admin/users/1/enable",
+			operation: "/admin/users/{github_id}/enable", role: models.RoleOperator, status: http.StatusOK},
+		{name: "admin list installations", method: "GET", path: "/admin/installations?after=1",
+			role: models.RoleReadOnly, status: http.StatusOK},
+		{name: "admin get installation", method: "GET", path: "/admin/installations/2",
+			operation: "/admin/installations/{installation_id}", role: models.RoleReadOnly, status: http.StatusOK},
+		{name: "admin relink installation", method: "PATCH", path: "/admin/installations/2",
+			operation: "/admin/installations/{installation_id}", role: models.RoleOperator, status: http.StatusOK,
+			body: `{"github_id": 3}`},
+		{name: "admin relink unknown installation", method: "PATCH", path: "/admin/installations/9",
+			operation: "/admin/installations/{installation_id}", role: models.RoleOperator,
+			status: http.StatusNotFound, body: `{"github_id": 3}`},
+		{name: "admin audit log", method: "GET", path: "/admin/audit-log", role: models.RoleReadOnly,
+			status: http.StatusOK},
+		{name: "admin audit log invalid", method: "GET", path: "/admin/audit-log?before=latest",
+			role: models.RoleReadOnly, status: http.StatusBadRequest},
+		{name: "disabled user", method: "GET", path: "/v1/users", header: user, status: http.StatusForbidden,
+			setup: func(server *handlers.Server) { disableUser(t, server, 1) }},
+	}
+
+	exercised := map[string]bool{}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server, closeFakes := newOpenAPITestServer(t)
+			defer closeFakes()
+
+			if testCase.setup != nil {
+				testCase.setup(server)
+			}
+
+			// Streams are ended once their backlog is written
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			req, _ := http.NewRequestWithContext(ctx, testCase.method, testCase.path, strings.NewReader(testCase.body))
+			for key, values := range testCase.header {
+				req.Header[key] = values
+			}
+
+			if testCase.role != "" {
+				req.Header.Set("Authorization", "Bearer "+createAPIKey(t, server, testCase.role))
+			}
+
+			rr := httptest.NewRecorder()
+			server.Routes().ServeHTTP(rr, req)
+			assert.Equal(t, testCase.status, rr.Code, rr.Body.String())
+
+			path := strings.SplitN(testCase.path, "?", 2)[0]
+			if testCase.operation != "" {
+				path = testCase.operation
+			}
+			operation := doc.Operation(testCase.method, path)
+			if !assert.NotNil(t, operation, "%s %s isn't documented", testCase.method, path) {
+				return
+			}
+
+			exercised[testCase.method+" "+path] = true
+
+			// Servers don't send the bodies of responses to HEAD requests
+			body := rr.Body.Bytes()
+			if testCase.method == "HEAD" {
+				body = nil
+			}
+
+			assert.NoError(t, doc.ValidateResponse(operation, rr.Code, rr.Header(), body))
+		})
+	}
+
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			assert.True(t, exercised[method+" "+path], "%s %s isn't tested", method, path)
+		}
+	}
+}
+
+func testOpenAPIRequestValidation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		method  string
+		path    string
+		header  http.Header
+		body    string
+		code    handlers.ErrorCode
+		details []handlers.FieldError
+	}{
+		{
+			name: "query", method: "DELETE", path: "/v1/users/subscriptions?repo_name=a/b&number=two",
+			header: http.Header{"Authorization": {"1"}}, code: handlers.CodeValidationFailed,
+			details: []handlers.FieldError{{Field: "number", Message: "must be a number"}},
+		},
+		{
+			name: "header", method: "POST", path: "/v1/webhook", body: `{}`, code: handlers.CodeValidationFailed,
+			details: []handlers.FieldError{{Field: "X-GitHub-Event", Message: "is required"}},
+		},
+		{
+			name: "body", method: "POST", path: "/v1/users", code: handlers.CodeValidationFailed,
+			body: `{"github_id": "1", "devices": [{"token": "a", "name": null}]}`,
+			details: []handlers.FieldError{
+				{Field: "devices[0].name", Message: "must not be null"},
+				{Field: "github_id", Message: "must be a number"},
+			},
+		},
+		{
+			name: "missing body", method: "PATCH", path: "/v1/users/devices", header: http.Header{"Authorization": {"1"}},
+			code: handlers.CodeValidationFailed, details: []handlers.FieldError{{Field: "body", Message: "is required"}},
+		},
+		{
+			name: "malformed body", method: "POST", path: "/v1/users/subscriptions", body: `{"repo_name": }`,
+			header: http.Header{"Authorization": {"1"}}, code: handlers.CodeInvalidRequest,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := newTestServer()
+			createUser(t, server, 1, "a", nil)
+
+			rr := serveRoutes(server, testCase.method, testCase.path, []byte(testCase.body), testCase.header)
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+			body := decodeErrorResponse(t, rr)
+			assert.Equal(t, testCase.code, body.Code)
+			assert.Equal(t, testCase.details, body.Details)
+		})
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-openapi-document-served":    testOpenAPIDocumentServed,
+		"test-openapi-routes-documented":  testOpenAPIRoutesDocumented,
+		"test-openapi-responses":          testOpenAPIResponses,
+		"test-openapi-request-validation": testOpenAPIRequestValidation,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
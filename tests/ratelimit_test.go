@@ -0,0 +1,277 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"github.com/Kamva/mgm"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"push-request/handlers"
+	"push-request/ratelimit"
+	"push-request/storage"
+	"testing"
+	"time"
+)
+
+// A clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) advance(duration time.Duration) {
+	clock.now = clock.now.Add(duration)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// Creates a limiter of 1 request per second with a burst of 2, on a fake clock
+func newTestLimiter(name string, store ratelimit.Store) (*ratelimit.Limiter, *fakeClock) {
+	clock := newFakeClock()
+
+	limiter := ratelimit.New(name, ratelimit.Limit{Rate: 1, Burst: 2}, store)
+	limiter.Now = clock.Now
+
+	return limiter, clock
+}
+
+// Checks that the store refills buckets at the rate of their limit, up to their burst
+func testTokenBuckets(t *testing.T, store ratelimit.Store) {
+	ctx := context.Background()
+	limiter, clock := newTestLimiter("test", store)
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "a")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+
+	result, err := limiter.Allow(ctx, "a")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	// Other clients have their own bucket
+	result, _ = limiter.Allow(ctx, "b")
+	assert.True(t, result.Allowed)
+
+	clock.advance(750 * time.Millisecond)
+
+	result, _ = limiter.Allow(ctx, "a")
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 250*time.Millisecond, result.RetryAfter)
+
+	clock.advance(250 * time.Millisecond)
+
+	result, _ = limiter.Allow(ctx, "a")
+	assert.True(t, result.Allowed)
+
+	// Buckets don't fill up past their burst
+	clock.advance(time.Hour)
+
+	for i := 0; i < 2; i++ {
+		result, _ = limiter.Allow(ctx, "a")
+		assert.True(t, result.Allowed)
+	}
+
+	result, _ = limiter.Allow(ctx, "a")
+	assert.False(t, result.Allowed)
+}
+
+func testMemoryStoreBuckets(t *testing.T) {
+	testTokenBuckets(t, ratelimit.NewMemoryStore())
+}
+
+func testMemoryStoreSweep(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limiter, clock := newTestLimiter("test", store)
+
+	for _, client := range []string{"a", "b", "c"} {
+		_, _ = limiter.Allow(context.Background(), client)
+	}
+
+	assert.Equal(t, 3, store.Len())
+
+	clock.advance(time.Minute)
+	_, _ = limiter.Allow(context.Background(), "a")
+
+	assert.Equal(t, 1, store.Len())
+}
+
+func testLimitDisabled(t *testing.T) {
+	limiter := ratelimit.New("test", ratelimit.PerMinute(0, 10), ratelimit.NewMemoryStore())
+
+	for i := 0; i < 20; i++ {
+		result, err := limiter.Allow(context.Background(), "a")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+}
+
+// Sends the request from the IP
+func serveFrom(server *handlers.Server, ip string, method string, path string, body []byte, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+	req.RemoteAddr = ip + ":51234"
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func assertRateLimited(t *testing.T, rr *httptest.ResponseRecorder, retryAfter string) {
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, retryAfter, rr.Header().Get("Retry-After"))
+	assert.Equal(t, handlers.CodeRateLimited, decodeErrorResponse(t, rr).Code)
+}
+
+func testRateLimitIP(t *testing.T) {
+	server := newTestServer()
+	server.IPLimiter, _ = newTestLimiter("ip", ratelimit.NewMemoryStore())
+	server.IPLimiter.Limit.Rate = 0.1
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, serveFrom(server, "192.0.2.1", "GET", "/v1/event-types", nil, nil).Code)
+	}
+
+	assertRateLimited(t, serveFrom(server, "192.0.2.1", "GET", "/event-types", nil, nil), "10")
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.RateLimited.WithLabelValues("ip")))
+
+	assert.Equal(t, http.StatusOK, serveFrom(server, "192.0.2.2", "GET", "/v1/event-types", nil, nil).Code)
+
+	// Probes and webhooks aren't limited by IP
+	assert.Equal(t, http.StatusOK, serveFrom(server, "192.0.2.1", "GET", "/healthz", nil, nil).Code)
+	assert.Equal(t, http.StatusOK, serveFrom(server, "192.0.2.1", "POST", "/v1/webhook", []byte(`{}`),
+		http.Header{"X-Github-Event": {"ping"}}).Code)
+}
+
+func testRateLimitTrustProxy(t *testing.T) {
+	server := newTestServer()
+	server.IPLimiter, _ = newTestLimiter("ip", ratelimit.NewMemoryStore())
+	server.TrustProxy = true
+
+	// The entries the client sent itself don't change the IP it is limited by
+	for _, forwarded := range []string{"198.51.100.1, 203.0.113.7", "198.51.100.2, 203.0.113.7", "203.0.113.7"} {
+		serveFrom(server, "10.0.0.1", "GET", "/v1/event-types", nil, http.Header{"X-Forwarded-For": {forwarded}})
+	}
+
+	rr := serveFrom(server, "10.0.0.2", "GET", "/v1/event-types", nil, http.Header{"X-Forwarded-For": {"203.0.113.7"}})
+	assertRateLimited(t, rr, "1")
+
+	rr = serveFrom(server, "10.0.0.1", "GET", "/v1/event-types", nil, http.Header{"X-Forwarded-For": {"203.0.113.8"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func testRateLimitUser(t *testing.T) {
+	server := newTestServer()
+	server.UserLimiter, _ = newTestLimiter("user", ratelimit.NewMemoryStore())
+
+	createUser(t, server, 1, "a", nil)
+	createUser(t, server, 2, "b", nil)
+
+	user := http.Header{"Authorization": {"1"}}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, serveRoutes(server, "GET", "/v1/users/devices", nil, user).Code)
+	}
+
+	assertRateLimited(t, serveRoutes(server, "GET", "/v1/users", nil, user), "1")
+	assert.Equal(t, http.StatusOK, serveRoutes(server, "GET", "/v1/users/devices", nil,
+		http.Header{"Authorization": {"2"}}).Code)
+}
+
+func testRateLimitInstallation(t *testing.T) {
+	server := newTestServer()
+	server.InstallationLimiter, _ = newTestLimiter("installation", ratelimit.NewMemoryStore())
+
+	createInstallation(t, server, 2, 1)
+	payload := []byte(fixture(t, "issue.json"))
+
+	// The installation of unsigned webhooks can't be trusted, so they aren't limited by it
+	for i := 0; i < 3; i++ {
+		assert.NotEqual(t, http.StatusTooManyRequests, postWebhook(server, "issues", payload).Code)
+	}
+
+	server.WebhookSecret = []byte("webhook-secret")
+
+	for i := 0; i < 2; i++ {
+		postWebhook(server, "issues", payload)
+	}
+
+	assertRateLimited(t, postWebhook(server, "issues", payload), "1")
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.RateLimited.WithLabelValues("installation")))
+
+	// Webhooks without an installation aren't limited
+	rr := postWebhook(server, "github_app_authorization", []byte(fixture(t, "github_app_authorization.json")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func testDeviceLimit(t *testing.T) {
+	server := newTestServer()
+	server.MaxDevicesPerUser = 2
+
+	rr := postUser(server, map[string]interface{}{"github_id": 1, "device_tokens": []string{"a", "b", "b"}})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	// Devices the User already has are registered again every time the app launches
+	rr = postUser(server, map[string]interface{}{"github_id": 1, "device_tokens": []string{"a"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = postUser(server, map[string]interface{}{"github_id": 1, "devices": []map[string]string{{"token": "c"}}})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, []handlers.FieldError{{Field: "devices", Message: "a User can have at most 2 devices"}},
+		decodeErrorResponse(t, rr).Details)
+	assert.Equal(t, 1.0, testutil.ToFloat64(server.Metrics.RateLimited.WithLabelValues("devices")))
+
+	user, err := server.Stores.Users.Get(context.Background(), 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"a", "b"}, user.DeviceTokens)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-memory-store-buckets":    testMemoryStoreBuckets,
+		"test-memory-store-sweep":      testMemoryStoreSweep,
+		"test-limit-disabled":          testLimitDisabled,
+		"test-rate-limit-ip":           testRateLimitIP,
+		"test-rate-limit-trust-proxy":  testRateLimitTrustProxy,
+		"test-rate-limit-user":         testRateLimitUser,
+		"test-rate-limit-installation": testRateLimitInstallation,
+		"test-device-limit":            testDeviceLimit,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
+
+// Runs against the MongoDB at `DB_URI`, and is skipped without one
+func TestMongoRateLimit(t *testing.T) {
+	if os.Getenv("DB_URI") == "" {
+		t.Skip("DB_URI is not set")
+	}
+
+	err := mgm.SetDefaultConfig(nil, "push_request_rate_limits", options.Client().ApplyURI(os.Getenv("DB_URI")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mgm.CollectionByName(storage.RateLimitsCollection).Drop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	testTokenBuckets(t, ratelimit.NewMongoStore())
+}
@@ -0,0 +1,227 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/models"
+	"push-request/router"
+	"push-request/storage"
+	"strings"
+	"testing"
+)
+
+// Serves the request through the routes of the server
+func serveRoutes(server *handlers.Server, method string, path string, body []byte, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func testRouterDispatch(t *testing.T) {
+	var calls []string
+
+	routes := router.New()
+	routes.UseRoute(func(pattern string, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "route "+pattern)
+			next.ServeHTTP(w, r)
+		})
+	})
+	routes.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "use")
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, name+" "+router.Param(r, "id"))
+		}
+	}
+
+	routes.HandleFunc("GET", "/v1/repos/{id}", handler("get"))
+	routes.HandleFunc("POST", "/v1/repos/{id}", handler("post"))
+	routes.HandleFunc("GET", "/v1/repos/new", handler("new"))
+	routes.Alias("/repos/{id}", "/v1/repos/{id}")
+
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		calls = nil
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	serve("GET", "/v1/repos/42")
+	assert.Equal(t, []string{"route /v1/repos/{id}", "use", "get 42"}, calls)
+
+	// Aliases are served by the routes of their pattern, and paths without parameters are preferred
+	serve("POST", "/repos/7")
+	assert.Equal(t, []string{"route /v1/repos/{id}", "use", "post 7"}, calls)
+
+	serve("GET", "/v1/repos/new")
+	assert.Equal(t, []string{"route /v1/repos/new", "use", "new "}, calls)
+
+	rr := serve("DELETE", "/v1/repos/42")
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "GET, POST", rr.Header().Get("Allow"))
+	assert.Equal(t, []string{"route unmatched", "use"}, calls)
+
+	rr = serve("GET", "/v1/repos/42/issues")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, []string{"route unmatched", "use"}, calls)
+
+	assert.Panics(t, func() { routes.HandleFunc("GET", "/v1/repos/{id}", handler("again")) })
+	assert.Panics(t, func() { routes.Use(nil) })
+	assert.Panics(t, func() { routes.Alias("/things", "/v1/things") })
+}
+
+func testRoutesVersioned(t *testing.T) {
+	server := newTestServer()
+	createUser(t, server, 1, "a", []models.EventType{models.PrMerged})
+
+	header := http.Header{"Authorization": {"1"}}
+
+	versioned := serveRoutes(server, "GET", "/v1/users/devices", nil, header)
+	unversioned := serveRoutes(server, "GET", "/users/devices", nil, header)
+
+	assert.Equal(t, http.StatusOK, versioned.Code)
+	assert.Equal(t, versioned.Body.String(), unversioned.Body.String())
+
+	rr := serveRoutes(server, "POST", "/v1/users/devices", nil, header)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "DELETE, GET, PATCH", rr.Header().Get("Allow"))
+	assert.Equal(t, handlers.CodeMethodNotAllowed, decodeErrorResponse(t, rr).Code)
+
+	rr = serveRoutes(server, "GET", "/v2/users", nil, header)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, handlers.CodeNotFound, decodeErrorResponse(t, rr).Code)
+	assert.NotEmpty(t, rr.Header().Get("X-Request-Id"))
+
+	// Routes that require a User are authenticated before their handler is called
+	rr = serveRoutes(server, "GET", "/v1/users/subscriptions", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func testRoutesRecoverPanics(t *testing.T) {
+	stores := storage.NewMemoryStores()
+	stores.Users = &panickingUserStore{stores.Users}
+	server := handlers.NewServer(stores)
+
+	req, _ := http.NewRequest("GET", "/v1/users", nil)
+	req.Header.Set("Authorization", "1")
+
+	rr, buffer := serveLogged(server.Routes(), req)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, handlers.CodeInternalError, decodeErrorResponse(t, rr).Code)
+
+	line := findLogLine(decodeLogLines(t, buffer), "handler panicked")
+	if assert.NotNil(t, line) {
+		assert.Equal(t, "user store panicked", line["panic"])
+		assert.Contains(t, line["stack"], "panickingUserStore")
+		assert.Equal(t, rr.Header().Get("X-Request-Id"), line["request_id"])
+	}
+}
+
+func testRoutesCORS(t *testing.T) {
+	server := newTestServer()
+	server.CORSOrigins = []string{"https://app.example.com"}
+
+	preflight := http.Header{
+		"Origin":                         {"https://app.example.com"},
+		"Access-Control-Request-Method":  {"PATCH"},
+		"Access-Control-Request-Headers": {"authorization, if-match"},
+	}
+
+	rr := serveRoutes(server, "OPTIONS", "/v1/users", nil, preflight)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "DELETE, GET, PATCH, POST", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Contains(t, rr.Header().Get("Access-Control-Allow-Headers"), "If-Match")
+
+	preflight.Set("Origin", "https://evil.example.com")
+
+	rr = serveRoutes(server, "OPTIONS", "/v1/users", nil, preflight)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+
+	rr = serveRoutes(server, "GET", "/v1/event-types", nil, http.Header{"Origin": {"https://app.example.com"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rr.Header().Get("Access-Control-Expose-Headers"), "X-Request-Id")
+}
+
+func testRoutesBodyLimit(t *testing.T) {
+	server := newTestServer()
+	server.MaxBodyBytes = 64
+
+	body, _ := json.Marshal(map[string]interface{}{"github_id": 1, "device_tokens": []string{strings.Repeat("a", 64)}})
+
+	rr := serveRoutes(server, "POST", "/v1/users", body, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, handlers.CodePayloadTooLarge, decodeErrorResponse(t, rr).Code)
+
+	// Webhooks have a limit of their own
+	data, _ := ioutil.ReadFile("./fixtures/github_app_authorization.json")
+	webhook := http.Header{"X-Github-Event": {"github_app_authorization"}}
+
+	rr = serveRoutes(server, "POST", "/v1/webhook", data, webhook)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	server.MaxWebhookBytes = 64
+
+	rr = serveRoutes(server, "POST", "/v1/webhook", data, webhook)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
+func testRoutesCompression(t *testing.T) {
+	server := newTestServer()
+
+	rr := serveRoutes(server, "GET", "/v1/event-types", nil, http.Header{"Accept-Encoding": {"gzip, deflate"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, []string{"Accept-Encoding", "Accept-Language"}, rr.Header()["Vary"])
+
+	reader, err := gzip.NewReader(rr.Body)
+	if assert.NoError(t, err) {
+		var response eventTypesResponse
+		assert.NoError(t, json.NewDecoder(reader).Decode(&response))
+		assert.NotEmpty(t, response.EventTypes)
+	}
+
+	rr = serveRoutes(server, "GET", "/v1/event-types", nil, nil)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.True(t, json.Valid(rr.Body.Bytes()))
+
+	// Responses without a body aren't compressed
+	rr = serveRoutes(server, "HEAD", "/healthz", nil, http.Header{"Accept-Encoding": {"gzip"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+}
+
+func TestRoutes(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-router-dispatch":       testRouterDispatch,
+		"test-routes-versioned":      testRoutesVersioned,
+		"test-routes-recover-panics": testRoutesRecoverPanics,
+		"test-routes-cors":           testRoutesCORS,
+		"test-routes-body-limit":     testRoutesBodyLimit,
+		"test-routes-compression":    testRoutesCompression,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
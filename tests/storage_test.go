@@ -0,0 +1,679 @@
+package tests
+
+import (
+	"context"
+	"github.com/Kamva/mgm"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"path/filepath"
+	"push-request/handlers"
+	"push-request/models"
+	"push-request/storage"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Creates a Server backed by empty in-memory stores
+func newTestServer() *handlers.Server {
+	return handlers.NewServer(storage.NewMemoryStores())
+}
+
+func createUser(t *testing.T, server *handlers.Server, githubId int64, deviceToken string, allowedTypes []models.EventType) *models.User {
+	user, err := models.NewUser(githubId, deviceToken, allowedTypes)
+	if err == nil {
+		err = server.Stores.Users.Create(context.Background(), user)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func createInstallation(t *testing.T, server *handlers.Server, installationId int64, githubId int64) {
+	installation := &models.Installation{Id: installationId, GithubId: githubId, AccountId: githubId}
+
+	if err := server.Stores.Installations.Create(context.Background(), installation); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testUserStore(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	_, err := stores.Users.Get(ctx, 1)
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	user, _ := models.NewUser(1, "a", []models.EventType{models.IssueOpened})
+	assert.NoError(t, stores.Users.Create(ctx, user))
+
+	user.DeviceTokens = append(user.DeviceTokens, "b")
+	assert.NoError(t, stores.Users.Update(ctx, user))
+
+	got, err := stores.Users.Get(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, got.DeviceTokens)
+	assert.Equal(t, []models.EventType{models.IssueOpened}, got.AllowedTypes)
+
+	got, err = stores.Users.GetByFeedToken(ctx, user.FeedToken)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), got.GithubId)
+
+	_, err = stores.Users.GetByFeedToken(ctx, "invalid")
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func testUserRegistration(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	created, err := stores.Users.Register(ctx, 1, models.Device{Token: "a"}, nil, 0)
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	created, err = stores.Users.Register(ctx, 1, models.Device{Token: "b"}, []models.EventType{models.IssueOpened}, 0)
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	// Registering a device twice doesn't add it twice
+	_, _ = stores.Users.Register(ctx, 1, models.Device{Token: "a"}, nil, 0)
+
+	user, err := stores.Users.Get(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, user.DeviceTokens)
+	assert.Equal(t, []models.EventType{models.IssueOpened}, user.AllowedTypes)
+	assert.NotEmpty(t, user.FeedToken)
+
+	// A device signed in to another account is moved to it
+	_, _ = stores.Users.Register(ctx, 2, models.Device{Token: "a"}, nil, 0)
+
+	user, _ = stores.Users.Get(ctx, 1)
+	assert.Equal(t, []string{"b"}, user.DeviceTokens)
+	assert.Len(t, user.ListDevices(), 1)
+
+	other, _ := stores.Users.Get(ctx, 2)
+	assert.Equal(t, []string{"a"}, other.DeviceTokens)
+
+	// A user registered without allowed types is given the defaults
+	assert.Equal(t, models.DefaultEventTypes(), other.AllowedTypes)
+
+	assert.NoError(t, stores.Users.RemoveDevice(ctx, 2, "a"))
+	assert.Equal(t, storage.ErrNotFound, stores.Users.RemoveDevice(ctx, 2, "a"))
+
+	other, _ = stores.Users.Get(ctx, 2)
+	assert.Empty(t, other.DeviceTokens)
+}
+
+func testDeviceDetails(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	iPad := models.Device{
+		Token:       "a",
+		Platform:    models.PlatformIPadOS,
+		Environment: models.APNSSandbox,
+		AppVersion:  "1.2.0",
+		Locale:      "fr_FR",
+		Name:        "iPad",
+	}
+
+	before := time.Now().Add(-time.Second)
+	_, _ = stores.Users.Register(ctx, 1, iPad, []models.EventType{models.IssueOpened}, 0)
+
+	user, _ := stores.Users.Get(ctx, 1)
+	devices := user.ListDevices()
+	assert.Len(t, devices, 1)
+
+	got := devices[0]
+	assert.NotNil(t, got.LastSeenAt)
+	assert.True(t, got.LastSeenAt.After(before))
+
+	got.LastSeenAt = nil
+	assert.Equal(t, iPad, got)
+
+	// The iPad only gets merged pull requests
+	_, err := storage.UpdateUser(ctx, stores.Users, 1, func(user *models.User) error {
+		device := user.Devices["a"]
+		device.AllowedTypes = []models.EventType{models.PrMerged}
+		user.Devices["a"] = device
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// Registering again updates the details given, and keeps the others
+	_, _ = stores.Users.Register(ctx, 1, models.Device{Token: "a", AppVersion: "1.3.0"}, nil, 0)
+
+	user, _ = stores.Users.Get(ctx, 1)
+	got = user.ListDevices()[0]
+	assert.Equal(t, "1.3.0", got.AppVersion)
+	assert.Equal(t, "iPad", got.Name)
+	assert.Equal(t, models.APNSSandbox, got.Environment)
+	assert.Equal(t, []models.EventType{models.PrMerged}, got.AllowedTypes)
+	assert.True(t, user.AllowsEventType(models.PrMerged))
+	assert.False(t, user.AllowsEventType(models.PrClosed))
+}
+
+func testUserDeviceLimit(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	for _, token := range []string{"a", "b", "a"} {
+		_, err := stores.Users.Register(ctx, 1, models.Device{Token: token}, nil, 2)
+		assert.NoError(t, err)
+	}
+
+	_, err := stores.Users.Register(ctx, 1, models.Device{Token: "c"}, []models.EventType{models.PrMerged}, 2)
+	assert.Equal(t, storage.ErrTooManyDevices, err)
+
+	// A refused device is neither added to the user nor removed from the one it was registered to
+	_, _ = stores.Users.Register(ctx, 2, models.Device{Token: "c"}, nil, 0)
+	_, err = stores.Users.Register(ctx, 1, models.Device{Token: "c"}, nil, 2)
+	assert.Equal(t, storage.ErrTooManyDevices, err)
+
+	user, _ := stores.Users.Get(ctx, 1)
+	assert.Equal(t, []string{"a", "b"}, user.DeviceTokens)
+	assert.Equal(t, models.DefaultEventTypes(), user.AllowedTypes)
+
+	other, _ := stores.Users.Get(ctx, 2)
+	assert.Equal(t, []string{"c"}, other.DeviceTokens)
+
+	// Registrations at the same time can't exceed the limit together
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func(token string) {
+			defer wg.Done()
+
+			_, err := stores.Users.Register(ctx, 3, models.Device{Token: token}, nil, 2)
+			if err != storage.ErrTooManyDevices {
+				assert.NoError(t, err)
+			}
+		}(strconv.Itoa(i))
+	}
+
+	wg.Wait()
+
+	user, _ = stores.Users.Get(ctx, 3)
+	assert.Len(t, user.DeviceTokens, 2)
+}
+
+func testUserConcurrentUpdates(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	user, _ := models.NewUser(1, "a", []models.EventType{models.IssueOpened})
+	assert.NoError(t, stores.Users.Create(ctx, user))
+
+	// Two requests read the user at the same time, and each changes a different field
+	first, _ := stores.Users.Get(ctx, 1)
+	second, _ := stores.Users.Get(ctx, 1)
+
+	first.AllowedTypes = []models.EventType{models.PrMerged}
+	assert.NoError(t, stores.Users.Update(ctx, first))
+
+	// Replacing the user with the second copy would erase the first change
+	second.DeviceTokens = append(second.DeviceTokens, "b")
+	assert.Equal(t, storage.ErrConflict, stores.Users.Update(ctx, second))
+
+	got, _ := stores.Users.Get(ctx, 1)
+	assert.Equal(t, []models.EventType{models.PrMerged}, got.AllowedTypes)
+	assert.Equal(t, []string{"a"}, got.DeviceTokens)
+	assert.Equal(t, first.Version, got.Version)
+
+	// Retried updates are all kept
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func(token string) {
+			defer wg.Done()
+
+			_, err := storage.UpdateUser(ctx, stores.Users, 1, func(user *models.User) error {
+				user.DeviceTokens = append(user.DeviceTokens, token)
+				return nil
+			})
+			assert.NoError(t, err)
+		}(strconv.Itoa(i))
+	}
+
+	wg.Wait()
+
+	got, _ = stores.Users.Get(ctx, 1)
+	assert.ElementsMatch(t, []string{"a", "0", "1", "2", "3", "4"}, got.DeviceTokens)
+
+	// Device changes made outside of Update also conflict with stale copies
+	_, _ = stores.Users.Register(ctx, 1, models.Device{Token: "a", Name: "iPhone"}, nil, 0)
+	assert.Equal(t, storage.ErrConflict, stores.Users.Update(ctx, got))
+
+	_, err := storage.UpdateUser(ctx, stores.Users, 2, func(user *models.User) error { return nil })
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func testUserDeletion(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	for _, githubId := range []int64{1, 2} {
+		user, _ := models.NewUser(githubId, strconv.FormatInt(githubId, 10), []models.EventType{models.IssueOpened})
+		assert.NoError(t, stores.Users.Create(ctx, user))
+		assert.NoError(t, stores.Installations.Create(ctx, &models.Installation{Id: githubId * 10, GithubId: githubId, AccountId: githubId}))
+		assert.NoError(t, stores.Subscriptions.Subscribe(ctx, githubId, "Codertocat/Hello-World", 1, models.ReasonAuthor))
+		_, err := stores.Events.Create(ctx, githubId, &models.Event{Title: "event"})
+		assert.NoError(t, err)
+	}
+
+	_, _ = stores.Events.Create(ctx, 1, &models.Event{Title: "other event"})
+	assert.NoError(t, stores.Installations.Create(ctx, &models.Installation{Id: 11, GithubId: 1, AccountId: 1}))
+
+	export, err := storage.ExportUser(ctx, stores, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), export.User.GithubId)
+	assert.Len(t, export.Devices, 1)
+	assert.Len(t, export.Installations, 2)
+	assert.Equal(t, int64(10), export.Installations[0].Id)
+	assert.Len(t, export.Subscriptions, 1)
+	assert.Len(t, export.Events, 2)
+	assert.Equal(t, "event", export.Events[0].Event.Title)
+
+	assert.NoError(t, storage.DeleteUser(ctx, stores, 1))
+	assert.Equal(t, storage.ErrNotFound, storage.DeleteUser(ctx, stores, 1))
+
+	_, err = stores.Users.Get(ctx, 1)
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	// Installations are unlinked rather than deleted, keeping their account
+	installations, _ := stores.Installations.ListByGithubId(ctx, 1)
+	assert.Empty(t, installations)
+
+	installation, err := stores.Installations.Get(ctx, 10)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), installation.GithubId)
+		assert.Equal(t, int64(1), installation.AccountId)
+	}
+
+	subscriptions, _ := stores.Subscriptions.ListByUser(ctx, 1)
+	assert.Empty(t, subscriptions)
+
+	events, _ := stores.Events.List(ctx, 1, nil, 10)
+	assert.Empty(t, events)
+
+	// Other users are left untouched
+	_, err = stores.Users.Get(ctx, 2)
+	assert.NoError(t, err)
+
+	installations, _ = stores.Installations.ListByGithubId(ctx, 2)
+	assert.Len(t, installations, 1)
+
+	subscriptions, _ = stores.Subscriptions.ListByUser(ctx, 2)
+	assert.Len(t, subscriptions, 1)
+
+	events, _ = stores.Events.List(ctx, 2, nil, 10)
+	assert.Len(t, events, 1)
+
+	// The installations on the account of a user are linked to them again
+	assert.NoError(t, stores.Installations.LinkAccount(ctx, 1))
+
+	installations, _ = stores.Installations.ListByGithubId(ctx, 1)
+	assert.Len(t, installations, 2)
+
+	installations, _ = stores.Installations.ListByGithubId(ctx, 2)
+	assert.Len(t, installations, 1)
+}
+
+func testInstallationStore(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	assert.NoError(t, stores.Installations.Create(ctx, &models.Installation{Id: 2, GithubId: 1}))
+
+	installation, err := stores.Installations.Get(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), installation.GithubId)
+
+	installation, err = stores.Installations.GetByGithubId(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), installation.Id)
+
+	_, err = stores.Installations.Get(ctx, 3)
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	assert.NoError(t, stores.Installations.Create(ctx, &models.Installation{Id: 4, GithubId: 5}))
+	assert.NoError(t, stores.Installations.Create(ctx, &models.Installation{Id: 3, GithubId: 1}))
+
+	installations, err := stores.Installations.List(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, installations, 3)
+	assert.Equal(t, int64(2), installations[0].Id)
+
+	installations, _ = stores.Installations.List(ctx, 2, 1)
+	if assert.Len(t, installations, 1) {
+		assert.Equal(t, int64(3), installations[0].Id)
+	}
+
+	assert.NoError(t, stores.Installations.Link(ctx, 3, 5))
+
+	installations, _ = stores.Installations.ListByGithubId(ctx, 5)
+	assert.Len(t, installations, 2)
+
+	assert.Equal(t, storage.ErrNotFound, stores.Installations.Link(ctx, 9, 5))
+}
+
+func testUserList(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	for githubId := int64(3); githubId >= 1; githubId-- {
+		user, _ := models.NewUser(githubId, "device"+strconv.FormatInt(githubId, 10), nil)
+		assert.NoError(t, stores.Users.Create(ctx, user))
+	}
+
+	_, err := storage.UpdateUser(ctx, stores.Users, 2, func(user *models.User) error {
+		user.Disabled = true
+		return nil
+	})
+	assert.NoError(t, err)
+
+	githubIds := func(query storage.UserQuery) []int64 {
+		users, err := stores.Users.List(ctx, query)
+		assert.NoError(t, err)
+
+		res := []int64{}
+		for _, user := range users {
+			res = append(res, user.GithubId)
+		}
+
+		return res
+	}
+
+	disabled, enabled := true, false
+
+	assert.Equal(t, []int64{1, 2, 3}, githubIds(storage.UserQuery{Limit: 10}))
+	assert.Equal(t, []int64{2}, githubIds(storage.UserQuery{After: 1, Limit: 1}))
+	assert.Equal(t, []int64{3}, githubIds(storage.UserQuery{DeviceToken: "device3", Limit: 10}))
+	assert.Equal(t, []int64{}, githubIds(storage.UserQuery{DeviceToken: "device3", After: 3, Limit: 10}))
+	assert.Equal(t, []int64{2}, githubIds(storage.UserQuery{Disabled: &disabled, Limit: 10}))
+	assert.Equal(t, []int64{1, 3}, githubIds(storage.UserQuery{Disabled: &enabled, Limit: 10}))
+
+	users, _ := stores.Users.List(ctx, storage.UserQuery{Disabled: &disabled, Limit: 10})
+	if assert.Len(t, users, 1) {
+		assert.True(t, users[0].Disabled)
+		assert.Equal(t, []string{"device2"}, users[0].DeviceTokens)
+	}
+}
+
+func testAPIKeyStore(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	first, key, err := models.NewAPIKey("first", models.RoleOperator)
+	assert.NoError(t, err)
+	assert.NoError(t, stores.APIKeys.Create(ctx, first))
+
+	second, _, _ := models.NewAPIKey("second", models.RoleReadOnly)
+	assert.NoError(t, stores.APIKeys.Create(ctx, second))
+
+	stored, err := stores.APIKeys.Get(ctx, first.KeyId)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", stored.Name)
+	assert.Equal(t, models.RoleOperator, stored.Role)
+	assert.True(t, stored.Matches(key))
+
+	keys, err := stores.APIKeys.List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, first.KeyId, keys[0].KeyId)
+		assert.Equal(t, second.KeyId, keys[1].KeyId)
+	}
+
+	assert.NoError(t, stores.APIKeys.Delete(ctx, first.KeyId))
+	assert.Equal(t, storage.ErrNotFound, stores.APIKeys.Delete(ctx, first.KeyId))
+
+	_, err = stores.APIKeys.Get(ctx, first.KeyId)
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func testAuditStore(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+
+	for _, action := range []string{"users.get", "users.disable", "users.enable"} {
+		entry := &models.AuditEntry{KeyId: "a", KeyName: "key", Role: models.RoleOperator, Action: action,
+			Target: "user:1", Status: 200}
+		assert.NoError(t, stores.Audit.Create(ctx, entry))
+	}
+
+	entries, err := stores.Audit.List(ctx, primitive.NilObjectID, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "users.enable", entries[0].Action)
+		assert.Equal(t, "user:1", entries[0].Target)
+		assert.Equal(t, 200, entries[0].Status)
+		assert.Equal(t, "users.disable", entries[1].Action)
+	}
+
+	entries, err = stores.Audit.List(ctx, entries[1].ID, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "users.get", entries[0].Action)
+	}
+}
+
+func testEventStore(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+	date := time.Date(2019, 5, 15, 15, 20, 0, 0, time.UTC)
+
+	first, err := stores.Events.Create(ctx, 1, &models.Event{RepoName: "Codertocat/Hello-World", Title: "first", Timestamp: date})
+	assert.NoError(t, err)
+
+	second, _ := stores.Events.Create(ctx, 1, &models.Event{RepoName: "Codertocat/Other", Title: "second", Timestamp: date.Add(time.Minute)})
+	_, _ = stores.Events.Create(ctx, 2, &models.Event{RepoName: "Codertocat/Hello-World", Title: "other user", Timestamp: date})
+
+	events, err := stores.Events.List(ctx, 1, nil, 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "second", events[0].Event.Title)
+
+	events, _ = stores.Events.List(ctx, 1, []string{"Codertocat/Hello-World"}, 10)
+	assert.Len(t, events, 1)
+	assert.Equal(t, first.ID, events[0].ID)
+
+	events, _ = stores.Events.List(ctx, 1, nil, 1)
+	assert.Len(t, events, 1)
+
+	events, err = stores.Events.ListAfter(ctx, 1, first.ID, 10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, second.ID, events[0].ID)
+
+	events, _ = stores.Events.ListAfter(ctx, 1, primitive.NilObjectID, 10)
+	assert.Len(t, events, 2)
+	assert.Equal(t, first.ID, events[0].ID)
+}
+
+func testSubscriptionStore(t *testing.T, stores *storage.Stores) {
+	ctx := context.Background()
+	repoName := "Codertocat/Hello-World"
+
+	assert.NoError(t, stores.Subscriptions.Subscribe(ctx, 1, repoName, 1, models.ReasonAuthor))
+	assert.NoError(t, stores.Subscriptions.Subscribe(ctx, 1, repoName, 1, models.ReasonCommented))
+
+	subscription, err := stores.Subscriptions.Get(ctx, 1, repoName, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ReasonAuthor, subscription.Reason)
+
+	subscription.Muted = true
+	assert.NoError(t, stores.Subscriptions.Update(ctx, subscription))
+
+	// Subscribing again must not unmute the thread
+	assert.NoError(t, stores.Subscriptions.Subscribe(ctx, 1, repoName, 1, models.ReasonCommented))
+
+	subscription, _ = stores.Subscriptions.Get(ctx, 1, repoName, 1)
+	assert.True(t, subscription.Muted)
+
+	other := &models.Subscription{GithubId: 2, RepoName: repoName, Number: 1, Reason: models.ReasonManual}
+	assert.NoError(t, stores.Subscriptions.Create(ctx, other))
+
+	subscriptions, err := stores.Subscriptions.ListByThread(ctx, repoName, 1)
+	assert.NoError(t, err)
+	assert.Len(t, subscriptions, 2)
+
+	subscriptions, err = stores.Subscriptions.ListByUser(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, subscriptions, 1)
+
+	assert.NoError(t, stores.Subscriptions.Delete(ctx, other))
+
+	_, err = stores.Subscriptions.Get(ctx, 2, repoName, 1)
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+// Creates stores backed by a new SQLite database
+func newSQLiteStores(t *testing.T) *storage.Stores {
+	stores, db, err := storage.OpenSQLStores(context.Background(), storage.SQLite, filepath.Join(t.TempDir(), "push-request.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = db.Close() })
+	return stores
+}
+
+// Runs the store tests against every backend, each test starting from empty stores. MongoDB is only tested when
+// `DB_URI` is set, and PostgreSQL when `POSTGRES_URL` is
+func TestStores(t *testing.T) {
+	backends := map[string]func(*testing.T) *storage.Stores{
+		"memory": func(*testing.T) *storage.Stores { return storage.NewMemoryStores() },
+		"sqlite": newSQLiteStores,
+
+		// Tracing must not change how the stores behave
+		"traced": func(*testing.T) *storage.Stores { return storage.Traced(storage.NewMemoryStores()) },
+	}
+
+	if os.Getenv("DB_URI") != "" {
+		err := mgm.SetDefaultConfig(nil, "push_request_3", options.Client().ApplyURI(os.Getenv("DB_URI")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		backends["mongo"] = func(*testing.T) *storage.Stores {
+			for _, model := range []mgm.Model{&models.User{}, &models.Installation{}, &models.StoredEvent{}, &models.Subscription{},
+				&models.APIKey{}, &models.AuditEntry{}} {
+				_ = mgm.Coll(model).Drop(mgm.Ctx())
+			}
+
+			return storage.NewMongoStores()
+		}
+	}
+
+	if os.Getenv("POSTGRES_URL") != "" {
+		stores, db, err := storage.OpenSQLStores(context.Background(), storage.Postgres, os.Getenv("POSTGRES_URL"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer db.Close()
+
+		backends["postgres"] = func(t *testing.T) *storage.Stores {
+			_, err := db.Exec("TRUNCATE devices, users, installations, events, subscriptions, api_keys, audit_log")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return stores
+		}
+	}
+
+	testMap := map[string]func(*testing.T, *storage.Stores){
+		"test-user-store":         testUserStore,
+		"test-user-registration":  testUserRegistration,
+		"test-user-concurrency":   testUserConcurrentUpdates,
+		"test-user-device-limit":  testUserDeviceLimit,
+		"test-device-details":     testDeviceDetails,
+		"test-user-deletion":      testUserDeletion,
+		"test-user-list":          testUserList,
+		"test-installation-store": testInstallationStore,
+		"test-event-store":        testEventStore,
+		"test-subscription-store": testSubscriptionStore,
+		"test-api-key-store":      testAPIKeyStore,
+		"test-audit-store":        testAuditStore,
+	}
+
+	for backend, newStores := range backends {
+		for testName, test := range testMap {
+			t.Run(backend+"/"+testName, func(t *testing.T) {
+				test(t, newStores(t))
+			})
+		}
+	}
+}
+
+func TestSQLMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "push-request.db")
+
+	var versions []int
+
+	// Opening the database again must not apply the migrations twice
+	for i := 0; i < 2; i++ {
+		_, db, err := storage.OpenSQLStores(context.Background(), storage.SQLite, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var count int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count))
+		versions = append(versions, count)
+
+		_ = db.Close()
+	}
+
+	assert.NotZero(t, versions[0])
+	assert.Equal(t, versions[0], versions[1])
+}
+
+// The records copied from MongoDB must keep their ids and creation dates
+func TestSQLKeepsCopiedModels(t *testing.T) {
+	ctx := context.Background()
+	stores := newSQLiteStores(t)
+
+	createdAt := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	newDefaultModel := func() mgm.DefaultModel {
+		model := mgm.DefaultModel{}
+		model.ID = primitive.NewObjectID()
+		model.CreatedAt = createdAt
+		model.UpdatedAt = createdAt.Add(time.Hour)
+		return model
+	}
+
+	assertKept := func(expected mgm.DefaultModel, actual mgm.DefaultModel) {
+		assert.Equal(t, expected.ID, actual.ID)
+		assert.True(t, expected.CreatedAt.Equal(actual.CreatedAt), "created at %v", actual.CreatedAt)
+		assert.True(t, expected.UpdatedAt.Equal(actual.UpdatedAt), "updated at %v", actual.UpdatedAt)
+	}
+
+	model := newDefaultModel()
+	assert.NoError(t, stores.Users.Create(ctx, &models.User{DefaultModel: model, GithubId: 1}))
+
+	if storedUser, err := stores.Users.Get(ctx, 1); assert.NoError(t, err) {
+		assertKept(model, storedUser.DefaultModel)
+	}
+
+	model = newDefaultModel()
+	assert.NoError(t, stores.Installations.Create(ctx, &models.Installation{DefaultModel: model, Id: 2, GithubId: 1}))
+
+	if storedInstallation, err := stores.Installations.Get(ctx, 2); assert.NoError(t, err) {
+		assertKept(model, storedInstallation.DefaultModel)
+	}
+
+	model = newDefaultModel()
+	subscription := &models.Subscription{DefaultModel: model, GithubId: 1, RepoName: "Codertocat/Hello-World", Number: 1}
+	assert.NoError(t, stores.Subscriptions.Create(ctx, subscription))
+
+	if storedSubscription, err := stores.Subscriptions.Get(ctx, 1, "Codertocat/Hello-World", 1); assert.NoError(t, err) {
+		assertKept(model, storedSubscription.DefaultModel)
+	}
+
+	// Records created by the stores are still given an id and a creation date
+	assert.NoError(t, stores.Users.Create(ctx, &models.User{GithubId: 3}))
+
+	if storedUser, err := stores.Users.Get(ctx, 3); assert.NoError(t, err) {
+		assert.False(t, storedUser.ID.IsZero())
+		assert.False(t, storedUser.CreatedAt.IsZero())
+	}
+}
@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"push-request/models"
+	"push-request/stream"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	broker := stream.NewMemoryBroker()
+
+	events, unsubscribe := broker.Subscribe(1)
+	otherEvents, unsubscribeOther := broker.Subscribe(2)
+	defer unsubscribeOther()
+
+	broker.Publish(models.StoredEvent{GithubId: 1, Event: models.Event{Title: "first"}})
+
+	select {
+	case storedEvent := <-events:
+		assert.Equal(t, "first", storedEvent.Event.Title)
+	case <-time.After(time.Second):
+		t.Fatal("event was not delivered")
+	}
+
+	select {
+	case <-otherEvents:
+		t.Fatal("event was delivered to another user")
+	default:
+	}
+
+	unsubscribe()
+	unsubscribe()
+
+	broker.Publish(models.StoredEvent{GithubId: 1, Event: models.Event{Title: "second"}})
+
+	select {
+	case <-events:
+		t.Fatal("event was delivered after unsubscribing")
+	default:
+	}
+}
+
+// Reads SSE lines until a blank line ends the next message
+func readSSEMessage(t *testing.T, reader *bufio.Reader) []string {
+	var lines []string
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+
+		lines = append(lines, line)
+	}
+}
+
+func openSSEStream(t *testing.T, server *httptest.Server, lastEventId string) (*bufio.Reader, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events/stream", nil)
+	req.Header.Add("Authorization", "1")
+	req.Header.Add("X-Github-Token", "token")
+	if lastEventId != "" {
+		req.Header.Add("Last-Event-ID", lastEventId)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	return bufio.NewReader(res.Body), func() {
+		cancel()
+		_ = res.Body.Close()
+	}
+}
+
+func testStreamSSE(t *testing.T) {
+	server := newTestServer()
+
+	github := newFakeGithubUser(server, 1)
+	defer github.close()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+
+	broker := stream.NewMemoryBroker()
+	server.Broker = broker
+	server.HeartbeatInterval = 50 * time.Millisecond
+
+	testServer := httptest.NewServer(server.Routes())
+	defer testServer.Close()
+
+	first, _ := server.Stores.Events.Create(context.Background(), 1, &models.Event{Title: "first"})
+	second, _ := server.Stores.Events.Create(context.Background(), 1, &models.Event{Title: "second"})
+
+	reader, closeStream := openSSEStream(t, testServer, first.ID.Hex())
+	defer closeStream()
+
+	message := readSSEMessage(t, reader)
+	assert.Equal(t, "id: "+second.ID.Hex(), message[0])
+	assert.Contains(t, message[2], `"title":"second"`)
+
+	// Replaying an event already sent from the backlog must not duplicate it, but an event with an older id, such as
+	// one stored by another replica, is still new
+	broker.Publish(*second)
+
+	earlier := models.StoredEvent{GithubId: 1, Event: models.Event{Title: "earlier"}}
+	earlier.ID = primitive.NewObjectIDFromTimestamp(time.Now().Add(-time.Hour))
+	broker.Publish(earlier)
+
+	third, _ := server.Stores.Events.Create(context.Background(), 1, &models.Event{Title: "third"})
+	broker.Publish(*third)
+
+	for _, want := range []*models.StoredEvent{&earlier, third} {
+		for {
+			message = readSSEMessage(t, reader)
+			if message[0] != ": heartbeat" {
+				break
+			}
+		}
+
+		assert.Equal(t, "id: "+want.ID.Hex(), message[0])
+		assert.Equal(t, "event: event", message[1])
+		assert.Contains(t, message[2], `"title":"`+want.Event.Title+`"`)
+	}
+
+	assert.Equal(t, []string{": heartbeat"}, readSSEMessage(t, reader))
+}
+
+func testStreamWebSocket(t *testing.T) {
+	server := newTestServer()
+
+	github := newFakeGithubUser(server, 1)
+	defer github.close()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+
+	broker := stream.NewMemoryBroker()
+	server.Broker = broker
+
+	testServer := httptest.NewServer(server.Routes())
+	defer testServer.Close()
+
+	url := "ws" + strings.TrimPrefix(testServer.URL, "http") + "/events/stream"
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"1"}, "X-Github-Token": {"token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	storedEvent, _ := server.Stores.Events.Create(context.Background(), 1, &models.Event{Title: "live"})
+
+	// The subscription is registered before the upgrade completes, so the event cannot be missed
+	broker.Publish(*storedEvent)
+
+	var message struct {
+		Id    string       `json:"id"`
+		Event models.Event `json:"event"`
+	}
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	assert.NoError(t, conn.ReadJSON(&message))
+	assert.Equal(t, storedEvent.ID.Hex(), message.Id)
+	assert.Equal(t, "live", message.Event.Title)
+}
+
+func testStreamUnauthorized(t *testing.T) {
+	server := newTestServer()
+
+	req, _ := http.NewRequest("GET", "/events/stream", nil)
+	req.Header.Add("Authorization", "5678")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func testStreamRequiresGithubToken(t *testing.T) {
+	server := newTestServer()
+
+	github := newFakeGithubUser(server, 5678)
+	defer github.close()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+
+	for token, status := range map[string]int{"": http.StatusUnauthorized, "invalid": http.StatusUnauthorized,
+		"token": http.StatusForbidden} {
+		req, _ := http.NewRequest("GET", "/events/stream", nil)
+		req.Header.Add("Authorization", "1")
+		if token != "" {
+			req.Header.Add("X-Github-Token", token)
+		}
+
+		rr := httptest.NewRecorder()
+		server.Routes().ServeHTTP(rr, req)
+
+		assert.Equal(t, status, rr.Code, token)
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-GET-stream-sse":          testStreamSSE,
+		"test-GET-stream-websocket":    testStreamWebSocket,
+		"test-GET-stream-unauthorized": testStreamUnauthorized,
+		"test-GET-stream-github-token": testStreamRequiresGithubToken,
+	}
+
+	for testName, test := range testMap {
+		t.Run(testName, test)
+	}
+}
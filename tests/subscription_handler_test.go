@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/models"
+	"testing"
+	"time"
+)
+
+func subscriptionRequest(server *handlers.Server, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var encoded []byte
+	if body != nil {
+		encoded, _ = json.Marshal(body)
+	}
+
+	req, _ := http.NewRequest(method, path, bytes.NewReader(encoded))
+	req.Header.Add("Authorization", "1")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+// Installs a GitHub App, backed by a fake GitHub API, on the private repository Codertocat/Hello-World as installation
+// 2 of User 1, whose login is `a`
+func installTestApp(t *testing.T, server *handlers.Server) *fakeGithub {
+	github := newFakeGithub()
+	github.respond("GET", "/repos/Codertocat/Hello-World/installation", http.StatusOK, map[string]interface{}{"id": 2})
+	github.respond("POST", "/app/installations/2/access_tokens", http.StatusCreated, map[string]interface{}{
+		"token":      "installation-token",
+		"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	github.respond("GET", "/repos/Codertocat/Hello-World", http.StatusOK, map[string]interface{}{
+		"full_name": "Codertocat/Hello-World",
+		"private":   true,
+	})
+	github.respond("GET", "/user/1", http.StatusOK, map[string]interface{}{"id": 1, "login": "a"})
+
+	server.GithubApp, _ = newTestApp(t, github.baseURL())
+	createInstallation(t, server, 2, 1)
+
+	return github
+}
+
+func testSubscriptionLifecycle(t *testing.T) {
+	server := newTestServer()
+
+	github := installTestApp(t, server)
+	defer github.close()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueOpened})
+
+	thread := map[string]interface{}{"repo_name": "Codertocat/Hello-World", "number": 1}
+
+	rr := subscriptionRequest(server, "POST", "/users/subscriptions", thread)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	rr = subscriptionRequest(server, "GET", "/users/subscriptions", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var subscriptions []models.Subscription
+	_ = json.NewDecoder(rr.Body).Decode(&subscriptions)
+
+	assert.Len(t, subscriptions, 1)
+	assert.Equal(t, "Codertocat/Hello-World", subscriptions[0].RepoName)
+	assert.Equal(t, 1, subscriptions[0].Number)
+	assert.Equal(t, models.ReasonManual, subscriptions[0].Reason)
+	assert.False(t, subscriptions[0].Muted)
+
+	rr = subscriptionRequest(server, "PATCH", "/users/subscriptions", map[string]interface{}{
+		"repo_name": "Codertocat/Hello-World", "number": 1, "muted": true,
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	subscription, _ := server.Stores.Subscriptions.Get(context.Background(), 1, "Codertocat/Hello-World", 1)
+	assert.True(t, subscription.Muted)
+
+	rr = subscriptionRequest(server, "DELETE", "/users/subscriptions?repo_name=Codertocat/Hello-World&number=1", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	_, err := server.Stores.Subscriptions.Get(context.Background(), 1, "Codertocat/Hello-World", 1)
+	assert.Error(t, err)
+
+	rr = subscriptionRequest(server, "DELETE", "/users/subscriptions?repo_name=Codertocat/Hello-World&number=1", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func testSubscriptionBadRequest(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueOpened})
+
+	rr := subscriptionRequest(server, "POST", "/users/subscriptions", map[string]interface{}{"repo_name": "Codertocat/Hello-World"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = subscriptionRequest(server, "PATCH", "/users/subscriptions", map[string]interface{}{"repo_name": "Codertocat/Hello-World", "number": 1})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func testSubscriptionNotInstalled(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueOpened})
+	thread := map[string]interface{}{"repo_name": "Codertocat/Hello-World", "number": 1}
+
+	// Without a GitHub App, no repository can be checked
+	rr := subscriptionRequest(server, "POST", "/users/subscriptions", thread)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	github := installTestApp(t, server)
+	defer github.close()
+
+	// The app isn't installed on the repository
+	rr = subscriptionRequest(server, "POST", "/users/subscriptions", map[string]interface{}{"repo_name": "Codertocat/Private", "number": 1})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = subscriptionRequest(server, "PATCH", "/users/subscriptions", map[string]interface{}{
+		"repo_name": "Codertocat/Private", "number": 1, "muted": true,
+	})
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// The app is installed on the repository by someone else, and the User isn't a collaborator
+	_ = server.Stores.Installations.Link(context.Background(), 2, 5)
+	github.respond("GET", "/repos/Codertocat/Hello-World/collaborators/a/permission", http.StatusOK,
+		map[string]interface{}{"permission": "none"})
+
+	rr = subscriptionRequest(server, "POST", "/users/subscriptions", thread)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	subscriptions, _ := server.Stores.Subscriptions.ListByUser(context.Background(), 1)
+	assert.Empty(t, subscriptions)
+
+	// Threads the User was subscribed to by participating can still be muted
+	_ = server.Stores.Subscriptions.Subscribe(context.Background(), 1, "Codertocat/Hello-World", 1, models.ReasonAuthor)
+
+	rr = subscriptionRequest(server, "PATCH", "/users/subscriptions", map[string]interface{}{
+		"repo_name": "Codertocat/Hello-World", "number": 1, "muted": true,
+	})
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func testSubscriptionOfOrganization(t *testing.T) {
+	server := newTestServer()
+
+	github := installTestApp(t, server)
+	defer github.close()
+
+	// The organization's admin installed the app, and the User is a member with access to the repository
+	_ = server.Stores.Installations.Link(context.Background(), 2, 5)
+	github.respond("GET", "/repos/Codertocat/Hello-World/collaborators/a/permission", http.StatusOK,
+		map[string]interface{}{"permission": "read"})
+
+	createUser(t, server, 1, "a", []models.EventType{models.IssueOpened})
+
+	rr := subscriptionRequest(server, "POST", "/users/subscriptions",
+		map[string]interface{}{"repo_name": "Codertocat/Hello-World", "number": 1})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	// Anyone can see the threads of public repositories
+	github.respond("GET", "/repos/Codertocat/Hello-World", http.StatusOK, map[string]interface{}{"private": false})
+	github.respond("GET", "/repos/Codertocat/Hello-World/collaborators/a/permission", http.StatusOK,
+		map[string]interface{}{"permission": "none"})
+
+	rr = subscriptionRequest(server, "POST", "/users/subscriptions",
+		map[string]interface{}{"repo_name": "Codertocat/Hello-World", "number": 2})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+}
+
+func testSubscribersNotified(t *testing.T) {
+	server := newTestServer()
+
+	// The installation owner only allows issues being opened
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.IssueOpened})
+
+	// A subscriber, a subscriber who doesn't allow comments, a user who muted the thread, and the commenter
+	createUser(t, server, 5, "b", []models.EventType{models.IssueCommented})
+	createUser(t, server, 7, "e", []models.EventType{models.IssueOpened})
+	createUser(t, server, 6, "c", []models.EventType{models.IssueCommented})
+	createUser(t, server, 21031067, "d", []models.EventType{models.IssueCommented})
+
+	_ = server.Stores.Subscriptions.Subscribe(context.Background(), 5, "Codertocat/Hello-World", 1, models.ReasonManual)
+	_ = server.Stores.Subscriptions.Subscribe(context.Background(), 7, "Codertocat/Hello-World", 1, models.ReasonManual)
+	_ = server.Stores.Subscriptions.Create(context.Background(), &models.Subscription{
+		GithubId: 6, RepoName: "Codertocat/Hello-World", Number: 1, Reason: models.ReasonManual, Muted: true,
+	})
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	data, _ := ioutil.ReadFile("./fixtures/issue_comment.json")
+
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewReader(data))
+	req.Header.Add("X-Github-Event", "issue_comment")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	notifications := apns.received()
+	assert.Len(t, notifications, 1)
+	assert.Equal(t, "b", notifications[0].DeviceToken)
+
+	subscription, err := server.Stores.Subscriptions.Get(context.Background(), 21031067, "Codertocat/Hello-World", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ReasonCommented, subscription.Reason)
+}
+
+func TestSubscriptionHandler(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-subscription-lifecycle":     testSubscriptionLifecycle,
+		"test-subscription-bad-request":   testSubscriptionBadRequest,
+		"test-subscription-not-installed": testSubscriptionNotInstalled,
+		"test-subscription-organization":  testSubscriptionOfOrganization,
+		"test-subscribers-notified":       testSubscribersNotified,
+	}
+
+	for testName, test := range testMap {
+		t.Run(testName, test)
+	}
+}
@@ -0,0 +1,284 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"push-request/handlers"
+	"push-request/logging"
+	"push-request/metrics"
+	"push-request/models"
+	"push-request/storage"
+	"push-request/tracing"
+	"testing"
+)
+
+// Records the spans created during the test with an in-memory exporter
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+
+	return exporter
+}
+
+func newTracedServer() *handlers.Server {
+	return handlers.NewServer(storage.Traced(storage.NewMemoryStores()))
+}
+
+// Finds the first span with the name
+func findSpan(spans []*sdktrace.SpanSnapshot, name string) *sdktrace.SpanSnapshot {
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	return nil
+}
+
+func spanNames(spans []*sdktrace.SpanSnapshot) []string {
+	var names []string
+
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+
+	return names
+}
+
+// Gets the value of the span's attribute, or an invalid value if it isn't set
+func spanAttribute(span *sdktrace.SpanSnapshot, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+
+	return attribute.Value{}
+}
+
+func serveTracedWebhook(server *handlers.Server, eventName string, payload []byte) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+	req.Header.Set("X-Github-Event", eventName)
+	req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func testTracingWebhookDelivered(t *testing.T) {
+	exporter := recordSpans(t)
+	server := newTracedServer()
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	exporter.Reset()
+
+	data, _ := ioutil.ReadFile("./fixtures/issue.json")
+	assert.Equal(t, http.StatusOK, serveTracedWebhook(server, "issues", data).Code)
+
+	spans := exporter.GetSpans()
+	names := spanNames(spans)
+
+	for _, name := range []string{"POST /v1/webhook", "parsers.ParseRawEventPayload", "InstallationStore.Get", "UserStore.Get",
+		"EventStore.Create", "apns.push"} {
+		assert.Contains(t, names, name)
+	}
+
+	root := findSpan(spans, "POST /v1/webhook")
+	if !assert.NotNil(t, root) {
+		return
+	}
+
+	assert.Equal(t, trace.SpanKindServer, root.SpanKind)
+	assert.False(t, root.Parent.IsValid())
+	assert.Equal(t, int64(200), spanAttribute(root, "http.status_code").AsInt64())
+	assert.Equal(t, "/v1/webhook", spanAttribute(root, "http.route").AsString())
+	assert.Equal(t, "issues", spanAttribute(root, tracing.GithubEventKey).AsString())
+	assert.Equal(t, "72d3162e-cc78-11e3-81ab-4c9367dc0958", spanAttribute(root, tracing.DeliveryIdKey).AsString())
+	assert.Equal(t, int64(2), spanAttribute(root, tracing.InstallationKey).AsInt64())
+	assert.Equal(t, string(models.IssueAssigned), spanAttribute(root, tracing.EventTypeKey).AsString())
+
+	// Every span is part of the request's trace
+	for _, span := range spans {
+		assert.Equal(t, root.SpanContext.TraceID(), span.SpanContext.TraceID(), span.Name)
+	}
+
+	parse := findSpan(spans, "parsers.ParseRawEventPayload")
+	assert.Equal(t, root.SpanContext.SpanID(), parse.Parent.SpanID())
+	assert.Equal(t, metrics.ParseParsed, spanAttribute(parse, tracing.ResultKey).AsString())
+	assert.Equal(t, string(models.IssueAssigned), spanAttribute(parse, tracing.EventTypeKey).AsString())
+	assert.Equal(t, int64(2), spanAttribute(parse, tracing.InstallationKey).AsInt64())
+
+	store := findSpan(spans, "InstallationStore.Get")
+	assert.Equal(t, "memory", spanAttribute(store, "db.system").AsString())
+	assert.Equal(t, "Get", spanAttribute(store, "db.operation").AsString())
+	assert.Equal(t, "ok", spanAttribute(store, tracing.ResultKey).AsString())
+
+	push := findSpan(spans, "apns.push")
+	assert.Equal(t, metrics.PushSent, spanAttribute(push, tracing.ResultKey).AsString())
+	assert.Equal(t, "production", spanAttribute(push, tracing.PushEnvironmentKey).AsString())
+	assert.Equal(t, codes.Unset, push.StatusCode)
+}
+
+func testTracingPushRejected(t *testing.T) {
+	exporter := recordSpans(t)
+	server := newTracedServer()
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+	apns.reject("BadDeviceToken")
+
+	data, _ := ioutil.ReadFile("./fixtures/issue.json")
+	assert.Equal(t, http.StatusOK, serveTracedWebhook(server, "issues", data).Code)
+
+	spans := exporter.GetSpans()
+
+	push := findSpan(spans, "apns.push")
+	if assert.NotNil(t, push) {
+		assert.Equal(t, metrics.PushRejected, spanAttribute(push, tracing.ResultKey).AsString())
+		assert.Equal(t, "BadDeviceToken", spanAttribute(push, tracing.ReasonKey).AsString())
+		assert.Equal(t, codes.Error, push.StatusCode)
+		assert.NotEmpty(t, push.MessageEvents, "the error is recorded")
+	}
+
+	// The webhook is still handled, since other devices may have been notified
+	root := findSpan(spans, "POST /v1/webhook")
+	if assert.NotNil(t, root) {
+		assert.Equal(t, int64(200), spanAttribute(root, "http.status_code").AsInt64())
+		assert.Equal(t, codes.Unset, root.StatusCode)
+	}
+}
+
+func testTracingStoreResults(t *testing.T) {
+	exporter := recordSpans(t)
+	ctx := context.Background()
+
+	stores := storage.NewMemoryStores()
+	stores.Users = &failingUserStore{stores.Users}
+	traced := storage.Traced(stores)
+
+	_, err := traced.Installations.Get(ctx, 404)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = traced.Users.Get(ctx, 1)
+	assert.ErrorIs(t, err, errFailingStore)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+
+	// Documents that don't exist aren't failures
+	assert.Equal(t, "not_found", spanAttribute(spans[0], tracing.ResultKey).AsString())
+	assert.Equal(t, codes.Unset, spans[0].StatusCode)
+	assert.Equal(t, int64(404), spanAttribute(spans[0], tracing.InstallationKey).AsInt64())
+
+	assert.Equal(t, "UserStore.Get", spans[1].Name)
+	assert.Equal(t, "error", spanAttribute(spans[1], tracing.ResultKey).AsString())
+	assert.Equal(t, codes.Error, spans[1].StatusCode)
+	assert.Equal(t, int64(1), spanAttribute(spans[1], tracing.UserIdKey).AsInt64())
+}
+
+func testTracingContinuesTrace(t *testing.T) {
+	exporter := recordSpans(t)
+	server := newTracedServer()
+
+	var buffer bytes.Buffer
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("Authorization", "1")
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req = req.WithContext(logging.NewContext(req.Context(), logging.New(&buffer, logging.LevelInfo)))
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	root := findSpan(exporter.GetSpans(), "GET /v1/users")
+	if !assert.NotNil(t, root) {
+		return
+	}
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", root.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", root.Parent.SpanID().String())
+	assert.True(t, root.Parent.IsRemote())
+
+	// Responses to bad requests don't mark the span as failed
+	assert.Equal(t, int64(404), spanAttribute(root, "http.status_code").AsInt64())
+	assert.Equal(t, codes.Unset, root.StatusCode)
+
+	// Log lines of the request can be found from the trace
+	line := findLogLine(decodeLogLines(t, &buffer), "request received")
+	if assert.NotNil(t, line) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", line["trace_id"])
+	}
+}
+
+func testTracingStreamsPassThrough(t *testing.T) {
+	recordSpans(t)
+
+	handler := tracing.Handler("/events/stream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flushes := w.(http.Flusher)
+		_, hijacks := w.(http.Hijacker)
+		assert.True(t, flushes)
+		assert.True(t, hijacks)
+
+		w.(http.Flusher).Flush()
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/events/stream", nil))
+
+	assert.True(t, rr.Flushed)
+}
+
+func testTracingTargetWithoutQuery(t *testing.T) {
+	exporter := recordSpans(t)
+
+	handler := tracing.Handler("/feed.atom", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/feed.atom?token=secret", nil))
+
+	span := findSpan(exporter.GetSpans(), "GET /feed.atom")
+	if assert.NotNil(t, span) {
+		assert.Equal(t, "/feed.atom", spanAttribute(span, "http.target").AsString())
+	}
+}
+
+func TestTracing(t *testing.T) {
+	testMap := map[string]func(*testing.T){
+		"test-tracing-webhook-delivered":    testTracingWebhookDelivered,
+		"test-tracing-push-rejected":        testTracingPushRejected,
+		"test-tracing-store-results":        testTracingStoreResults,
+		"test-tracing-continues-trace":      testTracingContinuesTrace,
+		"test-tracing-streams-pass-through": testTracingStreamsPassThrough,
+		"test-tracing-target-without-query": testTracingTargetWithoutQuery,
+	}
+
+	for name, test := range testMap {
+		t.Run(name, test)
+	}
+}
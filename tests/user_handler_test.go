@@ -2,15 +2,14 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"github.com/Kamva/mgm"
 	"github.com/stretchr/testify/assert"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"push-request/handlers"
 	"push-request/models"
+	"strconv"
 	"testing"
 )
 
@@ -20,6 +19,8 @@ type UserPatchData struct {
 }
 
 func testPostUser201(t *testing.T) {
+	server := newTestServer()
+
 	data := models.User{
 		GithubId:     1234,
 		DeviceTokens: []string{"a"},
@@ -34,20 +35,22 @@ func testPostUser201(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(handlers.HandleUser)
+	handler := server.Routes()
 
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusCreated, rr.Code)
 
-	user, _ := models.GetUser(1234)
+	user, _ := server.Stores.Users.Get(context.Background(), 1234)
 
 	assert.Contains(t, user.DeviceTokens, "a")
 	assert.Equal(t, data.AllowedTypes, user.AllowedTypes)
 }
 
 func testPatchUser200(t *testing.T) {
-	_ = models.CreateUser(1234, "a", []models.EventType{models.IssueOpened})
+	server := newTestServer()
+
+	createUser(t, server, 1234, "a", []models.EventType{models.IssueOpened})
 
 	data := UserPatchData{
 		AllowedTypes: []models.EventType{models.PrMerged},
@@ -63,26 +66,28 @@ func testPatchUser200(t *testing.T) {
 	req.Header.Add("Authorization", "1234")
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(handlers.HandleUser)
+	handler := server.Routes()
 
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 
-	user, _ := models.GetUser(1234)
+	user, _ := server.Stores.Users.Get(context.Background(), 1234)
 
 	assert.Contains(t, user.DeviceTokens, "a")
 	assert.Equal(t, user.AllowedTypes, []models.EventType{models.PrMerged})
 }
 
 func testPostUser400(t *testing.T) {
+	server := newTestServer()
+
 	data := models.User{
 		GithubId:     1234,
 		DeviceTokens: []string{"a"},
 		AllowedTypes: []models.EventType{models.IssueOpened},
 	}
 
-	_ = models.CreateUser(1234, "b", []models.EventType{models.IssueOpened})
+	createUser(t, server, 1234, "b", []models.EventType{models.IssueOpened})
 
 	encoded, _ := json.Marshal(data)
 
@@ -92,18 +97,105 @@ func testPostUser400(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(handlers.HandleUser)
+	handler := server.Routes()
 
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 
-	user, _ := models.GetUser(1234)
+	user, _ := server.Stores.Users.Get(context.Background(), 1234)
 	assert.Equal(t, user.DeviceTokens, []string{"b", "a"})
 }
 
+func postUser(server *handlers.Server, body interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/users", bytes.NewReader(encoded))
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func testPostUserUpdatesAllowedTypes(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1234, "a", []models.EventType{models.IssueOpened})
+
+	rr := postUser(server, map[string]interface{}{"github_id": 1234, "device_tokens": []string{"a"}, "allowed_types": []models.EventType{models.PrMerged}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	user, _ := server.Stores.Users.Get(context.Background(), 1234)
+	assert.Equal(t, []string{"a"}, user.DeviceTokens)
+	assert.Equal(t, []models.EventType{models.PrMerged}, user.AllowedTypes)
+}
+
+func testPostUserMovesDevice(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1234, "a", []models.EventType{models.IssueOpened})
+
+	rr := postUser(server, map[string]interface{}{"github_id": 5678, "device_tokens": []string{"a"}})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	previous, _ := server.Stores.Users.Get(context.Background(), 1234)
+	assert.Empty(t, previous.DeviceTokens)
+
+	user, _ := server.Stores.Users.Get(context.Background(), 5678)
+	assert.Equal(t, []string{"a"}, user.DeviceTokens)
+}
+
+func testPostUserDevices(t *testing.T) {
+	server := newTestServer()
+
+	rr := postUser(server, map[string]interface{}{
+		"github_id": 1234,
+		"devices": []map[string]string{
+			{"token": "a", "platform": "ios", "environment": "sandbox", "app_version": "1.2.0", "locale": "en_US", "name": "iPhone"},
+		},
+		"device_tokens": []string{"b"},
+	})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	user, _ := server.Stores.Users.Get(context.Background(), 1234)
+	assert.Equal(t, []string{"a", "b"}, user.DeviceTokens)
+
+	device := user.Devices["a"]
+	assert.Equal(t, models.PlatformIOS, device.Platform)
+	assert.Equal(t, models.APNSSandbox, device.Environment)
+	assert.Equal(t, "1.2.0", device.AppVersion)
+	assert.Equal(t, "en_US", device.Locale)
+	assert.Equal(t, "iPhone", device.Name)
+	assert.NotNil(t, device.LastSeenAt)
+}
+
+func testPostUserInvalid(t *testing.T) {
+	server := newTestServer()
+
+	invalid := map[string]map[string]interface{}{
+		"no device tokens":  {"github_id": 1234, "device_tokens": []string{}},
+		"empty token":       {"github_id": 1234, "device_tokens": []string{""}},
+		"non-hex token":     {"github_id": 1234, "device_tokens": []string{"not a token"}},
+		"missing github id": {"device_tokens": []string{"a"}},
+		"unknown platform":  {"github_id": 1234, "devices": []map[string]string{{"token": "a", "platform": "android"}}},
+		"unknown env":       {"github_id": 1234, "devices": []map[string]string{{"token": "a", "environment": "staging"}}},
+		"unknown type":      {"github_id": 1234, "device_tokens": []string{"a"}, "allowed_types": []string{"prExploded"}},
+	}
+
+	for name, body := range invalid {
+		rr := postUser(server, body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, name)
+	}
+
+	_, err := server.Stores.Users.Get(context.Background(), 1234)
+	assert.Error(t, err)
+}
+
 func testGetUser200(t *testing.T) {
-	_ = models.CreateUser(1234, "a", []models.EventType{models.IssueOpened})
+	server := newTestServer()
+
+	createUser(t, server, 1234, "a", []models.EventType{models.IssueOpened})
+	_, _ = server.Stores.Events.Create(context.Background(), 1234, &models.Event{Title: "latest"})
 
 	req, err := http.NewRequest("GET", "/users", nil)
 	if err != nil {
@@ -113,7 +205,7 @@ func testGetUser200(t *testing.T) {
 	req.Header.Add("Authorization", "1234")
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(handlers.HandleUser)
+	handler := server.Routes()
 
 	handler.ServeHTTP(rr, req)
 
@@ -130,9 +222,12 @@ func testGetUser200(t *testing.T) {
 	assert.Equal(t, want.GithubId, got.GithubId)
 	assert.Equal(t, want.DeviceTokens, got.DeviceTokens)
 	assert.Equal(t, want.AllowedTypes, got.AllowedTypes)
+	assert.Equal(t, "latest", got.LatestEvent.Title)
 }
 
 func testGetUser404(t *testing.T) {
+	server := newTestServer()
+
 	req, err := http.NewRequest("GET", "/users", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -141,32 +236,82 @@ func testGetUser404(t *testing.T) {
 	req.Header.Add("Authorization", "5678")
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(handlers.HandleUser)
+	handler := server.Routes()
 
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusNotFound, rr.Code)
 }
 
-func TestUserHandler(t *testing.T) {
-	_ = os.Setenv("DB_NAME", "push_request_3")
-	_ = os.Setenv("DB_URI", "mongodb://localhost:27017")
+func patchUser(server *handlers.Server, ifMatch string, body interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(body)
+	req, _ := http.NewRequest("PATCH", "/users", bytes.NewReader(encoded))
+	req.Header.Add("Authorization", "1234")
 
-	err := mgm.SetDefaultConfig(nil, os.Getenv("DB_NAME"), options.Client().ApplyURI(os.Getenv("DB_URI")))
-	if err != nil {
-		t.Fatal(err)
+	if ifMatch != "" {
+		req.Header.Add("If-Match", ifMatch)
 	}
 
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+func testPatchUserIfMatch(t *testing.T) {
+	server := newTestServer()
+
+	createUser(t, server, 1234, "a", []models.EventType{models.IssueOpened})
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Add("Authorization", "1234")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
+
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// Another client changes the user after the ETag was returned
+	rr = patchUser(server, "", UserPatchData{AllowedTypes: []models.EventType{models.PrMerged}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+
+	rr = patchUser(server, etag, UserPatchData{AllowedTypes: []models.EventType{models.PrClosed}})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	user, _ := server.Stores.Users.Get(context.Background(), 1234)
+	assert.Equal(t, []models.EventType{models.PrMerged}, user.AllowedTypes)
+
+	rr = patchUser(server, userETag(user), UserPatchData{AllowedTypes: []models.EventType{models.PrClosed}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	user, _ = server.Stores.Users.Get(context.Background(), 1234)
+	assert.Equal(t, []models.EventType{models.PrClosed}, user.AllowedTypes)
+
+	rr = patchUser(server, "not an etag", UserPatchData{})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func userETag(user *models.User) string {
+	return `"` + strconv.FormatInt(user.Version, 10) + `"`
+}
+
+func TestUserHandler(t *testing.T) {
 	testMap := map[string]func(*testing.T){
 		"test-POST-user-creation":       testPostUser201,
 		"test-POST-user-already-exists": testPostUser400,
+		"test-POST-user-allowed-types":  testPostUserUpdatesAllowedTypes,
+		"test-POST-user-moves-device":   testPostUserMovesDevice,
+		"test-POST-user-invalid":        testPostUserInvalid,
+		"test-POST-user-devices":        testPostUserDevices,
 		"test-GET-user":                 testGetUser200,
 		"test-GET-user-not-found":       testGetUser404,
 		"test-PATCH-user":               testPatchUser200,
+		"test-PATCH-user-if-match":      testPatchUserIfMatch,
 	}
 
 	for testName, test := range testMap {
-		_ = mgm.Coll(&models.User{}).Drop(mgm.Ctx())
 		t.Run(testName, test)
 	}
 }
@@ -2,26 +2,24 @@ package tests
 
 import (
 	"bytes"
-	"crypto/tls"
-	"github.com/Kamva/mgm"
-	"github.com/sideshow/apns2"
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"push-request/handlers"
+	"push-request/metrics"
 	"push-request/models"
+	"push-request/storage"
+	"strings"
 	"testing"
 	"time"
 )
 
-func mockClient() *apns2.Client {
-	return apns2.NewClient(tls.Certificate{})
-}
-
 func handleInstallationEvent(t *testing.T) {
+	server := newTestServer()
+
 	data, _ := ioutil.ReadFile("./fixtures/installation.json")
 
 	req, err := http.NewRequest("POST", "/webhook", bytes.NewReader(data))
@@ -32,23 +30,28 @@ func handleInstallationEvent(t *testing.T) {
 	req.Header.Add("X-Github-Event", "installation")
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(handlers.HandleWebhook)
+	handler := server.Routes()
 
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusCreated, rr.Code)
 
-	installation, err := models.GetInstallation(2)
+	installation, err := server.Stores.Installations.Get(context.Background(), 2)
 	assert.NoError(t, err)
 
 	assert.Equal(t, int64(1), installation.GithubId)
+	assert.Equal(t, int64(1), installation.AccountId)
 }
 
 func handleEventPayload(t *testing.T) {
-	_ = models.CreateInstallation(2, 1)
-	_ = models.CreateUser(1, "a", []models.EventType{models.IssueAssigned})
+	server := newTestServer()
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
 
-	handlers.SetAPNSClient(mockClient())
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
 
 	data, _ := ioutil.ReadFile("./fixtures/issue.json")
 
@@ -60,13 +63,13 @@ func handleEventPayload(t *testing.T) {
 	req.Header.Add("X-Github-Event", "issues")
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(handlers.HandleWebhook)
+	handler := server.Routes()
 
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 
-	user, err := models.GetUser(1)
+	events, err := server.Stores.Events.List(context.Background(), 1, nil, 1)
 	assert.NoError(t, err)
 
 	date, _ := time.Parse(time.RFC3339, "2019-05-15T15:20:18Z")
@@ -82,24 +85,310 @@ func handleEventPayload(t *testing.T) {
 		2,
 	)
 
-	assert.Equal(t, want, *user.LatestEvent)
+	assert.Len(t, events, 1)
+	assert.Equal(t, want, events[0].Event)
+
+	notifications := apns.received()
+	assert.Len(t, notifications, 1)
+	assert.Equal(t, "a", notifications[0].DeviceToken)
+	assert.Equal(t, "ISSUE", notifications[0].Payload["aps"].(map[string]interface{})["category"])
+	assert.Equal(t, "alert", notifications[0].PushType)
+	assert.Equal(t, "10", notifications[0].Priority)
 }
 
-func TestWebhookHandler(t *testing.T) {
-	_ = os.Setenv("DB_NAME", "push_request_3")
-	_ = os.Setenv("DB_URI", "mongodb://localhost:27017")
+func handleMention(t *testing.T) {
+	server := newTestServer()
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+	createUser(t, server, 583231, "b", []models.EventType{models.Mentioned})
+	createUser(t, server, 3, "c", []models.EventType{models.IssueAssigned})
 
-	err := mgm.SetDefaultConfig(nil, os.Getenv("DB_NAME"), options.Client().ApplyURI(os.Getenv("DB_URI")))
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	github := newFakeGithub()
+	defer github.close()
+
+	app, _ := newTestApp(t, github.baseURL())
+	server.GithubApp = app
+
+	github.respond("POST", "/app/installations/2/access_tokens", http.StatusCreated, map[string]interface{}{
+		"token":      "installation-token",
+		"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	github.respond("GET", "/orgs/octo-org/teams/reviewers/members", http.StatusOK, []map[string]interface{}{
+		{"login": "octocat", "id": 583231},
+		{"login": "hubot", "id": 3},
+	})
+	github.respond("GET", "/users/octocat", http.StatusOK, map[string]interface{}{"login": "octocat", "id": 583231})
+	github.respond("GET", "/users/hubot", http.StatusOK, map[string]interface{}{"login": "hubot", "id": 3})
+
+	data, _ := ioutil.ReadFile("./fixtures/issue_comment.json")
+
+	req, err := http.NewRequest("POST", "/webhook", bytes.NewReader(data))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_ = mgm.Coll(&models.User{}).Drop(mgm.Ctx())
-	_ = mgm.Coll(&models.Installation{}).Drop(mgm.Ctx())
+	req.Header.Add("X-Github-Event", "issue_comment")
 
-	t.Run("handle_installation_event", handleInstallationEvent)
+	rr := httptest.NewRecorder()
+	handler := server.Routes()
+
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Only octocat allows mentions; hubot doesn't, and the author, Codertocat, mentioned themselves
+	notifications := apns.received()
+	assert.Len(t, notifications, 1)
+	assert.Equal(t, "b", notifications[0].DeviceToken)
+
+	events, err := server.Stores.Events.List(context.Background(), 583231, nil, 1)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, models.Mentioned, events[0].Event.EventType)
+	assert.Equal(t, "@Codertocat mentioned you in #1", events[0].Event.Description)
+
+	for _, request := range github.received() {
+		assert.NotEqual(t, "/users/Codertocat", request.Path)
+	}
+}
+
+func handleEnrichTimeout(t *testing.T) {
+	server := newTestServer()
+	server.EnrichTimeout = 50 * time.Millisecond
 
-	_ = mgm.Coll(&models.Installation{}).Drop(mgm.Ctx())
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.PrOpened})
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	github := newFakeGithub()
+	defer github.close()
+
+	app, _ := newTestApp(t, github.baseURL())
+	server.GithubApp = app
+
+	github.respond("POST", "/app/installations/2/access_tokens", http.StatusCreated, map[string]interface{}{
+		"token":      "installation-token",
+		"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	github.respond("GET", "/repos/Codertocat/Hello-World/pulls/2", http.StatusOK, map[string]interface{}{
+		"number":    2,
+		"additions": 10,
+		"head":      map[string]interface{}{"sha": "ec26c3e57ca3a959ca5aad62de7213c562f8c821"},
+	})
+	github.respondTo("GET", "/repos/Codertocat/Hello-World/commits/ec26c3e57ca3a959ca5aad62de7213c562f8c821/status",
+		func(string) (int, interface{}) {
+			time.Sleep(200 * time.Millisecond)
+			return http.StatusOK, map[string]interface{}{"state": "success", "total_count": 1}
+		})
+
+	rr := postWebhook(server, "pull_request", []byte(fixture(t, "pull_request.json")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The event is delivered without the details that were looked up before GitHub stopped answering
+	assert.Len(t, apns.received(), 1)
+
+	events, err := server.Stores.Events.List(context.Background(), 1, nil, 1)
+	if assert.NoError(t, err) && assert.Len(t, events, 1) {
+		assert.Equal(t, 0, events[0].Event.Additions)
+		assert.Empty(t, events[0].Event.CIStatus)
+	}
+}
+
+func handleEventPerDevice(t *testing.T) {
+	server := newTestServer()
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.PrMerged})
+
+	// A development build of the app, and an iPad that doesn't want issue events
+	ctx := context.Background()
+	_, _ = server.Stores.Users.Register(ctx, 1, models.Device{Token: "b", Environment: models.APNSSandbox}, nil, 0)
+	_, _ = server.Stores.Users.Register(ctx, 1, models.Device{Token: "c", Platform: models.PlatformIPadOS}, nil, 0)
+	_, _ = storage.UpdateUser(ctx, server.Stores.Users, 1, func(user *models.User) error {
+		user.Devices["b"] = models.Device{Environment: models.APNSSandbox, AllowedTypes: []models.EventType{models.IssueAssigned}}
+		user.Devices["c"] = models.Device{Platform: models.PlatformIPadOS, AllowedTypes: []models.EventType{models.PrMerged}}
+		return nil
+	})
+
+	production := newFakeAPNS()
+	defer production.close()
+	server.APNS = production.client()
+
+	sandbox := newFakeAPNS()
+	defer sandbox.close()
+	server.APNSSandbox = sandbox.client()
+
+	data, _ := ioutil.ReadFile("./fixtures/issue.json")
+
+	req, err := http.NewRequest("POST", "/webhook", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Add("X-Github-Event", "issues")
+
+	rr := httptest.NewRecorder()
+	server.Routes().ServeHTTP(rr, req)
 
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The user doesn't allow assigned issues, but the development build does, so only it is notified
+	assert.Empty(t, production.received())
+
+	notifications := sandbox.received()
+	assert.Len(t, notifications, 1)
+	assert.Equal(t, "b", notifications[0].DeviceToken)
+}
+
+func handleEventDeviceRejected(t *testing.T) {
+	server := newTestServer()
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.IssueAssigned})
+	_, _ = server.Stores.Users.Register(context.Background(), 1,
+		models.Device{Token: "b", Environment: models.APNSSandbox}, nil, 0)
+
+	production := newFakeAPNS()
+	defer production.close()
+	server.APNS = production.client()
+	production.reject("BadDeviceToken")
+
+	sandbox := newFakeAPNS()
+	defer sandbox.close()
+	server.APNSSandbox = sandbox.client()
+
+	rr := postWebhook(server, "issues", []byte(fixture(t, "issue.json")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// The device APNs rejected doesn't keep the other one from being notified
+	assert.Len(t, production.received(), 1)
+
+	notifications := sandbox.received()
+	if assert.Len(t, notifications, 1) {
+		assert.Equal(t, "b", notifications[0].DeviceToken)
+	}
+}
+
+func handleMentionMuted(t *testing.T) {
+	server := newTestServer()
+
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", []models.EventType{models.IssueOpened})
+	createUser(t, server, 583231, "b", []models.EventType{models.IssueCommented, models.Mentioned})
+	_ = server.Stores.Subscriptions.Create(context.Background(), &models.Subscription{
+		GithubId: 583231, RepoName: "Codertocat/Hello-World", Number: 1, Reason: models.ReasonManual, Muted: true,
+	})
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	github := newFakeGithub()
+	defer github.close()
+
+	app, _ := newTestApp(t, github.baseURL())
+	server.GithubApp = app
+
+	github.respond("POST", "/app/installations/2/access_tokens", http.StatusCreated, map[string]interface{}{
+		"token":      "installation-token",
+		"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	github.respond("GET", "/users/octocat", http.StatusOK, map[string]interface{}{"login": "octocat", "id": 583231})
+
+	rr := postWebhook(server, "issue_comment", []byte(fixture(t, "issue_comment.json")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// octocat muted the thread, so is notified neither of the comment nor of being mentioned in it
+	assert.Empty(t, apns.received())
+	assert.Equal(t, 2.0, testutil.ToFloat64(server.Metrics.EventsFiltered.WithLabelValues(metrics.FilterMuted)))
+}
+
+func handleMentionOfLargeTeam(t *testing.T) {
+	server := newTestServer()
+	createInstallation(t, server, 2, 1)
+	createUser(t, server, 1, "a", nil)
+
+	apns := newFakeAPNS()
+	defer apns.close()
+	server.APNS = apns.client()
+
+	github := newFakeGithub()
+	defer github.close()
+
+	server.GithubApp, _ = newTestApp(t, github.baseURL())
+
+	github.respond("POST", "/app/installations/2/access_tokens", http.StatusCreated, map[string]interface{}{
+		"token":      "installation-token",
+		"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+
+	var members []map[string]interface{}
+	for i := 0; i < 150; i++ {
+		members = append(members, map[string]interface{}{"login": fmt.Sprintf("member%d", i), "id": 1000 + i})
+	}
+
+	github.respond("GET", "/orgs/octo-org/teams/reviewers/members", http.StatusOK, members)
+
+	rr := postWebhook(server, "issue_comment", []byte(fixture(t, "issue_comment.json")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Only the first 100 logins mentioned are looked up
+	lookups := 0
+	for _, request := range github.received() {
+		if strings.HasPrefix(request.Path, "/users/") {
+			lookups++
+		}
+	}
+
+	assert.Equal(t, 100, lookups)
+}
+
+func handleWebhookSignature(t *testing.T) {
+	server := newTestServer()
+	server.WebhookSecret = []byte("secret")
+
+	payload := []byte(fixture(t, "installation.json"))
+
+	for name, signature := range map[string]string{
+		"missing":    "",
+		"not hex":    "sha256=signature",
+		"sha1":       "sha1=" + strings.TrimPrefix(signPayload(server.WebhookSecret, payload), "sha256="),
+		"other key":  signPayload([]byte("other"), payload),
+		"other body": signPayload(server.WebhookSecret, []byte("{}")),
+	} {
+		req, _ := http.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+		req.Header.Add("X-Github-Event", "installation")
+		req.Header.Add("X-Hub-Signature-256", signature)
+
+		rr := httptest.NewRecorder()
+		server.Routes().ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, name)
+	}
+
+	_, err := server.Stores.Installations.Get(context.Background(), 2)
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	rr := postWebhook(server, "installation", payload)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+}
+
+func TestWebhookHandler(t *testing.T) {
+	t.Run("handle_installation_event", handleInstallationEvent)
 	t.Run("handle_event_payload", handleEventPayload)
+	t.Run("handle_mention", handleMention)
+	t.Run("handle_mention_muted", handleMentionMuted)
+	t.Run("handle_mention_of_large_team", handleMentionOfLargeTeam)
+	t.Run("handle_enrich_timeout", handleEnrichTimeout)
+	t.Run("handle_event_per_device", handleEventPerDevice)
+	t.Run("handle_event_device_rejected", handleEventDeviceRejected)
+	t.Run("handle_webhook_signature", handleWebhookSignature)
 }
@@ -0,0 +1,142 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"net/http"
+)
+
+// The name spans are created with, which identifies the instrumentation of this module
+const instrumentationName = "push-request"
+
+// The attributes of spans, besides the semantic conventions of OpenTelemetry
+const (
+	GithubEventKey     = attribute.Key("github.event")
+	DeliveryIdKey      = attribute.Key("github.delivery_id")
+	InstallationKey    = attribute.Key("github.installation_id")
+	UserIdKey          = attribute.Key("github.user_id")
+	EventTypeKey       = attribute.Key("push_request.event_type")
+	ResultKey          = attribute.Key("push_request.result")
+	ReasonKey          = attribute.Key("push_request.reason")
+	PushProviderKey    = attribute.Key("push.provider")
+	PushEnvironmentKey = attribute.Key("push.environment")
+)
+
+// Requests continue the traces of their callers given in W3C `traceparent` headers
+var propagator = propagation.TraceContext{}
+
+// Gets the tracer of the global TracerProvider, which creates spans that aren't recorded until one is set up
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Starts a span that is a child of the span of ctx, if any
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// Ends the span with its result. An error marks the span as failed and is recorded on it
+func End(span trace.Span, result string, err error) {
+	span.SetAttributes(ResultKey.String(result))
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Adds the attributes to the span of ctx, such as the server span of a request
+func Annotate(ctx context.Context, attributes ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attributes...)
+}
+
+// The semantic attributes of a request. Its target is the path without the query, whose parameters may be secrets
+// such as the token of a feed
+func requestAttributes(route string, r *http.Request) []attribute.KeyValue {
+	attributes := semconv.HTTPServerAttributesFromHTTPRequest("", route, r)
+
+	for i, kv := range attributes {
+		if kv.Key == semconv.HTTPTargetKey {
+			attributes[i] = semconv.HTTPTargetKey.String(r.URL.Path)
+		}
+	}
+
+	return attributes
+}
+
+// Traces the requests of a route, in server spans named by the method and the pattern the handler is registered with,
+// rather than the path of each request
+func Handler(route string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(requestAttributes(route, r)...))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(recorder.status)...)
+
+		// Responses to bad requests are the client's failure rather than the server's
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// A statusRecorder records the status code of a response. Event streams flush their responses or hijack their
+// connections, so it passes both through
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if !recorder.wroteHeader {
+		recorder.status = status
+		recorder.wroteHeader = true
+	}
+
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(bytes []byte) (int, error) {
+	recorder.wroteHeader = true
+	return recorder.ResponseWriter.Write(bytes)
+}
+
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		recorder.wroteHeader = true
+		flusher.Flush()
+	}
+}
+
+func (recorder *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := recorder.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer can't be hijacked")
+	}
+
+	conn, readWriter, err := hijacker.Hijack()
+	if err == nil {
+		recorder.status = http.StatusSwitchingProtocols
+		recorder.wroteHeader = true
+	}
+
+	return conn, readWriter, err
+}
@@ -0,0 +1,53 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The problem with a field of a request or response, named like `devices[0].token`
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// An Error lists the problems with the fields of a request or response
+type Error struct {
+	Details []FieldError
+}
+
+func (err *Error) Error() string {
+	messages := make([]string, len(err.Details))
+	for i, detail := range err.Details {
+		messages[i] = detail.Field + " " + detail.Message
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+// A Validator collects the problems with the fields of a request or response
+type Validator struct {
+	details []FieldError
+}
+
+// Records the problem with the field unless ok, and reports whether it was. The field of a whole body is named `body`
+func (v *Validator) Check(ok bool, field string, message string, args ...interface{}) bool {
+	if field == "" {
+		field = "body"
+	}
+
+	if !ok {
+		v.details = append(v.details, FieldError{Field: field, Message: fmt.Sprintf(message, args...)})
+	}
+
+	return ok
+}
+
+// Returns an Error with the problems found, or nil if there weren't any
+func (v *Validator) Err() error {
+	if len(v.details) == 0 {
+		return nil
+	}
+
+	return &Error{Details: v.details}
+}